@@ -53,9 +53,8 @@ func Start() {
 	openapi.RegisterHandlersWithOptions(r, handler, openapi.GinServerOptions{
 		Middlewares: []openapi.MiddlewareFunc{
 			middleware.CreateAuthMiddleware(cfg),
-			middleware.CreateApiKeyAuthMiddleware(func(apiKey string) (string, error) {
-				return c.ApiKeys.GetUsernameByApiKey(apiKey)
-			}),
+			middleware.CreateApiKeyAuthMiddleware(c.ApiKeys.Authenticate),
+			middleware.CreateDeployTokenAuthMiddleware(c.DeployTokens.Authenticate),
 			middleware.Authz,
 		},
 		ErrorHandler: func(ctx *gin.Context, err error, code int) {
@@ -82,7 +81,7 @@ func Start() {
 	r.StaticFile("swagger-ui.html", "./static/swagger-ui.html")
 	r.StaticFile("oauth2-redirect.html", "./static/oauth2-redirect.html")
 
-	r.GET("/health", healthcheck)
+	r.GET("/health", healthcheck(c.Leadership))
 
 	err := r.Run()
 	if err != nil {
@@ -188,8 +187,14 @@ func openApiValidatorMiddleware(includePaths []string, excludePaths []string) gi
 	}
 }
 
-func healthcheck(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, gin.H{
-		"status": "UP",
-	})
+// healthcheck reports if the replica is the leader that reconciles projects
+func healthcheck(leadership core.Leadership) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{
+			"status":   "UP",
+			"replica":  leadership.Identity(),
+			"leader":   leadership.IsLeader(),
+			"leaderId": leadership.Leader(),
+		})
+	}
 }
@@ -2,32 +2,48 @@ package core
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"github.com/kuzznya/letsdeploy/app/apperrors"
 	"github.com/kuzznya/letsdeploy/app/middleware"
 	"github.com/kuzznya/letsdeploy/app/storage"
 	"github.com/kuzznya/letsdeploy/internal/openapi"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"math/rand"
+	"slices"
+	"strings"
+	"time"
 )
 
-const apiKeyPrefix = "ldp_"
+const (
+	apiKeyPrefix = "ldp_"
+	apiKeyLength = 40
+	// apiKeyVisibleLength is the length of the key prefix stored as is to identify the key
+	apiKeyVisibleLength = 12
+	// apiKeyLastUsedPrecision limits the frequency of last usage time updates
+	apiKeyLastUsedPrecision = time.Minute
+)
 
+// ApiKeys manages API keys of users. Only SHA-256 hashes of the keys are stored,
+// the key itself is returned once on creation
 type ApiKeys interface {
 	GetApiKeys(auth middleware.Authentication) ([]openapi.ApiKey, error)
-	GetUsernameByApiKey(apiKey string) (string, error)
+	// Authenticate returns the owner of the API key and the scope of the key
+	Authenticate(apiKey string) (string, *middleware.Scope, error)
 	CreateApiKey(ctx context.Context, key openapi.ApiKey, auth middleware.Authentication) (*openapi.ApiKey, error)
-	DeleteApiKey(ctx context.Context, apiKey string, auth middleware.Authentication) error
+	DeleteApiKey(ctx context.Context, id int, auth middleware.Authentication) error
 }
 
 type apiKeysImpl struct {
-	storage *storage.Storage
+	projects Projects
+	storage  *storage.Storage
 }
 
 var _ ApiKeys = (*apiKeysImpl)(nil)
 
-func InitApiKeys(storage *storage.Storage) ApiKeys {
-	return &apiKeysImpl{storage: storage}
+func InitApiKeys(projects Projects, storage *storage.Storage) ApiKeys {
+	return &apiKeysImpl{projects: projects, storage: storage}
 }
 
 func (a apiKeysImpl) GetApiKeys(auth middleware.Authentication) ([]openapi.ApiKey, error) {
@@ -37,44 +53,117 @@ func (a apiKeysImpl) GetApiKeys(auth middleware.Authentication) ([]openapi.ApiKe
 	}
 	keys := make([]openapi.ApiKey, len(entities))
 	for i, entity := range entities {
-		id := entity.Id
-		keys[i] = openapi.ApiKey{
-			Key:  &id,
-			Name: entity.Name,
+		var projects []string
+		if entity.ProjectScoped {
+			projects, err = a.storage.ApiKeyRepository().GetProjects(entity.Id)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get API key projects")
+			}
 		}
+		keys[i] = apiKeyFromEntity(entity, projects)
 	}
 	return keys, nil
 }
 
-func (a apiKeysImpl) GetUsernameByApiKey(apiKey string) (string, error) {
-	entity, err := a.storage.ApiKeyRepository().FindByID(apiKey)
+func (a apiKeysImpl) Authenticate(apiKey string) (string, *middleware.Scope, error) {
+	if !strings.HasPrefix(apiKey, apiKeyPrefix) {
+		return "", nil, apperrors.Unauthorized("Invalid API key")
+	}
+	entity, err := a.storage.ApiKeyRepository().FindByKeyHash(hashApiKey(apiKey))
 	if err != nil {
-		return "", errors.Wrap(err, "failed to get API key")
+		return "", nil, errors.Wrap(err, "failed to get API key")
+	}
+	now := time.Now()
+	if entity.ExpiresAt.Valid && entity.ExpiresAt.Time.Before(now) {
+		return "", nil, apperrors.Unauthorized("API key has expired")
+	}
+	if !entity.LastUsedAt.Valid || now.Sub(entity.LastUsedAt.Time) > apiKeyLastUsedPrecision {
+		if err := a.storage.ApiKeyRepository().UpdateLastUsedAt(entity.Id, now); err != nil {
+			log.WithError(err).Warnf("Failed to update last usage time of API key %s", entity.Prefix)
+		}
+	}
+
+	if !entity.ReadOnly && !entity.ProjectScoped {
+		return entity.Username, nil, nil
+	}
+	scope := &middleware.Scope{ReadOnly: entity.ReadOnly}
+	if entity.ProjectScoped {
+		scope.Projects, err = a.storage.ApiKeyRepository().GetProjects(entity.Id)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "failed to get API key projects")
+		}
 	}
-	return entity.Username, nil
+	return entity.Username, scope, nil
 }
 
 func (a apiKeysImpl) CreateApiKey(ctx context.Context, key openapi.ApiKey, auth middleware.Authentication) (*openapi.ApiKey, error) {
-	keyId := generateApiKey()
-	key.Key = &keyId
+	// otherwise scoped key could be used to issue a key with wider access
+	if auth.Scope != nil {
+		return nil, apperrors.Forbidden("API keys cannot be created with a scoped API key")
+	}
+	if auth.DeployToken != nil {
+		return nil, apperrors.Forbidden("API keys cannot be created with a deploy token")
+	}
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(time.Now()) {
+		return nil, apperrors.BadRequest("API key expiration time is in the past")
+	}
+	var projects []string
+	if key.Projects != nil {
+		projects = slices.Clone(*key.Projects)
+		slices.Sort(projects)
+		projects = slices.Compact(projects)
+		if len(projects) == 0 {
+			return nil, apperrors.BadRequest("API key should have access to at least one project")
+		}
+		for _, project := range projects {
+			if err := a.projects.checkAccess(project, auth, viewProject); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	rawKey, err := generateApiKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate API key")
+	}
 	entity := storage.ApiKeyEntity{
-		Id:       keyId,
-		Name:     key.Name,
-		Username: auth.Username,
+		Name:          key.Name,
+		Username:      auth.Username,
+		KeyHash:       hashApiKey(rawKey),
+		Prefix:        rawKey[:apiKeyVisibleLength],
+		ReadOnly:      key.Access != nil && *key.Access == openapi.ApiKeyAccessReadOnly,
+		ProjectScoped: projects != nil,
+		CreatedAt:     time.Now(),
+	}
+	if key.ExpiresAt != nil {
+		entity.ExpiresAt = sql.NullTime{Time: *key.ExpiresAt, Valid: true}
 	}
-	err := a.storage.ExecTx(ctx, func(s *storage.Storage) error {
-		_, err := s.ApiKeyRepository().CreateNew(entity)
-		return err
+	err = a.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		id, err := s.ApiKeyRepository().CreateNew(entity)
+		if err != nil {
+			return err
+		}
+		entity.Id = id
+		return s.ApiKeyRepository().SetProjects(id, projects)
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create the API key")
 	}
-	log.Infof("Created API key for user %s", auth.Username)
-	return &key, nil
+	recordAudit(a.storage, auth, auditEvent{resourceType: auditApiKey, resourceId: entity.Prefix, action: "create"})
+	log.Infof("Created API key %s for user %s", entity.Prefix, auth.Username)
+	result := apiKeyFromEntity(entity, projects)
+	result.Key = &rawKey
+	return &result, nil
 }
 
-func (a apiKeysImpl) DeleteApiKey(ctx context.Context, apiKey string, auth middleware.Authentication) error {
-	key, err := a.storage.ApiKeyRepository().FindByID(apiKey)
+func (a apiKeysImpl) DeleteApiKey(ctx context.Context, id int, auth middleware.Authentication) error {
+	if auth.Scope != nil {
+		return apperrors.Forbidden("API keys cannot be deleted with a scoped API key")
+	}
+	if auth.DeployToken != nil {
+		return apperrors.Forbidden("API keys cannot be deleted with a deploy token")
+	}
+	key, err := a.storage.ApiKeyRepository().FindByID(id)
 	if apperrors.IsNotFound(err) {
 		return nil
 	} else if err != nil {
@@ -84,22 +173,49 @@ func (a apiKeysImpl) DeleteApiKey(ctx context.Context, apiKey string, auth middl
 		return apperrors.NotFound("API key not found")
 	}
 	err = a.storage.ExecTx(ctx, func(s *storage.Storage) error {
-		return s.ApiKeyRepository().Delete(apiKey)
+		return s.ApiKeyRepository().Delete(id)
 	})
 	if err != nil {
 		return errors.Wrap(err, "failed to delete API key")
 	}
-	log.Infof("Deleted API key for user %s", auth.Username)
+	recordAudit(a.storage, auth, auditEvent{resourceType: auditApiKey, resourceId: key.Prefix, action: "delete"})
+	log.Infof("Deleted API key %s for user %s", key.Prefix, auth.Username)
 	return nil
 }
 
-func generateApiKey() string {
-	letters := []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
-	tokenLen := 32
-	b := make([]rune, tokenLen)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
-	}
-	apiKey := string(b)
-	return apiKeyPrefix + apiKey
+func apiKeyFromEntity(entity storage.ApiKeyEntity, projects []string) openapi.ApiKey {
+	access := openapi.ApiKeyAccessReadWrite
+	if entity.ReadOnly {
+		access = openapi.ApiKeyAccessReadOnly
+	}
+	key := openapi.ApiKey{
+		Id:        &entity.Id,
+		Name:      entity.Name,
+		Prefix:    &entity.Prefix,
+		CreatedAt: &entity.CreatedAt,
+		Access:    &access,
+	}
+	if entity.ExpiresAt.Valid {
+		key.ExpiresAt = &entity.ExpiresAt.Time
+	}
+	if entity.LastUsedAt.Valid {
+		key.LastUsedAt = &entity.LastUsedAt.Time
+	}
+	if entity.ProjectScoped {
+		key.Projects = &projects
+	}
+	return key
+}
+
+func generateApiKey() (string, error) {
+	key, err := randomString(alphanumeric, apiKeyLength)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + key, nil
+}
+
+func hashApiKey(apiKey string) string {
+	hash := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(hash[:])
 }
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"time"
+)
+
+// Audit resource types
+const (
+	auditProject              = "project"
+	auditParticipant          = "participant"
+	auditInvitation           = "invitation"
+	auditSecret               = "secret"
+	auditService              = "service"
+	auditManagedService       = "managed-service"
+	auditMongoDbUser          = "mongodb-user"
+	auditRegistry             = "container-registry"
+	auditHibernation          = "hibernation-schedule"
+	auditDeployToken          = "deploy-token"
+	auditApiKey               = "api-key"
+	auditWebhook              = "webhook"
+	auditIncidentNotification = "incident-notification"
+	auditPreview              = "preview-environment"
+	auditEnvironment          = "environment"
+	auditQuota                = "project-quota"
+)
+
+const defaultPageSize = 50
+
+// auditEvent describes the operation performed on the resource, project is empty for resources outside projects
+type auditEvent struct {
+	project      string
+	resourceType string
+	resourceId   string
+	// action is create, update or delete for changes of the resource itself,
+	// other operations on the resource use their own verb (e.g. deploy, restart, rotate-password)
+	action  string
+	summary string
+}
+
+// AuditQuery filters audit events, empty fields are not used for filtering
+type AuditQuery struct {
+	Project      string
+	Actor        string
+	ResourceType string
+	ResourceId   string
+	Action       string
+	From         *time.Time
+	To           *time.Time
+	Limit        int
+	Offset       int
+}
+
+// Audit provides access to the append-only log of operations performed in core
+type Audit interface {
+	GetProjectAuditEvents(projectId string, query AuditQuery, auth middleware.Authentication) (*openapi.AuditEventPage, error)
+	// GetAuditEvents returns events of all projects, available only to platform admins
+	GetAuditEvents(query AuditQuery, auth middleware.Authentication) (*openapi.AuditEventPage, error)
+}
+
+type auditImpl struct {
+	projects Projects
+	storage  *storage.Storage
+	cfg      *viper.Viper
+}
+
+var _ Audit = (*auditImpl)(nil)
+
+func InitAudit(projects Projects, storage *storage.Storage, cfg *viper.Viper) Audit {
+	return &auditImpl{projects: projects, storage: storage, cfg: cfg}
+}
+
+func (a auditImpl) GetProjectAuditEvents(projectId string, query AuditQuery, auth middleware.Authentication) (*openapi.AuditEventPage, error) {
+	if err := a.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	query.Project = projectId
+	return a.findEvents(query)
+}
+
+func (a auditImpl) GetAuditEvents(query AuditQuery, auth middleware.Authentication) (*openapi.AuditEventPage, error) {
+	if !isPlatformAdmin(a.cfg, auth) {
+		return nil, apperrors.Forbidden("Audit log of all projects is available only to platform admins")
+	}
+	return a.findEvents(query)
+}
+
+func (a auditImpl) findEvents(query AuditQuery) (*openapi.AuditEventPage, error) {
+	if query.Limit <= 0 {
+		query.Limit = defaultPageSize
+	}
+	filter := storage.AuditEventFilter{
+		ProjectId:    query.Project,
+		Actor:        query.Actor,
+		ResourceType: query.ResourceType,
+		ResourceId:   query.ResourceId,
+		Action:       query.Action,
+		From:         query.From,
+		To:           query.To,
+	}
+	entities, err := a.storage.AuditEventRepository().Find(filter, query.Limit, query.Offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get audit events")
+	}
+	total, err := a.storage.AuditEventRepository().Count(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count audit events")
+	}
+	return &openapi.AuditEventPage{Events: mapItems(entities, auditEventFromEntity), Total: total}, nil
+}
+
+// recordAudit stores the event of the operation performed by auth. It is called after the operation succeeds,
+// so failures are logged instead of being returned
+func recordAudit(s *storage.Storage, auth middleware.Authentication, event auditEvent) {
+	err := s.AuditEventRepository().CreateNew(storage.AuditEventEntity{
+		Actor:        auth.Username,
+		AuthMethod:   string(auth.Method),
+		ProjectId:    toNullString(emptyToNil(event.project)),
+		ResourceType: event.resourceType,
+		ResourceId:   toNullString(emptyToNil(event.resourceId)),
+		Action:       event.action,
+		Summary:      toNullString(emptyToNil(event.summary)),
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Failed to record audit event %+v of %s", event, auth.Username)
+	}
+}
+
+func auditEventFromEntity(entity storage.AuditEventEntity) openapi.AuditEvent {
+	return openapi.AuditEvent{
+		Id:           entity.Id,
+		Actor:        entity.Actor,
+		AuthMethod:   entity.AuthMethod,
+		Project:      fromNullString(entity.ProjectId),
+		ResourceType: entity.ResourceType,
+		ResourceId:   fromNullString(entity.ResourceId),
+		Action:       entity.Action,
+		Summary:      fromNullString(entity.Summary),
+		CreatedAt:    entity.CreatedAt,
+	}
+}
+
+func emptyToNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
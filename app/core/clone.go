@@ -0,0 +1,222 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"slices"
+)
+
+const regeneratedSecretLength = 32
+
+// Clones creates copies of projects. The copy is built as the manifest of the source project
+// and applied to the new project, so the clone is reconciled in its own namespace like any other project
+type Clones interface {
+	// CloneProject creates the target project with resources of the source one,
+	// managed services of the clone are created with fresh empty data.
+	// Seeding them from a backup is not supported, as there are no managed service backups to seed from
+	CloneProject(ctx context.Context, projectId string, clone openapi.ProjectClone, auth middleware.Authentication) (*openapi.Project, error)
+	// cloneProject clones the project without access check, customizing the clone with the hooks
+	cloneProject(
+		ctx context.Context,
+		projectId string,
+		clone openapi.ProjectClone,
+		hooks cloneHooks,
+		auth middleware.Authentication,
+	) (*openapi.Project, error)
+}
+
+// cloneHooks allow other components to build their resources on top of the clone, nil hooks are skipped
+type cloneHooks struct {
+	// manifest is called with the manifest of the source project before the target project is created
+	manifest func(manifest *openapi.ProjectManifest) error
+	// created is called after the target project is created and before its resources are added
+	created func(projectId string) error
+}
+
+type clonesImpl struct {
+	projects  Projects
+	manifests Manifests
+	storage   *storage.Storage
+	sync      Sync
+}
+
+var _ Clones = (*clonesImpl)(nil)
+
+func InitClones(projects Projects, manifests Manifests, storage *storage.Storage, sync Sync) Clones {
+	return &clonesImpl{projects: projects, manifests: manifests, storage: storage, sync: sync}
+}
+
+func (c clonesImpl) CloneProject(
+	ctx context.Context,
+	projectId string,
+	clone openapi.ProjectClone,
+	auth middleware.Authentication,
+) (*openapi.Project, error) {
+	if err := c.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	return c.cloneProject(ctx, projectId, clone, cloneHooks{}, auth)
+}
+
+func (c clonesImpl) cloneProject(
+	ctx context.Context,
+	projectId string,
+	clone openapi.ProjectClone,
+	hooks cloneHooks,
+	auth middleware.Authentication,
+) (*openapi.Project, error) {
+	manifest, err := c.sourceManifest(projectId, clone, auth)
+	if err != nil {
+		return nil, err
+	}
+	if hooks.manifest != nil {
+		if err := hooks.manifest(manifest); err != nil {
+			return nil, err
+		}
+	}
+	var members []storage.ParticipantEntity
+	if clone.CopyMembers == nil || *clone.CopyMembers {
+		members, err = c.storage.ProjectRepository().GetMembers(projectId)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get project members")
+		}
+	}
+
+	project, err := c.projects.CreateProject(ctx, openapi.Project{Id: clone.Target}, auth)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Project = &project.Id
+	if err := c.fillClone(ctx, project.Id, *manifest, members, hooks, auth); err != nil {
+		// partially filled clone is removed so that cloning can be retried with the same name
+		if err := c.projects.DeleteProject(ctx, project.Id, auth); err != nil {
+			log.WithError(err).Errorf("Failed to delete partially cloned project %s", project.Id)
+		}
+		return nil, errors.Wrapf(err, "failed to clone project %s", projectId)
+	}
+	c.sync.Enqueue(project.Id)
+
+	recordAudit(c.storage, auth, auditEvent{project: projectId, resourceType: auditProject, resourceId: projectId,
+		action: "clone", summary: fmt.Sprintf("cloned to %s", project.Id)})
+	recordAudit(c.storage, auth, auditEvent{project: project.Id, resourceType: auditProject, resourceId: project.Id,
+		action: "clone", summary: fmt.Sprintf("cloned from %s", projectId)})
+	log.Infof("Project %s cloned to %s", projectId, project.Id)
+	return project, nil
+}
+
+// sourceManifest returns the manifest of the source project with secret values, registry passwords and env overrides
+func (c clonesImpl) sourceManifest(
+	projectId string,
+	clone openapi.ProjectClone,
+	auth middleware.Authentication,
+) (*openapi.ProjectManifest, error) {
+	manifest, err := c.manifests.GetProjectManifest(projectId, auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project manifest")
+	}
+
+	secrets, err := c.storage.SecretRepository().FindByProjectId(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project secrets")
+	}
+	secretValues := toMapSelf(secrets, func(e storage.SecretEntity) string { return e.Name })
+	manifestSecrets := fromPtr(manifest.Secrets)
+	for i, secret := range manifestSecrets {
+		value := secretValues[secret.Name].Value
+		if clone.RegenerateSecrets != nil && *clone.RegenerateSecrets {
+			value, err = randomString(alphanumeric, regeneratedSecretLength)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to generate secret value")
+			}
+		}
+		manifestSecrets[i].Value = &value
+	}
+
+	registries, err := c.storage.ContainerRegistryRepository().FindByProjectId(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project container registries")
+	}
+	passwords := toMapSelf(registries, func(e storage.ContainerRegistryEntity) string { return e.Url })
+	manifestRegistries := fromPtr(manifest.Registries)
+	for i, registry := range manifestRegistries {
+		password := passwords[registry.Url].Password
+		manifestRegistries[i].Password = &password
+	}
+
+	if clone.EnvOverrides != nil {
+		if err := overrideEnvVars(fromPtr(manifest.Services), *clone.EnvOverrides); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+func (c clonesImpl) fillClone(
+	ctx context.Context,
+	projectId string,
+	manifest openapi.ProjectManifest,
+	members []storage.ParticipantEntity,
+	hooks cloneHooks,
+	auth middleware.Authentication,
+) error {
+	if hooks.created != nil {
+		if err := hooks.created(projectId); err != nil {
+			return err
+		}
+	}
+	for _, member := range members {
+		if member.Username == auth.Username {
+			continue
+		}
+		role := openapi.ProjectRole(member.Role)
+		// the project can have only one owner, which is the user who cloned it
+		if role == openapi.Owner {
+			role = openapi.Admin
+		}
+		if err := c.projects.AddParticipant(projectId, member.Username, &role, auth); err != nil {
+			return errors.Wrapf(err, "failed to add member %s", member.Username)
+		}
+	}
+	_, err := c.manifests.ApplyProjectManifest(ctx, projectId, manifest, false, false, auth)
+	return err
+}
+
+// overrideEnvVars replaces env vars of the services with the values from overrides, missing env vars are appended
+func overrideEnvVars(services []openapi.ManifestService, overrides map[string]map[string]string) error {
+	servicesByName := make(map[string]*openapi.ManifestService, len(services))
+	for i := range services {
+		servicesByName[services[i].Name] = &services[i]
+	}
+	for serviceName, vars := range overrides {
+		service, found := servicesByName[serviceName]
+		if !found {
+			return apperrors.BadRequest(fmt.Sprintf("Service %s of env overrides does not exist", serviceName))
+		}
+		envVars := fromPtr(service.EnvVars)
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			envVar := openapi.EnvVar{Name: name}
+			if err := envVar.FromEnvVar0(openapi.EnvVar0{Value: vars[name]}); err != nil {
+				return errors.Wrap(err, "failed to create env var")
+			}
+			i := slices.IndexFunc(envVars, func(v openapi.EnvVar) bool { return v.Name == name })
+			if i >= 0 {
+				envVars[i] = envVar
+			} else {
+				envVars = append(envVars, envVar)
+			}
+		}
+		service.EnvVars = &envVars
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultComposePort          = 80
+	maxComposeServiceNameLength = 20
+	maxComposeSecretNameLength  = 255
+)
+
+// composeManagedServiceImages maps images of known databases to managed service types
+var composeManagedServiceImages = map[string]openapi.ManagedServiceType{
+	"postgres": "postgres",
+	"mysql":    "mysql",
+	"mongo":    "mongo",
+	"redis":    "redis",
+	"rabbitmq": "rabbitmq",
+}
+
+var (
+	secretEnvVarPattern   = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key|private_?key|credential)`)
+	invalidNameCharacters = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// ComposeImports converts docker-compose files to project manifests
+type ComposeImports interface {
+	// ImportCompose returns the manifest built from the compose file and the plan of its application,
+	// the manifest is applied to the project only if apply is set
+	ImportCompose(ctx context.Context, projectId string, compose []byte, apply bool, auth middleware.Authentication) (*openapi.ComposeImport, error)
+}
+
+type composeImportsImpl struct {
+	projects  Projects
+	manifests Manifests
+}
+
+var _ ComposeImports = (*composeImportsImpl)(nil)
+
+func InitComposeImports(projects Projects, manifests Manifests) ComposeImports {
+	return &composeImportsImpl{projects: projects, manifests: manifests}
+}
+
+// composeFile is the subset of docker-compose file format that is imported
+type composeFile struct {
+	Services map[string]composeService `json:"services"`
+}
+
+type composeService struct {
+	Image string `json:"image"`
+	Build any    `json:"build"`
+	// Ports are either strings (e.g. "8080:80/tcp"), numbers or objects with target field
+	Ports []any `json:"ports"`
+	// Environment is either a list of NAME=value strings or a map
+	Environment any `json:"environment"`
+	// Command is either a string or a list
+	Command any  `json:"command"`
+	Scale   *int `json:"scale"`
+	Deploy  *struct {
+		Replicas *int `json:"replicas"`
+	} `json:"deploy"`
+	// DependsOn is either a list of service names or a map by service name
+	DependsOn any `json:"depends_on"`
+}
+
+func (c composeImportsImpl) ImportCompose(
+	ctx context.Context,
+	projectId string,
+	compose []byte,
+	apply bool,
+	auth middleware.Authentication,
+) (*openapi.ComposeImport, error) {
+	if err := c.projects.checkAccess(projectId, auth, editResources); err != nil {
+		return nil, err
+	}
+	var file composeFile
+	if err := yaml.Unmarshal(compose, &file); err != nil {
+		return nil, apperrors.BadRequest("Invalid compose file: " + err.Error())
+	}
+	if len(file.Services) == 0 {
+		return nil, apperrors.BadRequest("Compose file does not define services")
+	}
+	manifest, warnings, err := composeManifest(projectId, file)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := c.manifests.ApplyProjectManifest(ctx, projectId, *manifest, !apply, false, auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to apply manifest of compose file")
+	}
+	return &openapi.ComposeImport{Manifest: *manifest, Warnings: warnings, Plan: *plan}, nil
+}
+
+func composeManifest(projectId string, file composeFile) (*openapi.ProjectManifest, []string, error) {
+	warnings := []string{}
+	services := []openapi.ManifestService{}
+	managedServices := []openapi.ManifestManagedService{}
+	secrets := []openapi.ManifestSecret{}
+	for _, composeName := range composeServicesOrder(file.Services) {
+		service := file.Services[composeName]
+		name := sanitizedName(composeName, maxComposeServiceNameLength)
+		if name == "" {
+			return nil, nil, apperrors.BadRequest(fmt.Sprintf("Service name %s cannot be converted to a valid name", composeName))
+		}
+		if name != composeName {
+			warnings = append(warnings, fmt.Sprintf("service %s is renamed to %s", composeName, name))
+		}
+		if service.Image == "" {
+			warnings = append(warnings, fmt.Sprintf("service %s has no image and is skipped, build is not supported", composeName))
+			continue
+		}
+		if serviceType, found := composeManagedServiceImages[imageBaseName(service.Image)]; found {
+			managedServices = append(managedServices, openapi.ManifestManagedService{Name: name, Type: serviceType})
+			warnings = append(warnings, fmt.Sprintf("service %s is imported as %s managed service, its environment is ignored, "+
+				"the password is available in secret %s", composeName, serviceType, getManagedServiceSecretName(name)))
+			continue
+		}
+
+		manifestService := openapi.ManifestService{Name: name, Image: service.Image, Port: defaultComposePort}
+		port, portWarnings := composeServicePort(composeName, service.Ports)
+		warnings = append(warnings, portWarnings...)
+		if port != 0 {
+			manifestService.Port = port
+		}
+		if command, err := composeCommand(service.Command); err != nil {
+			return nil, nil, apperrors.BadRequest(fmt.Sprintf("Invalid command of service %s: %s", composeName, err))
+		} else if len(command) > 0 {
+			manifestService.Command = &command
+		}
+		if service.Deploy != nil && service.Deploy.Replicas != nil {
+			manifestService.Replicas = service.Deploy.Replicas
+		} else if service.Scale != nil {
+			manifestService.Replicas = service.Scale
+		}
+
+		env, err := composeEnvironment(service.Environment)
+		if err != nil {
+			return nil, nil, apperrors.BadRequest(fmt.Sprintf("Invalid environment of service %s: %s", composeName, err))
+		}
+		envVars := make([]openapi.EnvVar, 0, len(env))
+		for _, variable := range env {
+			envVar := openapi.EnvVar{Name: variable[0]}
+			if variable[1] == "" {
+				warnings = append(warnings, fmt.Sprintf("env var %s of service %s has no value and is skipped", variable[0], composeName))
+				continue
+			}
+			if secretEnvVarPattern.MatchString(variable[0]) {
+				secretName := sanitizedName(name+"-"+variable[0], maxComposeSecretNameLength)
+				secrets = append(secrets, openapi.ManifestSecret{Name: secretName, Value: &variable[1]})
+				err = envVar.FromEnvVar1(openapi.EnvVar1{Secret: secretName})
+				warnings = append(warnings, fmt.Sprintf("env var %s of service %s is stored in secret %s", variable[0], composeName, secretName))
+			} else {
+				err = envVar.FromEnvVar0(openapi.EnvVar0{Value: variable[1]})
+			}
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to create env var")
+			}
+			envVars = append(envVars, envVar)
+		}
+		manifestService.EnvVars = &envVars
+		services = append(services, manifestService)
+	}
+
+	return &openapi.ProjectManifest{
+		ApiVersion:      openapi.ManifestV1,
+		Kind:            openapi.ManifestKindProject,
+		Project:         &projectId,
+		Services:        &services,
+		ManagedServices: &managedServices,
+		Secrets:         &secrets,
+	}, warnings, nil
+}
+
+// composeServicesOrder sorts services so that dependencies go before the services that depend on them
+func composeServicesOrder(services map[string]composeService) []string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	order := make([]string, 0, len(names))
+	visited := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if _, found := services[name]; !found || visited[name] {
+			return
+		}
+		// cycles are not valid in compose, the service is marked before its dependencies to stop on them
+		visited[name] = true
+		for _, dependency := range composeDependencies(services[name].DependsOn) {
+			visit(dependency)
+		}
+		order = append(order, name)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return order
+}
+
+func composeDependencies(dependsOn any) []string {
+	switch d := dependsOn.(type) {
+	case []any:
+		return mapItems(d, func(v any) string { return fmt.Sprint(v) })
+	case map[string]any:
+		dependencies := make([]string, 0, len(d))
+		for name := range d {
+			dependencies = append(dependencies, name)
+		}
+		slices.Sort(dependencies)
+		return dependencies
+	default:
+		return nil
+	}
+}
+
+// composeServicePort returns the container port of the first port mapping, 0 if there are no ports
+func composeServicePort(composeName string, ports []any) (int, []string) {
+	var warnings []string
+	if len(ports) == 0 {
+		warnings = append(warnings, fmt.Sprintf("service %s has no ports, port %d is used", composeName, defaultComposePort))
+		return 0, warnings
+	}
+	if len(ports) > 1 {
+		warnings = append(warnings, fmt.Sprintf("service %s has several ports, only the first one is used", composeName))
+	}
+	var target string
+	switch p := ports[0].(type) {
+	case float64:
+		target = strconv.Itoa(int(p))
+	case string:
+		p, _, _ = strings.Cut(p, "/")
+		target = p[strings.LastIndex(p, ":")+1:]
+		if from, _, isRange := strings.Cut(target, "-"); isRange {
+			warnings = append(warnings, fmt.Sprintf("service %s has a port range, only the first port is used", composeName))
+			target = from
+		}
+	case map[string]any:
+		target = fmt.Sprint(p["target"])
+	}
+	port, err := strconv.Atoi(target)
+	if err != nil || port < 1 || port > 65535 {
+		warnings = append(warnings, fmt.Sprintf("service %s has invalid port %v, port %d is used", composeName, ports[0], defaultComposePort))
+		return 0, warnings
+	}
+	return port, warnings
+}
+
+// composeEnvironment returns pairs of env var name and value in the order of the compose file
+func composeEnvironment(environment any) ([][2]string, error) {
+	var env [][2]string
+	switch e := environment.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		for _, item := range e {
+			name, value, _ := strings.Cut(fmt.Sprint(item), "=")
+			env = append(env, [2]string{name, value})
+		}
+	case map[string]any:
+		for name, value := range e {
+			if value == nil {
+				value = ""
+			}
+			env = append(env, [2]string{name, fmt.Sprint(value)})
+		}
+		slices.SortFunc(env, func(a, b [2]string) int { return strings.Compare(a[0], b[0]) })
+	default:
+		return nil, errors.New("environment should be a list or a map")
+	}
+	return env, nil
+}
+
+func composeCommand(command any) ([]string, error) {
+	switch c := command.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		return mapItems(c, func(v any) string { return fmt.Sprint(v) }), nil
+	case string:
+		return splitCommand(c)
+	default:
+		return nil, errors.New("command should be a string or a list")
+	}
+}
+
+// splitCommand splits the command into arguments the way shell does, supporting quotes but not expansions
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	for _, r := range command {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// imageBaseName returns the image name without registry, namespace and tag, e.g. postgres for docker.io/library/postgres:16
+func imageBaseName(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	image = image[strings.LastIndex(image, "/")+1:]
+	name, _, _ := strings.Cut(image, ":")
+	return name
+}
+
+// sanitizedName converts the name to the one allowed for services and secrets
+func sanitizedName(name string, maxLength int) string {
+	name = invalidNameCharacters.ReplaceAllString(strings.ToLower(name), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > maxLength {
+		name = strings.TrimRight(name[:maxLength], "-")
+	}
+	return name
+}
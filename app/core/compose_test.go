@@ -0,0 +1,215 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "Empty",
+			command: "",
+			want:    nil,
+		},
+		{
+			name:    "SeveralSpaces",
+			command: "  npm \t run\nstart ",
+			want:    []string{"npm", "run", "start"},
+		},
+		{
+			name:    "DoubleQuotes",
+			command: `sh -c "echo 'hello world'"`,
+			want:    []string{"sh", "-c", "echo 'hello world'"},
+		},
+		{
+			name:    "SingleQuotes",
+			command: `echo 'a "b" c'`,
+			want:    []string{"echo", `a "b" c`},
+		},
+		{
+			name:    "QuotesInsideArgument",
+			command: `--name="my app"`,
+			want:    []string{"--name=my app"},
+		},
+		{
+			name:    "EmptyQuotedArgument",
+			command: `echo ""`,
+			want:    []string{"echo", ""},
+		},
+		{
+			name:    "UnterminatedQuote",
+			command: `echo "hello`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitCommand(tt.command)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComposeServicePort(t *testing.T) {
+	tests := []struct {
+		name         string
+		ports        []any
+		want         int
+		wantWarnings int
+	}{
+		{
+			name:         "NoPorts",
+			ports:        nil,
+			want:         0,
+			wantWarnings: 1,
+		},
+		{
+			name:  "Number",
+			ports: []any{float64(8080)},
+			want:  8080,
+		},
+		{
+			name:  "HostAndContainerPorts",
+			ports: []any{"8080:80"},
+			want:  80,
+		},
+		{
+			name:  "HostIpAndProtocol",
+			ports: []any{"127.0.0.1:8080:80/tcp"},
+			want:  80,
+		},
+		{
+			name:         "Range",
+			ports:        []any{"3000-3005"},
+			want:         3000,
+			wantWarnings: 1,
+		},
+		{
+			name:  "LongSyntax",
+			ports: []any{map[string]any{"target": float64(443), "published": "8443"}},
+			want:  443,
+		},
+		{
+			name:         "SeveralPorts",
+			ports:        []any{"80", "443"},
+			want:         80,
+			wantWarnings: 1,
+		},
+		{
+			name:         "InvalidPort",
+			ports:        []any{"http"},
+			want:         0,
+			wantWarnings: 1,
+		},
+		{
+			name:         "OutOfRange",
+			ports:        []any{float64(70000)},
+			want:         0,
+			wantWarnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, warnings := composeServicePort("app", tt.ports)
+			if got != tt.want {
+				t.Errorf("composeServicePort() = %v, want %v", got, tt.want)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("composeServicePort() warnings = %q, want %d warnings", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestComposeEnvironment(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment any
+		want        [][2]string
+		wantErr     bool
+	}{
+		{
+			name:        "Nil",
+			environment: nil,
+			want:        nil,
+		},
+		{
+			name:        "List",
+			environment: []any{"B=2", "A=1=1", "EMPTY"},
+			want:        [][2]string{{"B", "2"}, {"A", "1=1"}, {"EMPTY", ""}},
+		},
+		{
+			name:        "MapIsSortedByName",
+			environment: map[string]any{"PORT": float64(8080), "DEBUG": true, "EMPTY": nil},
+			want:        [][2]string{{"DEBUG", "true"}, {"EMPTY", ""}, {"PORT", "8080"}},
+		},
+		{
+			name:        "String",
+			environment: "A=1",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := composeEnvironment(tt.environment)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("composeEnvironment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("composeEnvironment() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageBaseName(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+		want  string
+	}{
+		{
+			name:  "NameOnly",
+			image: "postgres",
+			want:  "postgres",
+		},
+		{
+			name:  "Tag",
+			image: "redis:7-alpine",
+			want:  "redis",
+		},
+		{
+			name:  "RegistryAndNamespace",
+			image: "docker.io/library/postgres:16",
+			want:  "postgres",
+		},
+		{
+			name:  "RegistryWithPort",
+			image: "registry.local:5000/team/api:1.0",
+			want:  "api",
+		},
+		{
+			name:  "Digest",
+			image: "ghcr.io/org/app@sha256:abcdef",
+			want:  "app",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := imageBaseName(tt.image); got != tt.want {
+				t.Errorf("imageBaseName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,10 +19,28 @@ type Core struct {
 	Registries      ContainerRegistries
 	Tokens          Tokens
 	ApiKeys         ApiKeys
+	Hibernation     Hibernation
+	Invitations     Invitations
+	DeployTokens    DeployTokens
+	Audit           Audit
+	Webhooks        Webhooks
+	Incidents       Incidents
+	Sync            Sync
+	Leadership      Leadership
+	Drift           Drift
+	Manifests       Manifests
+	ComposeImports  ComposeImports
+	Exports         Exports
+	Clones          Clones
+	Previews        Previews
+	Environments    Environments
+	Quotas          Quotas
 }
 
 type projectSynchronizable interface {
 	syncKubernetes(ctx context.Context, projectId string) error
+	// desiredObjects returns apply configurations of K8s objects that syncKubernetes applies
+	desiredObjects(projectId string) ([]any, error)
 }
 
 func New(
@@ -35,12 +53,28 @@ func New(
 ) *Core {
 	corePromise := promise.New[Core]()
 	projects := InitProjects(storage, clientset, cmClient, cfg, corePromise)
-	services := InitServices(projects, storage, clientset, cfg)
-	managedServices := InitManagedServices(projects, storage, clientset)
+	quotas := InitQuotas(projects, storage, cfg, corePromise)
+	services := InitServices(projects, quotas, storage, clientset, cfg)
+	leadership := InitLeadership(clientset, cfg)
+	managedServices := InitManagedServices(projects, services, quotas, storage, clientset, leadership, taskScheduler, cfg)
 	mongoDbMgmt := InitMongoDbMgmt(managedServices, storage, clientset)
 	registries := InitContainerRegistries(projects, storage, clientset)
 	tokens := InitTokens(rdb)
-	apiKeys := InitApiKeys(storage)
+	apiKeys := InitApiKeys(projects, storage)
+	hibernation := InitHibernation(projects, storage, taskScheduler, leadership)
+	invitations := InitInvitations(projects, storage)
+	deployTokens := InitDeployTokens(projects, storage)
+	audit := InitAudit(projects, storage, cfg)
+	webhooks := InitWebhooks(projects, storage, taskScheduler)
+	incidents := InitIncidents(projects, services, managedServices, storage, clientset, leadership, taskScheduler, cfg)
+	drift := InitDrift(projects, services, managedServices, registries, clientset)
+	sync := InitSync(storage, rdb, clientset, leadership, taskScheduler, cfg)
+	manifests := InitManifests(projects, services, managedServices, registries, storage, sync)
+	composeImports := InitComposeImports(projects, manifests)
+	exports := InitExports(projects, services, managedServices, registries, storage)
+	clones := InitClones(projects, manifests, storage, sync)
+	previews := InitPreviews(projects, clones, storage, leadership, taskScheduler)
+	environments := InitEnvironments(projects, services, manifests, clones, storage, sync, cfg)
 
 	core := &Core{
 		Projects:        projects,
@@ -50,8 +84,24 @@ func New(
 		Registries:      registries,
 		Tokens:          tokens,
 		ApiKeys:         apiKeys,
+		Hibernation:     hibernation,
+		Invitations:     invitations,
+		DeployTokens:    deployTokens,
+		Audit:           audit,
+		Webhooks:        webhooks,
+		Incidents:       incidents,
+		Sync:            sync,
+		Leadership:      leadership,
+		Drift:           drift,
+		Manifests:       manifests,
+		ComposeImports:  composeImports,
+		Exports:         exports,
+		Clones:          clones,
+		Previews:        previews,
+		Environments:    environments,
+		Quotas:          quotas,
 	}
 	corePromise.Resolve(*core)
-	InitSync(core, taskScheduler)
+	sync.start(core)
 	return core
 }
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const deployTokenPrefix = "ldd_"
+
+// deployTokenPermissions maps deploy token actions to permissions they grant on the services of the token
+var deployTokenPermissions = map[openapi.DeployTokenAction]permission{
+	openapi.UpdateImage: updateServiceImages,
+	openapi.Restart:     restartServices,
+	openapi.ReadStatus:  viewProject,
+}
+
+// DeployTokens manages project deploy tokens used by CI pipelines.
+// Tokens belong to the project, so they keep working after the creator leaves the project
+type DeployTokens interface {
+	GetDeployTokens(projectId string, auth middleware.Authentication) ([]openapi.DeployToken, error)
+	CreateDeployToken(ctx context.Context, projectId string, token openapi.DeployToken, auth middleware.Authentication) (*openapi.DeployToken, error)
+	DeleteDeployToken(ctx context.Context, projectId string, id int, auth middleware.Authentication) error
+	Authenticate(token string) (*middleware.DeployToken, error)
+}
+
+type deployTokensImpl struct {
+	projects Projects
+	storage  *storage.Storage
+}
+
+var _ DeployTokens = (*deployTokensImpl)(nil)
+
+func InitDeployTokens(projects Projects, storage *storage.Storage) DeployTokens {
+	return &deployTokensImpl{projects: projects, storage: storage}
+}
+
+func (d deployTokensImpl) GetDeployTokens(projectId string, auth middleware.Authentication) ([]openapi.DeployToken, error) {
+	if err := d.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	entities, err := d.storage.DeployTokenRepository().FindByProjectId(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project deploy tokens")
+	}
+	tokens := make([]openapi.DeployToken, len(entities))
+	for i, entity := range entities {
+		services, err := d.storage.DeployTokenRepository().GetServices(entity.Id)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get deploy token services")
+		}
+		tokens[i] = deployTokenFromEntity(entity, services)
+	}
+	return tokens, nil
+}
+
+func (d deployTokensImpl) CreateDeployToken(
+	ctx context.Context,
+	projectId string,
+	token openapi.DeployToken,
+	auth middleware.Authentication,
+) (*openapi.DeployToken, error) {
+	if err := d.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
+		return nil, apperrors.BadRequest("Deploy token expiration time is in the past")
+	}
+	for _, action := range token.Actions {
+		if _, found := deployTokenPermissions[action]; !found {
+			return nil, apperrors.BadRequest(fmt.Sprintf("Unknown deploy token action %s", action))
+		}
+	}
+	exists, err := d.storage.DeployTokenRepository().ExistsByNameAndProjectId(token.Name, projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check if deploy token already exists")
+	}
+	if exists {
+		return nil, apperrors.BadRequest(fmt.Sprintf("Deploy token %s already exists in the project", token.Name))
+	}
+	services := slices.Clone(token.Services)
+	slices.Sort(services)
+	services = slices.Compact(services)
+	for _, serviceId := range services {
+		service, err := d.storage.ServiceRepository().FindByID(serviceId)
+		if apperrors.IsNotFound(err) || (err == nil && service.ProjectId != projectId) {
+			return nil, apperrors.BadRequest(fmt.Sprintf("Service %d is not found in the project", serviceId))
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to get service")
+		}
+	}
+
+	rawToken, err := randomString(alphanumeric, apiKeyLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate deploy token")
+	}
+	rawToken = deployTokenPrefix + rawToken
+	entity := storage.DeployTokenEntity{
+		ProjectId: projectId,
+		Name:      token.Name,
+		TokenHash: hashApiKey(rawToken),
+		Prefix:    rawToken[:apiKeyVisibleLength],
+		Actions:   mapItems(token.Actions, func(a openapi.DeployTokenAction) string { return string(a) }),
+		CreatedBy: auth.Username,
+		CreatedAt: time.Now(),
+	}
+	if token.ExpiresAt != nil {
+		entity.ExpiresAt = sql.NullTime{Time: *token.ExpiresAt, Valid: true}
+	}
+	err = d.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		id, err := s.DeployTokenRepository().CreateNew(entity)
+		if err != nil {
+			return err
+		}
+		entity.Id = id
+		return s.DeployTokenRepository().SetServices(id, services)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create deploy token")
+	}
+	recordAudit(d.storage, auth, auditEvent{project: projectId, resourceType: auditDeployToken, resourceId: strconv.Itoa(entity.Id),
+		action: "create", summary: fmt.Sprintf("name %s, actions %v, services %v", entity.Name, entity.Actions, services)})
+	log.Infof("Created deploy token %s in project %s", entity.Name, projectId)
+	result := deployTokenFromEntity(entity, services)
+	result.Token = &rawToken
+	return &result, nil
+}
+
+func (d deployTokensImpl) DeleteDeployToken(ctx context.Context, projectId string, id int, auth middleware.Authentication) error {
+	if err := d.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return err
+	}
+	if err := d.storage.DeployTokenRepository().Delete(projectId, id); err != nil {
+		return errors.Wrap(err, "failed to delete deploy token")
+	}
+	recordAudit(d.storage, auth, auditEvent{project: projectId, resourceType: auditDeployToken, resourceId: strconv.Itoa(id), action: "delete"})
+	log.Infof("Deleted deploy token %d in project %s", id, projectId)
+	return nil
+}
+
+func (d deployTokensImpl) Authenticate(token string) (*middleware.DeployToken, error) {
+	if !strings.HasPrefix(token, deployTokenPrefix) {
+		return nil, apperrors.Unauthorized("Invalid deploy token")
+	}
+	entity, err := d.storage.DeployTokenRepository().FindByTokenHash(hashApiKey(token))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get deploy token")
+	}
+	now := time.Now()
+	if entity.ExpiresAt.Valid && entity.ExpiresAt.Time.Before(now) {
+		return nil, apperrors.Unauthorized("Deploy token has expired")
+	}
+	if !entity.LastUsedAt.Valid || now.Sub(entity.LastUsedAt.Time) > apiKeyLastUsedPrecision {
+		if err := d.storage.DeployTokenRepository().UpdateLastUsedAt(entity.Id, now); err != nil {
+			log.WithError(err).Warnf("Failed to update last usage time of deploy token %s", entity.Name)
+		}
+	}
+	services, err := d.storage.DeployTokenRepository().GetServices(entity.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get deploy token services")
+	}
+	return &middleware.DeployToken{
+		Id:       entity.Id,
+		Name:     entity.Name,
+		Project:  entity.ProjectId,
+		Services: services,
+		Actions:  mapItems(entity.Actions, func(a string) openapi.DeployTokenAction { return openapi.DeployTokenAction(a) }),
+	}, nil
+}
+
+// checkDeployTokenAccess returns NotFound error if the service is not in the token scope
+// and Forbidden error if none of the token actions grants the permission
+func checkDeployTokenAccess(token *middleware.DeployToken, projectId string, serviceId int, permission permission) error {
+	if token.Project != projectId || !slices.Contains(token.Services, serviceId) {
+		return apperrors.NotFound(fmt.Sprintf("cannot find service with id %d", serviceId))
+	}
+	for _, action := range token.Actions {
+		if deployTokenPermissions[action] == permission {
+			return nil
+		}
+	}
+	return apperrors.Forbidden(fmt.Sprintf("Deploy token does not allow to %s", permission))
+}
+
+func deployTokenFromEntity(entity storage.DeployTokenEntity, services []int) openapi.DeployToken {
+	token := openapi.DeployToken{
+		Id:        &entity.Id,
+		Name:      entity.Name,
+		Prefix:    &entity.Prefix,
+		Services:  services,
+		Actions:   mapItems(entity.Actions, func(a string) openapi.DeployTokenAction { return openapi.DeployTokenAction(a) }),
+		CreatedBy: &entity.CreatedBy,
+		CreatedAt: &entity.CreatedAt,
+	}
+	if entity.ExpiresAt.Valid {
+		token.ExpiresAt = &entity.ExpiresAt.Time
+	}
+	if entity.LastUsedAt.Valid {
+		token.LastUsedAt = &entity.LastUsedAt.Time
+	}
+	return token
+}
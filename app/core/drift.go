@@ -0,0 +1,308 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	traefikCrd "github.com/traefik/traefik/v2/pkg/provider/kubernetes/crd/traefikio/v1alpha1"
+	appsV1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	applyConfigsAppsV1 "k8s.io/client-go/applyconfigurations/apps/v1"
+	applyConfigsCoreV1 "k8s.io/client-go/applyconfigurations/core/v1"
+	applyConfigsNetworkingV1 "k8s.io/client-go/applyconfigurations/networking/v1"
+	"k8s.io/client-go/kubernetes"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+const redactedValue = "<redacted>"
+
+// Drift compares K8s objects of the project with the desired state built from the database.
+// Desired objects are applied with server-side dry run, so the report contains only the changes
+// that the reconciliation would actually make
+type Drift interface {
+	GetProjectDrift(ctx context.Context, projectId string, auth middleware.Authentication) (*openapi.DriftReport, error)
+}
+
+type driftImpl struct {
+	projects  Projects
+	sources   []projectSynchronizable
+	clientset *kubernetes.Clientset
+}
+
+var _ Drift = (*driftImpl)(nil)
+
+func InitDrift(
+	projects Projects,
+	services Services,
+	managedServices ManagedServices,
+	registries ContainerRegistries,
+	clientset *kubernetes.Clientset,
+) Drift {
+	return &driftImpl{
+		projects:  projects,
+		sources:   []projectSynchronizable{projects, registries, services, managedServices},
+		clientset: clientset,
+	}
+}
+
+func (d driftImpl) GetProjectDrift(ctx context.Context, projectId string, auth middleware.Authentication) (*openapi.DriftReport, error) {
+	if err := d.projects.checkAccess(projectId, auth, editResources); err != nil {
+		return nil, err
+	}
+	report := &openapi.DriftReport{
+		Project:   projectId,
+		CheckedAt: time.Now(),
+		Objects:   []openapi.ObjectDrift{},
+	}
+	objects, err := desiredProjectObjects(d.sources, projectId)
+	if err != nil {
+		return nil, err
+	}
+	desired := make(map[string]bool)
+	for _, object := range objects {
+		drift, err := d.checkObject(ctx, projectId, object)
+		if err != nil {
+			return nil, err
+		}
+		desired[objectKey(drift.Kind, drift.Name)] = true
+		if drift.Status != "" {
+			report.Objects = append(report.Objects, *drift)
+		}
+	}
+	extra, err := d.findExtraObjects(ctx, projectId, desired)
+	if err != nil {
+		return nil, err
+	}
+	report.Objects = append(report.Objects, extra...)
+	report.InSync = len(report.Objects) == 0
+	return report, nil
+}
+
+// checkObject returns the drift of the object with empty status if the object is in sync
+func (d driftImpl) checkObject(ctx context.Context, namespace string, object any) (*openapi.ObjectDrift, error) {
+	switch o := object.(type) {
+	case *applyConfigsCoreV1.NamespaceApplyConfiguration:
+		client := d.clientset.CoreV1().Namespaces()
+		return compareObject(ctx, "Namespace", *o.Name, o, client.Apply, client.Get)
+	case *applyConfigsCoreV1.SecretApplyConfiguration:
+		client := d.clientset.CoreV1().Secrets(namespace)
+		return compareObject(ctx, "Secret", *o.Name, o, client.Apply, client.Get)
+	case *applyConfigsCoreV1.ResourceQuotaApplyConfiguration:
+		client := d.clientset.CoreV1().ResourceQuotas(namespace)
+		return compareObject(ctx, "ResourceQuota", *o.Name, o, client.Apply, client.Get)
+	case *applyConfigsCoreV1.LimitRangeApplyConfiguration:
+		client := d.clientset.CoreV1().LimitRanges(namespace)
+		return compareObject(ctx, "LimitRange", *o.Name, o, client.Apply, client.Get)
+	case *applyConfigsCoreV1.ServiceApplyConfiguration:
+		client := d.clientset.CoreV1().Services(namespace)
+		return compareObject(ctx, "Service", *o.Name, o, client.Apply, client.Get)
+	case *applyConfigsAppsV1.DeploymentApplyConfiguration:
+		client := d.clientset.AppsV1().Deployments(namespace)
+		return compareObject(ctx, "Deployment", *o.Name, o, client.Apply, client.Get)
+	case *applyConfigsAppsV1.StatefulSetApplyConfiguration:
+		client := d.clientset.AppsV1().StatefulSets(namespace)
+		return compareObject(ctx, "StatefulSet", *o.Name, o, client.Apply, client.Get)
+	case *applyConfigsNetworkingV1.IngressApplyConfiguration:
+		client := d.clientset.NetworkingV1().Ingresses(namespace)
+		return compareObject(ctx, "Ingress", *o.Name, o, client.Apply, client.Get)
+	case *traefikCrd.Middleware:
+		// Traefik middlewares are not applied server-side, so they cannot be compared with dry run
+		return &openapi.ObjectDrift{Kind: o.Kind, Name: o.Name}, nil
+	default:
+		return nil, errors.Errorf("unsupported desired object type %T", object)
+	}
+}
+
+func compareObject[C any, O any](
+	ctx context.Context,
+	kind string,
+	name string,
+	config C,
+	apply func(context.Context, C, metav1.ApplyOptions) (O, error),
+	get func(context.Context, string, metav1.GetOptions) (O, error),
+) (*openapi.ObjectDrift, error) {
+	drift := &openapi.ObjectDrift{Kind: kind, Name: name}
+	live, err := get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		drift.Status = openapi.DriftMissing
+		return drift, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s %s", kind, name)
+	}
+	desired, err := apply(ctx, config, metav1.ApplyOptions{
+		FieldManager: "letsdeploy",
+		Force:        true,
+		DryRun:       []string{metav1.DryRunAll},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to dry run apply of %s %s", kind, name)
+	}
+
+	liveFields, err := comparableFields(live)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to convert %s %s", kind, name)
+	}
+	desiredFields, err := comparableFields(desired)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to convert %s %s", kind, name)
+	}
+	changes := []openapi.FieldChange{}
+	diffFields("", liveFields, desiredFields, &changes)
+	if kind == "Secret" {
+		redactSecretChanges(changes)
+	}
+	if len(changes) > 0 {
+		drift.Status = openapi.DriftChanged
+		drift.Changes = &changes
+	}
+	return drift, nil
+}
+
+// comparableFields converts the object to unstructured form without fields that are maintained by K8s
+func comparableFields(object any) (map[string]interface{}, error) {
+	fields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, "status")
+	if metadata, ok := fields["metadata"].(map[string]interface{}); ok {
+		for _, field := range []string{"managedFields", "resourceVersion", "generation", "uid", "creationTimestamp"} {
+			delete(metadata, field)
+		}
+	}
+	return fields, nil
+}
+
+func diffFields(path string, live interface{}, desired interface{}, changes *[]openapi.FieldChange) {
+	liveMap, liveIsMap := live.(map[string]interface{})
+	desiredMap, desiredIsMap := desired.(map[string]interface{})
+	if liveIsMap && desiredIsMap {
+		keys := make([]string, 0, len(liveMap)+len(desiredMap))
+		for key := range liveMap {
+			keys = append(keys, key)
+		}
+		for key := range desiredMap {
+			if _, found := liveMap[key]; !found {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			diffFields(fieldPath(path, key), liveMap[key], desiredMap[key], changes)
+		}
+		return
+	}
+	liveSlice, liveIsSlice := live.([]interface{})
+	desiredSlice, desiredIsSlice := desired.([]interface{})
+	if liveIsSlice && desiredIsSlice && len(liveSlice) == len(desiredSlice) {
+		for i := range liveSlice {
+			diffFields(fmt.Sprintf("%s[%d]", path, i), liveSlice[i], desiredSlice[i], changes)
+		}
+		return
+	}
+	if reflect.DeepEqual(live, desired) {
+		return
+	}
+	change := openapi.FieldChange{Path: path}
+	if live != nil {
+		change.Live = &live
+	}
+	if desired != nil {
+		change.Desired = &desired
+	}
+	*changes = append(*changes, change)
+}
+
+// fieldPath appends the key to the path, keys of labels and annotations are quoted as they contain dots
+func fieldPath(path string, key string) string {
+	if strings.ContainsAny(key, "./") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func redactSecretChanges(changes []openapi.FieldChange) {
+	var redacted interface{} = redactedValue
+	for i, change := range changes {
+		if !strings.HasPrefix(change.Path, "data") && !strings.HasPrefix(change.Path, "stringData") {
+			continue
+		}
+		if change.Live != nil {
+			changes[i].Live = &redacted
+		}
+		if change.Desired != nil {
+			changes[i].Desired = &redacted
+		}
+	}
+}
+
+// findExtraObjects returns objects labeled as managed by letsdeploy that are not a part of the desired state
+func (d driftImpl) findExtraObjects(ctx context.Context, namespace string, desired map[string]bool) ([]openapi.ObjectDrift, error) {
+	options := metav1.ListOptions{LabelSelector: "letsdeploy.space/managed=true"}
+	names := make(map[string][]string)
+
+	secrets, err := d.clientset.CoreV1().Secrets(namespace).List(ctx, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get K8s secrets")
+	}
+	names["Secret"] = mapItems(secrets.Items, func(o v1.Secret) string { return o.Name })
+	services, err := d.clientset.CoreV1().Services(namespace).List(ctx, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get K8s services")
+	}
+	names["Service"] = mapItems(services.Items, func(o v1.Service) string { return o.Name })
+	deployments, err := d.clientset.AppsV1().Deployments(namespace).List(ctx, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get deployments list")
+	}
+	names["Deployment"] = mapItems(deployments.Items, func(o appsV1.Deployment) string { return o.Name })
+	statefulSets, err := d.clientset.AppsV1().StatefulSets(namespace).List(ctx, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get statefulsets list")
+	}
+	names["StatefulSet"] = mapItems(statefulSets.Items, func(o appsV1.StatefulSet) string { return o.Name })
+	ingresses, err := d.clientset.NetworkingV1().Ingresses(namespace).List(ctx, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get ingresses")
+	}
+	names["Ingress"] = mapItems(ingresses.Items, func(o networkingV1.Ingress) string { return o.Name })
+
+	var extra []openapi.ObjectDrift
+	for _, kind := range []string{"Secret", "Service", "Deployment", "StatefulSet", "Ingress"} {
+		for _, name := range names[kind] {
+			if !desired[objectKey(kind, name)] {
+				extra = append(extra, openapi.ObjectDrift{Kind: kind, Name: name, Status: openapi.DriftExtra})
+			}
+		}
+	}
+	return extra, nil
+}
+
+// desiredProjectObjects returns apply configurations of all K8s objects of the project in the order they are synced
+func desiredProjectObjects(sources []projectSynchronizable, projectId string) ([]any, error) {
+	var objects []any
+	for _, source := range sources {
+		sourceObjects, err := source.desiredObjects(projectId)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build desired state of the project")
+		}
+		objects = append(objects, sourceObjects...)
+	}
+	return objects, nil
+}
+
+func objectKey(kind string, name string) string {
+	return kind + "/" + name
+}
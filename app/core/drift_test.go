@@ -0,0 +1,112 @@
+package core
+
+import (
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"reflect"
+	"testing"
+)
+
+func fieldChange(path string, live interface{}, desired interface{}) openapi.FieldChange {
+	change := openapi.FieldChange{Path: path}
+	if live != nil {
+		change.Live = &live
+	}
+	if desired != nil {
+		change.Desired = &desired
+	}
+	return change
+}
+
+func TestDiffFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		live    interface{}
+		desired interface{}
+		want    []openapi.FieldChange
+	}{
+		{
+			name:    "Equal",
+			live:    map[string]interface{}{"replicas": float64(1), "ports": []interface{}{float64(80)}},
+			desired: map[string]interface{}{"replicas": float64(1), "ports": []interface{}{float64(80)}},
+			want:    nil,
+		},
+		{
+			name:    "ChangedAddedAndRemovedFields",
+			live:    map[string]interface{}{"b": "old", "c": "removed"},
+			desired: map[string]interface{}{"a": "added", "b": "new"},
+			want: []openapi.FieldChange{
+				fieldChange("a", nil, "added"),
+				fieldChange("b", "old", "new"),
+				fieldChange("c", "removed", nil),
+			},
+		},
+		{
+			name: "NestedFields",
+			live: map[string]interface{}{"spec": map[string]interface{}{
+				"containers": []interface{}{map[string]interface{}{"image": "app:1"}},
+			}},
+			desired: map[string]interface{}{"spec": map[string]interface{}{
+				"containers": []interface{}{map[string]interface{}{"image": "app:2"}},
+			}},
+			want: []openapi.FieldChange{fieldChange("spec.containers[0].image", "app:1", "app:2")},
+		},
+		{
+			name:    "SlicesOfDifferentLength",
+			live:    map[string]interface{}{"args": []interface{}{"a"}},
+			desired: map[string]interface{}{"args": []interface{}{"a", "b"}},
+			want:    []openapi.FieldChange{fieldChange("args", []interface{}{"a"}, []interface{}{"a", "b"})},
+		},
+		{
+			name:    "KeysWithDots",
+			live:    map[string]interface{}{"labels": map[string]interface{}{"letsdeploy.space/managed": "false"}},
+			desired: map[string]interface{}{"labels": map[string]interface{}{"letsdeploy.space/managed": "true"}},
+			want:    []openapi.FieldChange{fieldChange(`labels["letsdeploy.space/managed"]`, "false", "true")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []openapi.FieldChange
+			diffFields("", tt.live, tt.desired, &got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactSecretChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []openapi.FieldChange
+		want    []openapi.FieldChange
+	}{
+		{
+			name:    "Data",
+			changes: []openapi.FieldChange{fieldChange("data.password", "b2xk", "bmV3")},
+			want:    []openapi.FieldChange{fieldChange("data.password", redactedValue, redactedValue)},
+		},
+		{
+			name:    "StringData",
+			changes: []openapi.FieldChange{fieldChange(`stringData[".dockerconfigjson"]`, nil, "{}")},
+			want:    []openapi.FieldChange{fieldChange(`stringData[".dockerconfigjson"]`, nil, redactedValue)},
+		},
+		{
+			name:    "RemovedValue",
+			changes: []openapi.FieldChange{fieldChange("data.token", "dG9rZW4=", nil)},
+			want:    []openapi.FieldChange{fieldChange("data.token", redactedValue, nil)},
+		},
+		{
+			name:    "OtherFields",
+			changes: []openapi.FieldChange{fieldChange("metadata.labels.app", "a", "b")},
+			want:    []openapi.FieldChange{fieldChange("metadata.labels.app", "a", "b")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redactSecretChanges(tt.changes)
+			if !reflect.DeepEqual(tt.changes, tt.want) {
+				t.Errorf("redactSecretChanges() = %v, want %v", tt.changes, tt.want)
+			}
+		})
+	}
+}
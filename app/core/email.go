@@ -0,0 +1,49 @@
+package core
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// mailer sends plain text emails through the SMTP server configured by smtp.* properties
+type mailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+// newMailer returns nil if SMTP server is not configured
+func newMailer(cfg *viper.Viper) *mailer {
+	host := cfg.GetString("smtp.host")
+	if host == "" {
+		return nil
+	}
+	cfg.SetDefault("smtp.port", 587)
+	return &mailer{
+		addr:     net.JoinHostPort(host, strconv.Itoa(cfg.GetInt("smtp.port"))),
+		host:     host,
+		username: cfg.GetString("smtp.username"),
+		password: cfg.GetString("smtp.password"),
+		from:     cfg.GetString("smtp.from"),
+	}
+}
+
+func (m mailer) send(to []string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		m.from, strings.Join(to, ", "), subject, body)
+	if err := smtp.SendMail(m.addr, auth, m.from, to, []byte(msg)); err != nil {
+		return errors.Wrap(err, "failed to send email")
+	}
+	return nil
+}
@@ -0,0 +1,411 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Environments manages environments of projects (e.g. dev, staging and prod). Environment is a separate project
+// with its own namespace and host, cloned from the parent project with the environment overrides applied.
+// Environments are ordered, so that images can be promoted from one environment to the next one
+type Environments interface {
+	GetEnvironments(projectId string, auth middleware.Authentication) ([]openapi.ProjectEnvironment, error)
+	CreateEnvironment(
+		ctx context.Context,
+		projectId string,
+		environment openapi.ProjectEnvironment,
+		auth middleware.Authentication,
+	) (*openapi.ProjectEnvironment, error)
+	UpdateEnvironment(
+		ctx context.Context,
+		projectId string,
+		name string,
+		environment openapi.ProjectEnvironment,
+		auth middleware.Authentication,
+	) (*openapi.ProjectEnvironment, error)
+	DeleteEnvironment(ctx context.Context, projectId string, name string, auth middleware.Authentication) error
+	// PromoteService deploys the image of the service in the environment to the target environment,
+	// which is the next one in the promotion chain by default
+	PromoteService(
+		ctx context.Context,
+		projectId string,
+		name string,
+		promotion openapi.ServicePromotion,
+		wait bool,
+		timeout time.Duration,
+		auth middleware.Authentication,
+	) (*openapi.ServiceDeploymentResult, error)
+}
+
+type environmentsImpl struct {
+	projects  Projects
+	services  Services
+	manifests Manifests
+	clones    Clones
+	storage   *storage.Storage
+	sync      Sync
+	cfg       *viper.Viper
+}
+
+var _ Environments = (*environmentsImpl)(nil)
+
+func InitEnvironments(
+	projects Projects,
+	services Services,
+	manifests Manifests,
+	clones Clones,
+	storage *storage.Storage,
+	sync Sync,
+	cfg *viper.Viper,
+) Environments {
+	return &environmentsImpl{
+		projects:  projects,
+		services:  services,
+		manifests: manifests,
+		clones:    clones,
+		storage:   storage,
+		sync:      sync,
+		cfg:       cfg,
+	}
+}
+
+func (e environmentsImpl) GetEnvironments(projectId string, auth middleware.Authentication) ([]openapi.ProjectEnvironment, error) {
+	if err := e.projects.checkAccess(projectId, auth, viewProject); err != nil {
+		return nil, err
+	}
+	entities, err := e.storage.ProjectEnvironmentRepository().FindByParentId(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project environments")
+	}
+	return mapItems(entities, environmentFromEntity), nil
+}
+
+func (e environmentsImpl) CreateEnvironment(
+	ctx context.Context,
+	projectId string,
+	environment openapi.ProjectEnvironment,
+	auth middleware.Authentication,
+) (*openapi.ProjectEnvironment, error) {
+	if err := e.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	if err := e.checkParent(projectId); err != nil {
+		return nil, err
+	}
+	_, err := e.storage.ProjectEnvironmentRepository().FindByParentIdAndName(projectId, environment.Name)
+	if err == nil {
+		return nil, apperrors.BadRequest(fmt.Sprintf("Environment %s already exists", environment.Name))
+	} else if !apperrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to check if environment exists")
+	}
+	// environments and previews share the <name>.<parent> host
+	_, err = e.storage.PreviewEnvironmentRepository().FindByParentIdAndName(projectId, environment.Name)
+	if err == nil {
+		return nil, apperrors.BadRequest(fmt.Sprintf("Preview environment %s already exists", environment.Name))
+	} else if !apperrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to check if preview environment exists")
+	}
+	if err := e.checkDomain(environment.Domain, nil); err != nil {
+		return nil, err
+	}
+	target := fromPtr(environment.Project)
+	if target == "" {
+		suffix, err := randomString(lowercaseAlphanumeric, previewIdSuffixLength)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate environment project id")
+		}
+		target = environment.Name + "-" + suffix
+	}
+
+	overrides := environmentOverridesOf(environment, nil)
+	var entity *storage.ProjectEnvironmentEntity
+	hooks := cloneHooks{
+		manifest: func(manifest *openapi.ProjectManifest) error {
+			return applyEnvironmentOverrides(manifest, overrides)
+		},
+		// the environment is stored before services are created, so that their ingresses get the environment host
+		created: func(environmentId string) error {
+			entity, err = e.storage.ProjectEnvironmentRepository().CreateNew(storage.ProjectEnvironmentEntity{
+				ProjectId: environmentId,
+				ParentId:  sql.NullString{String: projectId, Valid: true},
+				Name:      environment.Name,
+				Domain:    toNullString(environment.Domain),
+				Overrides: overrides,
+			})
+			return err
+		},
+	}
+	if _, err := e.clones.cloneProject(ctx, projectId, openapi.ProjectClone{Target: target}, hooks, auth); err != nil {
+		return nil, errors.Wrapf(err, "failed to create environment %s", environment.Name)
+	}
+	recordAudit(e.storage, auth, auditEvent{project: projectId, resourceType: auditEnvironment, resourceId: environment.Name,
+		action: "create", summary: fmt.Sprintf("project %s", entity.ProjectId)})
+	log.Infof("Environment %s of project %s created as project %s", environment.Name, projectId, entity.ProjectId)
+	result := environmentFromEntity(*entity)
+	return &result, nil
+}
+
+func (e environmentsImpl) UpdateEnvironment(
+	ctx context.Context,
+	projectId string,
+	name string,
+	environment openapi.ProjectEnvironment,
+	auth middleware.Authentication,
+) (*openapi.ProjectEnvironment, error) {
+	if err := e.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	if environment.Name != name {
+		return nil, apperrors.BadRequest("Environment cannot be renamed")
+	}
+	entity, err := e.storage.ProjectEnvironmentRepository().FindByParentIdAndName(projectId, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkDomain(environment.Domain, entity); err != nil {
+		return nil, err
+	}
+	entity.Domain = toNullString(environment.Domain)
+	entity.Overrides = environmentOverridesOf(environment, &entity.Overrides)
+
+	// overrides are applied to the current state of the environment, so that the changes made in it are kept
+	manifest, err := e.manifests.GetProjectManifest(entity.ProjectId, auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get environment manifest")
+	}
+	if err := applyEnvironmentOverrides(manifest, entity.Overrides); err != nil {
+		return nil, err
+	}
+	if _, err := e.manifests.ApplyProjectManifest(ctx, entity.ProjectId, *manifest, false, false, auth); err != nil {
+		return nil, errors.Wrapf(err, "failed to apply overrides of environment %s", name)
+	}
+	if err := e.storage.ProjectEnvironmentRepository().Update(*entity); err != nil {
+		return nil, err
+	}
+	// ingresses and TLS certificate are updated by sync if the domain is changed
+	e.sync.Enqueue(entity.ProjectId)
+	recordAudit(e.storage, auth, auditEvent{project: projectId, resourceType: auditEnvironment, resourceId: name, action: "update"})
+	log.Infof("Environment %s of project %s updated", name, projectId)
+	result := environmentFromEntity(*entity)
+	return &result, nil
+}
+
+func (e environmentsImpl) DeleteEnvironment(ctx context.Context, projectId string, name string, auth middleware.Authentication) error {
+	if err := e.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return err
+	}
+	entity, err := e.storage.ProjectEnvironmentRepository().FindByParentIdAndName(projectId, name)
+	if err != nil {
+		return err
+	}
+	// the environment project is deleted only by its owner, as it can be the production one
+	if err := e.projects.DeleteProject(ctx, entity.ProjectId, auth); err != nil {
+		return errors.Wrapf(err, "failed to delete project of environment %s", name)
+	}
+	recordAudit(e.storage, auth, auditEvent{project: projectId, resourceType: auditEnvironment, resourceId: name, action: "delete"})
+	log.Infof("Environment %s of project %s deleted", name, projectId)
+	return nil
+}
+
+func (e environmentsImpl) PromoteService(
+	ctx context.Context,
+	projectId string,
+	name string,
+	promotion openapi.ServicePromotion,
+	wait bool,
+	timeout time.Duration,
+	auth middleware.Authentication,
+) (*openapi.ServiceDeploymentResult, error) {
+	if err := e.projects.checkAccess(projectId, auth, viewProject); err != nil {
+		return nil, err
+	}
+	environments, err := e.storage.ProjectEnvironmentRepository().FindByParentId(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project environments")
+	}
+	i := slices.IndexFunc(environments, func(env storage.ProjectEnvironmentEntity) bool { return env.Name == name })
+	if i < 0 {
+		return nil, apperrors.NotFound(fmt.Sprintf("Environment %s not found", name))
+	}
+	source := environments[i]
+	var target storage.ProjectEnvironmentEntity
+	if promotion.To != nil {
+		j := slices.IndexFunc(environments, func(env storage.ProjectEnvironmentEntity) bool { return env.Name == *promotion.To })
+		if j < 0 {
+			return nil, apperrors.BadRequest(fmt.Sprintf("Environment %s does not exist", *promotion.To))
+		}
+		target = environments[j]
+	} else if i+1 < len(environments) {
+		target = environments[i+1]
+	} else {
+		return nil, apperrors.BadRequest(fmt.Sprintf("Environment %s is the last one, target environment should be set", name))
+	}
+	if target.ProjectId == source.ProjectId {
+		return nil, apperrors.BadRequest("Service cannot be promoted to the same environment")
+	}
+
+	sourceService, err := e.findService(source, promotion.Service, auth)
+	if err != nil {
+		return nil, err
+	}
+	targetService, err := e.findService(target, promotion.Service, auth)
+	if err != nil {
+		return nil, err
+	}
+	deployment := openapi.ServiceDeployment{Image: &sourceService.Image}
+	result, err := e.services.DeployService(ctx, *targetService.Id, deployment, wait, timeout, auth)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to promote service %s to environment %s", promotion.Service, target.Name)
+	}
+	recordAudit(e.storage, auth, auditEvent{project: projectId, resourceType: auditEnvironment, resourceId: target.Name,
+		action: "promote", summary: fmt.Sprintf("service %s, image %s from %s", promotion.Service, sourceService.Image, source.Name)})
+	log.Infof("Service %s of project %s promoted from %s to %s", promotion.Service, projectId, source.Name, target.Name)
+	return result, nil
+}
+
+func (e environmentsImpl) findService(
+	environment storage.ProjectEnvironmentEntity,
+	name string,
+	auth middleware.Authentication,
+) (*openapi.Service, error) {
+	services, err := e.services.GetProjectServices(environment.ProjectId, auth)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get services of environment %s", environment.Name)
+	}
+	i := slices.IndexFunc(services, func(s openapi.Service) bool { return s.Name == name })
+	if i < 0 {
+		return nil, apperrors.NotFound(fmt.Sprintf("Service %s not found in environment %s", name, environment.Name))
+	}
+	return &services[i], nil
+}
+
+// checkParent returns BadRequest error if the project is an environment or a preview itself
+func (e environmentsImpl) checkParent(projectId string) error {
+	if _, err := e.storage.ProjectEnvironmentRepository().FindByProjectId(projectId); err == nil {
+		return apperrors.BadRequest("Environment cannot be created in another environment")
+	} else if !apperrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to check if project is an environment")
+	}
+	if _, err := e.storage.PreviewEnvironmentRepository().FindByProjectId(projectId); err == nil {
+		return apperrors.BadRequest("Environment cannot be created in a preview")
+	} else if !apperrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to check if project is a preview")
+	}
+	return nil
+}
+
+// checkDomain returns BadRequest error if the domain is used by another environment, belongs to the platform
+// or is not in the list of domains allowed by platform admins
+func (e environmentsImpl) checkDomain(domain *string, current *storage.ProjectEnvironmentEntity) error {
+	if domain == nil || (current != nil && current.Domain.Valid && current.Domain.String == *domain) {
+		return nil
+	}
+	if isSubdomain(*domain, baseDomain) {
+		return apperrors.BadRequest(fmt.Sprintf("Domain %s belongs to the platform", *domain))
+	}
+	allowed := slices.ContainsFunc(e.cfg.GetStringSlice("environments.allowed-domains"), func(allowed string) bool {
+		return isSubdomain(*domain, strings.ToLower(allowed))
+	})
+	if !allowed {
+		return apperrors.BadRequest(fmt.Sprintf("Domain %s is not allowed, ask platform admins to allow it", *domain))
+	}
+	exists, err := e.storage.ProjectEnvironmentRepository().ExistsByDomain(*domain)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return apperrors.BadRequest(fmt.Sprintf("Domain %s is already used", *domain))
+	}
+	return nil
+}
+
+// isSubdomain returns true if the domain is the parent domain or its subdomain
+func isSubdomain(domain string, parent string) bool {
+	return domain == parent || strings.HasSuffix(domain, "."+parent)
+}
+
+// environmentOverridesOf returns overrides of the environment, secret values are kept from the current overrides if not set
+func environmentOverridesOf(environment openapi.ProjectEnvironment, current *storage.EnvironmentOverrides) storage.EnvironmentOverrides {
+	overrides := storage.EnvironmentOverrides{
+		EnvVars:  fromPtr(environment.EnvOverrides),
+		Replicas: fromPtr(environment.Replicas),
+		Secrets:  fromPtr(environment.SecretOverrides),
+	}
+	if environment.SecretOverrides == nil && current != nil {
+		overrides.Secrets = current.Secrets
+	}
+	return overrides
+}
+
+func applyEnvironmentOverrides(manifest *openapi.ProjectManifest, overrides storage.EnvironmentOverrides) error {
+	services := fromPtr(manifest.Services)
+	if err := overrideEnvVars(services, overrides.EnvVars); err != nil {
+		return err
+	}
+	for serviceName, replicas := range overrides.Replicas {
+		i := slices.IndexFunc(services, func(s openapi.ManifestService) bool { return s.Name == serviceName })
+		if i < 0 {
+			return apperrors.BadRequest(fmt.Sprintf("Service %s of replicas overrides does not exist", serviceName))
+		}
+		services[i].Replicas = &replicas
+	}
+	secrets := fromPtr(manifest.Secrets)
+	for secretName, value := range overrides.Secrets {
+		i := slices.IndexFunc(secrets, func(s openapi.ManifestSecret) bool { return s.Name == secretName })
+		if i < 0 {
+			return apperrors.BadRequest(fmt.Sprintf("Secret %s of secret overrides does not exist", secretName))
+		}
+		secrets[i].Value = &value
+	}
+	return nil
+}
+
+func environmentHost(entity storage.ProjectEnvironmentEntity) string {
+	switch {
+	case entity.Domain.Valid:
+		return entity.Domain.String
+	case entity.ParentId.Valid:
+		return childProjectHost(entity.Name, entity.ParentId.String)
+	default:
+		return entity.ProjectId + "." + baseDomain
+	}
+}
+
+func environmentFromEntity(entity storage.ProjectEnvironmentEntity) openapi.ProjectEnvironment {
+	host := environmentHost(entity)
+	overriddenSecrets := make([]string, 0, len(entity.Overrides.Secrets))
+	for name := range entity.Overrides.Secrets {
+		overriddenSecrets = append(overriddenSecrets, name)
+	}
+	slices.Sort(overriddenSecrets)
+	environment := openapi.ProjectEnvironment{
+		Name:              entity.Name,
+		Project:           &entity.ProjectId,
+		Position:          &entity.Position,
+		Domain:            fromNullString(entity.Domain),
+		Host:              &host,
+		OverriddenSecrets: &overriddenSecrets,
+		CreatedAt:         &entity.CreatedAt,
+	}
+	if entity.ParentId.Valid {
+		environment.Parent = &entity.ParentId.String
+	}
+	if len(entity.Overrides.EnvVars) > 0 {
+		environment.EnvOverrides = &entity.Overrides.EnvVars
+	}
+	if len(entity.Overrides.Replicas) > 0 {
+		environment.Replicas = &entity.Overrides.Replicas
+	}
+	return environment
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"time"
+)
+
+const (
+	middlewaresAnnotation = "traefik.ingress.kubernetes.io/router.middlewares"
+	// releaseNamespacePlaceholder is replaced with Helm template after the chart templates are escaped
+	releaseNamespacePlaceholder = "__RELEASE_NAMESPACE__"
+	exportedChartVersion        = "0.1.0"
+)
+
+// Exports renders K8s objects of the project the way they are applied by sync,
+// so that the project can be deployed outside of the platform
+type Exports interface {
+	// ExportProject returns multi-document YAML or packaged Helm chart (tgz) depending on the format,
+	// secret values are exported only if redactSecrets is false
+	ExportProject(projectId string, format openapi.ExportFormat, redactSecrets bool, auth middleware.Authentication) ([]byte, error)
+}
+
+type exportsImpl struct {
+	projects Projects
+	sources  []projectSynchronizable
+	storage  *storage.Storage
+}
+
+// exportedObject is the K8s object converted to plain fields
+type exportedObject struct {
+	kind   string
+	name   string
+	fields map[string]interface{}
+}
+
+type chartFile struct {
+	name    string
+	content []byte
+}
+
+var _ Exports = (*exportsImpl)(nil)
+
+func InitExports(
+	projects Projects,
+	services Services,
+	managedServices ManagedServices,
+	registries ContainerRegistries,
+	storage *storage.Storage,
+) Exports {
+	return &exportsImpl{
+		projects: projects,
+		sources:  []projectSynchronizable{projects, registries, services, managedServices},
+		storage:  storage,
+	}
+}
+
+func (e exportsImpl) ExportProject(
+	projectId string,
+	format openapi.ExportFormat,
+	redactSecrets bool,
+	auth middleware.Authentication,
+) ([]byte, error) {
+	permission := viewProject
+	if !redactSecrets {
+		permission = viewSecrets
+	}
+	if err := e.projects.checkAccess(projectId, auth, permission); err != nil {
+		return nil, err
+	}
+	desired, err := desiredProjectObjects(e.sources, projectId)
+	if err != nil {
+		return nil, err
+	}
+	helm := format == openapi.ExportHelm
+	var objects []exportedObject
+	for _, object := range desired {
+		exported, err := exportObject(object, projectId, redactSecrets, helm)
+		if err != nil {
+			return nil, err
+		}
+		// the namespace is chosen on chart installation
+		if helm && exported.kind == "Namespace" {
+			continue
+		}
+		objects = append(objects, *exported)
+	}
+
+	var content []byte
+	if helm {
+		content, err = helmChart(projectId, objects)
+	} else {
+		content, err = yamlDocuments(objects)
+	}
+	if err != nil {
+		return nil, err
+	}
+	summary := fmt.Sprintf("format %s", format)
+	if !redactSecrets {
+		summary += ", with secret values"
+	}
+	recordAudit(e.storage, auth, auditEvent{project: projectId, resourceType: auditProject, resourceId: projectId,
+		action: "export", summary: summary})
+	return content, nil
+}
+
+func exportObject(object any, projectId string, redactSecrets bool, helm bool) (*exportedObject, error) {
+	serialized, err := json.Marshal(object)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to serialize K8s object")
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(serialized, &fields); err != nil {
+		return nil, errors.Wrap(err, "failed to serialize K8s object")
+	}
+	exported := &exportedObject{kind: fmt.Sprint(fields["kind"]), fields: fields}
+	metadata, _ := fields["metadata"].(map[string]interface{})
+	if metadata != nil {
+		exported.name = fmt.Sprint(metadata["name"])
+		if metadata["creationTimestamp"] == nil {
+			delete(metadata, "creationTimestamp")
+		}
+		if helm {
+			delete(metadata, "namespace")
+		}
+	}
+	if exported.kind == "Secret" && redactSecrets {
+		redactSecretFields(fields)
+	}
+	if helm && metadata != nil {
+		if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+			if ref, ok := annotations[middlewaresAnnotation].(string); ok {
+				// middleware reference contains the namespace of the middleware
+				annotations[middlewaresAnnotation] = releaseNamespacePlaceholder + "-" + strings.TrimPrefix(ref, projectId+"-")
+			}
+		}
+	}
+	return exported, nil
+}
+
+// redactSecretFields replaces values of the secret with placeholders keeping the keys
+func redactSecretFields(fields map[string]interface{}) {
+	stringData := make(map[string]interface{})
+	for _, field := range []string{"data", "stringData"} {
+		values, _ := fields[field].(map[string]interface{})
+		for key := range values {
+			stringData[key] = redactedValue
+		}
+		delete(fields, field)
+	}
+	if len(stringData) > 0 {
+		fields["stringData"] = stringData
+	}
+}
+
+func yamlDocuments(objects []exportedObject) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, object := range objects {
+		document, err := yaml.Marshal(object.fields)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to serialize %s %s", object.kind, object.name)
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(document)
+	}
+	return buf.Bytes(), nil
+}
+
+// helmChart packages objects as templates of Helm chart named after the project
+func helmChart(projectId string, objects []exportedObject) ([]byte, error) {
+	files := []chartFile{
+		{name: "Chart.yaml", content: []byte(fmt.Sprintf("apiVersion: v2\nname: %s\n"+
+			"description: Project %s exported from Letsdeploy\ntype: application\nversion: %s\n",
+			projectId, projectId, exportedChartVersion))},
+		{name: "values.yaml", content: []byte("{}\n")},
+	}
+	for _, object := range objects {
+		document, err := yaml.Marshal(object.fields)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to serialize %s %s", object.kind, object.name)
+		}
+		// values are rendered by Helm as is, template delimiters in them should not be evaluated
+		template := strings.ReplaceAll(string(document), "{{", `{{ "{{" }}`)
+		template = strings.ReplaceAll(template, releaseNamespacePlaceholder, "{{ .Release.Namespace }}")
+		files = append(files, chartFile{
+			name:    fmt.Sprintf("templates/%s-%s.yaml", strings.ToLower(object.kind), object.name),
+			content: []byte(template),
+		})
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, file := range files {
+		header := &tar.Header{
+			Name:    projectId + "/" + file.name,
+			Mode:    0644,
+			Size:    int64(len(file.content)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, errors.Wrap(err, "failed to write Helm chart")
+		}
+		if _, err := tw.Write(file.content); err != nil {
+			return nil, errors.Wrap(err, "failed to write Helm chart")
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to write Helm chart")
+	}
+	if err := gz.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to write Helm chart")
+	}
+	return buf.Bytes(), nil
+}
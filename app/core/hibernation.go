@@ -0,0 +1,277 @@
+package core
+
+import (
+	"codnect.io/chrono"
+	"context"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	gosync "sync"
+	"time"
+)
+
+// hibernationReloadInterval is the interval the leader picks up schedules changed on other replicas with
+const hibernationReloadInterval = time.Minute
+
+// Hibernation stops and starts projects by the schedule defined with cron expressions.
+// Cron expressions have 6 fields including seconds, e.g. "0 0 20 * * MON-FRI".
+// Schedules are run by the leader replica only
+type Hibernation interface {
+	GetSchedule(projectId string, auth middleware.Authentication) (*openapi.HibernationSchedule, error)
+	SetSchedule(ctx context.Context, projectId string, schedule openapi.HibernationSchedule, auth middleware.Authentication) (*openapi.HibernationSchedule, error)
+	DeleteSchedule(ctx context.Context, projectId string, auth middleware.Authentication) error
+}
+
+type hibernationImpl struct {
+	projects   Projects
+	storage    *storage.Storage
+	scheduler  chrono.TaskScheduler
+	leadership Leadership
+	// tasks holds scheduled stop and start tasks by project id
+	tasks map[string]scheduledHibernation
+	mutex *gosync.Mutex
+}
+
+// scheduledHibernation is the schedule the stop and start tasks are created by
+type scheduledHibernation struct {
+	schedule storage.HibernationScheduleEntity
+	tasks    []chrono.ScheduledTask
+}
+
+var _ Hibernation = (*hibernationImpl)(nil)
+
+func InitHibernation(
+	projects Projects,
+	storage *storage.Storage,
+	scheduler chrono.TaskScheduler,
+	leadership Leadership,
+) Hibernation {
+	h := &hibernationImpl{
+		projects:   projects,
+		storage:    storage,
+		scheduler:  scheduler,
+		leadership: leadership,
+		tasks:      make(map[string]scheduledHibernation),
+		mutex:      &gosync.Mutex{},
+	}
+	leadership.onElected(func() { h.reload(context.Background()) })
+	_, err := scheduler.ScheduleWithFixedDelay(h.reload, hibernationReloadInterval)
+	if err != nil {
+		log.WithError(err).Panicln("Unable to schedule hibernation schedules reload")
+	}
+	return h
+}
+
+func (h hibernationImpl) GetSchedule(projectId string, auth middleware.Authentication) (*openapi.HibernationSchedule, error) {
+	if err := h.projects.checkAccess(projectId, auth, viewProject); err != nil {
+		return nil, err
+	}
+	entity, err := h.storage.HibernationScheduleRepository().FindByProjectId(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get hibernation schedule")
+	}
+	return &openapi.HibernationSchedule{
+		StopCron:  entity.StopCron,
+		StartCron: entity.StartCron,
+		TimeZone:  &entity.TimeZone,
+		Enabled:   &entity.Enabled,
+	}, nil
+}
+
+func (h hibernationImpl) SetSchedule(
+	ctx context.Context,
+	projectId string,
+	schedule openapi.HibernationSchedule,
+	auth middleware.Authentication,
+) (*openapi.HibernationSchedule, error) {
+	if err := h.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	entity := storage.HibernationScheduleEntity{
+		ProjectId: projectId,
+		StopCron:  schedule.StopCron,
+		StartCron: schedule.StartCron,
+		TimeZone:  "UTC",
+		Enabled:   true,
+	}
+	if schedule.TimeZone != nil {
+		entity.TimeZone = *schedule.TimeZone
+	}
+	if schedule.Enabled != nil {
+		entity.Enabled = *schedule.Enabled
+	}
+	if _, err := time.LoadLocation(entity.TimeZone); err != nil {
+		return nil, apperrors.BadRequestWrap(err, fmt.Sprintf("Unknown time zone %s", entity.TimeZone))
+	}
+
+	err := h.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		if err := s.HibernationScheduleRepository().Save(entity); err != nil {
+			return err
+		}
+		if !entity.Enabled {
+			h.cancel(projectId)
+			return nil
+		}
+		// scheduling fails on invalid cron expression, so the schedule is not saved
+		if err := h.schedule(entity); err != nil {
+			return apperrors.BadRequestWrap(err, "Invalid hibernation schedule")
+		}
+		// the leader picks up the schedule on reload
+		if !h.leadership.IsLeader() {
+			h.cancel(projectId)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set hibernation schedule")
+	}
+	recordAudit(h.storage, auth, auditEvent{project: projectId, resourceType: auditHibernation, action: "update",
+		summary: fmt.Sprintf("stop '%s', start '%s' (%s), enabled: %t", entity.StopCron, entity.StartCron, entity.TimeZone, entity.Enabled)})
+	log.Infof("Hibernation schedule of project %s is set to stop at '%s' and start at '%s' (%s), enabled: %t",
+		projectId, entity.StopCron, entity.StartCron, entity.TimeZone, entity.Enabled)
+	return &openapi.HibernationSchedule{
+		StopCron:  entity.StopCron,
+		StartCron: entity.StartCron,
+		TimeZone:  &entity.TimeZone,
+		Enabled:   &entity.Enabled,
+	}, nil
+}
+
+func (h hibernationImpl) DeleteSchedule(ctx context.Context, projectId string, auth middleware.Authentication) error {
+	if err := h.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return err
+	}
+	err := h.storage.HibernationScheduleRepository().Delete(projectId)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete hibernation schedule")
+	}
+	h.cancel(projectId)
+	recordAudit(h.storage, auth, auditEvent{project: projectId, resourceType: auditHibernation, action: "delete"})
+	log.Infof("Hibernation schedule of project %s is deleted", projectId)
+	return nil
+}
+
+// schedule replaces previously scheduled tasks of the project if the new ones are scheduled successfully
+func (h hibernationImpl) schedule(schedule storage.HibernationScheduleEntity) error {
+	stopTask, err := h.scheduler.ScheduleWithCron(h.hibernationTask(schedule, true),
+		schedule.StopCron, chrono.WithLocation(schedule.TimeZone))
+	if err != nil {
+		return errors.Wrapf(err, "invalid stop cron expression '%s'", schedule.StopCron)
+	}
+	startTask, err := h.scheduler.ScheduleWithCron(h.hibernationTask(schedule, false),
+		schedule.StartCron, chrono.WithLocation(schedule.TimeZone))
+	if err != nil {
+		stopTask.Cancel()
+		return errors.Wrapf(err, "invalid start cron expression '%s'", schedule.StartCron)
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, task := range h.tasks[schedule.ProjectId].tasks {
+		task.Cancel()
+	}
+	h.tasks[schedule.ProjectId] = scheduledHibernation{schedule: schedule, tasks: []chrono.ScheduledTask{stopTask, startTask}}
+	return nil
+}
+
+func (h hibernationImpl) cancel(projectId string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, task := range h.tasks[projectId].tasks {
+		task.Cancel()
+	}
+	delete(h.tasks, projectId)
+}
+
+// reload schedules tasks of the enabled schedules stored in DB while the replica is the leader,
+// so that the changes made on other replicas are applied. Tasks are cancelled if the replica is not the leader
+func (h hibernationImpl) reload(ctx context.Context) {
+	if !h.leadership.IsLeader() {
+		for _, projectId := range h.scheduledProjects() {
+			h.cancel(projectId)
+		}
+		return
+	}
+	schedules, err := h.storage.HibernationScheduleRepository().FindAll()
+	if err != nil {
+		log.WithError(err).Errorln("Failed to load hibernation schedules")
+		return
+	}
+	enabled := make(map[string]storage.HibernationScheduleEntity)
+	for _, schedule := range schedules {
+		if schedule.Enabled {
+			enabled[schedule.ProjectId] = schedule
+		}
+	}
+	for _, projectId := range h.scheduledProjects() {
+		if _, found := enabled[projectId]; !found {
+			h.cancel(projectId)
+		}
+	}
+	for _, schedule := range enabled {
+		if h.isScheduled(schedule) {
+			continue
+		}
+		if err := h.schedule(schedule); err != nil {
+			log.WithError(err).Errorf("Failed to schedule hibernation of project %s, skipping", schedule.ProjectId)
+		}
+	}
+}
+
+func (h hibernationImpl) scheduledProjects() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	projectIds := make([]string, 0, len(h.tasks))
+	for projectId := range h.tasks {
+		projectIds = append(projectIds, projectId)
+	}
+	return projectIds
+}
+
+func (h hibernationImpl) isScheduled(schedule storage.HibernationScheduleEntity) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	scheduled, found := h.tasks[schedule.ProjectId]
+	return found && scheduled.schedule == schedule
+}
+
+// hibernationTask stops or starts the project only if the replica is the leader
+// and the schedule the task is created by is still current
+func (h hibernationImpl) hibernationTask(schedule storage.HibernationScheduleEntity, stop bool) chrono.Task {
+	projectId := schedule.ProjectId
+	return func(ctx context.Context) {
+		if !h.leadership.IsLeader() {
+			return
+		}
+		// schedule is deleted together with the project
+		current, err := h.storage.HibernationScheduleRepository().FindByProjectId(projectId)
+		if apperrors.IsNotFound(err) {
+			log.Infof("Hibernation schedule of project %s is not found, cancelling it", projectId)
+			h.cancel(projectId)
+			return
+		} else if err != nil {
+			log.WithError(err).Errorf("Failed to get hibernation schedule of project %s", projectId)
+			return
+		}
+		if *current != schedule {
+			// the schedule is changed on another replica, tasks are replaced on reload
+			log.Infof("Hibernation schedule of project %s is changed, skipping outdated task", projectId)
+			return
+		}
+
+		if stop {
+			log.Infof("Hibernating project %s by schedule", projectId)
+			err = h.projects.StopProject(ctx, projectId, middleware.ServiceAccount)
+		} else {
+			log.Infof("Waking up project %s by schedule", projectId)
+			err = h.projects.StartProject(ctx, projectId, middleware.ServiceAccount)
+		}
+		if err != nil {
+			log.WithError(err).Errorf("Scheduled hibernation of project %s failed", projectId)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package core
+
+import (
+	"codnect.io/chrono"
+	"context"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"slices"
+	"strings"
+	"time"
+)
+
+const maxIncidentNotificationEmails = 20
+
+// Incidents detects crashes and unhealthy states of project services in background on the leader replica.
+// Incident is opened when the service becomes unhealthy, its container is OOMKilled or restarts too often
+// and is resolved when the service is available again without new restarts
+type Incidents interface {
+	GetIncidents(projectId string, openOnly bool, limit int, offset int, auth middleware.Authentication) ([]openapi.Incident, error)
+	GetNotifications(projectId string, auth middleware.Authentication) (*openapi.IncidentNotifications, error)
+	SetNotifications(
+		ctx context.Context,
+		projectId string,
+		notifications openapi.IncidentNotifications,
+		auth middleware.Authentication,
+	) (*openapi.IncidentNotifications, error)
+}
+
+type incidentsImpl struct {
+	projects        Projects
+	services        Services
+	managedServices ManagedServices
+	storage         *storage.Storage
+	clientset       *kubernetes.Clientset
+	leadership      Leadership
+	mailer          *mailer
+	// restartSpikeThreshold is the number of restarts in restartSpikeWindow that opens an incident
+	restartSpikeThreshold int
+	restartSpikeWindow    time.Duration
+	// observations holds restarts observed by the watcher by service key, it is accessed only by the watcher task
+	observations map[string]*serviceObservation
+}
+
+// serviceObservation is the state of the service containers observed in the current restart window
+type serviceObservation struct {
+	windowStart    time.Time
+	windowRestarts int
+	lastOomKill    time.Time
+}
+
+// watchedService is the service or managed service checked by the watcher
+type watchedService struct {
+	kind    openapi.IncidentServiceKind
+	id      int
+	name    string
+	project string
+	image   string
+	// pods are values of app label of the service pods
+	pods   []string
+	status openapi.ServiceStatusStatus
+}
+
+func (w watchedService) key() string {
+	return fmt.Sprintf("%s/%d", w.kind, w.id)
+}
+
+var _ Incidents = (*incidentsImpl)(nil)
+
+func InitIncidents(
+	projects Projects,
+	services Services,
+	managedServices ManagedServices,
+	storage *storage.Storage,
+	clientset *kubernetes.Clientset,
+	leadership Leadership,
+	scheduler chrono.TaskScheduler,
+	cfg *viper.Viper,
+) Incidents {
+	cfg.SetDefault("incidents.check-interval", 30*time.Second)
+	cfg.SetDefault("incidents.restart-spike-threshold", 3)
+	cfg.SetDefault("incidents.restart-spike-window", 10*time.Minute)
+	i := &incidentsImpl{
+		projects:              projects,
+		services:              services,
+		managedServices:       managedServices,
+		storage:               storage,
+		clientset:             clientset,
+		leadership:            leadership,
+		mailer:                newMailer(cfg),
+		restartSpikeThreshold: cfg.GetInt("incidents.restart-spike-threshold"),
+		restartSpikeWindow:    cfg.GetDuration("incidents.restart-spike-window"),
+		observations:          make(map[string]*serviceObservation),
+	}
+	if i.mailer == nil {
+		log.Warnln("SMTP server is not configured, incident notifications will not be sent by email")
+	}
+	_, err := scheduler.ScheduleWithFixedDelay(i.watch, cfg.GetDuration("incidents.check-interval"))
+	if err != nil {
+		log.WithError(err).Panicln("Unable to schedule incident detection")
+	}
+	return i
+}
+
+func (i incidentsImpl) GetIncidents(
+	projectId string,
+	openOnly bool,
+	limit int,
+	offset int,
+	auth middleware.Authentication,
+) ([]openapi.Incident, error) {
+	if err := i.projects.checkAccess(projectId, auth, viewProject); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	entities, err := i.storage.IncidentRepository().FindByProjectId(projectId, openOnly, limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project incidents")
+	}
+	return mapItems(entities, incidentFromEntity), nil
+}
+
+func (i incidentsImpl) GetNotifications(projectId string, auth middleware.Authentication) (*openapi.IncidentNotifications, error) {
+	if err := i.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	entity, err := i.getNotifications(projectId)
+	if err != nil {
+		return nil, err
+	}
+	return incidentNotificationsFromEntity(*entity), nil
+}
+
+func (i incidentsImpl) SetNotifications(
+	ctx context.Context,
+	projectId string,
+	notifications openapi.IncidentNotifications,
+	auth middleware.Authentication,
+) (*openapi.IncidentNotifications, error) {
+	if err := i.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	entity := storage.IncidentNotificationEntity{
+		ProjectId: projectId,
+		Channels:  []string{},
+		Emails:    []string{},
+	}
+	for _, channel := range notifications.Channels {
+		if channel != openapi.ChannelWebhook && channel != openapi.ChannelEmail {
+			return nil, apperrors.BadRequest(fmt.Sprintf("Unknown notification channel %s", channel))
+		}
+		if !slices.Contains(entity.Channels, string(channel)) {
+			entity.Channels = append(entity.Channels, string(channel))
+		}
+	}
+	for _, email := range fromPtr(notifications.Emails) {
+		if !slices.Contains(entity.Emails, string(email)) {
+			entity.Emails = append(entity.Emails, string(email))
+		}
+	}
+	if len(entity.Emails) > maxIncidentNotificationEmails {
+		return nil, apperrors.BadRequest(fmt.Sprintf("At most %d emails can be notified", maxIncidentNotificationEmails))
+	}
+	if slices.Contains(entity.Channels, string(openapi.ChannelEmail)) && len(entity.Emails) == 0 {
+		return nil, apperrors.BadRequest("Email channel requires at least one email")
+	}
+	if err := i.storage.IncidentNotificationRepository().Save(entity); err != nil {
+		return nil, errors.Wrap(err, "failed to set incident notification settings")
+	}
+	recordAudit(i.storage, auth, auditEvent{project: projectId, resourceType: auditIncidentNotification, action: "update",
+		summary: fmt.Sprintf("channels %v, emails %v", entity.Channels, entity.Emails)})
+	log.Infof("Incident notifications of project %s are set to channels %v", projectId, entity.Channels)
+	return incidentNotificationsFromEntity(entity), nil
+}
+
+// getNotifications returns notification settings of the project, incidents are published to webhooks by default
+func (i incidentsImpl) getNotifications(projectId string) (*storage.IncidentNotificationEntity, error) {
+	entity, err := i.storage.IncidentNotificationRepository().FindByProjectId(projectId)
+	if apperrors.IsNotFound(err) {
+		return &storage.IncidentNotificationEntity{
+			ProjectId: projectId,
+			Channels:  []string{string(openapi.ChannelWebhook)},
+			Emails:    []string{},
+		}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get incident notification settings")
+	}
+	return entity, nil
+}
+
+// watch checks the status of all services and opens or resolves incidents on transitions
+func (i incidentsImpl) watch(ctx context.Context) {
+	if !i.leadership.IsLeader() {
+		// observations are collected again if the replica becomes the leader
+		clear(i.observations)
+		return
+	}
+	open, err := i.storage.IncidentRepository().FindOpen()
+	if err != nil {
+		log.WithError(err).Errorln("Failed to get open incidents")
+		return
+	}
+	openByService := make(map[string][]storage.IncidentEntity)
+	for _, incident := range open {
+		key := fmt.Sprintf("%s/%d", incident.ServiceKind, incident.ServiceId)
+		openByService[key] = append(openByService[key], incident)
+	}
+
+	limit := 1000
+	offset := 0
+	checked := make(map[string]bool)
+	for {
+		projects, err := i.projects.FindAll(limit, offset)
+		if err != nil {
+			log.WithError(err).Errorln("Failed to retrieve projects")
+			return
+		}
+		for _, project := range projects {
+			services, err := i.projectServices(ctx, project.Id)
+			if err != nil {
+				log.WithError(err).Errorf("Failed to check services of project %s, skipping", project.Id)
+				continue
+			}
+			for _, service := range services {
+				key := service.key()
+				checked[key] = true
+				if err := i.check(ctx, service, openByService[key]); err != nil {
+					log.WithError(err).Errorf("Failed to check %s %s in project %s", service.kind, service.name, service.project)
+				}
+			}
+		}
+		if len(projects) < limit {
+			break
+		}
+		offset += limit
+	}
+
+	now := time.Now()
+	for key, incidents := range openByService {
+		if checked[key] {
+			continue
+		}
+		// the service is deleted
+		delete(i.observations, key)
+		for _, incident := range incidents {
+			i.resolve(incident, now)
+		}
+	}
+}
+
+func (i incidentsImpl) projectServices(ctx context.Context, projectId string) ([]watchedService, error) {
+	var result []watchedService
+	services, err := i.storage.ServiceRepository().FindByProjectId(projectId)
+	if err != nil {
+		return nil, err
+	}
+	for _, entity := range services {
+		service, err := serviceFromEntity(entity)
+		if err != nil {
+			return nil, err
+		}
+		status, err := i.services.getServiceStatus(ctx, *service)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to get status of service %s in project %s", service.Name, projectId)
+			continue
+		}
+		result = append(result, watchedService{
+			kind:    openapi.IncidentService,
+			id:      entity.Id,
+			name:    entity.Name,
+			project: projectId,
+			image:   entity.Image,
+			pods:    []string{entity.Name},
+			status:  status.Status,
+		})
+	}
+	managedServices, err := i.storage.ManagedServiceRepository().FindByProjectId(projectId)
+	if err != nil {
+		return nil, err
+	}
+	for _, entity := range managedServices {
+		service := managedServiceFromEntity(entity)
+		status, err := i.managedServices.getManagedServiceStatus(ctx, service)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to get status of managed service %s in project %s", service.Name, projectId)
+			continue
+		}
+		components := i.managedServices.statefulSetComponents(service)
+		result = append(result, watchedService{
+			kind:    openapi.IncidentManagedService,
+			id:      entity.Id,
+			name:    entity.Name,
+			project: projectId,
+			pods:    mapItems(components, func(c statefulSetComponent) string { return c.name }),
+			status:  status.Status,
+		})
+	}
+	return result, nil
+}
+
+// check compares the current state of the service with the previous observation
+func (i incidentsImpl) check(ctx context.Context, service watchedService, open []storage.IncidentEntity) error {
+	now := time.Now()
+	if service.status == openapi.Stopped {
+		delete(i.observations, service.key())
+		for _, incident := range open {
+			i.resolve(incident, now)
+		}
+		return nil
+	}
+
+	restarts, lastOomKill, err := i.podRestarts(ctx, service)
+	if err != nil {
+		return err
+	}
+	observation, found := i.observations[service.key()]
+	if !found {
+		// history before the first observation is not reported
+		observation = &serviceObservation{windowStart: now, windowRestarts: restarts, lastOomKill: lastOomKill}
+		i.observations[service.key()] = observation
+	}
+	// restart counters are reset when pods are recreated
+	if restarts < observation.windowRestarts || now.Sub(observation.windowStart) > i.restartSpikeWindow {
+		observation.windowStart = now
+		observation.windowRestarts = restarts
+	}
+
+	isOpen := func(reason openapi.IncidentReason) bool {
+		return slices.ContainsFunc(open, func(incident storage.IncidentEntity) bool { return incident.Reason == string(reason) })
+	}
+	opened := false
+	if service.status == openapi.Unhealthy && !isOpen(openapi.IncidentUnhealthy) {
+		i.open(service, openapi.IncidentUnhealthy, "Service containers are crashing (CrashLoopBackOff)", now)
+		opened = true
+	}
+	if lastOomKill.After(observation.lastOomKill) {
+		observation.lastOomKill = lastOomKill
+		if !isOpen(openapi.IncidentOomKilled) {
+			i.open(service, openapi.IncidentOomKilled,
+				fmt.Sprintf("Container was killed at %s because it exceeded the memory limit", lastOomKill.Format(time.RFC3339)), now)
+			opened = true
+		}
+	}
+	if restarts-observation.windowRestarts >= i.restartSpikeThreshold {
+		if !isOpen(openapi.IncidentRestartSpike) {
+			i.open(service, openapi.IncidentRestartSpike, fmt.Sprintf("Containers restarted %d times in %s",
+				restarts-observation.windowRestarts, now.Sub(observation.windowStart).Round(time.Second)), now)
+			opened = true
+		}
+		observation.windowStart = now
+		observation.windowRestarts = restarts
+	}
+
+	if !opened && service.status == openapi.Available && restarts == observation.windowRestarts {
+		for _, incident := range open {
+			i.resolve(incident, now)
+		}
+	}
+	return nil
+}
+
+// podRestarts returns the total number of container restarts in the service pods and the time of the latest OOM kill
+func (i incidentsImpl) podRestarts(ctx context.Context, service watchedService) (int, time.Time, error) {
+	selector := fmt.Sprintf("app in (%s)", strings.Join(service.pods, ","))
+	list, err := i.clientset.CoreV1().Pods(service.project).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return 0, time.Time{}, errors.Wrap(err, "failed to get pods of "+service.name)
+	}
+	restarts := 0
+	var lastOomKill time.Time
+	for _, pod := range list.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			restarts += int(status.RestartCount)
+			terminated := status.LastTerminationState.Terminated
+			if terminated != nil && terminated.Reason == "OOMKilled" && terminated.FinishedAt.After(lastOomKill) {
+				lastOomKill = terminated.FinishedAt.Time
+			}
+		}
+	}
+	return restarts, lastOomKill, nil
+}
+
+func (i incidentsImpl) open(service watchedService, reason openapi.IncidentReason, details string, now time.Time) {
+	entity := storage.IncidentEntity{
+		ProjectId:   service.project,
+		ServiceKind: string(service.kind),
+		ServiceId:   service.id,
+		ServiceName: service.name,
+		Reason:      string(reason),
+		Details:     toNullString(&details),
+		OpenedAt:    now,
+	}
+	id, err := i.storage.IncidentRepository().CreateNew(entity)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to store %s incident of %s %s in project %s", reason, service.kind, service.name, service.project)
+		return
+	}
+	entity.Id = id
+	log.Warnf("Incident %d opened: %s %s in project %s is %s", id, service.kind, service.name, service.project, reason)
+	i.notify(entity)
+	if service.kind == openapi.IncidentService && reason == openapi.IncidentUnhealthy {
+		publishEvent(i.storage, service.project, openapi.ServiceUnhealthy, deploymentFinishedData{
+			Service:   service.name,
+			ServiceId: service.id,
+			Image:     service.image,
+			Status:    openapi.Unhealthy,
+		})
+	}
+}
+
+func (i incidentsImpl) resolve(incident storage.IncidentEntity, now time.Time) {
+	if err := i.storage.IncidentRepository().Resolve(incident.Id, now); err != nil {
+		log.WithError(err).Errorf("Failed to resolve incident %d", incident.Id)
+		return
+	}
+	incident.ResolvedAt.Time, incident.ResolvedAt.Valid = now, true
+	log.Infof("Incident %d resolved: %s %s in project %s", incident.Id, incident.ServiceKind, incident.ServiceName, incident.ProjectId)
+	i.notify(incident)
+}
+
+// notify publishes the incident to the channels configured in the project, failures are logged
+func (i incidentsImpl) notify(incident storage.IncidentEntity) {
+	settings, err := i.getNotifications(incident.ProjectId)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to notify about incident %d", incident.Id)
+		return
+	}
+	eventType, state := openapi.IncidentOpened, "opened"
+	if incident.ResolvedAt.Valid {
+		eventType, state = openapi.IncidentResolved, "resolved"
+	}
+	if slices.Contains(settings.Channels, string(openapi.ChannelWebhook)) {
+		publishEvent(i.storage, incident.ProjectId, eventType, incidentFromEntity(incident))
+	}
+	if slices.Contains(settings.Channels, string(openapi.ChannelEmail)) && len(settings.Emails) > 0 && i.mailer != nil {
+		subject := fmt.Sprintf("[letsdeploy] Incident %s: %s %s is %s", state, incident.ServiceKind, incident.ServiceName, incident.Reason)
+		body := fmt.Sprintf("Project: %s\nService: %s (%s)\nReason: %s\nDetails: %s\nOpened at: %s\n",
+			incident.ProjectId, incident.ServiceName, incident.ServiceKind, incident.Reason, incident.Details.String,
+			incident.OpenedAt.Format(time.RFC3339))
+		if incident.ResolvedAt.Valid {
+			body += fmt.Sprintf("Resolved at: %s\n", incident.ResolvedAt.Time.Format(time.RFC3339))
+		}
+		emails := slices.Clone(settings.Emails)
+		go func() {
+			if err := i.mailer.send(emails, subject, body); err != nil {
+				log.WithError(err).Errorf("Failed to send email about incident %d", incident.Id)
+			}
+		}()
+	}
+}
+
+func incidentFromEntity(entity storage.IncidentEntity) openapi.Incident {
+	incident := openapi.Incident{
+		Id:          entity.Id,
+		ServiceKind: openapi.IncidentServiceKind(entity.ServiceKind),
+		ServiceId:   entity.ServiceId,
+		ServiceName: entity.ServiceName,
+		Reason:      openapi.IncidentReason(entity.Reason),
+		Details:     fromNullString(entity.Details),
+		OpenedAt:    entity.OpenedAt,
+	}
+	if entity.ResolvedAt.Valid {
+		incident.ResolvedAt = &entity.ResolvedAt.Time
+	}
+	return incident
+}
+
+func incidentNotificationsFromEntity(entity storage.IncidentNotificationEntity) *openapi.IncidentNotifications {
+	emails := mapItems(entity.Emails, func(e string) openapi_types.Email { return openapi_types.Email(e) })
+	return &openapi.IncidentNotifications{
+		Channels: mapItems(entity.Channels, func(c string) openapi.IncidentNotificationsChannels { return openapi.IncidentNotificationsChannels(c) }),
+		Emails:   &emails,
+	}
+}
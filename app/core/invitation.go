@@ -0,0 +1,234 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Invitations manages invitations to join projects.
+// Each project has a default invitation (invite link) that grants developer role and never expires
+type Invitations interface {
+	GetInvitations(projectId string, auth middleware.Authentication) ([]openapi.Invitation, error)
+	CreateInvitation(ctx context.Context, projectId string, invitation openapi.Invitation, auth middleware.Authentication) (*openapi.Invitation, error)
+	RevokeInvitation(ctx context.Context, projectId string, id int, auth middleware.Authentication) error
+	RegenerateInviteCode(ctx context.Context, projectId string, auth middleware.Authentication) (string, error)
+	JoinProject(ctx context.Context, code string, auth middleware.Authentication) (*openapi.Project, error)
+}
+
+type invitationsImpl struct {
+	projects Projects
+	storage  *storage.Storage
+}
+
+var _ Invitations = (*invitationsImpl)(nil)
+
+func InitInvitations(projects Projects, storage *storage.Storage) Invitations {
+	return &invitationsImpl{projects: projects, storage: storage}
+}
+
+func (i invitationsImpl) GetInvitations(projectId string, auth middleware.Authentication) ([]openapi.Invitation, error) {
+	if err := i.projects.checkAccess(projectId, auth, manageMembers); err != nil {
+		return nil, err
+	}
+	entities, err := i.storage.InvitationRepository().FindByProjectId(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project invitations")
+	}
+	return mapItems(entities, invitationFromEntity), nil
+}
+
+func (i invitationsImpl) CreateInvitation(
+	ctx context.Context,
+	projectId string,
+	invitation openapi.Invitation,
+	auth middleware.Authentication,
+) (*openapi.Invitation, error) {
+	if !isValidRole(invitation.Role) {
+		return nil, apperrors.BadRequest(fmt.Sprintf("Unknown project role %s", invitation.Role))
+	}
+	if invitation.Role == openapi.Owner {
+		return nil, apperrors.BadRequest("Owner role can be granted only by transferring the project")
+	}
+	if invitation.Username != nil && invitation.EmailDomain != nil {
+		return nil, apperrors.BadRequest("Invitation can be restricted either to username or to email domain")
+	}
+	if invitation.ExpiresAt != nil && invitation.ExpiresAt.Before(time.Now()) {
+		return nil, apperrors.BadRequest("Invitation expiration time is in the past")
+	}
+	if err := i.projects.checkAccess(projectId, auth, manageMembers); err != nil {
+		return nil, err
+	}
+	if invitation.Role == openapi.Admin {
+		if err := i.projects.checkAccess(projectId, auth, manageAdmins); err != nil {
+			return nil, err
+		}
+	}
+
+	if invitation.EmailDomain != nil {
+		domain := strings.ToLower(strings.TrimPrefix(*invitation.EmailDomain, "@"))
+		invitation.EmailDomain = &domain
+	}
+
+	code, err := uuid.NewRandom()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate invite code")
+	}
+	entity := storage.InvitationEntity{
+		ProjectId:   projectId,
+		Code:        code.String(),
+		Role:        string(invitation.Role),
+		Username:    toNullString(invitation.Username),
+		EmailDomain: toNullString(invitation.EmailDomain),
+		CreatedBy:   sql.NullString{String: auth.Username, Valid: true},
+	}
+	if invitation.ExpiresAt != nil {
+		entity.ExpiresAt = sql.NullTime{Time: *invitation.ExpiresAt, Valid: true}
+	}
+	if invitation.MaxUses != nil {
+		entity.MaxUses = sql.NullInt32{Int32: int32(*invitation.MaxUses), Valid: true}
+	}
+	created, err := i.storage.InvitationRepository().CreateNew(entity)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create invitation")
+	}
+	recordAudit(i.storage, auth, auditEvent{project: projectId, resourceType: auditInvitation, resourceId: strconv.Itoa(created.Id),
+		action: "create", summary: "role " + created.Role})
+	log.Infof("Created invitation %d to project %s with role %s", created.Id, projectId, created.Role)
+	result := invitationFromEntity(*created)
+	return &result, nil
+}
+
+func (i invitationsImpl) RevokeInvitation(ctx context.Context, projectId string, id int, auth middleware.Authentication) error {
+	if err := i.projects.checkAccess(projectId, auth, manageMembers); err != nil {
+		return err
+	}
+	if err := i.storage.InvitationRepository().Delete(projectId, id); err != nil {
+		return errors.Wrap(err, "failed to revoke invitation")
+	}
+	recordAudit(i.storage, auth, auditEvent{project: projectId, resourceType: auditInvitation, resourceId: strconv.Itoa(id), action: "revoke"})
+	log.Infof("Revoked invitation %d to project %s", id, projectId)
+	return nil
+}
+
+// RegenerateInviteCode replaces the default invitation of the project
+func (i invitationsImpl) RegenerateInviteCode(ctx context.Context, projectId string, auth middleware.Authentication) (string, error) {
+	if err := i.projects.checkAccess(projectId, auth, manageMembers); err != nil {
+		return "", err
+	}
+	var code string
+	err := i.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		if err := s.InvitationRepository().DeleteDefault(projectId); err != nil {
+			return err
+		}
+		invitation, err := createDefaultInvitation(s, projectId, auth)
+		if err != nil {
+			return err
+		}
+		code = invitation.Code
+		return nil
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to regenerate invite code")
+	}
+	recordAudit(i.storage, auth, auditEvent{project: projectId, resourceType: auditInvitation, action: "regenerate-default"})
+	log.Infof("Regenerated invite code of project %s", projectId)
+	return code, nil
+}
+
+func (i invitationsImpl) JoinProject(ctx context.Context, code string, auth middleware.Authentication) (*openapi.Project, error) {
+	if auth.DeployToken != nil {
+		return nil, apperrors.Forbidden("Deploy token cannot join projects")
+	}
+	if _, err := uuid.Parse(code); err != nil {
+		return nil, apperrors.NotFound("Invitation not found")
+	}
+	invitation, err := i.storage.InvitationRepository().FindByCode(code)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find invitation")
+	}
+	if invitation.ExpiresAt.Valid && invitation.ExpiresAt.Time.Before(time.Now()) {
+		return nil, apperrors.Forbidden("Invitation has expired")
+	}
+	if invitation.Username.Valid && invitation.Username.String != auth.Username {
+		return nil, apperrors.Forbidden("Invitation is issued to another user")
+	}
+	if invitation.EmailDomain.Valid && !strings.HasSuffix(strings.ToLower(auth.Email), "@"+invitation.EmailDomain.String) {
+		return nil, apperrors.Forbidden(fmt.Sprintf("Invitation is issued to users with verified email in %s domain",
+			invitation.EmailDomain.String))
+	}
+
+	err = i.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		isParticipant, err := s.ProjectRepository().IsParticipant(invitation.ProjectId, auth.Username)
+		if err != nil {
+			return err
+		}
+		// participants keep their role and do not consume invitation uses
+		if isParticipant {
+			return nil
+		}
+		used, err := s.InvitationRepository().Use(invitation.Id)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return apperrors.Forbidden("Invitation has reached the maximum number of uses")
+		}
+		return s.ProjectRepository().AddParticipant(invitation.ProjectId, auth.Username, invitation.Role)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to add participant")
+	}
+	recordAudit(i.storage, auth, auditEvent{project: invitation.ProjectId, resourceType: auditParticipant, resourceId: auth.Username,
+		action: "create", summary: fmt.Sprintf("joined by invitation %d, role %s", invitation.Id, invitation.Role)})
+	publishEvent(i.storage, invitation.ProjectId, openapi.MemberChanged, memberChangedData{Username: auth.Username,
+		Change: "joined", Role: openapi.ProjectRole(invitation.Role), Actor: auth.Username})
+	log.Infof("User %s joined project %s with role %s", auth.Username, invitation.ProjectId, invitation.Role)
+	return &openapi.Project{Id: invitation.ProjectId}, nil
+}
+
+func createDefaultInvitation(s *storage.Storage, projectId string, auth middleware.Authentication) (*storage.InvitationEntity, error) {
+	code, err := uuid.NewRandom()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate invite code")
+	}
+	return s.InvitationRepository().CreateNew(storage.InvitationEntity{
+		ProjectId: projectId,
+		Code:      code.String(),
+		Role:      string(openapi.Developer),
+		IsDefault: true,
+		CreatedBy: sql.NullString{String: auth.Username, Valid: true},
+	})
+}
+
+func invitationFromEntity(entity storage.InvitationEntity) openapi.Invitation {
+	invitation := openapi.Invitation{
+		Id:          &entity.Id,
+		Code:        &entity.Code,
+		Role:        openapi.ProjectRole(entity.Role),
+		Uses:        &entity.Uses,
+		Username:    fromNullString(entity.Username),
+		EmailDomain: fromNullString(entity.EmailDomain),
+		Default:     &entity.IsDefault,
+		CreatedBy:   fromNullString(entity.CreatedBy),
+		CreatedAt:   &entity.CreatedAt,
+	}
+	if entity.ExpiresAt.Valid {
+		invitation.ExpiresAt = &entity.ExpiresAt.Time
+	}
+	if entity.MaxUses.Valid {
+		maxUses := int(entity.MaxUses.Int32)
+		invitation.MaxUses = &maxUses
+	}
+	return invitation
+}
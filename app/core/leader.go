@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"os"
+	gosync "sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	leaseDuration      = 15 * time.Second
+	leaseRenewDeadline = 10 * time.Second
+	leaseRetryPeriod   = 2 * time.Second
+)
+
+// Leadership elects the replica that reconciles K8s objects, watches services and runs hibernation schedules
+// using K8s Lease. If leader election is disabled with leader.enabled property, the replica is always the leader
+type Leadership interface {
+	IsLeader() bool
+	// Leader returns the identity of the current leader, empty if it is unknown yet
+	Leader() string
+	Identity() string
+	// onElected registers the callback called every time the replica becomes the leader,
+	// it is called immediately if the replica is the leader already
+	onElected(f func())
+}
+
+type leadershipImpl struct {
+	identity string
+	leader   *atomic.Bool
+	current  *string
+	elected  *[]func()
+	mutex    *gosync.RWMutex
+}
+
+var _ Leadership = (*leadershipImpl)(nil)
+
+func InitLeadership(clientset *kubernetes.Clientset, cfg *viper.Viper) Leadership {
+	hostname, _ := os.Hostname()
+	cfg.SetDefault("leader.identity", hostname)
+	cfg.SetDefault("leader.namespace", "letsdeploy")
+	cfg.SetDefault("leader.lease-name", "letsdeploy-leader")
+	identity := cfg.GetString("leader.identity")
+	l := &leadershipImpl{
+		identity: identity,
+		leader:   &atomic.Bool{},
+		current:  new(string),
+		elected:  &[]func(){},
+		mutex:    &gosync.RWMutex{},
+	}
+	if !cfg.GetBool("leader.enabled") {
+		log.Infoln("Leader election is disabled, the replica is the leader")
+		l.leader.Store(true)
+		l.setCurrent(identity)
+		return l
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: cfg.GetString("leader.lease-name"), Namespace: cfg.GetString("leader.namespace")},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   leaseRenewDeadline,
+		RetryPeriod:     leaseRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Infof("Replica %s became the leader", identity)
+				l.mutex.RLock()
+				defer l.mutex.RUnlock()
+				l.leader.Store(true)
+				for _, f := range *l.elected {
+					go f()
+				}
+			},
+			OnStoppedLeading: func() {
+				log.Warnf("Replica %s is not the leader anymore", identity)
+				l.leader.Store(false)
+			},
+			OnNewLeader: func(leader string) {
+				log.Infof("Current leader is %s", leader)
+				l.setCurrent(leader)
+			},
+		},
+	})
+	if err != nil {
+		log.WithError(err).Panicln("Unable to set up leader election")
+	}
+	go func() {
+		// Run returns when the leadership is lost, the replica keeps trying to acquire it again
+		for {
+			elector.Run(context.Background())
+		}
+	}()
+	return l
+}
+
+func (l leadershipImpl) IsLeader() bool {
+	return l.leader.Load()
+}
+
+func (l leadershipImpl) Leader() string {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return *l.current
+}
+
+func (l leadershipImpl) Identity() string {
+	return l.identity
+}
+
+func (l leadershipImpl) onElected(f func()) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	*l.elected = append(*l.elected, f)
+	if l.leader.Load() {
+		go f()
+	}
+}
+
+func (l leadershipImpl) setCurrent(leader string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	*l.current = leader
+}
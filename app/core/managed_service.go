@@ -1,67 +1,106 @@
 package core
 
 import (
+	"codnect.io/chrono"
 	"context"
 	"fmt"
 	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/infrastructure/k8s"
 	"github.com/kuzznya/letsdeploy/app/middleware"
 	"github.com/kuzznya/letsdeploy/app/storage"
 	"github.com/kuzznya/letsdeploy/internal/openapi"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	appsV1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	applyConfigsAppsV1 "k8s.io/client-go/applyconfigurations/apps/v1"
 	applyConfigsCoreV1 "k8s.io/client-go/applyconfigurations/core/v1"
 	applyConfigsMetaV1 "k8s.io/client-go/applyconfigurations/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"math/rand"
+	"k8s.io/client-go/rest"
+	"path"
+	"strings"
+	"time"
 )
 
-type managedServiceParams struct {
-	image    string
-	username string
-	podPort  int
-}
-
-var managedServices = map[openapi.ManagedServiceType]managedServiceParams{
-	openapi.Postgres: {image: "postgres:15", username: "postgres", podPort: 5432},
-	openapi.Mysql:    {image: "mysql:8", username: "root", podPort: 3306},
-	openapi.Mongo:    {image: "mongo:6", username: "root", podPort: 27017},
-	openapi.Redis:    {image: "redis:7", username: "", podPort: 6379},
-	openapi.Rabbitmq: {image: "rabbitmq:3-management", username: "guest", podPort: 5672},
-}
+const managedServicePasswordLength = 16
 
 type ManagedServices interface {
 	projectSynchronizable
 	GetProjectManagedServices(project string, auth middleware.Authentication) ([]openapi.ManagedService, error)
 	CreateManagedService(ctx context.Context, service openapi.ManagedService, auth middleware.Authentication) (*openapi.ManagedService, error)
 	GetManagedService(id int, auth middleware.Authentication) (*openapi.ManagedService, error)
+	UpdateManagedService(ctx context.Context, service openapi.ManagedService, auth middleware.Authentication) (*openapi.ManagedService, error)
 	DeleteManagedService(ctx context.Context, id int, auth middleware.Authentication) error
 	GetManagedServiceStatus(ctx context.Context, id int, auth middleware.Authentication) (*openapi.ServiceStatus, error)
+	RotatePassword(ctx context.Context, id int, restartServices bool, auth middleware.Authentication) error
+	StopManagedService(ctx context.Context, id int, auth middleware.Authentication) error
+	StartManagedService(ctx context.Context, id int, auth middleware.Authentication) error
+	GetManagedServiceTypes() []openapi.ManagedServiceTypeInfo
+	getManagedService(id int, auth middleware.Authentication, permission permission) (*openapi.ManagedService, error)
+	getManagedServiceStatus(ctx context.Context, service openapi.ManagedService) (*openapi.ServiceStatus, error)
+	statefulSetComponents(service openapi.ManagedService) []statefulSetComponent
+	// resourceUsage returns resources of the managed service counted against the project quota
+	resourceUsage(service openapi.ManagedService) quotaUsage
+	withStorage(s *storage.Storage) ManagedServices
 }
 
 type managedServicesImpl struct {
-	projects  Projects
-	storage   *storage.Storage
-	clientset *kubernetes.Clientset
+	projects   Projects
+	services   Services
+	quotas     Quotas
+	storage    *storage.Storage
+	clientset  *kubernetes.Clientset
+	restConfig *rest.Config
+	leadership Leadership
+	types      map[openapi.ManagedServiceType]managedServiceType
+	// failoverWaits holds the time since when Redis with Sentinel has no primary, by <namespace>/<name>
+	failoverWaits map[string]time.Time
 }
 
 var _ ManagedServices = (*managedServicesImpl)(nil)
 
 func InitManagedServices(
 	projects Projects,
+	services Services,
+	quotas Quotas,
 	storage *storage.Storage,
 	clientset *kubernetes.Clientset,
+	leadership Leadership,
+	scheduler chrono.TaskScheduler,
+	cfg *viper.Viper,
 ) ManagedServices {
-	return &managedServicesImpl{projects: projects, storage: storage, clientset: clientset}
+	types, err := loadManagedServiceTypes(cfg)
+	if err != nil {
+		log.WithError(err).Panicln("Failed to load managed service types")
+	}
+	cfg.SetDefault("managed-services.failover-check-interval", 10*time.Second)
+	m := &managedServicesImpl{
+		projects:      projects,
+		services:      services,
+		quotas:        quotas,
+		storage:       storage,
+		clientset:     clientset,
+		restConfig:    k8s.SetupConfig(cfg),
+		leadership:    leadership,
+		types:         types,
+		failoverWaits: make(map[string]time.Time),
+	}
+	_, err = scheduler.ScheduleWithFixedDelay(m.watchRedisFailover, cfg.GetDuration("managed-services.failover-check-interval"))
+	if err != nil {
+		log.WithError(err).Panicln("Unable to schedule Redis failover watcher")
+	}
+	return m
 }
 
 func (m managedServicesImpl) GetProjectManagedServices(project string, auth middleware.Authentication) ([]openapi.ManagedService, error) {
-	if err := m.projects.checkAccess(project, auth); err != nil {
+	if err := m.projects.checkAccess(project, auth, viewProject); err != nil {
 		return nil, err
 	}
 	entities, err := m.storage.ManagedServiceRepository().FindByProjectId(project)
@@ -70,22 +109,36 @@ func (m managedServicesImpl) GetProjectManagedServices(project string, auth midd
 	}
 	services := make([]openapi.ManagedService, len(entities))
 	for i, entity := range entities {
-		id := entity.Id
-		services[i] = openapi.ManagedService{
-			Id:      &id,
-			Name:    entity.Name,
-			Project: entity.ProjectId,
-			Type:    openapi.ManagedServiceType(entity.Type),
-		}
+		services[i] = managedServiceFromEntity(entity)
 	}
 	return services, nil
 }
 
 func (m managedServicesImpl) CreateManagedService(ctx context.Context, service openapi.ManagedService, auth middleware.Authentication) (*openapi.ManagedService, error) {
-	if err := m.projects.checkAccess(service.Project, auth); err != nil {
+	if err := m.projects.checkAccess(service.Project, auth, editResources); err != nil {
+		return nil, err
+	}
+	if _, found := m.types[service.Type]; !found {
+		return nil, apperrors.BadRequest(fmt.Sprintf("Unknown managed service type %s", service.Type))
+	}
+	if service.Replicas == nil {
+		replicas := 1
+		service.Replicas = &replicas
+	}
+	if err := m.validateReplicas(service); err != nil {
+		return nil, err
+	}
+	if err := m.quotas.checkManagedService(service, nil); err != nil {
 		return nil, err
 	}
-	entity := storage.ManagedServiceEntity{ProjectId: service.Project, Name: service.Name, Type: string(service.Type)}
+	stopped := false
+	service.Stopped = &stopped
+	entity := storage.ManagedServiceEntity{
+		ProjectId: service.Project,
+		Name:      service.Name,
+		Type:      string(service.Type),
+		Replicas:  *service.Replicas,
+	}
 	err := m.storage.ExecTx(ctx, func(s *storage.Storage) error {
 		id, err := s.ManagedServiceRepository().CreateNew(entity)
 		if err != nil {
@@ -102,24 +155,75 @@ func (m managedServicesImpl) CreateManagedService(ctx context.Context, service o
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create managed service")
 	}
+	recordAudit(m.storage, auth, auditEvent{project: service.Project, resourceType: auditManagedService, resourceId: service.Name,
+		action: "create", summary: fmt.Sprintf("type %s, replicas %d", service.Type, *service.Replicas)})
 	log.Infof("Created managed service %s in project %s", service.Name, service.Project)
 	return &service, nil
 }
 
+func (m managedServicesImpl) withStorage(s *storage.Storage) ManagedServices {
+	m.storage = s
+	m.projects = m.projects.withStorage(s)
+	m.services = m.services.withStorage(s)
+	m.quotas = m.quotas.withStorage(s)
+	return m
+}
+
 func (m managedServicesImpl) GetManagedService(id int, auth middleware.Authentication) (*openapi.ManagedService, error) {
+	return m.getManagedService(id, auth, viewProject)
+}
+
+// getManagedService returns the managed service if the user has the permission in its project
+func (m managedServicesImpl) getManagedService(id int, auth middleware.Authentication, permission permission) (*openapi.ManagedService, error) {
 	entity, err := m.storage.ManagedServiceRepository().FindByID(id)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get managed service by id")
 	}
-	if err := m.projects.checkAccess(entity.ProjectId, auth); err != nil {
+	if err := m.projects.checkAccess(entity.ProjectId, auth, permission); err != nil {
 		return nil, err
 	}
-	return &openapi.ManagedService{
-		Id:      &entity.Id,
-		Name:    entity.Name,
-		Project: entity.ProjectId,
-		Type:    openapi.ManagedServiceType(entity.Type),
-	}, nil
+	service := managedServiceFromEntity(*entity)
+	return &service, nil
+}
+
+func (m managedServicesImpl) UpdateManagedService(ctx context.Context, service openapi.ManagedService, auth middleware.Authentication) (*openapi.ManagedService, error) {
+	existing, err := m.getManagedService(*service.Id, auth, editResources)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get managed service")
+	}
+	if service.Project != existing.Project || service.Name != existing.Name || service.Type != existing.Type {
+		return nil, apperrors.BadRequest("Only replicas of managed service can be changed")
+	}
+	if service.Replicas == nil {
+		service.Replicas = existing.Replicas
+	}
+	service.Stopped = existing.Stopped
+	if err := m.validateReplicas(service); err != nil {
+		return nil, err
+	}
+	if err := m.quotas.checkManagedService(service, existing); err != nil {
+		return nil, err
+	}
+	entity := storage.ManagedServiceEntity{
+		Id:        *service.Id,
+		ProjectId: service.Project,
+		Name:      service.Name,
+		Type:      string(service.Type),
+		Replicas:  *service.Replicas,
+	}
+	err = m.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		if err := s.ManagedServiceRepository().Update(entity); err != nil {
+			return err
+		}
+		return m.createManagedServiceDeployment(ctx, s, service)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update managed service")
+	}
+	recordAudit(m.storage, auth, auditEvent{project: service.Project, resourceType: auditManagedService, resourceId: service.Name,
+		action: "update", summary: fmt.Sprintf("replicas %d -> %d", *existing.Replicas, *service.Replicas)})
+	log.Infof("Updated managed service %s in project %s, replicas: %d", service.Name, service.Project, *service.Replicas)
+	return &service, nil
 }
 
 func (m managedServicesImpl) DeleteManagedService(ctx context.Context, id int, auth middleware.Authentication) error {
@@ -129,7 +233,7 @@ func (m managedServicesImpl) DeleteManagedService(ctx context.Context, id int, a
 	} else if err != nil {
 		return errors.Wrap(err, "failed to get managed service by id")
 	}
-	if err := m.projects.checkAccess(entity.ProjectId, auth); err != nil {
+	if err := m.projects.checkAccess(entity.ProjectId, auth, editResources); err != nil {
 		return err
 	}
 	err = m.storage.ExecTx(ctx, func(s *storage.Storage) error {
@@ -146,6 +250,7 @@ func (m managedServicesImpl) DeleteManagedService(ctx context.Context, id int, a
 	if err != nil {
 		return errors.Wrap(err, "failed to delete managed service")
 	}
+	recordAudit(m.storage, auth, auditEvent{project: entity.ProjectId, resourceType: auditManagedService, resourceId: entity.Name, action: "delete"})
 	log.Infof("Deleted managed service %s in project %s", entity.Name, entity.ProjectId)
 	return nil
 }
@@ -155,30 +260,71 @@ func (m managedServicesImpl) GetManagedServiceStatus(ctx context.Context, id int
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get managed service")
 	}
+	return m.getManagedServiceStatus(ctx, *service)
+}
+
+func (m managedServicesImpl) getManagedServiceStatus(ctx context.Context, service openapi.ManagedService) (*openapi.ServiceStatus, error) {
+	id := *service.Id
+	if *service.Stopped {
+		return &openapi.ServiceStatus{Id: id, Status: openapi.Stopped}, nil
+	}
+
+	components := m.statefulSetComponents(service)
+	instances := make([]openapi.ServiceInstanceStatus, len(components))
+	for i, component := range components {
+		set, status, err := m.getStatefulSetStatus(ctx, service.Project, component.name)
+		if err != nil {
+			return nil, err
+		}
+		instances[i] = openapi.ServiceInstanceStatus{
+			Role:          component.role,
+			Replicas:      int(set.Status.Replicas),
+			ReadyReplicas: int(set.Status.ReadyReplicas),
+			Status:        status,
+		}
+	}
+	return &openapi.ServiceStatus{Id: id, Status: aggregateStatus(instances), Instances: &instances}, nil
+}
 
-	set, err := m.clientset.AppsV1().StatefulSets(service.Project).Get(ctx, service.Name, metav1.GetOptions{})
+// aggregateStatus returns unhealthy if any of the instances is unhealthy,
+// progressing if any of the instances is progressing and available otherwise
+func aggregateStatus(instances []openapi.ServiceInstanceStatus) openapi.ServiceStatusStatus {
+	status := openapi.Available
+	for _, instance := range instances {
+		if instance.Status == openapi.Unhealthy {
+			return openapi.Unhealthy
+		}
+		if instance.Status == openapi.Progressing {
+			status = openapi.Progressing
+		}
+	}
+	return status
+}
+
+func (m managedServicesImpl) getStatefulSetStatus(ctx context.Context, namespace string, name string) (*appsV1.StatefulSet, openapi.ServiceStatusStatus, error) {
+	set, err := m.clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get managed service stateful set")
+		return nil, "", errors.Wrap(err, "failed to get managed service stateful set")
 	}
 
 	if set.Generation > set.Status.ObservedGeneration {
-		log.Debugf("Managed service %s generation is greater than observed generation, deployment is progressing", service.Name)
-		return &openapi.ServiceStatus{Id: id, Status: openapi.Progressing}, nil
+		log.Debugf("Managed service %s generation is greater than observed generation, deployment is progressing", name)
+		return set, openapi.Progressing, nil
 	}
 	if set.Spec.Replicas != nil && set.Status.UpdatedReplicas < *set.Spec.Replicas {
-		log.Debugf("Managed service %s updated replicas is less than expected, deployment is progressing", service.Name)
-		return &openapi.ServiceStatus{Id: id, Status: openapi.Progressing}, nil
+		log.Debugf("Managed service %s updated replicas is less than expected, deployment is progressing", name)
+		return set, openapi.Progressing, nil
 	}
 	if set.Status.Replicas > set.Status.UpdatedReplicas {
-		list, err := m.clientset.CoreV1().Pods(service.Project).List(ctx, metav1.ListOptions{LabelSelector: "app=" + service.Name})
+		list, err := m.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + name})
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to find a pod for managed service "+service.Name)
+			return nil, "", errors.Wrap(err, "failed to find a pod for managed service "+name)
 		}
 		if len(list.Items) == 0 {
-			return nil, apperrors.InternalServerError("failed to find a pod for managed service " + service.Name)
+			return nil, "", apperrors.InternalServerError("failed to find a pod for managed service " + name)
 		}
 
-		log.Debugf("Managed service %s old replicas are waiting termination", service.Name)
+		log.Debugf("Managed service %s old replicas are waiting termination", name)
 
 		newestPod := list.Items[0]
 		for _, pod := range list.Items {
@@ -188,26 +334,152 @@ func (m managedServicesImpl) GetManagedServiceStatus(ctx context.Context, id int
 		}
 
 		if len(newestPod.Status.ContainerStatuses) == 0 {
-			return &openapi.ServiceStatus{Id: id, Status: openapi.Progressing}, nil
+			return set, openapi.Progressing, nil
 		}
 
 		podState := newestPod.Status.ContainerStatuses[0].State
 		if podState.Waiting != nil && podState.Waiting.Reason == "CrashLoopBackOff" {
-			log.Debugf("Managed service %s pod %s is unhealthy", service.Name, newestPod.Name)
-			return &openapi.ServiceStatus{Id: id, Status: openapi.Unhealthy}, nil
+			log.Debugf("Managed service %s pod %s is unhealthy", name, newestPod.Name)
+			return set, openapi.Unhealthy, nil
 		}
 
-		return &openapi.ServiceStatus{Id: id, Status: openapi.Progressing}, nil
+		return set, openapi.Progressing, nil
 	}
 	if set.Status.AvailableReplicas < set.Status.UpdatedReplicas {
 		log.Debugf("Managed service %s %d of %d updated replicas are available",
-			service.Name, set.Status.AvailableReplicas, set.Status.UpdatedReplicas)
-		return &openapi.ServiceStatus{Id: id, Status: openapi.Progressing}, nil
+			name, set.Status.AvailableReplicas, set.Status.UpdatedReplicas)
+		return set, openapi.Progressing, nil
+	}
+	return set, openapi.Available, nil
+}
+
+func (m managedServicesImpl) RotatePassword(ctx context.Context, id int, restartServices bool, auth middleware.Authentication) error {
+	service, err := m.getManagedService(id, auth, editResources)
+	if err != nil {
+		return errors.Wrap(err, "failed to get managed service")
+	}
+	if !m.types[service.Type].supportsPasswordRotation() {
+		return apperrors.BadRequest(fmt.Sprintf("Password rotation is not supported for %s", service.Type))
+	}
+	status, err := m.GetManagedServiceStatus(ctx, id, auth)
+	if err != nil {
+		return errors.Wrap(err, "failed to get managed service status")
+	}
+	if status.Status != openapi.Available {
+		return apperrors.BadRequest("Managed service is not available")
+	}
+
+	password, err := randomString(alphanumeric, managedServicePasswordLength)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate new password")
+	}
+
+	definition := m.types[service.Type]
+	byRestart := m.rotatesPasswordByRestart(*service)
+	var oldPassword string
+	engineChanged := false
+	err = m.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		secret, err := s.SecretRepository().FindByProjectIdAndName(service.Project, getManagedServiceSecretName(service.Name))
+		if err != nil {
+			return errors.Wrap(err, "failed to get managed service password secret")
+		}
+		oldPassword = secret.Value
+		secret.Value = password
+		if err := s.SecretRepository().Update(*secret); err != nil {
+			return err
+		}
+		if !byRestart {
+			if err := m.changeEnginePassword(ctx, *service, oldPassword, password); err != nil {
+				return err
+			}
+			engineChanged = true
+		}
+		return m.applyPasswordSecret(ctx, *service, password)
+	})
+	if err != nil {
+		// the password is kept in the database, so the engine and K8s secret get the old password back,
+		// otherwise the next sync writes the old password to the secret and clients are locked out
+		if engineChanged {
+			if err := m.changeEnginePassword(ctx, *service, password, oldPassword); err != nil {
+				log.WithError(err).Errorf("Failed to restore password of managed service %s after rotation failure", service.Name)
+			}
+		}
+		if oldPassword != "" {
+			if err := m.applyPasswordSecret(ctx, *service, oldPassword); err != nil {
+				log.WithError(err).Errorf("Failed to restore password secret of managed service %s after rotation failure", service.Name)
+			}
+		}
+		return errors.Wrap(err, "failed to rotate managed service password")
+	}
+	recordAudit(m.storage, auth, auditEvent{project: service.Project, resourceType: auditManagedService, resourceId: service.Name, action: "rotate-password"})
+	log.Infof("Rotated password of managed service %s in project %s", service.Name, service.Project)
+
+	// replicas read the password only on startup, the primary is restarted if it does the same or its probes read the password
+	if byRestart || definition.restartsOnPasswordRotation() {
+		err = m.restartManagedService(ctx, *service)
+	} else {
+		err = m.restartHighAvailabilityComponents(ctx, *service)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to restart managed service after password rotation")
+	}
+
+	if restartServices {
+		return m.restartBoundServices(ctx, *service, auth)
+	}
+	return nil
+}
+
+func (m managedServicesImpl) StopManagedService(ctx context.Context, id int, auth middleware.Authentication) error {
+	return m.setStopped(ctx, id, true, auth)
+}
+
+func (m managedServicesImpl) StartManagedService(ctx context.Context, id int, auth middleware.Authentication) error {
+	return m.setStopped(ctx, id, false, auth)
+}
+
+// setStopped stores the stopped flag and scales the StatefulSets accordingly, PVCs are kept
+func (m managedServicesImpl) setStopped(ctx context.Context, id int, stopped bool, auth middleware.Authentication) error {
+	service, err := m.getManagedService(id, auth, editResources)
+	if err != nil {
+		return errors.Wrap(err, "failed to get managed service")
 	}
-	return &openapi.ServiceStatus{Id: id, Status: openapi.Available}, nil
+	if *service.Stopped == stopped {
+		return nil
+	}
+	service.Stopped = &stopped
+	err = m.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		if err := s.ManagedServiceRepository().SetStopped(id, stopped); err != nil {
+			return err
+		}
+		return m.createManagedServiceDeployment(ctx, s, *service)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to change managed service state")
+	}
+	if stopped {
+		recordAudit(m.storage, auth, auditEvent{project: service.Project, resourceType: auditManagedService, resourceId: service.Name, action: "stop"})
+		log.Infof("Stopped managed service %s in project %s", service.Name, service.Project)
+	} else {
+		recordAudit(m.storage, auth, auditEvent{project: service.Project, resourceType: auditManagedService, resourceId: service.Name, action: "start"})
+		log.Infof("Started managed service %s in project %s", service.Name, service.Project)
+	}
+	return nil
+}
+
+func (m managedServicesImpl) GetManagedServiceTypes() []openapi.ManagedServiceTypeInfo {
+	names := sortedManagedServiceTypeNames(m.types)
+	infos := make([]openapi.ManagedServiceTypeInfo, len(names))
+	for i, name := range names {
+		infos[i] = m.types[name].info(name)
+	}
+	return infos
 }
 
 func (m managedServicesImpl) createManagedServiceDeployment(ctx context.Context, store *storage.Storage, service openapi.ManagedService) error {
+	if _, found := m.types[service.Type]; !found {
+		return errors.Errorf("Unknown managed service type %s", service.Type)
+	}
 	err := m.createK8sService(ctx, service)
 	if err != nil {
 		return errors.Wrap(err, "failed to create K8s Service for managed service")
@@ -219,20 +491,7 @@ func (m managedServicesImpl) createManagedServiceDeployment(ctx context.Context,
 		}
 		return errors.Wrap(err, "failed to create secret for managed service")
 	}
-	switch service.Type {
-	case openapi.Postgres:
-		err = m.createPostgresDeployment(ctx, service)
-	case openapi.Mysql:
-		err = m.createMySqlDeployment(ctx, service)
-	case openapi.Mongo:
-		err = m.createMongoDeployment(ctx, service)
-	case openapi.Redis:
-		err = m.createRedisDeployment(ctx, service)
-	case openapi.Rabbitmq:
-		err = m.createRabbitMQDeployment(ctx, service)
-	default:
-		return errors.Errorf("Unknown managed service type %s", service.Type)
-	}
+	err = m.createStatefulSet(ctx, service)
 	if err != nil {
 		if err := m.deletePasswordSecret(ctx, service.Project, service.Name); err != nil {
 			log.WithError(err).Errorln("Failed to delete password secret after managed service deployment failure")
@@ -242,21 +501,35 @@ func (m managedServicesImpl) createManagedServiceDeployment(ctx context.Context,
 		}
 		return err
 	}
+	err = m.applyHighAvailabilityComponents(ctx, service)
+	if err != nil {
+		return errors.Wrap(err, "failed to create high availability components of managed service")
+	}
 	return nil
 }
 
 func (m managedServicesImpl) createK8sService(ctx context.Context, service openapi.ManagedService) error {
-	port := applyConfigsCoreV1.ServicePort().
-		WithPort(int32(managedServices[service.Type].podPort)).
-		WithTargetPort(intstr.FromInt32(int32(managedServices[service.Type].podPort)))
-	serviceConfig := applyConfigsCoreV1.Service(service.Name, service.Project).
-		WithLabels(map[string]string{
-			"letsdeploy.space/managed":      "true",
-			"letsdeploy.space/service-type": "managed",
-			"app":                           service.Name,
-		}).
-		WithSpec(applyConfigsCoreV1.ServiceSpec().WithPorts(port).
-			WithSelector(map[string]string{"app": service.Name}))
+	return m.applyK8sService(ctx, service, service.Name, m.servicePorts(service))
+}
+
+func (m managedServicesImpl) servicePorts(service openapi.ManagedService) []*applyConfigsCoreV1.ServicePortApplyConfiguration {
+	return mapItems(m.types[service.Type].ports, func(p managedServicePort) *applyConfigsCoreV1.ServicePortApplyConfiguration {
+		return applyConfigsCoreV1.ServicePort().
+			WithName(p.name).
+			WithPort(int32(p.port)).
+			WithTargetPort(intstr.FromInt32(int32(p.port)))
+	})
+}
+
+// applyK8sService creates K8s service with the given name that selects pods labeled with app=<name>
+// (or by the role for Redis with Sentinel, see highAvailabilitySelector)
+func (m managedServicesImpl) applyK8sService(
+	ctx context.Context,
+	service openapi.ManagedService,
+	name string,
+	ports []*applyConfigsCoreV1.ServicePortApplyConfiguration,
+) error {
+	serviceConfig := m.k8sServiceConfig(service, name, ports)
 	_, err := m.clientset.CoreV1().Services(service.Project).Apply(ctx, serviceConfig, metav1.ApplyOptions{FieldManager: "letsdeploy"})
 	if err != nil {
 		return errors.Wrap(err, "failed to create K8s service for managed service")
@@ -264,7 +537,38 @@ func (m managedServicesImpl) createK8sService(ctx context.Context, service opena
 	return nil
 }
 
+func (m managedServicesImpl) k8sServiceConfig(
+	service openapi.ManagedService,
+	name string,
+	ports []*applyConfigsCoreV1.ServicePortApplyConfiguration,
+) *applyConfigsCoreV1.ServiceApplyConfiguration {
+	serviceConfig := k8sServiceConfigOf(service, name, ports)
+	if selector := m.highAvailabilitySelector(service, name); selector != nil {
+		serviceConfig.Spec.Selector = selector
+	}
+	return serviceConfig
+}
+
+func k8sServiceConfigOf(
+	service openapi.ManagedService,
+	name string,
+	ports []*applyConfigsCoreV1.ServicePortApplyConfiguration,
+) *applyConfigsCoreV1.ServiceApplyConfiguration {
+	return applyConfigsCoreV1.Service(name, service.Project).
+		WithLabels(map[string]string{
+			"letsdeploy.space/managed":         "true",
+			"letsdeploy.space/service-type":    "managed",
+			"letsdeploy.space/managed-service": service.Name,
+			"app":                              name,
+		}).
+		WithSpec(applyConfigsCoreV1.ServiceSpec().WithPorts(ports...).
+			WithSelector(map[string]string{"app": name}))
+}
+
 func (m managedServicesImpl) createPasswordSecret(ctx context.Context, store *storage.Storage, service openapi.ManagedService) error {
+	if m.types[service.Type].passwordless {
+		return nil
+	}
 	secretName := getManagedServiceSecretName(service.Name)
 	exists, err := store.SecretRepository().ExistsByProjectIdAndName(service.Project, secretName)
 	if err != nil {
@@ -274,17 +578,10 @@ func (m managedServicesImpl) createPasswordSecret(ctx context.Context, store *st
 		return nil
 	}
 
-	letters := []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-	passwordLen := 16
-	b := make([]rune, passwordLen)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+	password, err := randomString(alphanumeric, managedServicePasswordLength)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate password")
 	}
-	password := string(b)
-
-	secret := applyConfigsCoreV1.Secret(secretName, service.Project).
-		WithLabels(map[string]string{"letsdeploy.space/managed": "true"}).
-		WithStringData(map[string]string{secretKey: password})
 
 	secretEntity := storage.SecretEntity{
 		ProjectId:        service.Project,
@@ -298,12 +595,7 @@ func (m managedServicesImpl) createPasswordSecret(ctx context.Context, store *st
 		if err != nil {
 			return err
 		}
-
-		_, err = m.clientset.CoreV1().Secrets(service.Project).Apply(ctx, secret, metav1.ApplyOptions{FieldManager: "letsdeploy"})
-		if err != nil {
-			return err
-		}
-		return nil
+		return m.applyPasswordSecret(ctx, service, password)
 	})
 	if err != nil {
 		return errors.Wrap(err, "failed to create secret for managed service")
@@ -311,186 +603,80 @@ func (m managedServicesImpl) createPasswordSecret(ctx context.Context, store *st
 	return nil
 }
 
-func (m managedServicesImpl) createPasswordEnvVarSource(service openapi.ManagedService) *applyConfigsCoreV1.EnvVarSourceApplyConfiguration {
-	return applyConfigsCoreV1.EnvVarSource().
-		WithSecretKeyRef(applyConfigsCoreV1.SecretKeySelector().
-			WithName(getManagedServiceSecretName(service.Name)).
-			WithKey(secretKey))
-}
-
-func (m managedServicesImpl) createPostgresDeployment(ctx context.Context, service openapi.ManagedService) error {
-	portArg := fmt.Sprintf("--port=%d", managedServices[service.Type].podPort)
-	container := applyConfigsCoreV1.Container().
-		WithName(containerName).
-		WithImage(managedServices[service.Type].image).
-		WithPorts(applyConfigsCoreV1.ContainerPort().WithContainerPort(int32(managedServices[service.Type].podPort))).
-		WithVolumeMounts(applyConfigsCoreV1.VolumeMount().WithName("data").WithMountPath("/var/lib/postgresql")).
-		WithEnv(applyConfigsCoreV1.EnvVar().
-			WithName("POSTGRES_PASSWORD").
-			WithValueFrom(m.createPasswordEnvVarSource(service))).
-		WithLivenessProbe(applyConfigsCoreV1.Probe().
-			WithExec(applyConfigsCoreV1.ExecAction().WithCommand("pg_isready", portArg)).
-			WithInitialDelaySeconds(20).
-			WithPeriodSeconds(20).
-			WithTimeoutSeconds(5).
-			WithFailureThreshold(3)).
-		WithReadinessProbe(applyConfigsCoreV1.Probe().
-			WithExec(applyConfigsCoreV1.ExecAction().WithCommand("pg_isready", portArg)).
-			WithInitialDelaySeconds(30).
-			WithPeriodSeconds(20).
-			WithTimeoutSeconds(5).
-			WithFailureThreshold(3))
-
-	podTemplate := applyConfigsCoreV1.PodTemplateSpec().
-		WithLabels(map[string]string{"app": service.Name}).
-		WithSpec(applyConfigsCoreV1.PodSpec().WithContainers(container).WithTerminationGracePeriodSeconds(10))
-
-	pvClaim := applyConfigsCoreV1.PersistentVolumeClaim("data", service.Project).
-		WithSpec(applyConfigsCoreV1.PersistentVolumeClaimSpec().
-			WithAccessModes(v1.ReadWriteOnce).
-			WithResources(applyConfigsCoreV1.VolumeResourceRequirements().
-				WithRequests(v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")})))
-
-	statefulSet := applyConfigsAppsV1.StatefulSet(service.Name, service.Project).
+func (m managedServicesImpl) applyPasswordSecret(ctx context.Context, service openapi.ManagedService, password string) error {
+	secret := applyConfigsCoreV1.Secret(getManagedServiceSecretName(service.Name), service.Project).
 		WithLabels(map[string]string{"letsdeploy.space/managed": "true"}).
-		WithSpec(applyConfigsAppsV1.StatefulSetSpec().
-			WithSelector(applyConfigsMetaV1.LabelSelector().
-				WithMatchLabels(map[string]string{"app": service.Name})).
-			WithServiceName(service.Name).
-			WithTemplate(podTemplate).
-			WithVolumeClaimTemplates(pvClaim))
-
-	_, err := m.clientset.AppsV1().StatefulSets(service.Project).Apply(ctx, statefulSet, metav1.ApplyOptions{FieldManager: "letsdeploy"})
+		WithStringData(map[string]string{secretKey: password})
+	_, err := m.clientset.CoreV1().Secrets(service.Project).Apply(ctx, secret, metav1.ApplyOptions{FieldManager: "letsdeploy"})
 	if err != nil {
-		return errors.Wrap(err, "failed to create K8s deployment for managed service")
+		return errors.Wrap(err, "failed to apply password secret for managed service")
 	}
 	return nil
 }
 
-func (m managedServicesImpl) createMySqlDeployment(ctx context.Context, service openapi.ManagedService) error {
-	livenessCmd := fmt.Sprintf("mysqladmin -u%s -p$MYSQL_ROOT_PASSWORD ping",
-		managedServices[service.Type].username)
-	readinessCmd := fmt.Sprintf("mysql -h 127.0.0.1 -u%s -p$MYSQL_ROOT_PASSWORD -e 'SELECT 1'",
-		managedServices[service.Type].username)
-
-	container := applyConfigsCoreV1.Container().
-		WithName(containerName).
-		WithImage(managedServices[service.Type].image).
-		WithPorts(applyConfigsCoreV1.ContainerPort().WithContainerPort(int32(managedServices[service.Type].podPort))).
-		WithVolumeMounts(applyConfigsCoreV1.VolumeMount().WithName("data").WithMountPath("/var/lib/mysql")).
-		WithEnv(applyConfigsCoreV1.EnvVar().
-			WithName("MYSQL_ROOT_PASSWORD").
-			WithValueFrom(m.createPasswordEnvVarSource(service)),
-			applyConfigsCoreV1.EnvVar().
-				WithName("MYSQL_DATABASE").
-				WithValue("db")).
-		WithLivenessProbe(applyConfigsCoreV1.Probe().
-			WithExec(applyConfigsCoreV1.ExecAction().
-				WithCommand("/bin/sh", "-c", livenessCmd)).
-			WithInitialDelaySeconds(20).
-			WithPeriodSeconds(20).
-			WithTimeoutSeconds(5).
-			WithFailureThreshold(3)).
-		WithReadinessProbe(applyConfigsCoreV1.Probe().
-			WithExec(applyConfigsCoreV1.ExecAction().
-				WithCommand("/bin/sh", "-c", readinessCmd)).
-			WithInitialDelaySeconds(30).
-			WithPeriodSeconds(20).
-			WithTimeoutSeconds(5).
-			WithFailureThreshold(3))
-
-	podTemplate := applyConfigsCoreV1.PodTemplateSpec().
-		WithLabels(map[string]string{"app": service.Name}).
-		WithSpec(applyConfigsCoreV1.PodSpec().WithContainers(container).WithTerminationGracePeriodSeconds(10))
-
-	pvClaim := applyConfigsCoreV1.PersistentVolumeClaim("data", service.Project).
-		WithSpec(applyConfigsCoreV1.PersistentVolumeClaimSpec().
-			WithAccessModes(v1.ReadWriteOnce).
-			WithResources(applyConfigsCoreV1.VolumeResourceRequirements().
-				WithRequests(v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")})))
+func (m managedServicesImpl) changeEnginePassword(ctx context.Context, service openapi.ManagedService, oldPassword string, newPassword string) error {
+	stdin := strings.NewReader(oldPassword + "\n" + newPassword + "\n")
+	_, err := k8s.ExecInPod(ctx, m.clientset, m.restConfig, service.Project, service.Name+"-0", containerName,
+		m.types[service.Type].rotatePasswordCmd, stdin)
+	if err != nil {
+		return errors.Wrapf(err, "failed to change password of managed service %s", service.Name)
+	}
+	return nil
+}
 
-	statefulSet := applyConfigsAppsV1.StatefulSet(service.Name, service.Project).
-		WithLabels(map[string]string{"letsdeploy.space/managed": "true"}).
-		WithSpec(applyConfigsAppsV1.StatefulSetSpec().
-			WithSelector(applyConfigsMetaV1.LabelSelector().
-				WithMatchLabels(map[string]string{"app": service.Name})).
-			WithServiceName(service.Name).
-			WithTemplate(podTemplate).
-			WithVolumeClaimTemplates(pvClaim))
+func (m managedServicesImpl) restartManagedService(ctx context.Context, service openapi.ManagedService) error {
+	if err := m.restartStatefulSet(ctx, service.Project, service.Name); err != nil {
+		return err
+	}
+	return m.restartHighAvailabilityComponents(ctx, service)
+}
 
-	_, err := m.clientset.AppsV1().StatefulSets(service.Project).Apply(ctx, statefulSet, metav1.ApplyOptions{FieldManager: "letsdeploy"})
+func (m managedServicesImpl) restartStatefulSet(ctx context.Context, namespace string, name string) error {
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"%s"}}}}}`,
+		time.Now().Format(time.RFC3339))
+	_, err := m.clientset.AppsV1().StatefulSets(namespace).
+		Patch(ctx, name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{FieldManager: "letsdeploy"})
 	if err != nil {
-		return errors.Wrap(err, "failed to create K8s deployment for managed service")
+		return errors.Wrapf(err, "failed to restart managed service %s", name)
 	}
 	return nil
 }
 
-func (m managedServicesImpl) createMongoDeployment(ctx context.Context, service openapi.ManagedService) error {
-	livenessCmd := "mongosh --port 27017 --eval 'db.runCommand({ping: 1})' --quiet"
-	readinessCmd := fmt.Sprintf("mongosh --port 27017 --username %s --password \"$(cat $MONGO_INITDB_ROOT_PASSWORD_FILE)\" "+
-		"--eval 'db.serverStatus().ok' --quiet | grep -q 1",
-		managedServices[service.Type].username)
-
-	container := applyConfigsCoreV1.Container().
-		WithName(containerName).
-		WithImage(managedServices[service.Type].image).
-		WithPorts(applyConfigsCoreV1.ContainerPort().WithContainerPort(int32(managedServices[service.Type].podPort))).
-		WithVolumeMounts(applyConfigsCoreV1.VolumeMount().
-			WithName("data").
-			WithMountPath("/data/db"),
-			applyConfigsCoreV1.VolumeMount().
-				WithName("mongo-root-auth").
-				WithMountPath("/run/secrets/mongo-root-auth").
-				WithReadOnly(true)).
-		WithEnv(applyConfigsCoreV1.EnvVar().
-			WithName("MONGO_INITDB_ROOT_PASSWORD_FILE").
-			WithValue("/run/secrets/mongo-root-auth/password"),
-			applyConfigsCoreV1.EnvVar().
-				WithName("MONGO_INITDB_ROOT_USERNAME").
-				WithValue(managedServices[service.Type].username)).
-		WithLivenessProbe(applyConfigsCoreV1.Probe().
-			WithExec(applyConfigsCoreV1.ExecAction().
-				WithCommand("/bin/sh", "-c", livenessCmd)).
-			WithInitialDelaySeconds(20).
-			WithPeriodSeconds(30).
-			WithTimeoutSeconds(10).
-			WithFailureThreshold(3)).
-		WithReadinessProbe(applyConfigsCoreV1.Probe().
-			WithExec(applyConfigsCoreV1.ExecAction().
-				WithCommand("/bin/sh", "-c", readinessCmd)).
-			WithInitialDelaySeconds(30).
-			WithPeriodSeconds(40).
-			WithTimeoutSeconds(10).
-			WithFailureThreshold(3))
+func (m managedServicesImpl) restartBoundServices(ctx context.Context, service openapi.ManagedService, auth middleware.Authentication) error {
+	secretName := getManagedServiceSecretName(service.Name)
+	services, err := m.services.GetProjectServices(service.Project, auth)
+	if err != nil {
+		return errors.Wrap(err, "failed to get project services")
+	}
+	for _, s := range services {
+		bound := false
+		for _, envVar := range s.EnvVars {
+			processEnvVar(envVar, func(openapi.EnvVar0) {}, func(e openapi.EnvVar1) {
+				if e.Secret == secretName {
+					bound = true
+				}
+			})
+		}
+		if !bound {
+			continue
+		}
+		if err := m.services.RestartService(ctx, *s.Id, auth); err != nil {
+			return errors.Wrapf(err, "failed to restart service %s", s.Name)
+		}
+		log.Infof("Restarted service %s after password rotation of managed service %s", s.Name, service.Name)
+	}
+	return nil
+}
 
-	podTemplate := applyConfigsCoreV1.PodTemplateSpec().
-		WithLabels(map[string]string{"app": service.Name}).
-		WithSpec(applyConfigsCoreV1.PodSpec().
-			WithContainers(container).
-			WithTerminationGracePeriodSeconds(10).
-			WithVolumes(applyConfigsCoreV1.Volume().
-				WithName("mongo-root-auth").
-				WithSecret(applyConfigsCoreV1.SecretVolumeSource().
-					WithSecretName(getManagedServiceSecretName(service.Name)).
-					WithItems(applyConfigsCoreV1.KeyToPath().
-						WithKey("value").
-						WithPath("password")))))
-
-	pvClaim := applyConfigsCoreV1.PersistentVolumeClaim("data", service.Project).
-		WithSpec(applyConfigsCoreV1.PersistentVolumeClaimSpec().
-			WithAccessModes(v1.ReadWriteOnce).
-			WithResources(applyConfigsCoreV1.VolumeResourceRequirements().
-				WithRequests(v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")})))
-
-	statefulSet := applyConfigsAppsV1.StatefulSet(service.Name, service.Project).
-		WithLabels(map[string]string{"letsdeploy.space/managed": "true"}).
-		WithSpec(applyConfigsAppsV1.StatefulSetSpec().
-			WithSelector(applyConfigsMetaV1.LabelSelector().
-				WithMatchLabels(map[string]string{"app": service.Name})).
-			WithServiceName(service.Name).
-			WithTemplate(podTemplate).
-			WithVolumeClaimTemplates(pvClaim))
+func (m managedServicesImpl) createPasswordEnvVarSource(service openapi.ManagedService) *applyConfigsCoreV1.EnvVarSourceApplyConfiguration {
+	return applyConfigsCoreV1.EnvVarSource().
+		WithSecretKeyRef(applyConfigsCoreV1.SecretKeySelector().
+			WithName(getManagedServiceSecretName(service.Name)).
+			WithKey(secretKey))
+}
 
+func (m managedServicesImpl) createStatefulSet(ctx context.Context, service openapi.ManagedService) error {
+	statefulSet := m.primaryStatefulSetConfig(service)
 	_, err := m.clientset.AppsV1().StatefulSets(service.Project).Apply(ctx, statefulSet, metav1.ApplyOptions{FieldManager: "letsdeploy"})
 	if err != nil {
 		return errors.Wrap(err, "failed to create K8s deployment for managed service")
@@ -498,125 +684,132 @@ func (m managedServicesImpl) createMongoDeployment(ctx context.Context, service
 	return nil
 }
 
-func (m managedServicesImpl) createRedisDeployment(ctx context.Context, service openapi.ManagedService) error {
-	livenessCmd := "redis-cli --pass $REDIS_PASSWORD ping | grep -q PONG"
-	readinessCmd := "redis-cli --pass $REDIS_PASSWORD ping | grep -q PONG"
+func (m managedServicesImpl) primaryStatefulSetConfig(service openapi.ManagedService) *applyConfigsAppsV1.StatefulSetApplyConfiguration {
+	command := m.types[service.Type].command
+	if haCommand := m.highAvailabilityPrimaryCommand(service); haCommand != nil {
+		command = haCommand
+	}
+	statefulSet := m.statefulSetConfig(service, service.Name, scaledReplicas(service, 1), command)
+	if m.highAvailabilityEnabled(service) && m.types[service.Type].highAvailability == redisSentinel {
+		return m.withRedisDataPods(service, statefulSet)
+	}
+	return statefulSet
+}
+
+// statefulSetConfig creates StatefulSet of the managed service type with the given name, replicas and command
+func (m managedServicesImpl) statefulSetConfig(
+	service openapi.ManagedService,
+	name string,
+	replicas int32,
+	command []string,
+) *applyConfigsAppsV1.StatefulSetApplyConfiguration {
+	definition := m.types[service.Type]
 
 	container := applyConfigsCoreV1.Container().
 		WithName(containerName).
-		WithImage(managedServices[service.Type].image).
-		WithPorts(applyConfigsCoreV1.ContainerPort().WithContainerPort(int32(managedServices[service.Type].podPort))).
-		WithVolumeMounts(applyConfigsCoreV1.VolumeMount().WithName("data").WithMountPath("/data")).
-		WithCommand("/bin/sh", "-c", "redis-server --appendonly yes --requirepass ${REDIS_PASSWORD}").
-		WithEnv(applyConfigsCoreV1.EnvVar().
-			WithName("REDIS_PASSWORD").
-			WithValueFrom(m.createPasswordEnvVarSource(service))).
-		WithLivenessProbe(applyConfigsCoreV1.Probe().
-			WithExec(applyConfigsCoreV1.ExecAction().
-				WithCommand("/bin/sh", "-c", livenessCmd)).
-			WithInitialDelaySeconds(20).
-			WithPeriodSeconds(20).
-			WithTimeoutSeconds(5).
-			WithFailureThreshold(3)).
-		WithReadinessProbe(applyConfigsCoreV1.Probe().
-			WithExec(applyConfigsCoreV1.ExecAction().
-				WithCommand("/bin/sh", "-c", readinessCmd)).
-			WithInitialDelaySeconds(30).
-			WithPeriodSeconds(20).
-			WithTimeoutSeconds(5).
-			WithFailureThreshold(3))
+		WithImage(definition.image)
+	for _, port := range definition.ports {
+		container = container.WithPorts(applyConfigsCoreV1.ContainerPort().
+			WithName(port.name).
+			WithContainerPort(int32(port.port)))
+	}
+	if len(command) > 0 {
+		container = container.WithCommand(command...)
+	}
+	for _, envVar := range definition.env {
+		container = container.WithEnv(m.createEnvVar(service, envVar))
+	}
+	container = container.
+		WithLivenessProbe(createProbe(definition.livenessProbe)).
+		WithReadinessProbe(createProbe(definition.readinessProbe))
+
+	podSpec := applyConfigsCoreV1.PodSpec().WithTerminationGracePeriodSeconds(10)
+	if definition.fsGroup != 0 {
+		podSpec = podSpec.WithSecurityContext(applyConfigsCoreV1.PodSecurityContext().WithFSGroup(definition.fsGroup))
+	}
+	pvClaims := make([]*applyConfigsCoreV1.PersistentVolumeClaimApplyConfiguration, len(definition.volumes))
+	for i, volume := range definition.volumes {
+		container = container.WithVolumeMounts(applyConfigsCoreV1.VolumeMount().
+			WithName(volume.name).
+			WithMountPath(volume.mountPath))
+		pvClaims[i] = applyConfigsCoreV1.PersistentVolumeClaim(volume.name, service.Project).
+			WithSpec(applyConfigsCoreV1.PersistentVolumeClaimSpec().
+				WithAccessModes(v1.ReadWriteOnce).
+				WithResources(applyConfigsCoreV1.VolumeResourceRequirements().
+					WithRequests(v1.ResourceList{v1.ResourceStorage: resource.MustParse(volume.size)})))
+	}
+
+	if definition.passwordFile != "" {
+		// the volume is named after the directory it is mounted to (e.g. mongo-root-auth),
+		// so that the pod template of existing StatefulSets does not change
+		volumeName := path.Base(path.Dir(definition.passwordFile))
+		container = container.WithVolumeMounts(applyConfigsCoreV1.VolumeMount().
+			WithName(volumeName).
+			WithMountPath(path.Dir(definition.passwordFile)).
+			WithReadOnly(true))
+		podSpec = podSpec.WithVolumes(applyConfigsCoreV1.Volume().
+			WithName(volumeName).
+			WithSecret(applyConfigsCoreV1.SecretVolumeSource().
+				WithSecretName(getManagedServiceSecretName(service.Name)).
+				WithItems(applyConfigsCoreV1.KeyToPath().
+					WithKey(secretKey).
+					WithPath(path.Base(definition.passwordFile)))))
+	}
 
 	podTemplate := applyConfigsCoreV1.PodTemplateSpec().
-		WithLabels(map[string]string{"app": service.Name}).
-		WithSpec(applyConfigsCoreV1.PodSpec().WithContainers(container).WithTerminationGracePeriodSeconds(10))
-
-	pvClaim := applyConfigsCoreV1.PersistentVolumeClaim("data", service.Project).
-		WithSpec(applyConfigsCoreV1.PersistentVolumeClaimSpec().
-			WithAccessModes(v1.ReadWriteOnce).
-			WithResources(applyConfigsCoreV1.VolumeResourceRequirements().
-				WithRequests(v1.ResourceList{v1.ResourceStorage: resource.MustParse("500Mi")})))
+		WithLabels(map[string]string{"app": name}).
+		WithSpec(podSpec.WithContainers(container))
 
-	statefulSet := applyConfigsAppsV1.StatefulSet(service.Name, service.Project).
-		WithLabels(map[string]string{"letsdeploy.space/managed": "true"}).
+	return applyConfigsAppsV1.StatefulSet(name, service.Project).
+		WithLabels(map[string]string{
+			"letsdeploy.space/managed":         "true",
+			"letsdeploy.space/managed-service": service.Name,
+		}).
 		WithSpec(applyConfigsAppsV1.StatefulSetSpec().
+			WithReplicas(replicas).
 			WithSelector(applyConfigsMetaV1.LabelSelector().
-				WithMatchLabels(map[string]string{"app": service.Name})).
-			WithServiceName(service.Name).
+				WithMatchLabels(map[string]string{"app": name})).
+			WithServiceName(name).
 			WithTemplate(podTemplate).
-			WithVolumeClaimTemplates(pvClaim))
+			WithVolumeClaimTemplates(pvClaims...))
+}
 
-	_, err := m.clientset.AppsV1().StatefulSets(service.Project).Apply(ctx, statefulSet, metav1.ApplyOptions{FieldManager: "letsdeploy"})
-	if err != nil {
-		return errors.Wrap(err, "failed to create K8s deployment for managed service")
+func (m managedServicesImpl) createEnvVar(service openapi.ManagedService, envVar managedServiceEnvVar) *applyConfigsCoreV1.EnvVarApplyConfiguration {
+	config := applyConfigsCoreV1.EnvVar().WithName(envVar.name)
+	if envVar.password {
+		return config.WithValueFrom(m.createPasswordEnvVarSource(service))
 	}
-	return nil
+	if envVar.fieldPath != "" {
+		return config.WithValueFrom(applyConfigsCoreV1.EnvVarSource().
+			WithFieldRef(applyConfigsCoreV1.ObjectFieldSelector().WithFieldPath(envVar.fieldPath)))
+	}
+	return config.WithValue(envVar.value)
 }
 
-func (m managedServicesImpl) createRabbitMQDeployment(ctx context.Context, service openapi.ManagedService) error {
-	container := applyConfigsCoreV1.Container().
-		WithName(containerName).
-		WithImage(managedServices[service.Type].image).
-		WithPorts(
-			applyConfigsCoreV1.ContainerPort().WithContainerPort(5672).WithName("amqp"),
-			applyConfigsCoreV1.ContainerPort().WithContainerPort(15672).WithName("http"),
-			applyConfigsCoreV1.ContainerPort().WithContainerPort(4369)).
-		WithEnv(
-			applyConfigsCoreV1.EnvVar().
-				WithName("HOSTNAME").
-				WithValueFrom(applyConfigsCoreV1.EnvVarSource().WithFieldRef(
-					applyConfigsCoreV1.ObjectFieldSelector().WithFieldPath("metadata.name"))),
-			applyConfigsCoreV1.EnvVar().
-				WithName("NODE_NAME").
-				WithValueFrom(applyConfigsCoreV1.EnvVarSource().WithFieldRef(
-					applyConfigsCoreV1.ObjectFieldSelector().WithFieldPath("metadata.name"))),
-			applyConfigsCoreV1.EnvVar().
-				WithName("NAMESPACE").
-				WithValueFrom(applyConfigsCoreV1.EnvVarSource().WithFieldRef(
-					applyConfigsCoreV1.ObjectFieldSelector().WithFieldPath("metadata.namespace"))),
-			applyConfigsCoreV1.EnvVar().WithName("RABBITMQ_USE_LONGNAME").WithValue("true"),
-			applyConfigsCoreV1.EnvVar().WithName("RABBITMQ_NODENAME").
-				WithValue("rabbit@$(HOSTNAME)."+service.Name+".$(NAMESPACE).svc.cluster.local"),
-			applyConfigsCoreV1.EnvVar().WithName("RABBITMQ_DEFAULT_USER").
-				WithValue(managedServices[service.Type].username),
-			applyConfigsCoreV1.EnvVar().WithName("RABBITMQ_DEFAULT_PASS").
-				WithValueFrom(m.createPasswordEnvVarSource(service)),
-			applyConfigsCoreV1.EnvVar().WithName("RABBITMQ_ERLANG_COOKIE").
-				WithValue("secret_cookie_12345678"), // TODO refactor
-		).
-		WithLivenessProbe(applyConfigsCoreV1.Probe().
-			WithExec(applyConfigsCoreV1.ExecAction().WithCommand("rabbitmq-diagnostics", "status", "--timeout", "10")).
-			WithInitialDelaySeconds(20).
-			WithPeriodSeconds(20).
-			WithTimeoutSeconds(15).
-			WithFailureThreshold(3)).
-		WithReadinessProbe(applyConfigsCoreV1.Probe().
-			WithExec(applyConfigsCoreV1.ExecAction().WithCommand("rabbitmq-diagnostics", "ping", "--timeout", "10")).
-			WithInitialDelaySeconds(30).
-			WithPeriodSeconds(20).
-			WithTimeoutSeconds(10).
-			WithFailureThreshold(3))
-
-	podTemplate := applyConfigsCoreV1.PodTemplateSpec().
-		WithLabels(map[string]string{"app": service.Name}).
-		WithSpec(applyConfigsCoreV1.PodSpec().WithContainers(container).WithTerminationGracePeriodSeconds(10))
-
-	statefulSet := applyConfigsAppsV1.StatefulSet(service.Name, service.Project).
-		WithLabels(map[string]string{"letsdeploy.space/managed": "true"}).
-		WithSpec(applyConfigsAppsV1.StatefulSetSpec().
-			WithSelector(applyConfigsMetaV1.LabelSelector().
-				WithMatchLabels(map[string]string{"app": service.Name})).
-			WithServiceName(service.Name).
-			WithTemplate(podTemplate))
+func createProbe(probe managedServiceProbe) *applyConfigsCoreV1.ProbeApplyConfiguration {
+	config := applyConfigsCoreV1.Probe().
+		WithInitialDelaySeconds(probe.initialDelaySeconds).
+		WithPeriodSeconds(probe.periodSeconds).
+		WithTimeoutSeconds(probe.timeoutSeconds).
+		WithFailureThreshold(probe.failureThreshold)
+	if len(probe.command) > 0 {
+		return config.WithExec(applyConfigsCoreV1.ExecAction().WithCommand(probe.command...))
+	}
+	if probe.httpPath != "" {
+		return config.WithHTTPGet(applyConfigsCoreV1.HTTPGetAction().
+			WithPath(probe.httpPath).
+			WithPort(intstr.FromInt32(int32(probe.port))))
+	}
+	return config.WithTCPSocket(applyConfigsCoreV1.TCPSocketAction().WithPort(intstr.FromInt32(int32(probe.port))))
+}
 
-	_, err := m.clientset.AppsV1().StatefulSets(service.Project).Apply(ctx, statefulSet, metav1.ApplyOptions{FieldManager: "letsdeploy"})
+func (m managedServicesImpl) deleteManagedServiceDeployment(ctx context.Context, namespace string, name string) error {
+	err := m.deleteHighAvailabilityComponents(ctx, namespace, name)
 	if err != nil {
-		return errors.Wrap(err, "failed to create K8s deployment for managed service")
+		return err
 	}
-	return nil
-}
 
-func (m managedServicesImpl) deleteManagedServiceDeployment(ctx context.Context, namespace string, name string) error {
-	err := m.clientset.AppsV1().StatefulSets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	err = m.clientset.AppsV1().StatefulSets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil && !apierrors.IsNotFound(err) {
 		return errors.Wrap(err, "failed to delete managed service StatefulSet")
 	}
@@ -649,6 +842,34 @@ func (m managedServicesImpl) deletePasswordSecret(ctx context.Context, namespace
 	return nil
 }
 
+func (m managedServicesImpl) desiredObjects(projectId string) ([]any, error) {
+	services, err := m.GetProjectManagedServices(projectId, middleware.ServiceAccount)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project managed services")
+	}
+	var objects []any
+	for _, service := range services {
+		if _, found := m.types[service.Type]; !found {
+			continue
+		}
+		objects = append(objects,
+			m.k8sServiceConfig(service, service.Name, m.servicePorts(service)),
+			m.primaryStatefulSetConfig(service))
+		if !m.highAvailabilityEnabled(service) {
+			continue
+		}
+		objects = append(objects,
+			m.replicaStatefulSetConfig(service),
+			m.k8sServiceConfig(service, readOnlyServiceName(service.Name), m.servicePorts(service)))
+		if m.types[service.Type].highAvailability == redisSentinel {
+			objects = append(objects,
+				m.sentinelStatefulSetConfig(service),
+				k8sServiceConfigOf(service, sentinelName(service.Name), sentinelServicePorts()))
+		}
+	}
+	return objects, nil
+}
+
 func (m managedServicesImpl) syncKubernetes(ctx context.Context, projectId string) error {
 	services, err := m.GetProjectManagedServices(projectId, middleware.ServiceAccount)
 	if err != nil {
@@ -670,8 +891,9 @@ func (m managedServicesImpl) syncKubernetes(ctx context.Context, projectId strin
 		return errors.Wrap(err, "failed to get statefulsets list")
 	}
 	for _, statefulSet := range statefulSets.Items {
-		if !contains(servicesMap, statefulSet.Name) {
-			err := m.deleteManagedServiceDeployment(ctx, projectId, statefulSet.Name)
+		name := managedServiceNameOf(statefulSet.ObjectMeta)
+		if !contains(servicesMap, name) {
+			err := m.deleteManagedServiceDeployment(ctx, projectId, name)
 			if err != nil {
 				log.WithError(err).Errorf("Failed to delete managed service statefulset %s, skipping\n", statefulSet.Name)
 			}
@@ -686,7 +908,7 @@ func (m managedServicesImpl) syncKubernetes(ctx context.Context, projectId strin
 		return errors.Wrap(err, "failed to get K8s services")
 	}
 	for _, k8sService := range k8sServices.Items {
-		if !contains(servicesMap, k8sService.Name) {
+		if !contains(servicesMap, managedServiceNameOf(k8sService.ObjectMeta)) {
 			err := m.deleteK8sService(ctx, projectId, k8sService.Name)
 			if err != nil {
 				log.WithError(err).Errorf("Failed to delete k8s service %s, skipping\n", k8sService.Name)
@@ -697,6 +919,37 @@ func (m managedServicesImpl) syncKubernetes(ctx context.Context, projectId strin
 	return nil
 }
 
+// managedServiceNameOf returns the name of managed service the K8s object belongs to,
+// objects created before the managed-service label was introduced are named after the managed service
+func managedServiceNameOf(meta metav1.ObjectMeta) string {
+	if name, found := meta.Labels["letsdeploy.space/managed-service"]; found {
+		return name
+	}
+	return meta.Name
+}
+
+func managedServiceFromEntity(entity storage.ManagedServiceEntity) openapi.ManagedService {
+	id := entity.Id
+	replicas := entity.Replicas
+	stopped := entity.Stopped
+	return openapi.ManagedService{
+		Id:       &id,
+		Name:     entity.Name,
+		Project:  entity.ProjectId,
+		Type:     openapi.ManagedServiceType(entity.Type),
+		Replicas: &replicas,
+		Stopped:  &stopped,
+	}
+}
+
+// scaledReplicas returns 0 for stopped managed service
+func scaledReplicas(service openapi.ManagedService, replicas int32) int32 {
+	if service.Stopped != nil && *service.Stopped {
+		return 0
+	}
+	return replicas
+}
+
 func getManagedServiceSecretName(serviceName string) string {
 	return managedSecretPrefix + serviceName + ".password"
 }
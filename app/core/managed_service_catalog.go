@@ -0,0 +1,222 @@
+package core
+
+import (
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"path"
+	"regexp"
+	"sort"
+)
+
+var managedServiceTypeNameRegex = regexp.MustCompile("^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$")
+
+// managedServiceCatalog is the file format of the managed service catalog,
+// see configs/managed-services.example.yaml
+type managedServiceCatalog struct {
+	Types []managedServiceTypeDefinition `mapstructure:"types"`
+}
+
+type managedServiceTypeDefinition struct {
+	Name     string   `mapstructure:"name"`
+	Image    string   `mapstructure:"image"`
+	Username string   `mapstructure:"username"`
+	Command  []string `mapstructure:"command"`
+	Ports    []struct {
+		Name string `mapstructure:"name"`
+		Port int    `mapstructure:"port"`
+	} `mapstructure:"ports"`
+	// PasswordEnv is the name of env var the password is passed in
+	PasswordEnv  string `mapstructure:"password-env"`
+	PasswordFile string `mapstructure:"password-file"`
+	Passwordless bool   `mapstructure:"passwordless"`
+	Env          []struct {
+		Name      string `mapstructure:"name"`
+		Value     string `mapstructure:"value"`
+		FieldPath string `mapstructure:"field-path"`
+	} `mapstructure:"env"`
+	LivenessProbe  managedServiceProbeDefinition `mapstructure:"liveness-probe"`
+	ReadinessProbe managedServiceProbeDefinition `mapstructure:"readiness-probe"`
+	Volumes        []struct {
+		Name      string `mapstructure:"name"`
+		MountPath string `mapstructure:"mount-path"`
+		Size      string `mapstructure:"size"`
+	} `mapstructure:"volumes"`
+	FsGroup               int64    `mapstructure:"fs-group"`
+	RotatePasswordCommand []string `mapstructure:"rotate-password-command"`
+	RotateByRestart       bool     `mapstructure:"rotate-by-restart"`
+}
+
+type managedServiceProbeDefinition struct {
+	Command             []string `mapstructure:"command"`
+	HttpPath            string   `mapstructure:"http-path"`
+	Port                int      `mapstructure:"port"`
+	InitialDelaySeconds int32    `mapstructure:"initial-delay-seconds"`
+	PeriodSeconds       int32    `mapstructure:"period-seconds"`
+	TimeoutSeconds      int32    `mapstructure:"timeout-seconds"`
+	FailureThreshold    int32    `mapstructure:"failure-threshold"`
+}
+
+// loadManagedServiceTypes returns built-in managed service types
+// merged with the types from the catalog file configured by managed-services.catalog
+func loadManagedServiceTypes(cfg *viper.Viper) (map[openapi.ManagedServiceType]managedServiceType, error) {
+	types := make(map[openapi.ManagedServiceType]managedServiceType, len(builtinManagedServiceTypes))
+	for name, t := range builtinManagedServiceTypes {
+		types[name] = t
+	}
+
+	path := cfg.GetString("managed-services.catalog")
+	if path == "" {
+		return types, nil
+	}
+	catalogCfg := viper.New()
+	catalogCfg.SetConfigFile(path)
+	if err := catalogCfg.ReadInConfig(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read managed service catalog %s", path)
+	}
+	catalog := managedServiceCatalog{}
+	if err := catalogCfg.Unmarshal(&catalog); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse managed service catalog %s", path)
+	}
+
+	for _, definition := range catalog.Types {
+		if _, found := types[definition.Name]; found {
+			return nil, errors.Errorf("managed service type %s is already defined", definition.Name)
+		}
+		t, err := definition.toManagedServiceType()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid managed service type %s", definition.Name)
+		}
+		types[definition.Name] = t
+		log.Infof("Loaded managed service type %s (%s) from catalog", definition.Name, definition.Image)
+	}
+	return types, nil
+}
+
+func (d managedServiceTypeDefinition) toManagedServiceType() (managedServiceType, error) {
+	if !managedServiceTypeNameRegex.MatchString(d.Name) {
+		return managedServiceType{}, errors.Errorf("name must match %s", managedServiceTypeNameRegex)
+	}
+	if d.Image == "" {
+		return managedServiceType{}, errors.New("image is required")
+	}
+	if len(d.Ports) == 0 {
+		return managedServiceType{}, errors.New("at least one port is required")
+	}
+	if d.Passwordless && (d.PasswordEnv != "" || d.PasswordFile != "") {
+		return managedServiceType{}, errors.New("passwordless type cannot define password-env or password-file")
+	}
+	if !d.Passwordless && d.PasswordEnv == "" && d.PasswordFile == "" {
+		return managedServiceType{}, errors.New("either password-env or password-file is required")
+	}
+	if d.PasswordFile != "" && (!path.IsAbs(d.PasswordFile) ||
+		!managedServiceTypeNameRegex.MatchString(path.Base(path.Dir(d.PasswordFile)))) {
+		return managedServiceType{}, errors.New("password-file must be an absolute path in a directory " +
+			"that is a valid volume name, e.g. /run/secrets/auth/password")
+	}
+
+	t := managedServiceType{
+		image:             d.Image,
+		username:          d.Username,
+		command:           d.Command,
+		passwordFile:      d.PasswordFile,
+		fsGroup:           d.FsGroup,
+		passwordless:      d.Passwordless,
+		rotatePasswordCmd: d.RotatePasswordCommand,
+		rotateByRestart:   d.RotateByRestart,
+	}
+	for _, p := range d.Ports {
+		if p.Name == "" || p.Port <= 0 || p.Port > 65535 {
+			return managedServiceType{}, errors.Errorf("invalid port %s: %d", p.Name, p.Port)
+		}
+		t.ports = append(t.ports, managedServicePort{name: p.Name, port: p.Port})
+	}
+	if d.PasswordEnv != "" {
+		t.env = append(t.env, managedServiceEnvVar{name: d.PasswordEnv, password: true})
+	}
+	for _, e := range d.Env {
+		if e.Name == "" {
+			return managedServiceType{}, errors.New("env var name is required")
+		}
+		t.env = append(t.env, managedServiceEnvVar{name: e.Name, value: e.Value, fieldPath: e.FieldPath})
+	}
+	for _, v := range d.Volumes {
+		if v.Name == "" || v.MountPath == "" {
+			return managedServiceType{}, errors.New("volume name and mount-path are required")
+		}
+		size := v.Size
+		if size == "" {
+			size = "1Gi"
+		}
+		if _, err := resource.ParseQuantity(size); err != nil {
+			return managedServiceType{}, errors.Wrapf(err, "invalid size of volume %s", v.Name)
+		}
+		t.volumes = append(t.volumes, managedServiceVolume{name: v.Name, mountPath: v.MountPath, size: size})
+	}
+	t.livenessProbe = d.LivenessProbe.toManagedServiceProbe(t.port())
+	t.readinessProbe = d.ReadinessProbe.toManagedServiceProbe(t.port())
+	return t, nil
+}
+
+// toManagedServiceProbe fills the missing probe settings with defaults,
+// probe without command and HTTP path checks that the port is open (main port by default)
+func (d managedServiceProbeDefinition) toManagedServiceProbe(defaultPort int) managedServiceProbe {
+	probe := managedServiceProbe{
+		command:             d.Command,
+		httpPath:            d.HttpPath,
+		port:                d.Port,
+		initialDelaySeconds: d.InitialDelaySeconds,
+		periodSeconds:       d.PeriodSeconds,
+		timeoutSeconds:      d.TimeoutSeconds,
+		failureThreshold:    d.FailureThreshold,
+	}
+	if probe.port == 0 {
+		probe.port = defaultPort
+	}
+	if probe.initialDelaySeconds == 0 {
+		probe.initialDelaySeconds = 20
+	}
+	if probe.periodSeconds == 0 {
+		probe.periodSeconds = 20
+	}
+	if probe.timeoutSeconds == 0 {
+		probe.timeoutSeconds = 5
+	}
+	if probe.failureThreshold == 0 {
+		probe.failureThreshold = 3
+	}
+	return probe
+}
+
+func (t managedServiceType) info(name openapi.ManagedServiceType) openapi.ManagedServiceTypeInfo {
+	_, builtin := builtinManagedServiceTypes[name]
+	info := openapi.ManagedServiceTypeInfo{
+		Type:             name,
+		Image:            t.image,
+		Builtin:          builtin,
+		PasswordRotation: t.supportsPasswordRotation(),
+		HighAvailability: t.highAvailability != noHighAvailability,
+	}
+	if t.username != "" {
+		username := t.username
+		info.Username = &username
+	}
+	for _, p := range t.ports {
+		info.Ports = append(info.Ports, struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		}{Name: p.name, Port: p.port})
+	}
+	return info
+}
+
+func sortedManagedServiceTypeNames(types map[openapi.ManagedServiceType]managedServiceType) []openapi.ManagedServiceType {
+	names := make([]openapi.ManagedServiceType, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"testing"
+)
+
+func validManagedServiceTypeDefinition() managedServiceTypeDefinition {
+	d := managedServiceTypeDefinition{
+		Name:        "clickhouse",
+		Image:       "clickhouse/clickhouse-server:24",
+		Username:    "default",
+		PasswordEnv: "CLICKHOUSE_PASSWORD",
+	}
+	d.Ports = append(d.Ports, struct {
+		Name string `mapstructure:"name"`
+		Port int    `mapstructure:"port"`
+	}{Name: "http", Port: 8123})
+	d.Volumes = append(d.Volumes, struct {
+		Name      string `mapstructure:"name"`
+		MountPath string `mapstructure:"mount-path"`
+		Size      string `mapstructure:"size"`
+	}{Name: "data", MountPath: "/var/lib/clickhouse"})
+	return d
+}
+
+func TestToManagedServiceType(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(d *managedServiceTypeDefinition)
+		wantErr bool
+	}{
+		{
+			name:   "Valid",
+			modify: func(d *managedServiceTypeDefinition) {},
+		},
+		{
+			name:    "InvalidName",
+			modify:  func(d *managedServiceTypeDefinition) { d.Name = "Click_House" },
+			wantErr: true,
+		},
+		{
+			name:    "NoImage",
+			modify:  func(d *managedServiceTypeDefinition) { d.Image = "" },
+			wantErr: true,
+		},
+		{
+			name:    "NoPorts",
+			modify:  func(d *managedServiceTypeDefinition) { d.Ports = nil },
+			wantErr: true,
+		},
+		{
+			name:    "InvalidPort",
+			modify:  func(d *managedServiceTypeDefinition) { d.Ports[0].Port = 70000 },
+			wantErr: true,
+		},
+		{
+			name:    "UnnamedPort",
+			modify:  func(d *managedServiceTypeDefinition) { d.Ports[0].Name = "" },
+			wantErr: true,
+		},
+		{
+			name:    "NoPassword",
+			modify:  func(d *managedServiceTypeDefinition) { d.PasswordEnv = "" },
+			wantErr: true,
+		},
+		{
+			name: "Passwordless",
+			modify: func(d *managedServiceTypeDefinition) {
+				d.PasswordEnv = ""
+				d.Passwordless = true
+			},
+		},
+		{
+			name:    "PasswordlessWithPassword",
+			modify:  func(d *managedServiceTypeDefinition) { d.Passwordless = true },
+			wantErr: true,
+		},
+		{
+			name: "PasswordFile",
+			modify: func(d *managedServiceTypeDefinition) {
+				d.PasswordEnv = ""
+				d.PasswordFile = "/run/secrets/clickhouse-auth/password"
+			},
+		},
+		{
+			name: "RelativePasswordFile",
+			modify: func(d *managedServiceTypeDefinition) {
+				d.PasswordEnv = ""
+				d.PasswordFile = "secrets/auth/password"
+			},
+			wantErr: true,
+		},
+		{
+			name: "PasswordFileInInvalidDirectory",
+			modify: func(d *managedServiceTypeDefinition) {
+				d.PasswordEnv = ""
+				d.PasswordFile = "/run/Secrets_Dir/password"
+			},
+			wantErr: true,
+		},
+		{
+			name:    "VolumeWithoutMountPath",
+			modify:  func(d *managedServiceTypeDefinition) { d.Volumes[0].MountPath = "" },
+			wantErr: true,
+		},
+		{
+			name:    "InvalidVolumeSize",
+			modify:  func(d *managedServiceTypeDefinition) { d.Volumes[0].Size = "ten gigabytes" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := validManagedServiceTypeDefinition()
+			tt.modify(&d)
+			if _, err := d.toManagedServiceType(); (err != nil) != tt.wantErr {
+				t.Errorf("toManagedServiceType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestToManagedServiceTypeDefaults(t *testing.T) {
+	got, err := validManagedServiceTypeDefinition().toManagedServiceType()
+	if err != nil {
+		t.Fatalf("toManagedServiceType() error = %v", err)
+	}
+	if got.volumes[0].size != "1Gi" {
+		t.Errorf("volume size = %v, want 1Gi", got.volumes[0].size)
+	}
+	if got.livenessProbe.port != 8123 || got.readinessProbe.port != 8123 {
+		t.Errorf("probe ports = %v, %v, want main port 8123", got.livenessProbe.port, got.readinessProbe.port)
+	}
+	if len(got.env) != 1 || got.env[0].name != "CLICKHOUSE_PASSWORD" || !got.env[0].password {
+		t.Errorf("env = %+v, want password env var CLICKHOUSE_PASSWORD", got.env)
+	}
+}
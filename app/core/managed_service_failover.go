@@ -0,0 +1,305 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/infrastructure/k8s"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	redisSentinelDownAfter       = 5 * time.Second
+	redisSentinelFailoverTimeout = 60 * time.Second
+	redisFailoverCheckTimeout    = 30 * time.Second
+)
+
+// redisDataPod is a running data pod of Redis with Sentinel and the replication state it reports
+type redisDataPod struct {
+	name string
+	ip   string
+	// role is the role reported by Redis (master or slave), empty if the pod did not respond
+	role   string
+	offset int64
+	// labeledMaster is true if the pod is labeled as the primary
+	labeledMaster bool
+}
+
+// sentinelView is the primary and replicas a sentinel monitors
+type sentinelView struct {
+	primary  string
+	replicas []string
+}
+
+// watchRedisFailover keeps K8s services of Redis with Sentinel pointing to the primary.
+// Sentinels promote a replica if the primary fails, the watcher moves the role labels K8s services select by.
+// It also makes sentinels monitor the primary after they are restarted, since they keep no state,
+// demotes pods that report master role besides the primary, and promotes the pod with the most recent data
+// if there is no primary and sentinels cannot fail over, e.g. on the first start or when all pods were restarted
+func (m managedServicesImpl) watchRedisFailover(ctx context.Context) {
+	if !m.leadership.IsLeader() {
+		clear(m.failoverWaits)
+		return
+	}
+	pods, err := m.clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{LabelSelector: redisComponentLabel})
+	if err != nil {
+		log.WithError(err).Errorln("Failed to list Redis pods")
+		return
+	}
+	podsByService := make(map[string][]v1.Pod)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != v1.PodRunning || pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		key := pod.Namespace + "/" + pod.Labels["letsdeploy.space/managed-service"]
+		podsByService[key] = append(podsByService[key], pod)
+	}
+	for key, servicePods := range podsByService {
+		namespace, name, _ := strings.Cut(key, "/")
+		checkCtx, cancel := context.WithTimeout(ctx, redisFailoverCheckTimeout)
+		if err := m.reconcileRedisFailover(checkCtx, namespace, name, servicePods); err != nil {
+			log.WithError(err).Errorf("Failed to check failover of managed service %s in project %s", name, namespace)
+		}
+		cancel()
+	}
+	for key := range m.failoverWaits {
+		if _, found := podsByService[key]; !found {
+			delete(m.failoverWaits, key)
+		}
+	}
+}
+
+func (m managedServicesImpl) reconcileRedisFailover(ctx context.Context, namespace string, name string, pods []v1.Pod) error {
+	var dataPods []redisDataPod
+	var sentinels []string
+	for _, pod := range pods {
+		switch pod.Labels[redisComponentLabel] {
+		case redisComponentData:
+			dataPods = append(dataPods, m.getRedisDataPod(ctx, namespace, pod))
+		case redisComponentSentinel:
+			sentinels = append(sentinels, pod.Name)
+		}
+	}
+	if len(dataPods) == 0 || len(sentinels) == 0 {
+		return nil
+	}
+	views := make([]sentinelView, len(sentinels))
+	for i, sentinel := range sentinels {
+		output, err := k8s.ExecInPod(ctx, m.clientset, m.restConfig, namespace, sentinel, containerName,
+			[]string{"/bin/sh", "-c", fmt.Sprintf(`redis-cli -p %[1]d --raw SENTINEL get-master-addr-by-name %[2]s; `+
+				`echo ---; redis-cli -p %[1]d --raw SENTINEL replicas %[2]s`, redisSentinelPort, redisSentinelPrimaryName)}, nil)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get state of sentinel %s", sentinel)
+		}
+		views[i] = parseSentinelView(output)
+	}
+
+	key := namespace + "/" + name
+	primary, canFailOver := choosePrimary(dataPods, views)
+	if primary == nil {
+		if canFailOver {
+			if since, found := m.failoverWaits[key]; !found {
+				m.failoverWaits[key] = time.Now()
+				return nil
+			} else if time.Since(since) < redisSentinelDownAfter+redisSentinelFailoverTimeout {
+				return nil
+			}
+			log.Warnf("Sentinels did not fail over managed service %s in project %s, promoting a replica", name, namespace)
+		}
+		primary = promotionCandidate(dataPods, name)
+		if primary == nil {
+			return nil
+		}
+		if err := m.execRedisCommand(ctx, namespace, primary.name, "REPLICAOF NO ONE"); err != nil {
+			return errors.Wrapf(err, "failed to promote %s", primary.name)
+		}
+		log.Infof("Promoted %s to primary of managed service %s in project %s", primary.name, name, namespace)
+	}
+	delete(m.failoverWaits, key)
+
+	for i, sentinel := range sentinels {
+		if views[i].primary == primary.ip {
+			continue
+		}
+		if err := m.monitorRedisPrimary(ctx, namespace, name, sentinel, primary.ip); err != nil {
+			return err
+		}
+		log.Infof("Sentinel %s of managed service %s in project %s monitors primary %s", sentinel, name, namespace, primary.name)
+	}
+	for _, pod := range dataPods {
+		if pod.name != primary.name && pod.role == redisRoleMaster {
+			// the pod was not demoted by sentinels, e.g. it was promoted while sentinels were not monitoring it
+			command := fmt.Sprintf("REPLICAOF %s %d", primary.ip, m.types[redisType].port())
+			if err := m.execRedisCommand(ctx, namespace, pod.name, command); err != nil {
+				return errors.Wrapf(err, "failed to demote %s", pod.name)
+			}
+			log.Infof("Demoted %s to replica of managed service %s in project %s", pod.name, name, namespace)
+		}
+		if pod.labeledMaster == (pod.name == primary.name) {
+			continue
+		}
+		role := redisRoleReplica
+		if pod.name == primary.name {
+			role = redisRoleMaster
+		}
+		patch := fmt.Sprintf(`{"metadata":{"labels":{"%s":"%s"}}}`, redisRoleLabel, role)
+		_, err := m.clientset.CoreV1().Pods(namespace).
+			Patch(ctx, pod.name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{FieldManager: "letsdeploy"})
+		if err != nil {
+			return errors.Wrapf(err, "failed to set role of %s", pod.name)
+		}
+	}
+	return nil
+}
+
+// getRedisDataPod returns the replication state of the pod, the role is empty if Redis does not respond
+func (m managedServicesImpl) getRedisDataPod(ctx context.Context, namespace string, pod v1.Pod) redisDataPod {
+	dataPod := redisDataPod{
+		name:          pod.Name,
+		ip:            pod.Status.PodIP,
+		labeledMaster: pod.Labels[redisRoleLabel] == redisRoleMaster,
+	}
+	output, err := k8s.ExecInPod(ctx, m.clientset, m.restConfig, namespace, pod.Name, containerName,
+		[]string{"/bin/sh", "-c", `redis-cli --no-auth-warning --pass "$REDIS_PASSWORD" INFO replication`}, nil)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to get replication info of %s in project %s", pod.Name, namespace)
+		return dataPod
+	}
+	dataPod.role, dataPod.offset = parseRedisReplicationInfo(output)
+	return dataPod
+}
+
+func (m managedServicesImpl) execRedisCommand(ctx context.Context, namespace string, pod string, command string) error {
+	_, err := k8s.ExecInPod(ctx, m.clientset, m.restConfig, namespace, pod, containerName, []string{"/bin/sh", "-c",
+		fmt.Sprintf(`redis-cli --no-auth-warning --pass "$REDIS_PASSWORD" %s | grep -q OK`, command)}, nil)
+	return err
+}
+
+// monitorRedisPrimary makes the sentinel monitor the primary with the given IP.
+// The password is read from the database, it is passed in stdin to keep it out of the command
+func (m managedServicesImpl) monitorRedisPrimary(ctx context.Context, namespace string, name string, sentinel string, ip string) error {
+	secret, err := m.storage.SecretRepository().FindByProjectIdAndName(namespace, getManagedServiceSecretName(name))
+	if err != nil {
+		return errors.Wrap(err, "failed to get managed service password secret")
+	}
+	script := fmt.Sprintf(`read PASS; redis-cli -p %[1]d SENTINEL REMOVE %[2]s > /dev/null; `+
+		`redis-cli -p %[1]d SENTINEL MONITOR %[2]s %[3]s %[4]d %[5]d | grep -q OK && `+
+		`redis-cli -p %[1]d SENTINEL SET %[2]s auth-pass "$PASS" `+
+		`down-after-milliseconds %[6]d failover-timeout %[7]d | grep -q OK`,
+		redisSentinelPort, redisSentinelPrimaryName, ip, m.types[redisType].port(), redisSentinelQuorum,
+		redisSentinelDownAfter.Milliseconds(), redisSentinelFailoverTimeout.Milliseconds())
+	_, err = k8s.ExecInPod(ctx, m.clientset, m.restConfig, namespace, sentinel, containerName,
+		[]string{"/bin/sh", "-c", script}, strings.NewReader(secret.Value+"\n"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to configure sentinel %s", sentinel)
+	}
+	return nil
+}
+
+// choosePrimary returns the data pod that is the primary: the one most sentinels monitor,
+// or the one reporting master role if sentinels do not monitor a running primary.
+// If there is no primary, canFailOver is true when sentinels know a running pod and can promote it
+func choosePrimary(pods []redisDataPod, views []sentinelView) (primary *redisDataPod, canFailOver bool) {
+	votes := make(map[string]int)
+	for _, view := range views {
+		votes[view.primary]++
+	}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.role != redisRoleMaster || votes[pod.ip] == 0 {
+			continue
+		}
+		if primary == nil || votes[pod.ip] > votes[primary.ip] {
+			primary = pod
+		}
+	}
+	if primary != nil {
+		return primary, false
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		if pod.role != redisRoleMaster {
+			continue
+		}
+		if primary == nil || (pod.labeledMaster && !primary.labeledMaster) ||
+			(pod.labeledMaster == primary.labeledMaster && pod.offset > primary.offset) {
+			primary = pod
+		}
+	}
+	if primary != nil {
+		return primary, false
+	}
+
+	for _, view := range views {
+		for _, replica := range view.replicas {
+			for _, pod := range pods {
+				if pod.ip == replica && pod.role != "" {
+					return nil, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// promotionCandidate returns the responding pod with the largest replication offset,
+// the pod of the primary StatefulSet is preferred if offsets are equal
+func promotionCandidate(pods []redisDataPod, name string) *redisDataPod {
+	var candidate *redisDataPod
+	for i := range pods {
+		pod := &pods[i]
+		if pod.role == "" {
+			continue
+		}
+		if candidate == nil || pod.offset > candidate.offset ||
+			(pod.offset == candidate.offset && pod.name == name+"-0") {
+			candidate = pod
+		}
+	}
+	return candidate
+}
+
+// parseSentinelView parses the output of SENTINEL get-master-addr-by-name and SENTINEL replicas separated with ---
+func parseSentinelView(output string) sentinelView {
+	var view sentinelView
+	primaryOutput, replicasOutput, _ := strings.Cut(output, "---")
+	for _, line := range strings.Split(primaryOutput, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			view.primary = line
+			break
+		}
+	}
+	lines := strings.Split(replicasOutput, "\n")
+	for i := 0; i+1 < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "ip" {
+			view.replicas = append(view.replicas, strings.TrimSpace(lines[i+1]))
+		}
+	}
+	return view
+}
+
+// parseRedisReplicationInfo returns the role and replication offset from the output of INFO replication
+func parseRedisReplicationInfo(output string) (string, int64) {
+	var role string
+	var offset int64
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
+		}
+		switch key {
+		case "role":
+			role = value
+		case "master_repl_offset":
+			offset, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return role, offset
+}
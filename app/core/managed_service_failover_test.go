@@ -0,0 +1,195 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChoosePrimary(t *testing.T) {
+	tests := []struct {
+		name            string
+		pods            []redisDataPod
+		views           []sentinelView
+		wantPrimary     string
+		wantCanFailOver bool
+	}{
+		{
+			name: "MonitoredByMostSentinels",
+			pods: []redisDataPod{
+				{name: "cache-0", ip: "10.0.0.1", role: "master"},
+				{name: "cache-replica-0", ip: "10.0.0.2", role: "master"},
+			},
+			views:       []sentinelView{{primary: "10.0.0.2"}, {primary: "10.0.0.2"}, {primary: "10.0.0.1"}},
+			wantPrimary: "cache-replica-0",
+		},
+		{
+			name: "MonitoredPodIsReplica",
+			pods: []redisDataPod{
+				{name: "cache-0", ip: "10.0.0.1", role: "slave"},
+				{name: "cache-replica-0", ip: "10.0.0.2", role: "master"},
+			},
+			views:       []sentinelView{{primary: "10.0.0.1"}},
+			wantPrimary: "cache-replica-0",
+		},
+		{
+			name: "SentinelsRestarted",
+			pods: []redisDataPod{
+				{name: "cache-0", ip: "10.0.0.1", role: "slave", offset: 100},
+				{name: "cache-replica-0", ip: "10.0.0.2", role: "master", offset: 100, labeledMaster: true},
+			},
+			views:       []sentinelView{{}, {}, {}},
+			wantPrimary: "cache-replica-0",
+		},
+		{
+			name: "LabeledMasterPreferred",
+			pods: []redisDataPod{
+				{name: "cache-0", ip: "10.0.0.1", role: "master", offset: 200},
+				{name: "cache-replica-0", ip: "10.0.0.2", role: "master", offset: 100, labeledMaster: true},
+			},
+			views:       []sentinelView{{}},
+			wantPrimary: "cache-replica-0",
+		},
+		{
+			name: "PrimaryFailedWithKnownReplica",
+			pods: []redisDataPod{
+				{name: "cache-replica-0", ip: "10.0.0.2", role: "slave"},
+			},
+			views:           []sentinelView{{primary: "10.0.0.1", replicas: []string{"10.0.0.2"}}},
+			wantCanFailOver: true,
+		},
+		{
+			name: "FirstStart",
+			pods: []redisDataPod{
+				{name: "cache-0", ip: "10.0.0.1", role: "slave"},
+				{name: "cache-replica-0", ip: "10.0.0.2", role: "slave"},
+			},
+			views: []sentinelView{{}, {}},
+		},
+		{
+			name: "KnownReplicaNotResponding",
+			pods: []redisDataPod{
+				{name: "cache-replica-0", ip: "10.0.0.2"},
+			},
+			views: []sentinelView{{primary: "10.0.0.1", replicas: []string{"10.0.0.2"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, canFailOver := choosePrimary(tt.pods, tt.views)
+			gotPrimary := ""
+			if primary != nil {
+				gotPrimary = primary.name
+			}
+			if gotPrimary != tt.wantPrimary || canFailOver != tt.wantCanFailOver {
+				t.Errorf("choosePrimary() = %q, %v, want %q, %v", gotPrimary, canFailOver, tt.wantPrimary, tt.wantCanFailOver)
+			}
+		})
+	}
+}
+
+func TestPromotionCandidate(t *testing.T) {
+	tests := []struct {
+		name string
+		pods []redisDataPod
+		want string
+	}{
+		{
+			name: "LargestOffset",
+			pods: []redisDataPod{
+				{name: "cache-0", role: "slave", offset: 100},
+				{name: "cache-replica-0", role: "slave", offset: 200},
+			},
+			want: "cache-replica-0",
+		},
+		{
+			name: "PrimaryStatefulSetOnEqualOffsets",
+			pods: []redisDataPod{
+				{name: "cache-replica-0", role: "slave"},
+				{name: "cache-0", role: "slave"},
+				{name: "cache-replica-1", role: "slave"},
+			},
+			want: "cache-0",
+		},
+		{
+			name: "NotResponding",
+			pods: []redisDataPod{
+				{name: "cache-0", offset: 300},
+				{name: "cache-replica-0", role: "slave", offset: 100},
+			},
+			want: "cache-replica-0",
+		},
+		{
+			name: "NoneResponding",
+			pods: []redisDataPod{{name: "cache-0"}},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if candidate := promotionCandidate(tt.pods, "cache"); candidate != nil {
+				got = candidate.name
+			}
+			if got != tt.want {
+				t.Errorf("promotionCandidate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSentinelView(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   sentinelView
+	}{
+		{
+			name: "Monitoring",
+			output: "10.0.0.1\n6379\n---\nname\n10.0.0.2:6379\nip\n10.0.0.2\nport\n6379\n" +
+				"name\n10.0.0.3:6379\nip\n10.0.0.3\nport\n6379\n",
+			want: sentinelView{primary: "10.0.0.1", replicas: []string{"10.0.0.2", "10.0.0.3"}},
+		},
+		{
+			name:   "NotMonitoring",
+			output: "\n---\nERR No such master with that name\n",
+			want:   sentinelView{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSentinelView(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSentinelView() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRedisReplicationInfo(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		wantRole   string
+		wantOffset int64
+	}{
+		{
+			name:       "Master",
+			output:     "# Replication\r\nrole:master\r\nconnected_slaves:1\r\nmaster_repl_offset:1234\r\n",
+			wantRole:   "master",
+			wantOffset: 1234,
+		},
+		{
+			name:       "Replica",
+			output:     "# Replication\r\nrole:slave\r\nmaster_host:cache\r\nslave_repl_offset:42\r\nmaster_repl_offset:42\r\n",
+			wantRole:   "slave",
+			wantOffset: 42,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, offset := parseRedisReplicationInfo(tt.output)
+			if role != tt.wantRole || offset != tt.wantOffset {
+				t.Errorf("parseRedisReplicationInfo() = %v, %v, want %v, %v", role, offset, tt.wantRole, tt.wantOffset)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	applyConfigsAppsV1 "k8s.io/client-go/applyconfigurations/apps/v1"
+	applyConfigsCoreV1 "k8s.io/client-go/applyconfigurations/core/v1"
+	"path"
+)
+
+// highAvailabilityMode defines how managed service with more than one replica is deployed
+type highAvailabilityMode int
+
+const (
+	noHighAvailability highAvailabilityMode = iota
+	// postgresStreamingReplication deploys read-only replicas that stream WAL from the primary,
+	// replicas are available through <name>-ro K8s service
+	postgresStreamingReplication
+	// redisSentinel deploys replicas of the primary (available through <name>-ro K8s service)
+	// and 3 Sentinel instances (available through <name>-sentinel K8s service) that monitor the primary
+	// named redisSentinelPrimaryName and fail over to a replica.
+	// Any data pod can be the primary, K8s services select pods by the role label set by watchRedisFailover
+	redisSentinel
+)
+
+const (
+	maxManagedServiceReplicas = 5
+	// postgresReplicationPasswordFile is the path the password secret is mounted to in Postgres replicas
+	postgresReplicationPasswordFile = "/run/secrets/postgres-replication/password"
+	postgresReplicationPassFile     = "/tmp/.pgpass"
+	redisSentinelPort               = 26379
+	redisSentinelReplicas           = 3
+	redisSentinelQuorum             = 2
+	redisSentinelPrimaryName        = "primary"
+	// redisComponentLabel distinguishes data pods and sentinels of Redis with Sentinel
+	redisComponentLabel    = "letsdeploy.space/redis-component"
+	redisComponentData     = "data"
+	redisComponentSentinel = "sentinel"
+	// redisRoleLabel is set on data pods by watchRedisFailover to the role reported by Redis
+	redisRoleLabel   = "letsdeploy.space/redis-role"
+	redisRoleMaster  = "master"
+	redisRoleReplica = "replica"
+)
+
+// statefulSetComponent is a StatefulSet managed service consists of
+type statefulSetComponent struct {
+	name string
+	role openapi.ServiceInstanceStatusRole
+}
+
+func replicaStatefulSetName(name string) string {
+	return name + "-replica"
+}
+
+func readOnlyServiceName(name string) string {
+	return name + "-ro"
+}
+
+func sentinelName(name string) string {
+	return name + "-sentinel"
+}
+
+func managedServiceReplicas(service openapi.ManagedService) int {
+	if service.Replicas == nil {
+		return 1
+	}
+	return *service.Replicas
+}
+
+func (m managedServicesImpl) highAvailabilityEnabled(service openapi.ManagedService) bool {
+	return managedServiceReplicas(service) > 1 && m.types[service.Type].highAvailability != noHighAvailability
+}
+
+func (m managedServicesImpl) validateReplicas(service openapi.ManagedService) error {
+	replicas := managedServiceReplicas(service)
+	if replicas < 1 || replicas > maxManagedServiceReplicas {
+		return apperrors.BadRequest(fmt.Sprintf("Managed service replicas must be between 1 and %d", maxManagedServiceReplicas))
+	}
+	if replicas > 1 && m.types[service.Type].highAvailability == noHighAvailability {
+		return apperrors.BadRequest(fmt.Sprintf("Managed service type %s does not support replicas", service.Type))
+	}
+	return nil
+}
+
+func (m managedServicesImpl) statefulSetComponents(service openapi.ManagedService) []statefulSetComponent {
+	components := []statefulSetComponent{{name: service.Name, role: openapi.Primary}}
+	if !m.highAvailabilityEnabled(service) {
+		return components
+	}
+	components = append(components, statefulSetComponent{name: replicaStatefulSetName(service.Name), role: openapi.Replica})
+	if m.types[service.Type].highAvailability == redisSentinel {
+		components = append(components, statefulSetComponent{name: sentinelName(service.Name), role: openapi.Sentinel})
+	}
+	return components
+}
+
+// highAvailabilityPrimaryCommand returns the command of the primary instance if it differs from the default one
+func (m managedServicesImpl) highAvailabilityPrimaryCommand(service openapi.ManagedService) []string {
+	if !m.highAvailabilityEnabled(service) {
+		return nil
+	}
+	switch m.types[service.Type].highAvailability {
+	case postgresStreamingReplication:
+		// default pg_hba.conf of postgres image does not allow replication connections
+		return []string{"/bin/sh", "-c", `printf "local all all trust\nhost all all 127.0.0.1/32 trust\n` +
+			`host all all ::1/128 trust\nhost all all all scram-sha-256\nhost replication all all scram-sha-256\n" ` +
+			`> /tmp/pg_hba.conf && exec docker-entrypoint.sh postgres -c hba_file=/tmp/pg_hba.conf`}
+	case redisSentinel:
+		return m.redisDataCommand(service)
+	default:
+		return nil
+	}
+}
+
+func (m managedServicesImpl) highAvailabilityReplicaCommand(service openapi.ManagedService) []string {
+	port := m.types[service.Type].port()
+	switch m.types[service.Type].highAvailability {
+	case postgresStreamingReplication:
+		// replica data is copied from the primary on the first start.
+		// The password is passed in a passfile written from the mounted secret on every start
+		// (to pick up the rotated password), so that it is not visible in the process list
+		return []string{"/bin/sh", "-c", fmt.Sprintf(`export PGDATA=${PGDATA:-/var/lib/postgresql/data}; `+
+			`(umask 077; printf '%[1]s:%[2]d:*:postgres:%%s\n' "$(cat %[3]s)" > %[4]s); chown postgres %[4]s; `+
+			`export PGPASSFILE=%[4]s; `+
+			`if [ ! -s "$PGDATA/PG_VERSION" ]; then `+
+			`until pg_basebackup -h %[1]s -p %[2]d -U postgres -D "$PGDATA" -X stream -R; `+
+			`do echo "Waiting for primary"; rm -rf "$PGDATA"; sleep 5; done; fi; `+
+			`exec docker-entrypoint.sh postgres `+
+			`-c "primary_conninfo=host=%[1]s port=%[2]d user=postgres passfile=%[4]s application_name=$HOSTNAME"`,
+			service.Name, port, postgresReplicationPasswordFile, postgresReplicationPassFile)}
+	case redisSentinel:
+		return m.redisDataCommand(service)
+	default:
+		return nil
+	}
+}
+
+// redisDataCommand returns the command of Redis data pods. Every pod starts as a replica of the current primary,
+// so that a restarted primary does not come back as the second one. If there is no primary,
+// e.g. when all pods are restarted, watchRedisFailover promotes the pod with the most recent data.
+// Replicas announce the pod IP, so that sentinels can reach them and promote them
+func (m managedServicesImpl) redisDataCommand(service openapi.ManagedService) []string {
+	return []string{"/bin/sh", "-c", fmt.Sprintf("exec redis-server --appendonly yes "+
+		"--requirepass ${REDIS_PASSWORD} --masterauth ${REDIS_PASSWORD} --replica-announce-ip ${POD_IP} --replicaof %s %d",
+		service.Name, m.types[service.Type].port())}
+}
+
+// applyHighAvailabilityComponents creates or updates replicas (and sentinels) of the managed service,
+// deletes them if the managed service has a single replica. PVCs of deleted replicas are kept
+func (m managedServicesImpl) applyHighAvailabilityComponents(ctx context.Context, service openapi.ManagedService) error {
+	if !m.highAvailabilityEnabled(service) {
+		return m.deleteHighAvailabilityComponents(ctx, service.Project, service.Name)
+	}
+
+	replicas := m.replicaStatefulSetConfig(service)
+	_, err := m.clientset.AppsV1().StatefulSets(service.Project).Apply(ctx, replicas, metav1.ApplyOptions{FieldManager: "letsdeploy"})
+	if err != nil {
+		return errors.Wrap(err, "failed to apply managed service replicas StatefulSet")
+	}
+	err = m.applyK8sService(ctx, service, readOnlyServiceName(service.Name), m.servicePorts(service))
+	if err != nil {
+		return err
+	}
+	if m.types[service.Type].highAvailability != redisSentinel {
+		return nil
+	}
+
+	sentinel := m.sentinelStatefulSetConfig(service)
+	_, err = m.clientset.AppsV1().StatefulSets(service.Project).Apply(ctx, sentinel, metav1.ApplyOptions{FieldManager: "letsdeploy"})
+	if err != nil {
+		return errors.Wrap(err, "failed to apply managed service sentinel StatefulSet")
+	}
+	return m.applyK8sService(ctx, service, sentinelName(service.Name), sentinelServicePorts())
+}
+
+func (m managedServicesImpl) replicaStatefulSetConfig(service openapi.ManagedService) *applyConfigsAppsV1.StatefulSetApplyConfiguration {
+	replicas := m.statefulSetConfig(service, replicaStatefulSetName(service.Name),
+		scaledReplicas(service, int32(managedServiceReplicas(service)-1)), m.highAvailabilityReplicaCommand(service))
+	if m.types[service.Type].highAvailability == redisSentinel {
+		return m.withRedisDataPods(service, replicas)
+	}
+	if m.types[service.Type].highAvailability != postgresStreamingReplication {
+		return replicas
+	}
+	volumeName := path.Base(path.Dir(postgresReplicationPasswordFile))
+	podSpec := replicas.Spec.Template.Spec
+	podSpec.Containers[0].WithVolumeMounts(applyConfigsCoreV1.VolumeMount().
+		WithName(volumeName).
+		WithMountPath(path.Dir(postgresReplicationPasswordFile)).
+		WithReadOnly(true))
+	podSpec.WithVolumes(applyConfigsCoreV1.Volume().
+		WithName(volumeName).
+		WithSecret(applyConfigsCoreV1.SecretVolumeSource().
+			WithSecretName(getManagedServiceSecretName(service.Name)).
+			WithItems(applyConfigsCoreV1.KeyToPath().
+				WithKey(secretKey).
+				WithPath(path.Base(postgresReplicationPasswordFile)))))
+	return replicas
+}
+
+// withRedisDataPods labels pods of Redis with Sentinel StatefulSet as data pods and passes the pod IP they announce
+func (m managedServicesImpl) withRedisDataPods(
+	service openapi.ManagedService,
+	statefulSet *applyConfigsAppsV1.StatefulSetApplyConfiguration,
+) *applyConfigsAppsV1.StatefulSetApplyConfiguration {
+	statefulSet.Spec.Template.WithLabels(map[string]string{
+		"letsdeploy.space/managed-service": service.Name,
+		redisComponentLabel:                redisComponentData,
+	})
+	statefulSet.Spec.Template.Spec.Containers[0].WithEnv(applyConfigsCoreV1.EnvVar().
+		WithName("POD_IP").
+		WithValueFrom(applyConfigsCoreV1.EnvVarSource().
+			WithFieldRef(applyConfigsCoreV1.ObjectFieldSelector().WithFieldPath("status.podIP"))))
+	return statefulSet
+}
+
+func (m managedServicesImpl) sentinelStatefulSetConfig(service openapi.ManagedService) *applyConfigsAppsV1.StatefulSetApplyConfiguration {
+	sentinel := m.statefulSetConfig(service, sentinelName(service.Name), scaledReplicas(service, redisSentinelReplicas), nil)
+	sentinel.Spec.VolumeClaimTemplates = nil
+	sentinel.Spec.Template.Spec.Volumes = nil
+	sentinel.Spec.Template.Spec.Containers[0] = *m.sentinelContainer(service)
+	sentinel.Spec.Template.WithLabels(map[string]string{
+		"letsdeploy.space/managed-service": service.Name,
+		redisComponentLabel:                redisComponentSentinel,
+	})
+	return sentinel
+}
+
+func sentinelServicePorts() []*applyConfigsCoreV1.ServicePortApplyConfiguration {
+	return []*applyConfigsCoreV1.ServicePortApplyConfiguration{applyConfigsCoreV1.ServicePort().
+		WithName("sentinel").
+		WithPort(redisSentinelPort).
+		WithTargetPort(intstr.FromInt32(redisSentinelPort))}
+}
+
+// sentinelContainer starts Sentinel without monitored primary, watchRedisFailover configures it on every start,
+// so that sentinels do not lose the primary they monitor and the password when they are restarted
+func (m managedServicesImpl) sentinelContainer(service openapi.ManagedService) *applyConfigsCoreV1.ContainerApplyConfiguration {
+	script := fmt.Sprintf(`printf "port %d\n" > /tmp/sentinel.conf && exec redis-sentinel /tmp/sentinel.conf`, redisSentinelPort)
+	probe := createProbe(managedServiceProbe{
+		command:             []string{"/bin/sh", "-c", fmt.Sprintf("redis-cli -p %d ping | grep -q PONG", redisSentinelPort)},
+		initialDelaySeconds: 10,
+		periodSeconds:       20,
+		timeoutSeconds:      5,
+		failureThreshold:    3,
+	})
+	return applyConfigsCoreV1.Container().
+		WithName(containerName).
+		WithImage(m.types[service.Type].image).
+		WithPorts(applyConfigsCoreV1.ContainerPort().WithName("sentinel").WithContainerPort(redisSentinelPort)).
+		WithCommand("/bin/sh", "-c", script).
+		WithLivenessProbe(probe).
+		WithReadinessProbe(probe)
+}
+
+// highAvailabilitySelector returns the selector of K8s service with the given name if it differs from app=<name>.
+// Primary and read-only services of Redis with Sentinel select data pods by the role label
+func (m managedServicesImpl) highAvailabilitySelector(service openapi.ManagedService, name string) map[string]string {
+	if !m.highAvailabilityEnabled(service) || m.types[service.Type].highAvailability != redisSentinel {
+		return nil
+	}
+	var role string
+	switch name {
+	case service.Name:
+		role = redisRoleMaster
+	case readOnlyServiceName(service.Name):
+		role = redisRoleReplica
+	default:
+		return nil
+	}
+	return map[string]string{
+		"letsdeploy.space/managed-service": service.Name,
+		redisComponentLabel:                redisComponentData,
+		redisRoleLabel:                     role,
+	}
+}
+
+// rotatesPasswordByRestart returns true if the password of the managed service cannot be changed in place.
+// Any data pod of Redis with Sentinel can be the primary, and sentinels get the password when they are configured,
+// so the whole setup is restarted
+func (m managedServicesImpl) rotatesPasswordByRestart(service openapi.ManagedService) bool {
+	definition := m.types[service.Type]
+	return definition.rotateByRestart || (m.highAvailabilityEnabled(service) && definition.highAvailability == redisSentinel)
+}
+
+// restartHighAvailabilityComponents restarts replicas and sentinels, they read the password only on startup
+func (m managedServicesImpl) restartHighAvailabilityComponents(ctx context.Context, service openapi.ManagedService) error {
+	for _, component := range m.statefulSetComponents(service) {
+		if component.role == openapi.Primary {
+			continue
+		}
+		if err := m.restartStatefulSet(ctx, service.Project, component.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m managedServicesImpl) deleteHighAvailabilityComponents(ctx context.Context, namespace string, name string) error {
+	for _, statefulSet := range []string{replicaStatefulSetName(name), sentinelName(name)} {
+		err := m.clientset.AppsV1().StatefulSets(namespace).Delete(ctx, statefulSet, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete managed service StatefulSet %s", statefulSet)
+		}
+	}
+	for _, k8sService := range []string{readOnlyServiceName(name), sentinelName(name)} {
+		if err := m.deleteK8sService(ctx, namespace, k8sService); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,417 @@
+package core
+
+import (
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"slices"
+	"strings"
+)
+
+// managedServiceType describes how a managed service of some type is deployed:
+// its image, ports, credentials, probes and volumes
+type managedServiceType struct {
+	image    string
+	username string
+	// ports exposed by the container and K8s service, the first one is the main port
+	ports   []managedServicePort
+	command []string
+	env     []managedServiceEnvVar
+	// passwordFile is a path the password secret is mounted to, empty if password is not mounted as a file
+	passwordFile   string
+	livenessProbe  managedServiceProbe
+	readinessProbe managedServiceProbe
+	volumes        []managedServiceVolume
+	fsGroup        int64
+	// passwordless types do not get a password secret
+	passwordless bool
+	// rotatePasswordCmd is executed in the managed service pod,
+	// the old and the new passwords are passed to stdin line by line
+	rotatePasswordCmd []string
+	// rotateByRestart types read the password only on startup, so the rotation is done by restarting the pod
+	rotateByRestart bool
+	// highAvailability defines how managed service with more than one replica is deployed
+	highAvailability highAvailabilityMode
+}
+
+type managedServicePort struct {
+	name string
+	port int
+}
+
+type managedServiceEnvVar struct {
+	name  string
+	value string
+	// fieldPath references pod field, e.g. metadata.namespace
+	fieldPath string
+	// password env var gets the value from the managed service password secret
+	password bool
+}
+
+// managedServiceProbe is either exec (if command is defined), HTTP GET (if httpPath is defined) or TCP probe
+type managedServiceProbe struct {
+	command             []string
+	httpPath            string
+	port                int
+	initialDelaySeconds int32
+	periodSeconds       int32
+	timeoutSeconds      int32
+	failureThreshold    int32
+}
+
+type managedServiceVolume struct {
+	name      string
+	mountPath string
+	size      string
+}
+
+func (t managedServiceType) port() int {
+	return t.ports[0].port
+}
+
+func (t managedServiceType) supportsPasswordRotation() bool {
+	return !t.passwordless && (t.rotatePasswordCmd != nil || t.rotateByRestart)
+}
+
+// restartsOnPasswordRotation returns true if the primary is restarted after the password is rotated.
+// Probes that read the password env var keep its value from the container start, and the mounted password file
+// is refreshed by kubelet with a delay, so such probes fail after the password is changed in the engine
+func (t managedServiceType) restartsOnPasswordRotation() bool {
+	return t.rotateByRestart || t.probesReadPassword()
+}
+
+func (t managedServiceType) probesReadPassword() bool {
+	var references []string
+	if t.passwordFile != "" {
+		references = append(references, t.passwordFile)
+	}
+	for _, envVar := range t.env {
+		if envVar.password || (t.passwordFile != "" && envVar.value == t.passwordFile) {
+			references = append(references, "$"+envVar.name, "${"+envVar.name+"}")
+		}
+	}
+	for _, reference := range references {
+		readsReference := func(arg string) bool { return strings.Contains(arg, reference) }
+		if slices.ContainsFunc(t.livenessProbe.command, readsReference) ||
+			slices.ContainsFunc(t.readinessProbe.command, readsReference) {
+			return true
+		}
+	}
+	return false
+}
+
+var serviceNameEnvVar = managedServiceEnvVar{name: "SERVICE_NAME", fieldPath: "metadata.labels['app']"}
+var namespaceEnvVar = managedServiceEnvVar{name: "NAMESPACE", fieldPath: "metadata.namespace"}
+
+const (
+	postgresType      openapi.ManagedServiceType = "postgres"
+	mysqlType         openapi.ManagedServiceType = "mysql"
+	mongoType         openapi.ManagedServiceType = "mongo"
+	redisType         openapi.ManagedServiceType = "redis"
+	rabbitmqType      openapi.ManagedServiceType = "rabbitmq"
+	minioType         openapi.ManagedServiceType = "minio"
+	kafkaType         openapi.ManagedServiceType = "kafka"
+	elasticsearchType openapi.ManagedServiceType = "elasticsearch"
+	memcachedType     openapi.ManagedServiceType = "memcached"
+	natsType          openapi.ManagedServiceType = "nats"
+)
+
+// builtinManagedServiceTypes are available regardless of the managed service catalog
+var builtinManagedServiceTypes = map[openapi.ManagedServiceType]managedServiceType{
+	postgresType: {
+		image:    "postgres:15",
+		username: "postgres",
+		ports:    []managedServicePort{{name: "postgres", port: 5432}},
+		env:      []managedServiceEnvVar{{name: "POSTGRES_PASSWORD", password: true}},
+		livenessProbe: managedServiceProbe{
+			command:             []string{"pg_isready", "--port=5432"},
+			initialDelaySeconds: 20,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		readinessProbe: managedServiceProbe{
+			command:             []string{"pg_isready", "--port=5432"},
+			initialDelaySeconds: 30,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		volumes: []managedServiceVolume{{name: "data", mountPath: "/var/lib/postgresql", size: "1Gi"}},
+		rotatePasswordCmd: []string{"/bin/sh", "-c", `read OLD; read NEW; ` +
+			`psql -v ON_ERROR_STOP=1 -U postgres -c "ALTER USER postgres PASSWORD '$NEW'"`},
+		highAvailability: postgresStreamingReplication,
+	},
+	mysqlType: {
+		image:    "mysql:8",
+		username: "root",
+		ports:    []managedServicePort{{name: "mysql", port: 3306}},
+		env: []managedServiceEnvVar{
+			{name: "MYSQL_ROOT_PASSWORD", password: true},
+			{name: "MYSQL_DATABASE", value: "db"},
+		},
+		livenessProbe: managedServiceProbe{
+			command:             []string{"/bin/sh", "-c", "mysqladmin -uroot -p$MYSQL_ROOT_PASSWORD ping"},
+			initialDelaySeconds: 20,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		readinessProbe: managedServiceProbe{
+			command:             []string{"/bin/sh", "-c", "mysql -h 127.0.0.1 -uroot -p$MYSQL_ROOT_PASSWORD -e 'SELECT 1'"},
+			initialDelaySeconds: 30,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		volumes: []managedServiceVolume{{name: "data", mountPath: "/var/lib/mysql", size: "1Gi"}},
+		rotatePasswordCmd: []string{"/bin/sh", "-c", `read OLD; read NEW; ` +
+			`mysql -uroot -p"$OLD" -e "ALTER USER 'root'@'%' IDENTIFIED BY '$NEW'; ` +
+			`ALTER USER 'root'@'localhost' IDENTIFIED BY '$NEW'; FLUSH PRIVILEGES;"`},
+	},
+	mongoType: {
+		image:    "mongo:6",
+		username: "root",
+		ports:    []managedServicePort{{name: "mongo", port: 27017}},
+		env: []managedServiceEnvVar{
+			{name: "MONGO_INITDB_ROOT_PASSWORD_FILE", value: "/run/secrets/mongo-root-auth/password"},
+			{name: "MONGO_INITDB_ROOT_USERNAME", value: "root"},
+		},
+		passwordFile: "/run/secrets/mongo-root-auth/password",
+		livenessProbe: managedServiceProbe{
+			command:             []string{"/bin/sh", "-c", "mongosh --port 27017 --eval 'db.runCommand({ping: 1})' --quiet"},
+			initialDelaySeconds: 20,
+			periodSeconds:       30,
+			timeoutSeconds:      10,
+			failureThreshold:    3,
+		},
+		readinessProbe: managedServiceProbe{
+			command: []string{"/bin/sh", "-c", "mongosh --port 27017 --username root " +
+				"--password \"$(cat $MONGO_INITDB_ROOT_PASSWORD_FILE)\" --eval 'db.serverStatus().ok' --quiet | grep -q 1"},
+			initialDelaySeconds: 30,
+			periodSeconds:       40,
+			timeoutSeconds:      10,
+			failureThreshold:    3,
+		},
+		volumes: []managedServiceVolume{{name: "data", mountPath: "/data/db", size: "1Gi"}},
+		rotatePasswordCmd: []string{"/bin/sh", "-c", `read OLD; read NEW; ` +
+			`mongosh --port 27017 --username root --password "$OLD" --authenticationDatabase admin --quiet ` +
+			`--eval "db.getSiblingDB('admin').changeUserPassword('root', '$NEW')"`},
+	},
+	redisType: {
+		image:    "redis:7",
+		username: "",
+		ports:    []managedServicePort{{name: "redis", port: 6379}},
+		command:  []string{"/bin/sh", "-c", "redis-server --appendonly yes --requirepass ${REDIS_PASSWORD}"},
+		env:      []managedServiceEnvVar{{name: "REDIS_PASSWORD", password: true}},
+		livenessProbe: managedServiceProbe{
+			command:             []string{"/bin/sh", "-c", "redis-cli --pass $REDIS_PASSWORD ping | grep -q PONG"},
+			initialDelaySeconds: 20,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		readinessProbe: managedServiceProbe{
+			command:             []string{"/bin/sh", "-c", "redis-cli --pass $REDIS_PASSWORD ping | grep -q PONG"},
+			initialDelaySeconds: 30,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		volumes: []managedServiceVolume{{name: "data", mountPath: "/data", size: "500Mi"}},
+		rotatePasswordCmd: []string{"/bin/sh", "-c", `read OLD; read NEW; ` +
+			`redis-cli --no-auth-warning --pass "$OLD" CONFIG SET requirepass "$NEW" | grep -q OK`},
+		highAvailability: redisSentinel,
+	},
+	rabbitmqType: {
+		image:    "rabbitmq:3-management",
+		username: "guest",
+		ports: []managedServicePort{
+			{name: "amqp", port: 5672},
+			{name: "http", port: 15672},
+			{name: "epmd", port: 4369},
+		},
+		env: []managedServiceEnvVar{
+			{name: "HOSTNAME", fieldPath: "metadata.name"},
+			{name: "NODE_NAME", fieldPath: "metadata.name"},
+			namespaceEnvVar,
+			serviceNameEnvVar,
+			{name: "RABBITMQ_USE_LONGNAME", value: "true"},
+			{name: "RABBITMQ_NODENAME", value: "rabbit@$(HOSTNAME).$(SERVICE_NAME).$(NAMESPACE).svc.cluster.local"},
+			{name: "RABBITMQ_DEFAULT_USER", value: "guest"},
+			{name: "RABBITMQ_DEFAULT_PASS", password: true},
+			{name: "RABBITMQ_ERLANG_COOKIE", value: "secret_cookie_12345678"}, // TODO refactor
+		},
+		livenessProbe: managedServiceProbe{
+			command:             []string{"rabbitmq-diagnostics", "status", "--timeout", "10"},
+			initialDelaySeconds: 20,
+			periodSeconds:       20,
+			timeoutSeconds:      15,
+			failureThreshold:    3,
+		},
+		readinessProbe: managedServiceProbe{
+			command:             []string{"rabbitmq-diagnostics", "ping", "--timeout", "10"},
+			initialDelaySeconds: 30,
+			periodSeconds:       20,
+			timeoutSeconds:      10,
+			failureThreshold:    3,
+		},
+		rotatePasswordCmd: []string{"/bin/sh", "-c", `read OLD; read NEW; ` +
+			`rabbitmqctl change_password guest "$NEW"`},
+	},
+	minioType: {
+		image:    "quay.io/minio/minio:RELEASE.2024-03-15T01-07-19Z",
+		username: "minio",
+		ports: []managedServicePort{
+			{name: "api", port: 9000},
+			{name: "console", port: 9001},
+		},
+		command: []string{"minio", "server", "/data", "--console-address", ":9001"},
+		env: []managedServiceEnvVar{
+			{name: "MINIO_ROOT_USER", value: "minio"},
+			{name: "MINIO_ROOT_PASSWORD", password: true},
+		},
+		livenessProbe: managedServiceProbe{
+			httpPath:            "/minio/health/live",
+			port:                9000,
+			initialDelaySeconds: 10,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		readinessProbe: managedServiceProbe{
+			httpPath:            "/minio/health/ready",
+			port:                9000,
+			initialDelaySeconds: 10,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		volumes:         []managedServiceVolume{{name: "data", mountPath: "/data", size: "1Gi"}},
+		rotateByRestart: true,
+	},
+	kafkaType: {
+		image:    "bitnami/kafka:3.7",
+		username: "user",
+		ports: []managedServicePort{
+			{name: "client", port: 9092},
+			{name: "controller", port: 9093},
+		},
+		env: []managedServiceEnvVar{
+			namespaceEnvVar,
+			serviceNameEnvVar,
+			{name: "KAFKA_CFG_NODE_ID", value: "0"},
+			{name: "KAFKA_CFG_PROCESS_ROLES", value: "controller,broker"},
+			{name: "KAFKA_CFG_CONTROLLER_QUORUM_VOTERS", value: "0@localhost:9093"},
+			{name: "KAFKA_CFG_LISTENERS", value: "CLIENT://:9092,CONTROLLER://:9093"},
+			{name: "KAFKA_CFG_ADVERTISED_LISTENERS", value: "CLIENT://$(SERVICE_NAME).$(NAMESPACE).svc.cluster.local:9092"},
+			{name: "KAFKA_CFG_LISTENER_SECURITY_PROTOCOL_MAP", value: "CLIENT:SASL_PLAINTEXT,CONTROLLER:PLAINTEXT"},
+			{name: "KAFKA_CFG_CONTROLLER_LISTENER_NAMES", value: "CONTROLLER"},
+			{name: "KAFKA_CFG_INTER_BROKER_LISTENER_NAME", value: "CLIENT"},
+			{name: "KAFKA_CFG_SASL_MECHANISM_INTER_BROKER_PROTOCOL", value: "PLAIN"},
+			{name: "KAFKA_CLIENT_USERS", value: "user"},
+			{name: "KAFKA_CLIENT_PASSWORDS", password: true},
+			{name: "KAFKA_INTER_BROKER_USER", value: "user"},
+			{name: "KAFKA_INTER_BROKER_PASSWORD", password: true},
+		},
+		livenessProbe: managedServiceProbe{
+			port:                9092,
+			initialDelaySeconds: 30,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		readinessProbe: managedServiceProbe{
+			port:                9092,
+			initialDelaySeconds: 30,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		volumes:         []managedServiceVolume{{name: "data", mountPath: "/bitnami/kafka", size: "2Gi"}},
+		fsGroup:         1001,
+		rotateByRestart: true,
+	},
+	elasticsearchType: {
+		image:    "docker.elastic.co/elasticsearch/elasticsearch:8.12.2",
+		username: "elastic",
+		ports: []managedServicePort{
+			{name: "http", port: 9200},
+			{name: "transport", port: 9300},
+		},
+		env: []managedServiceEnvVar{
+			{name: "discovery.type", value: "single-node"},
+			{name: "xpack.security.enabled", value: "true"},
+			{name: "xpack.security.http.ssl.enabled", value: "false"},
+			{name: "ES_JAVA_OPTS", value: "-Xms512m -Xmx512m"},
+			{name: "ELASTIC_PASSWORD", password: true},
+		},
+		livenessProbe: managedServiceProbe{
+			port:                9200,
+			initialDelaySeconds: 30,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		readinessProbe: managedServiceProbe{
+			command: []string{"/bin/sh", "-c", "curl -sf -u elastic:$ELASTIC_PASSWORD " +
+				"'http://localhost:9200/_cluster/health?wait_for_status=yellow&timeout=5s'"},
+			initialDelaySeconds: 40,
+			periodSeconds:       20,
+			timeoutSeconds:      10,
+			failureThreshold:    3,
+		},
+		volumes: []managedServiceVolume{{name: "data", mountPath: "/usr/share/elasticsearch/data", size: "2Gi"}},
+		fsGroup: 1000,
+		rotatePasswordCmd: []string{"/bin/sh", "-c", `read OLD; read NEW; ` +
+			`curl -sf -u "elastic:$OLD" -X POST http://localhost:9200/_security/user/elastic/_password ` +
+			`-H 'Content-Type: application/json' -d "{\"password\":\"$NEW\"}"`},
+	},
+	memcachedType: {
+		image:   "memcached:1.6",
+		ports:   []managedServicePort{{name: "memcached", port: 11211}},
+		command: []string{"memcached", "-m", "256"},
+		livenessProbe: managedServiceProbe{
+			port:                11211,
+			initialDelaySeconds: 10,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		readinessProbe: managedServiceProbe{
+			port:                11211,
+			initialDelaySeconds: 5,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		passwordless: true,
+	},
+	natsType: {
+		image:    "nats:2.10",
+		username: "nats",
+		ports: []managedServicePort{
+			{name: "client", port: 4222},
+			{name: "monitoring", port: 8222},
+		},
+		command: []string{"nats-server", "--jetstream", "--store_dir", "/data", "--http_port", "8222",
+			"--user", "nats", "--pass", "$(NATS_PASSWORD)"},
+		env: []managedServiceEnvVar{{name: "NATS_PASSWORD", password: true}},
+		livenessProbe: managedServiceProbe{
+			httpPath:            "/healthz",
+			port:                8222,
+			initialDelaySeconds: 10,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		readinessProbe: managedServiceProbe{
+			httpPath:            "/healthz?js-enabled-only=true",
+			port:                8222,
+			initialDelaySeconds: 10,
+			periodSeconds:       20,
+			timeoutSeconds:      5,
+			failureThreshold:    3,
+		},
+		volumes:         []managedServiceVolume{{name: "data", mountPath: "/data", size: "1Gi"}},
+		rotateByRestart: true,
+	},
+}
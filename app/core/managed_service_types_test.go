@@ -0,0 +1,90 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordRotationKeepsProbesValid(t *testing.T) {
+	for name, definition := range builtinManagedServiceTypes {
+		if definition.rotatePasswordCmd == nil {
+			continue
+		}
+		t.Run(string(name), func(t *testing.T) {
+			if definition.restartsOnPasswordRotation() {
+				return
+			}
+			for _, probe := range []managedServiceProbe{definition.livenessProbe, definition.readinessProbe} {
+				command := strings.Join(probe.command, " ")
+				for _, envVar := range definition.env {
+					if envVar.password && strings.Contains(command, envVar.name) {
+						t.Errorf("probe %q reads password env var %s, but the primary is not restarted on rotation",
+							command, envVar.name)
+					}
+					if definition.passwordFile != "" && envVar.value == definition.passwordFile &&
+						strings.Contains(command, envVar.name) {
+						t.Errorf("probe %q reads password file from env var %s, but the primary is not restarted on rotation",
+							command, envVar.name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRestartsOnPasswordRotation(t *testing.T) {
+	tests := []struct {
+		name         string
+		managedType  managedServiceType
+		wantRestarts bool
+	}{
+		{
+			name:         "ProbeWithoutPassword",
+			managedType:  builtinManagedServiceTypes[postgresType],
+			wantRestarts: false,
+		},
+		{
+			name:         "ProbeReadsPasswordEnvVar",
+			managedType:  builtinManagedServiceTypes[mysqlType],
+			wantRestarts: true,
+		},
+		{
+			name: "ProbeReadsPasswordEnvVarInBraces",
+			managedType: managedServiceType{
+				env:            []managedServiceEnvVar{{name: "PASSWORD", password: true}},
+				readinessProbe: managedServiceProbe{command: []string{"/bin/sh", "-c", "check --password ${PASSWORD}"}},
+			},
+			wantRestarts: true,
+		},
+		{
+			name: "ProbeReadsOtherEnvVar",
+			managedType: managedServiceType{
+				env:            []managedServiceEnvVar{{name: "PASSWORD", password: true}, {name: "PASSWORD_FILE", value: "/tmp/p"}},
+				readinessProbe: managedServiceProbe{command: []string{"/bin/sh", "-c", "check --user $USER"}},
+			},
+			wantRestarts: false,
+		},
+		{
+			name:         "ReadinessProbeReadsPasswordEnvVar",
+			managedType:  builtinManagedServiceTypes[elasticsearchType],
+			wantRestarts: true,
+		},
+		{
+			name:         "ProbeReadsPasswordFile",
+			managedType:  builtinManagedServiceTypes[mongoType],
+			wantRestarts: true,
+		},
+		{
+			name:         "RotateByRestart",
+			managedType:  builtinManagedServiceTypes[minioType],
+			wantRestarts: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.managedType.restartsOnPasswordRotation(); got != tt.wantRestarts {
+				t.Errorf("restartsOnPasswordRotation() = %v, want %v", got, tt.wantRestarts)
+			}
+		})
+	}
+}
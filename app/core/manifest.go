@@ -0,0 +1,550 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"slices"
+)
+
+// Manifests exports the project as a declarative document and applies such documents to the project
+// through the other core components, so that the same permission checks, audit and K8s sync are performed
+type Manifests interface {
+	GetProjectManifest(projectId string, auth middleware.Authentication) (*openapi.ProjectManifest, error)
+	// ApplyProjectManifest creates and updates project resources to match the manifest, resources missing in the manifest
+	// are deleted only if prune is set. If dryRun is set, only the plan of changes is returned.
+	// Changes are applied in one transaction, permissions to change every resource type are checked while planning
+	ApplyProjectManifest(
+		ctx context.Context,
+		projectId string,
+		manifest openapi.ProjectManifest,
+		dryRun bool,
+		prune bool,
+		auth middleware.Authentication,
+	) (*openapi.ManifestPlan, error)
+}
+
+type manifestsImpl struct {
+	projects        Projects
+	services        Services
+	managedServices ManagedServices
+	registries      ContainerRegistries
+	storage         *storage.Storage
+	sync            Sync
+}
+
+// manifestStep is the planned change and the function that performs it with the components bound to the transaction
+type manifestStep struct {
+	change openapi.ManifestChange
+	apply  func(ctx context.Context, tx manifestsImpl) error
+}
+
+var _ Manifests = (*manifestsImpl)(nil)
+
+func InitManifests(
+	projects Projects,
+	services Services,
+	managedServices ManagedServices,
+	registries ContainerRegistries,
+	storage *storage.Storage,
+	sync Sync,
+) Manifests {
+	return &manifestsImpl{
+		projects:        projects,
+		services:        services,
+		managedServices: managedServices,
+		registries:      registries,
+		storage:         storage,
+		sync:            sync,
+	}
+}
+
+// withStorage returns the copy that applies changes through the components bound to the transaction of s
+func (m manifestsImpl) withStorage(s *storage.Storage) manifestsImpl {
+	m.projects = m.projects.withStorage(s)
+	m.services = m.services.withStorage(s)
+	m.managedServices = m.managedServices.withStorage(s)
+	m.registries = m.registries.withStorage(s)
+	m.storage = s
+	return m
+}
+
+func (m manifestsImpl) GetProjectManifest(projectId string, auth middleware.Authentication) (*openapi.ProjectManifest, error) {
+	services, err := m.services.GetProjectServices(projectId, auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project services")
+	}
+	managedServices, err := m.managedServices.GetProjectManagedServices(projectId, auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project managed services")
+	}
+	secrets, err := m.projects.GetSecrets(projectId, auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project secrets")
+	}
+	registries, err := m.registries.GetProjectContainerRegistries(projectId, auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project container registries")
+	}
+
+	manifestServices := mapItems(services, manifestServiceOf)
+	manifestManagedServices := mapItems(managedServices, func(s openapi.ManagedService) openapi.ManifestManagedService {
+		return openapi.ManifestManagedService{Name: s.Name, Type: s.Type, Replicas: s.Replicas}
+	})
+	manifestSecrets := []openapi.ManifestSecret{}
+	for _, secret := range secrets {
+		// managed service passwords are created with the managed service
+		if secret.ManagedServiceId == nil {
+			manifestSecrets = append(manifestSecrets, openapi.ManifestSecret{Name: secret.Name})
+		}
+	}
+	manifestRegistries := mapItems(registries, func(r openapi.ContainerRegistry) openapi.ManifestRegistry {
+		return openapi.ManifestRegistry{Url: r.Url, Username: r.Username}
+	})
+	return &openapi.ProjectManifest{
+		ApiVersion:      openapi.ManifestV1,
+		Kind:            openapi.ManifestKindProject,
+		Project:         &projectId,
+		Services:        &manifestServices,
+		ManagedServices: &manifestManagedServices,
+		Secrets:         &manifestSecrets,
+		Registries:      &manifestRegistries,
+	}, nil
+}
+
+func (m manifestsImpl) ApplyProjectManifest(
+	ctx context.Context,
+	projectId string,
+	manifest openapi.ProjectManifest,
+	dryRun bool,
+	prune bool,
+	auth middleware.Authentication,
+) (*openapi.ManifestPlan, error) {
+	if err := m.projects.checkAccess(projectId, auth, viewProject); err != nil {
+		return nil, err
+	}
+	if err := validateManifest(projectId, manifest); err != nil {
+		return nil, err
+	}
+
+	var steps []manifestStep
+	// secrets and registries are applied first as services depend on them
+	// and deleted last for the same reason
+	planners := []struct {
+		plan       func(string, openapi.ProjectManifest, bool, middleware.Authentication) ([]manifestStep, []manifestStep, error)
+		permission permission
+	}{
+		{m.planSecrets, editResources},
+		{m.planRegistries, manageProject},
+		{m.planManagedServices, editResources},
+		{m.planServices, editResources},
+	}
+	var deletions [][]manifestStep
+	for _, planner := range planners {
+		applied, deleted, err := planner.plan(projectId, manifest, prune, auth)
+		if err != nil {
+			return nil, err
+		}
+		// permission is checked while planning, so that the plan returned by dry run can be applied
+		if len(applied)+len(deleted) > 0 {
+			if err := m.projects.checkAccess(projectId, auth, planner.permission); err != nil {
+				return nil, err
+			}
+		}
+		steps = append(steps, applied...)
+		deletions = append(deletions, deleted)
+	}
+	for i := len(deletions) - 1; i >= 0; i-- {
+		steps = append(steps, deletions[i]...)
+	}
+
+	plan := &openapi.ManifestPlan{
+		DryRun:  dryRun,
+		Changes: mapItems(steps, func(s manifestStep) openapi.ManifestChange { return s.change }),
+	}
+	if dryRun || len(steps) == 0 {
+		return plan, nil
+	}
+	// all DB changes are made in one transaction, so the manifest is either applied fully or not applied at all
+	err := m.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		tx := m.withStorage(s)
+		for _, step := range steps {
+			if err := step.apply(ctx, tx); err != nil {
+				return errors.Wrapf(err, "failed to %s %s %s", step.change.Action, step.change.ResourceType, step.change.Name)
+			}
+		}
+		recordAudit(s, auth, auditEvent{project: projectId, resourceType: auditProject, resourceId: projectId,
+			action: "apply-manifest", summary: fmt.Sprintf("%d changes", len(steps))})
+		return nil
+	})
+	// K8s objects are changed by the steps before the transaction is finished,
+	// sync brings them in line with the committed state whether the manifest is applied or not
+	m.sync.Enqueue(projectId)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Applied manifest to project %s, %d changes", projectId, len(steps))
+	return plan, nil
+}
+
+func validateManifest(projectId string, manifest openapi.ProjectManifest) error {
+	if manifest.ApiVersion != openapi.ManifestV1 {
+		return apperrors.BadRequest(fmt.Sprintf("Unsupported manifest apiVersion %s", manifest.ApiVersion))
+	}
+	if manifest.Kind != openapi.ManifestKindProject {
+		return apperrors.BadRequest(fmt.Sprintf("Unsupported manifest kind %s", manifest.Kind))
+	}
+	if manifest.Project != nil && *manifest.Project != projectId {
+		return apperrors.BadRequest(fmt.Sprintf("Manifest is defined for project %s", *manifest.Project))
+	}
+	names := map[string][]string{
+		"service":         mapItems(fromPtr(manifest.Services), func(s openapi.ManifestService) string { return s.Name }),
+		"managed service": mapItems(fromPtr(manifest.ManagedServices), func(s openapi.ManifestManagedService) string { return s.Name }),
+		"secret":          mapItems(fromPtr(manifest.Secrets), func(s openapi.ManifestSecret) string { return s.Name }),
+		"registry":        mapItems(fromPtr(manifest.Registries), func(r openapi.ManifestRegistry) string { return r.Url }),
+	}
+	for resource, list := range names {
+		seen := make(map[string]bool)
+		for _, name := range list {
+			if seen[name] {
+				return apperrors.BadRequest(fmt.Sprintf("Duplicate %s %s in manifest", resource, name))
+			}
+			seen[name] = true
+		}
+	}
+	return nil
+}
+
+func (m manifestsImpl) planSecrets(
+	projectId string,
+	manifest openapi.ProjectManifest,
+	prune bool,
+	auth middleware.Authentication,
+) ([]manifestStep, []manifestStep, error) {
+	entities, err := m.storage.SecretRepository().FindByProjectId(projectId)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get project secrets")
+	}
+	existing := toMapSelf(entities, func(e storage.SecretEntity) string { return e.Name })
+	desired := make(map[string]bool)
+	var applied, deleted []manifestStep
+	for _, secret := range fromPtr(manifest.Secrets) {
+		desired[secret.Name] = true
+		entity, found := existing[secret.Name]
+		value := openapi.SecretValue{Name: secret.Name, Value: fromPtr(secret.Value)}
+		switch {
+		case !found && secret.Value == nil:
+			return nil, nil, apperrors.BadRequest(fmt.Sprintf("Secret %s does not exist, its value should be set in manifest", secret.Name))
+		case !found:
+			applied = append(applied, manifestStep{
+				change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceSecret, Name: secret.Name, Action: openapi.ManifestCreate},
+				apply: func(ctx context.Context, tx manifestsImpl) error {
+					_, err := tx.projects.CreateSecret(ctx, projectId, value, auth)
+					return err
+				},
+			})
+		case entity.ManagedServiceId != nil:
+			return nil, nil, apperrors.BadRequest(fmt.Sprintf("Secret %s is managed by a managed service", secret.Name))
+		case secret.Value != nil && *secret.Value != entity.Value:
+			applied = append(applied, manifestStep{
+				change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceSecret, Name: secret.Name, Action: openapi.ManifestUpdate,
+					Fields: &[]string{"value"}},
+				apply: func(ctx context.Context, tx manifestsImpl) error {
+					_, err := tx.projects.UpdateSecret(ctx, projectId, value, auth)
+					return err
+				},
+			})
+		}
+	}
+	if !prune {
+		return applied, nil, nil
+	}
+	for _, entity := range entities {
+		if desired[entity.Name] || entity.ManagedServiceId != nil {
+			continue
+		}
+		name := entity.Name
+		deleted = append(deleted, manifestStep{
+			change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceSecret, Name: name, Action: openapi.ManifestDelete},
+			apply: func(ctx context.Context, tx manifestsImpl) error {
+				return tx.projects.DeleteSecret(ctx, projectId, name, auth)
+			},
+		})
+	}
+	return applied, deleted, nil
+}
+
+func (m manifestsImpl) planRegistries(
+	projectId string,
+	manifest openapi.ProjectManifest,
+	prune bool,
+	auth middleware.Authentication,
+) ([]manifestStep, []manifestStep, error) {
+	entities, err := m.storage.ContainerRegistryRepository().FindByProjectId(projectId)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get project container registries")
+	}
+	existing := toMapSelf(entities, func(e storage.ContainerRegistryEntity) string { return e.Url })
+	desired := make(map[string]bool)
+	var applied, deleted []manifestStep
+	for _, registry := range fromPtr(manifest.Registries) {
+		desired[registry.Url] = true
+		entity, found := existing[registry.Url]
+		value := openapi.ContainerRegistry{Url: registry.Url, Username: registry.Username, Password: registry.Password}
+		if found && entity.Username == registry.Username && (registry.Password == nil || *registry.Password == entity.Password) {
+			continue
+		}
+		if registry.Password == nil {
+			return nil, nil, apperrors.BadRequest(fmt.Sprintf("Password of container registry %s should be set in manifest", registry.Url))
+		}
+		if !found {
+			applied = append(applied, manifestStep{
+				change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceRegistry, Name: registry.Url, Action: openapi.ManifestCreate},
+				apply: func(ctx context.Context, tx manifestsImpl) error {
+					_, err := tx.registries.AddContainerRegistry(ctx, projectId, value, auth)
+					return err
+				},
+			})
+			continue
+		}
+		var fields []string
+		if entity.Username != registry.Username {
+			fields = append(fields, "username")
+		}
+		if *registry.Password != entity.Password {
+			fields = append(fields, "password")
+		}
+		id := entity.Id
+		applied = append(applied, manifestStep{
+			change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceRegistry, Name: registry.Url, Action: openapi.ManifestUpdate,
+				Fields: &fields},
+			apply: func(ctx context.Context, tx manifestsImpl) error {
+				_, err := tx.registries.UpdateContainerRegistry(ctx, projectId, id, value, auth)
+				return err
+			},
+		})
+	}
+	if !prune {
+		return applied, nil, nil
+	}
+	for _, entity := range entities {
+		if desired[entity.Url] {
+			continue
+		}
+		id := entity.Id
+		deleted = append(deleted, manifestStep{
+			change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceRegistry, Name: entity.Url, Action: openapi.ManifestDelete},
+			apply: func(ctx context.Context, tx manifestsImpl) error {
+				return tx.registries.DeleteContainerRegistry(ctx, projectId, id, auth)
+			},
+		})
+	}
+	return applied, deleted, nil
+}
+
+func (m manifestsImpl) planManagedServices(
+	projectId string,
+	manifest openapi.ProjectManifest,
+	prune bool,
+	auth middleware.Authentication,
+) ([]manifestStep, []manifestStep, error) {
+	services, err := m.managedServices.GetProjectManagedServices(projectId, auth)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get project managed services")
+	}
+	existing := toMapSelf(services, func(s openapi.ManagedService) string { return s.Name })
+	desired := make(map[string]bool)
+	var applied, deleted []manifestStep
+	for _, manifestService := range fromPtr(manifest.ManagedServices) {
+		desired[manifestService.Name] = true
+		replicas := 1
+		if manifestService.Replicas != nil {
+			replicas = *manifestService.Replicas
+		}
+		service, found := existing[manifestService.Name]
+		if !found {
+			created := openapi.ManagedService{Project: projectId, Name: manifestService.Name, Type: manifestService.Type, Replicas: &replicas}
+			applied = append(applied, manifestStep{
+				change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceManagedService, Name: created.Name, Action: openapi.ManifestCreate},
+				apply: func(ctx context.Context, tx manifestsImpl) error {
+					_, err := tx.managedServices.CreateManagedService(ctx, created, auth)
+					return err
+				},
+			})
+			continue
+		}
+		if service.Type != manifestService.Type {
+			return nil, nil, apperrors.BadRequest(fmt.Sprintf("Type of managed service %s cannot be changed", service.Name))
+		}
+		if fromPtr(service.Replicas) == replicas {
+			continue
+		}
+		service.Replicas = &replicas
+		applied = append(applied, manifestStep{
+			change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceManagedService, Name: service.Name, Action: openapi.ManifestUpdate,
+				Fields: &[]string{"replicas"}},
+			apply: func(ctx context.Context, tx manifestsImpl) error {
+				_, err := tx.managedServices.UpdateManagedService(ctx, service, auth)
+				return err
+			},
+		})
+	}
+	if !prune {
+		return applied, nil, nil
+	}
+	for _, service := range services {
+		if desired[service.Name] {
+			continue
+		}
+		id := *service.Id
+		deleted = append(deleted, manifestStep{
+			change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceManagedService, Name: service.Name, Action: openapi.ManifestDelete},
+			apply: func(ctx context.Context, tx manifestsImpl) error {
+				return tx.managedServices.DeleteManagedService(ctx, id, auth)
+			},
+		})
+	}
+	return applied, deleted, nil
+}
+
+func (m manifestsImpl) planServices(
+	projectId string,
+	manifest openapi.ProjectManifest,
+	prune bool,
+	auth middleware.Authentication,
+) ([]manifestStep, []manifestStep, error) {
+	services, err := m.services.GetProjectServices(projectId, auth)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get project services")
+	}
+	existing := toMapSelf(services, func(s openapi.Service) string { return s.Name })
+	desired := make(map[string]bool)
+	var applied, deleted []manifestStep
+	for _, manifestService := range fromPtr(manifest.Services) {
+		desired[manifestService.Name] = true
+		service := serviceOfManifest(projectId, manifestService)
+		current, found := existing[manifestService.Name]
+		if !found {
+			applied = append(applied, manifestStep{
+				change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceService, Name: service.Name, Action: openapi.ManifestCreate},
+				apply: func(ctx context.Context, tx manifestsImpl) error {
+					_, err := tx.services.CreateService(ctx, service, auth)
+					return err
+				},
+			})
+			continue
+		}
+		fields := changedServiceFields(manifestServiceOf(current), manifestService)
+		if len(fields) == 0 {
+			continue
+		}
+		service.Id = current.Id
+		applied = append(applied, manifestStep{
+			change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceService, Name: service.Name, Action: openapi.ManifestUpdate,
+				Fields: &fields},
+			apply: func(ctx context.Context, tx manifestsImpl) error {
+				_, err := tx.services.UpdateService(ctx, service, auth)
+				return err
+			},
+		})
+	}
+	if !prune {
+		return applied, nil, nil
+	}
+	for _, service := range services {
+		if desired[service.Name] {
+			continue
+		}
+		id := *service.Id
+		deleted = append(deleted, manifestStep{
+			change: openapi.ManifestChange{ResourceType: openapi.ManifestResourceService, Name: service.Name, Action: openapi.ManifestDelete},
+			apply: func(ctx context.Context, tx manifestsImpl) error {
+				return tx.services.DeleteService(ctx, id, auth)
+			},
+		})
+	}
+	return applied, deleted, nil
+}
+
+func manifestServiceOf(service openapi.Service) openapi.ManifestService {
+	replicas := service.Replicas
+	envVars := service.EnvVars
+	manifestService := openapi.ManifestService{
+		Name:     service.Name,
+		Image:    service.Image,
+		Port:     service.Port,
+		Replicas: &replicas,
+		EnvVars:  &envVars,
+	}
+	if len(fromPtr(service.Command)) > 0 {
+		manifestService.Command = service.Command
+	}
+	if service.PublicApiPrefix != nil {
+		manifestService.Routing = &openapi.ManifestRouting{
+			PublicApiPrefix: *service.PublicApiPrefix,
+			StripApiPrefix:  service.StripApiPrefix,
+		}
+	}
+	return manifestService
+}
+
+func serviceOfManifest(projectId string, manifestService openapi.ManifestService) openapi.Service {
+	service := openapi.Service{
+		Project:  projectId,
+		Name:     manifestService.Name,
+		Image:    manifestService.Image,
+		Port:     manifestService.Port,
+		EnvVars:  fromPtr(manifestService.EnvVars),
+		Command:  manifestService.Command,
+		Replicas: 1,
+	}
+	if service.EnvVars == nil {
+		service.EnvVars = []openapi.EnvVar{}
+	}
+	if manifestService.Replicas != nil {
+		service.Replicas = *manifestService.Replicas
+	}
+	if manifestService.Routing != nil {
+		service.PublicApiPrefix = &manifestService.Routing.PublicApiPrefix
+		service.StripApiPrefix = manifestService.Routing.StripApiPrefix
+	}
+	return service
+}
+
+// changedServiceFields compares the manifest of the existing service with the desired one
+func changedServiceFields(current openapi.ManifestService, desired openapi.ManifestService) []string {
+	var fields []string
+	if current.Image != desired.Image {
+		fields = append(fields, "image")
+	}
+	if current.Port != desired.Port {
+		fields = append(fields, "port")
+	}
+	replicas := 1
+	if desired.Replicas != nil {
+		replicas = *desired.Replicas
+	}
+	if fromPtr(current.Replicas) != replicas {
+		fields = append(fields, "replicas")
+	}
+	if !slices.Equal(fromPtr(current.Command), fromPtr(desired.Command)) {
+		fields = append(fields, "command")
+	}
+	currentEnv, _ := json.Marshal(fromPtr(current.EnvVars))
+	desiredEnv, _ := json.Marshal(fromPtr(desired.EnvVars))
+	if len(fromPtr(current.EnvVars))+len(fromPtr(desired.EnvVars)) > 0 && !slices.Equal(currentEnv, desiredEnv) {
+		fields = append(fields, "envVars")
+	}
+	currentRouting, desiredRouting := fromPtr(current.Routing), fromPtr(desired.Routing)
+	if current.Routing == nil != (desired.Routing == nil) || currentRouting.PublicApiPrefix != desiredRouting.PublicApiPrefix ||
+		fromPtr(currentRouting.StripApiPrefix) != fromPtr(desiredRouting.StripApiPrefix) {
+		fields = append(fields, "routing")
+	}
+	return fields
+}
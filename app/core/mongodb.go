@@ -56,7 +56,7 @@ func InitMongoDbMgmt(
 }
 
 func (m mongoDbMgmtImpl) GetMongoDbUsers(ctx context.Context, serviceId int, auth middleware.Authentication) ([]openapi.MongoDbUser, error) {
-	service, err := m.getMongoDbService(ctx, serviceId, auth)
+	service, err := m.getMongoDbService(ctx, serviceId, auth, viewProject)
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +75,7 @@ func (m mongoDbMgmtImpl) GetMongoDbUsers(ctx context.Context, serviceId int, aut
 
 	users := make([]openapi.MongoDbUser, 0)
 	for _, user := range resp.Users {
-		if user.User == managedServices[openapi.Mongo].username {
+		if user.User == builtinManagedServiceTypes[mongoType].username {
 			continue
 		}
 
@@ -93,7 +93,7 @@ func (m mongoDbMgmtImpl) GetMongoDbUsers(ctx context.Context, serviceId int, aut
 }
 
 func (m mongoDbMgmtImpl) GetMongoDbUser(ctx context.Context, serviceId int, mongoDbUsername string, auth middleware.Authentication) (openapi.MongoDbUser, error) {
-	service, err := m.getMongoDbService(ctx, serviceId, auth)
+	service, err := m.getMongoDbService(ctx, serviceId, auth, viewProject)
 	if err != nil {
 		return openapi.MongoDbUser{}, err
 	}
@@ -107,7 +107,7 @@ func (m mongoDbMgmtImpl) GetMongoDbUser(ctx context.Context, serviceId int, mong
 	if err != nil {
 		return openapi.MongoDbUser{}, err
 	}
-	if u.Username == managedServices[openapi.Mongo].username {
+	if u.Username == builtinManagedServiceTypes[mongoType].username {
 		return openapi.MongoDbUser{}, apperrors.NotFound("User " + u.Username + " not found")
 	}
 
@@ -117,7 +117,7 @@ func (m mongoDbMgmtImpl) GetMongoDbUser(ctx context.Context, serviceId int, mong
 }
 
 func (m mongoDbMgmtImpl) CreateMongoDbUser(ctx context.Context, serviceId int, mongoDbUser openapi.MongoDbUser, auth middleware.Authentication) (openapi.MongoDbUser, error) {
-	service, err := m.getMongoDbService(ctx, serviceId, auth)
+	service, err := m.getMongoDbService(ctx, serviceId, auth, editResources)
 	if err != nil {
 		return openapi.MongoDbUser{}, err
 	}
@@ -127,7 +127,7 @@ func (m mongoDbMgmtImpl) CreateMongoDbUser(ctx context.Context, serviceId int, m
 		return openapi.MongoDbUser{}, err
 	}
 
-	if mongoDbUser.Username == managedServices[openapi.Mongo].username {
+	if mongoDbUser.Username == builtinManagedServiceTypes[mongoType].username {
 		return openapi.MongoDbUser{}, apperrors.Forbidden("Cannot create user with username 'root'")
 	}
 
@@ -157,12 +157,14 @@ func (m mongoDbMgmtImpl) CreateMongoDbUser(ctx context.Context, serviceId int, m
 		}
 		return openapi.MongoDbUser{}, errors.Wrap(err, "failed to create user")
 	}
+	recordAudit(m.storage, auth, auditEvent{project: service.Project, resourceType: auditMongoDbUser,
+		resourceId: service.Name + "/" + mongoDbUser.Username, action: "create"})
 	log.Infof("Created MongoDB user of service %s in project %s", service.Name, service.Project)
 	return mongoDbUser, nil
 }
 
 func (m mongoDbMgmtImpl) UpdateMongoDbUser(ctx context.Context, serviceId int, mongoDbUser openapi.MongoDbUser, auth middleware.Authentication) (openapi.MongoDbUser, error) {
-	service, err := m.getMongoDbService(ctx, serviceId, auth)
+	service, err := m.getMongoDbService(ctx, serviceId, auth, editResources)
 	if err != nil {
 		return openapi.MongoDbUser{}, err
 	}
@@ -196,12 +198,18 @@ func (m mongoDbMgmtImpl) UpdateMongoDbUser(ctx context.Context, serviceId int, m
 	if err != nil {
 		return openapi.MongoDbUser{}, errors.Wrap(err, "failed to update user")
 	}
+	summary := "roles changed"
+	if mongoDbUser.PasswordSecret != nil {
+		summary = "roles changed, password set from secret " + *mongoDbUser.PasswordSecret
+	}
+	recordAudit(m.storage, auth, auditEvent{project: service.Project, resourceType: auditMongoDbUser,
+		resourceId: service.Name + "/" + mongoDbUser.Username, action: "update", summary: summary})
 	log.Infof("Updated MongoDB user of service %s in project %s", service.Name, service.Project)
 	return mongoDbUser, nil
 }
 
 func (m mongoDbMgmtImpl) DeleteMongoDbUser(ctx context.Context, serviceId int, mongoDbUsername string, auth middleware.Authentication) error {
-	service, err := m.getMongoDbService(ctx, serviceId, auth)
+	service, err := m.getMongoDbService(ctx, serviceId, auth, editResources)
 	if err != nil {
 		return err
 	}
@@ -218,7 +226,7 @@ func (m mongoDbMgmtImpl) DeleteMongoDbUser(ctx context.Context, serviceId int, m
 		return errors.Wrap(err, "failed to delete user")
 	}
 
-	if mongoDbUsername == managedServices[openapi.Mongo].username {
+	if mongoDbUsername == builtinManagedServiceTypes[mongoType].username {
 		return apperrors.Forbidden("Cannot delete user with username 'root'")
 	}
 
@@ -227,12 +235,19 @@ func (m mongoDbMgmtImpl) DeleteMongoDbUser(ctx context.Context, serviceId int, m
 	if err != nil {
 		return errors.Wrap(err, "failed to delete user")
 	}
+	recordAudit(m.storage, auth, auditEvent{project: service.Project, resourceType: auditMongoDbUser,
+		resourceId: service.Name + "/" + mongoDbUsername, action: "delete"})
 	log.Infof("Deleted MongoDB user of service %s in project %s", service.Name, service.Project)
 	return nil
 }
 
-func (m mongoDbMgmtImpl) getMongoDbService(ctx context.Context, serviceId int, auth middleware.Authentication) (*openapi.ManagedService, error) {
-	service, err := m.managedServices.GetManagedService(serviceId, auth)
+func (m mongoDbMgmtImpl) getMongoDbService(
+	ctx context.Context,
+	serviceId int,
+	auth middleware.Authentication,
+	permission permission,
+) (*openapi.ManagedService, error) {
+	service, err := m.managedServices.getManagedService(serviceId, auth, permission)
 	if err != nil {
 		return nil, err
 	}
@@ -240,7 +255,7 @@ func (m mongoDbMgmtImpl) getMongoDbService(ctx context.Context, serviceId int, a
 	if err != nil {
 		return nil, err
 	}
-	if service.Type != openapi.Mongo {
+	if service.Type != mongoType {
 		return nil, apperrors.BadRequest("Managed service is not MongoDB")
 	}
 	if status.Status != openapi.Available {
@@ -251,12 +266,12 @@ func (m mongoDbMgmtImpl) getMongoDbService(ctx context.Context, serviceId int, a
 
 func (m mongoDbMgmtImpl) getMongoDbClient(ctx context.Context, service openapi.ManagedService) (*mongo.Client, error) {
 	mongoHost := fmt.Sprintf("%s.%s.svc.cluster.local:%d",
-		service.Name, service.Project, managedServices[openapi.Mongo].podPort)
+		service.Name, service.Project, builtinManagedServiceTypes[mongoType].port())
 	secret, err := m.storage.SecretRepository().FindByProjectIdAndName(service.Project, getManagedServiceSecretName(service.Name))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get root password for MongoDB")
 	}
-	credential := options.Credential{Username: managedServices[openapi.Mongo].username, Password: secret.Value}
+	credential := options.Credential{Username: builtinManagedServiceTypes[mongoType].username, Password: secret.Value}
 	clientOptions := options.Client().SetHosts([]string{mongoHost}).SetAuth(credential)
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
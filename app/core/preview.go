@@ -0,0 +1,198 @@
+package core
+
+import (
+	"codnect.io/chrono"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"slices"
+	"time"
+)
+
+const (
+	defaultPreviewTtl      = 72 * time.Hour
+	previewCleanupInterval = time.Minute
+	previewIdSuffixLength  = 6
+)
+
+// Previews manages temporary copies of projects, e.g. for pull requests. Preview is a separate project
+// cloned from the parent one, it is deleted by the cleanup task when it expires
+type Previews interface {
+	GetPreviews(projectId string, auth middleware.Authentication) ([]openapi.PreviewEnvironment, error)
+	CreatePreview(ctx context.Context, projectId string, preview openapi.PreviewEnvironment, auth middleware.Authentication) (*openapi.PreviewEnvironment, error)
+	ClosePreview(ctx context.Context, projectId string, name string, auth middleware.Authentication) error
+}
+
+type previewsImpl struct {
+	projects   Projects
+	clones     Clones
+	storage    *storage.Storage
+	leadership Leadership
+}
+
+var _ Previews = (*previewsImpl)(nil)
+
+func InitPreviews(
+	projects Projects,
+	clones Clones,
+	storage *storage.Storage,
+	leadership Leadership,
+	scheduler chrono.TaskScheduler,
+) Previews {
+	p := &previewsImpl{projects: projects, clones: clones, storage: storage, leadership: leadership}
+	_, err := scheduler.ScheduleWithFixedDelay(p.deleteExpired, previewCleanupInterval)
+	if err != nil {
+		log.WithError(err).Panicln("Unable to schedule preview environments cleanup")
+	}
+	return p
+}
+
+func (p previewsImpl) GetPreviews(projectId string, auth middleware.Authentication) ([]openapi.PreviewEnvironment, error) {
+	if err := p.projects.checkAccess(projectId, auth, viewProject); err != nil {
+		return nil, err
+	}
+	entities, err := p.storage.PreviewEnvironmentRepository().FindByParentId(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project preview environments")
+	}
+	return mapItems(entities, previewFromEntity), nil
+}
+
+func (p previewsImpl) CreatePreview(
+	ctx context.Context,
+	projectId string,
+	preview openapi.PreviewEnvironment,
+	auth middleware.Authentication,
+) (*openapi.PreviewEnvironment, error) {
+	if err := p.projects.checkAccess(projectId, auth, editResources); err != nil {
+		return nil, err
+	}
+	if _, err := p.storage.PreviewEnvironmentRepository().FindByProjectId(projectId); err == nil {
+		return nil, apperrors.BadRequest("Preview environment cannot be created from another preview")
+	} else if !apperrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to check if project is a preview")
+	}
+	_, err := p.storage.PreviewEnvironmentRepository().FindByParentIdAndName(projectId, preview.Name)
+	if err == nil {
+		return nil, apperrors.BadRequest(fmt.Sprintf("Preview environment %s already exists", preview.Name))
+	} else if !apperrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to check if preview environment exists")
+	}
+	// environments and previews share the <name>.<parent> host
+	_, err = p.storage.ProjectEnvironmentRepository().FindByParentIdAndName(projectId, preview.Name)
+	if err == nil {
+		return nil, apperrors.BadRequest(fmt.Sprintf("Environment %s already exists", preview.Name))
+	} else if !apperrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to check if environment exists")
+	}
+	ttl := defaultPreviewTtl
+	if preview.TtlHours != nil {
+		ttl = time.Duration(*preview.TtlHours) * time.Hour
+	}
+	suffix, err := randomString(lowercaseAlphanumeric, previewIdSuffixLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate preview project id")
+	}
+
+	var entity *storage.PreviewEnvironmentEntity
+	clone := openapi.ProjectClone{Target: preview.Name + "-" + suffix, EnvOverrides: preview.EnvOverrides}
+	hooks := cloneHooks{
+		manifest: func(manifest *openapi.ProjectManifest) error {
+			return overrideImageTags(fromPtr(manifest.Services), fromPtr(preview.ImageTags))
+		},
+		// the preview is stored before services are created, so that their ingresses get the preview host
+		created: func(previewId string) error {
+			entity, err = p.storage.PreviewEnvironmentRepository().CreateNew(storage.PreviewEnvironmentEntity{
+				ProjectId: previewId,
+				ParentId:  sql.NullString{String: projectId, Valid: true},
+				Name:      preview.Name,
+				ExpiresAt: time.Now().Add(ttl),
+				CreatedBy: auth.Username,
+			})
+			return err
+		},
+	}
+	if _, err := p.clones.cloneProject(ctx, projectId, clone, hooks, auth); err != nil {
+		return nil, errors.Wrapf(err, "failed to create preview environment %s", preview.Name)
+	}
+	recordAudit(p.storage, auth, auditEvent{project: projectId, resourceType: auditPreview, resourceId: preview.Name,
+		action: "create", summary: fmt.Sprintf("project %s, expires at %s", entity.ProjectId, entity.ExpiresAt.Format(time.RFC3339))})
+	log.Infof("Preview environment %s of project %s created as project %s", preview.Name, projectId, entity.ProjectId)
+	result := previewFromEntity(*entity)
+	return &result, nil
+}
+
+func (p previewsImpl) ClosePreview(ctx context.Context, projectId string, name string, auth middleware.Authentication) error {
+	if err := p.projects.checkAccess(projectId, auth, editResources); err != nil {
+		return err
+	}
+	entity, err := p.storage.PreviewEnvironmentRepository().FindByParentIdAndName(projectId, name)
+	if err != nil {
+		return err
+	}
+	// the preview can be closed by any developer of the parent project, not only by the owner of the preview
+	if err := p.projects.DeleteProject(ctx, entity.ProjectId, middleware.ServiceAccount); err != nil {
+		return errors.Wrapf(err, "failed to delete project of preview environment %s", name)
+	}
+	recordAudit(p.storage, auth, auditEvent{project: projectId, resourceType: auditPreview, resourceId: name, action: "close"})
+	log.Infof("Preview environment %s of project %s closed", name, projectId)
+	return nil
+}
+
+// deleteExpired deletes expired previews and previews of deleted projects
+func (p previewsImpl) deleteExpired(ctx context.Context) {
+	if !p.leadership.IsLeader() {
+		return
+	}
+	expired, err := p.storage.PreviewEnvironmentRepository().FindExpired(time.Now())
+	if err != nil {
+		log.WithError(err).Errorln("Failed to get expired preview environments")
+		return
+	}
+	for _, preview := range expired {
+		if err := p.projects.DeleteProject(ctx, preview.ProjectId, middleware.ServiceAccount); err != nil {
+			log.WithError(err).Errorf("Failed to delete expired preview environment %s, skipping", preview.ProjectId)
+			continue
+		}
+		if preview.ParentId.Valid {
+			recordAudit(p.storage, middleware.ServiceAccount, auditEvent{project: preview.ParentId.String,
+				resourceType: auditPreview, resourceId: preview.Name, action: "expire"})
+		}
+		log.Infof("Expired preview environment %s deleted", preview.ProjectId)
+	}
+}
+
+// overrideImageTags replaces tags of the services images with the tags by service name
+func overrideImageTags(services []openapi.ManifestService, tags map[string]string) error {
+	for serviceName, tag := range tags {
+		i := slices.IndexFunc(services, func(s openapi.ManifestService) bool { return s.Name == serviceName })
+		if i < 0 {
+			return apperrors.BadRequest(fmt.Sprintf("Service %s of image tags does not exist", serviceName))
+		}
+		services[i].Image = imageWithTag(services[i].Image, tag)
+	}
+	return nil
+}
+
+func previewFromEntity(entity storage.PreviewEnvironmentEntity) openapi.PreviewEnvironment {
+	preview := openapi.PreviewEnvironment{
+		Name:      entity.Name,
+		Project:   &entity.ProjectId,
+		ExpiresAt: &entity.ExpiresAt,
+		CreatedBy: &entity.CreatedBy,
+		CreatedAt: &entity.CreatedAt,
+	}
+	host := entity.ProjectId + "." + baseDomain
+	if entity.ParentId.Valid {
+		preview.Parent = &entity.ParentId.String
+		host = childProjectHost(entity.Name, entity.ParentId.String)
+	}
+	preview.Host = &host
+	return preview
+}
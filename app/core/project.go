@@ -8,7 +8,6 @@ import (
 	certManagerV1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	v1 "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	certManagerClientset "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned"
-	"github.com/google/uuid"
 	"github.com/kuzznya/letsdeploy/app/apperrors"
 	"github.com/kuzznya/letsdeploy/app/middleware"
 	"github.com/kuzznya/letsdeploy/app/storage"
@@ -28,6 +27,7 @@ import (
 const namespaceLabel = "letsdeploy.space/project-namespace"
 const secretKey = "value"
 const managedSecretPrefix = "letsdeploy."
+const baseDomain = "letsdeploy.space"
 
 type Projects interface {
 	projectSynchronizable
@@ -39,21 +39,28 @@ type Projects interface {
 	DeleteProject(ctx context.Context, id string, auth middleware.Authentication) error
 	GetUserProjects(auth middleware.Authentication) ([]openapi.Project, error)
 	GetParticipants(id string, auth middleware.Authentication) ([]string, error)
-	AddParticipant(id string, username string, auth middleware.Authentication) error
+	AddParticipant(id string, username string, role *openapi.ProjectRole, auth middleware.Authentication) error
 	RemoveParticipant(id string, username string, auth middleware.Authentication) error
-	JoinProject(ctx context.Context, code string, auth middleware.Authentication) (*openapi.Project, error)
-	RegenerateInviteCode(ctx context.Context, id string, auth middleware.Authentication) (string, error)
+	TransferProject(ctx context.Context, id string, username string, auth middleware.Authentication) error
+	StopProject(ctx context.Context, id string, auth middleware.Authentication) error
+	StartProject(ctx context.Context, id string, auth middleware.Authentication) error
 	GetSecrets(projectId string, auth middleware.Authentication) ([]openapi.Secret, error)
 	CreateSecret(ctx context.Context, projectId string, secretValue openapi.SecretValue, auth middleware.Authentication) (*openapi.Secret, error)
 	GetSecretValue(projectId string, name string, auth middleware.Authentication) (*openapi.SecretValue, error)
+	// UpdateSecret changes the value of the existing secret, K8s secret is updated in place
+	UpdateSecret(ctx context.Context, projectId string, secretValue openapi.SecretValue, auth middleware.Authentication) (*openapi.Secret, error)
 	DeleteSecret(ctx context.Context, projectId string, name string, auth middleware.Authentication) error
-	checkAccess(id string, auth middleware.Authentication) error
+	checkAccess(id string, auth middleware.Authentication, permission permission) error
+	// withStorage returns the copy of the component that uses the storage,
+	// so that its operations join the transaction the storage is bound to
+	withStorage(s *storage.Storage) Projects
 }
 
 type projectsImpl struct {
 	services        Services
 	managedServices ManagedServices
 	registries      ContainerRegistries
+	sync            Sync
 	storage         *storage.Storage
 	clientset       *kubernetes.Clientset
 	cmClient        *certManagerClientset.Clientset
@@ -75,6 +82,7 @@ func InitProjects(
 		p.services = core.Services
 		p.managedServices = core.ManagedServices
 		p.registries = core.Registries
+		p.sync = core.Sync
 	})
 	return p
 }
@@ -94,17 +102,19 @@ func (p projectsImpl) FindAll(limit int, offset int) ([]openapi.Project, error)
 }
 
 func (p projectsImpl) CreateProject(ctx context.Context, project openapi.Project, auth middleware.Authentication) (*openapi.Project, error) {
+	if auth.Scope.ProjectScoped() {
+		return nil, apperrors.Forbidden("Projects cannot be created with a project scoped API key")
+	}
+	if auth.DeployToken != nil {
+		return nil, apperrors.Forbidden("Projects cannot be created with a deploy token")
+	}
 	exists, err := p.storage.ProjectRepository().ExistsByID(project.Id)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot check if project with this name already exists")
 	} else if exists {
 		return nil, apperrors.BadRequest("project with this name already exists")
 	}
-	inviteCode, err := uuid.NewRandom()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to generate invite code")
-	}
-	record := storage.ProjectEntity{Id: project.Id, InviteCode: inviteCode.String()}
+	record := storage.ProjectEntity{Id: project.Id}
 	err = p.storage.ExecTx(ctx, func(s *storage.Storage) error {
 		id, err := s.ProjectRepository().CreateNew(record)
 		if err != nil {
@@ -112,7 +122,12 @@ func (p projectsImpl) CreateProject(ctx context.Context, project openapi.Project
 		}
 		project.Id = id
 
-		err = s.ProjectRepository().AddParticipant(id, auth.Username)
+		err = s.ProjectRepository().AddParticipant(id, auth.Username, string(openapi.Owner))
+		if err != nil {
+			return err
+		}
+
+		_, err = createDefaultInvitation(s, id, auth)
 		if err != nil {
 			return err
 		}
@@ -139,12 +154,13 @@ func (p projectsImpl) CreateProject(ctx context.Context, project openapi.Project
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create new project")
 	}
+	recordAudit(p.storage, auth, auditEvent{project: project.Id, resourceType: auditProject, resourceId: project.Id, action: "create"})
 	log.Infof("Project %s created", project.Id)
 	return &project, nil
 }
 
 func (p projectsImpl) GetProject(id string, auth middleware.Authentication) (*openapi.Project, error) {
-	if err := p.checkAccess(id, auth); err != nil {
+	if err := p.checkAccess(id, auth, viewProject); err != nil {
 		return nil, err
 	}
 	record, err := p.storage.ProjectRepository().FindByID(id)
@@ -155,14 +171,15 @@ func (p projectsImpl) GetProject(id string, auth middleware.Authentication) (*op
 }
 
 func (p projectsImpl) GetProjectInfo(id string, auth middleware.Authentication) (*openapi.ProjectInfo, error) {
-	if err := p.checkAccess(id, auth); err != nil {
+	role, err := p.getRole(id, auth)
+	if err != nil {
 		return nil, err
 	}
 	record, err := p.storage.ProjectRepository().FindByID(id)
 	if err != nil {
 		return nil, apperrors.WrapNonAppError(err, "cannot find project by id")
 	}
-	participants, err := p.storage.ProjectRepository().GetParticipants(id)
+	members, err := p.storage.ProjectRepository().GetMembers(id)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to retrieve project participants")
 	}
@@ -175,17 +192,56 @@ func (p projectsImpl) GetProjectInfo(id string, auth middleware.Authentication)
 		return nil, errors.Wrap(err, "failed to retrieve project managed services")
 	}
 
+	previews, err := p.storage.PreviewEnvironmentRepository().FindByParentId(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve project preview environments")
+	}
+	environments, err := p.storage.ProjectEnvironmentRepository().FindByParentId(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve project environments")
+	}
+	var parent *string
+	if preview, err := p.storage.PreviewEnvironmentRepository().FindByProjectId(id); err == nil && preview.ParentId.Valid {
+		parent = &preview.ParentId.String
+	} else if err != nil && !apperrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to retrieve project parent")
+	}
+	if environment, err := p.storage.ProjectEnvironmentRepository().FindByProjectId(id); err == nil && environment.ParentId.Valid {
+		parent = &environment.ParentId.String
+	} else if err != nil && !apperrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to retrieve project parent")
+	}
+
+	// invite code allows to join the project, so it is hidden from those who cannot add members
+	inviteCode := ""
+	if hasPermission(role, manageMembers) {
+		invitation, err := p.storage.InvitationRepository().FindDefault(id)
+		if err != nil && !apperrors.IsNotFound(err) {
+			return nil, errors.Wrap(err, "failed to retrieve project invite code")
+		} else if err == nil {
+			inviteCode = invitation.Code
+		}
+	}
 	return &openapi.ProjectInfo{
-		Id:              record.Id,
-		InviteCode:      record.InviteCode,
-		Participants:    participants,
+		Id:         record.Id,
+		InviteCode: inviteCode,
+		Participants: mapItems(members, func(m storage.ParticipantEntity) string {
+			return m.Username
+		}),
+		Members: mapItems(members, func(m storage.ParticipantEntity) openapi.ProjectMember {
+			return openapi.ProjectMember{Username: m.Username, Role: openapi.ProjectRole(m.Role)}
+		}),
+		Role:            role,
 		Services:        services,
 		ManagedServices: managedServices,
+		Parent:          parent,
+		Previews:        mapItems(previews, previewFromEntity),
+		Environments:    mapItems(environments, environmentFromEntity),
 	}, nil
 }
 
 func (p projectsImpl) UpdateProject(project openapi.Project, auth middleware.Authentication) error {
-	if err := p.checkAccess(project.Id, auth); err != nil {
+	if err := p.checkAccess(project.Id, auth, manageProject); err != nil {
 		return err
 	}
 	record := storage.ProjectEntity{Id: project.Id}
@@ -198,7 +254,7 @@ func (p projectsImpl) UpdateProject(project openapi.Project, auth middleware.Aut
 }
 
 func (p projectsImpl) DeleteProject(ctx context.Context, id string, auth middleware.Authentication) error {
-	if err := p.checkAccess(id, auth); err != nil {
+	if err := p.checkAccess(id, auth, ownProject); err != nil {
 		return err
 	}
 	err := p.storage.ExecTx(ctx, func(s *storage.Storage) error {
@@ -216,6 +272,7 @@ func (p projectsImpl) DeleteProject(ctx context.Context, id string, auth middlew
 	if err != nil {
 		return errors.Wrap(err, "failed to delete project")
 	}
+	recordAudit(p.storage, auth, auditEvent{project: id, resourceType: auditProject, resourceId: id, action: "delete"})
 	log.Infof("Project %s deleted", id)
 	return nil
 }
@@ -225,15 +282,17 @@ func (p projectsImpl) GetUserProjects(auth middleware.Authentication) ([]openapi
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to find user's projects")
 	}
-	result := make([]openapi.Project, len(projects))
-	for i, record := range projects {
-		result[i] = openapi.Project{Id: record.Id}
+	result := make([]openapi.Project, 0, len(projects))
+	for _, record := range projects {
+		if auth.Scope.AllowsProject(record.Id) {
+			result = append(result, openapi.Project{Id: record.Id})
+		}
 	}
 	return result, nil
 }
 
 func (p projectsImpl) GetParticipants(id string, auth middleware.Authentication) ([]string, error) {
-	if err := p.checkAccess(id, auth); err != nil {
+	if err := p.checkAccess(id, auth, viewProject); err != nil {
 		return nil, err
 	}
 	participants, err := p.storage.ProjectRepository().GetParticipants(id)
@@ -243,76 +302,197 @@ func (p projectsImpl) GetParticipants(id string, auth middleware.Authentication)
 	return participants, nil
 }
 
-func (p projectsImpl) AddParticipant(id string, username string, auth middleware.Authentication) error {
-	if err := p.checkAccess(id, auth); err != nil {
+// AddParticipant adds the user to the project with the given role (developer by default)
+// or changes the role of the existing participant if the role is specified
+func (p projectsImpl) AddParticipant(id string, username string, role *openapi.ProjectRole, auth middleware.Authentication) error {
+	if role != nil && !isValidRole(*role) {
+		return apperrors.BadRequest(fmt.Sprintf("Unknown project role %s", *role))
+	}
+	if role != nil && *role == openapi.Owner {
+		return apperrors.BadRequest("Owner role can be granted only by transferring the project")
+	}
+	if err := p.checkAccess(id, auth, manageMembers); err != nil {
 		return err
 	}
-	err := p.storage.ProjectRepository().AddParticipant(id, username)
+	current, err := p.storage.ProjectRepository().GetParticipantRole(id, username)
+	if err != nil && !apperrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to get participant role")
+	}
+	isParticipant := err == nil
+	if isParticipant && role == nil {
+		return nil
+	}
+	if role == nil {
+		developer := openapi.Developer
+		role = &developer
+	}
+	if openapi.ProjectRole(current) == openapi.Owner {
+		return apperrors.Forbidden("Role of the project owner can be changed only by transferring the project")
+	}
+	if *role == openapi.Admin || openapi.ProjectRole(current) == openapi.Admin {
+		if err := p.checkAccess(id, auth, manageAdmins); err != nil {
+			return err
+		}
+	}
+
+	if isParticipant {
+		err = p.storage.ProjectRepository().SetParticipantRole(id, username, string(*role))
+	} else {
+		err = p.storage.ProjectRepository().AddParticipant(id, username, string(*role))
+	}
 	if err != nil {
 		return errors.Wrap(err, "failed to add participant")
 	}
-	log.Infof("Added participant %s to project %s", username, id)
+	event := auditEvent{project: id, resourceType: auditParticipant, resourceId: username, action: "create",
+		summary: "role " + string(*role)}
+	change := "added"
+	if isParticipant {
+		event.action = "update"
+		event.summary = fmt.Sprintf("role %s -> %s", current, *role)
+		change = "role-changed"
+	}
+	recordAudit(p.storage, auth, event)
+	publishEvent(p.storage, id, openapi.MemberChanged, memberChangedData{Username: username, Change: change,
+		Role: *role, Actor: auth.Username})
+	log.Infof("Added participant %s to project %s with role %s", username, id, *role)
 	return nil
 }
 
+// RemoveParticipant removes the participant from the project, any participant except the owner can leave the project
 func (p projectsImpl) RemoveParticipant(id string, username string, auth middleware.Authentication) error {
-	if err := p.checkAccess(id, auth); err != nil {
+	if username == auth.Username {
+		if err := p.checkAccess(id, auth, viewProject); err != nil {
+			return err
+		}
+	} else if err := p.checkAccess(id, auth, manageMembers); err != nil {
 		return err
 	}
-	err := p.storage.ProjectRepository().RemoveParticipant(id, username)
+	role, err := p.storage.ProjectRepository().GetParticipantRole(id, username)
+	if apperrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to get participant role")
+	}
+	if openapi.ProjectRole(role) == openapi.Owner {
+		return apperrors.BadRequest("Project owner cannot be removed, transfer the project first")
+	}
+	if openapi.ProjectRole(role) == openapi.Admin && username != auth.Username {
+		if err := p.checkAccess(id, auth, manageAdmins); err != nil {
+			return err
+		}
+	}
+	err = p.storage.ProjectRepository().RemoveParticipant(id, username)
 	if err != nil {
-		return errors.Wrap(err, "failed to add participant")
+		return errors.Wrap(err, "failed to remove participant")
 	}
+	recordAudit(p.storage, auth, auditEvent{project: id, resourceType: auditParticipant, resourceId: username,
+		action: "delete", summary: "role " + role})
+	publishEvent(p.storage, id, openapi.MemberChanged, memberChangedData{Username: username, Change: "removed",
+		Role: openapi.ProjectRole(role), Actor: auth.Username})
 	log.Infof("Removed participant %s from project %s", username, id)
 	return nil
 }
 
-func (p projectsImpl) JoinProject(ctx context.Context, code string, auth middleware.Authentication) (*openapi.Project, error) {
-	entity, err := p.storage.ProjectRepository().FindByInviteCode(code)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to find project by invite code")
+// TransferProject makes the participant the owner of the project, previous owner becomes admin
+func (p projectsImpl) TransferProject(ctx context.Context, id string, username string, auth middleware.Authentication) error {
+	if err := p.checkAccess(id, auth, ownProject); err != nil {
+		return err
 	}
-	err = p.storage.ExecTx(ctx, func(s *storage.Storage) error {
-		if err := s.ProjectRepository().AddParticipant(entity.Id, auth.Username); err != nil {
+	err := p.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		role, err := s.ProjectRepository().GetParticipantRole(id, username)
+		if apperrors.IsNotFound(err) {
+			return apperrors.BadRequest(fmt.Sprintf("User %s is not a participant of the project", username))
+		} else if err != nil {
 			return err
 		}
-		return nil
+		if openapi.ProjectRole(role) == openapi.Owner {
+			return nil
+		}
+		members, err := s.ProjectRepository().GetMembers(id)
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			if openapi.ProjectRole(member.Role) != openapi.Owner {
+				continue
+			}
+			if err := s.ProjectRepository().SetParticipantRole(id, member.Username, string(openapi.Admin)); err != nil {
+				return err
+			}
+		}
+		return s.ProjectRepository().SetParticipantRole(id, username, string(openapi.Owner))
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to add participant")
+		return errors.Wrap(err, "failed to transfer project")
 	}
-	log.Infof("User %s joined project %s", auth.Username, entity.Id)
-	return &openapi.Project{Id: entity.Id}, nil
+	recordAudit(p.storage, auth, auditEvent{project: id, resourceType: auditProject, resourceId: id,
+		action: "transfer", summary: "new owner " + username})
+	publishEvent(p.storage, id, openapi.MemberChanged, memberChangedData{Username: username, Change: "transferred",
+		Role: openapi.Owner, Actor: auth.Username})
+	log.Infof("Project %s is transferred to %s", id, username)
+	return nil
 }
 
-func (p projectsImpl) RegenerateInviteCode(ctx context.Context, id string, auth middleware.Authentication) (string, error) {
-	if err := p.checkAccess(id, auth); err != nil {
-		return "", err
+// StopProject stops services first, so that they do not fail while managed services they depend on are stopping
+func (p projectsImpl) StopProject(ctx context.Context, id string, auth middleware.Authentication) error {
+	if err := p.checkAccess(id, auth, editResources); err != nil {
+		return err
 	}
-	newCode, err := uuid.NewRandom()
+	services, err := p.services.GetProjectServices(id, auth)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to regenerate invite code")
+		return errors.Wrap(err, "failed to get project services")
 	}
-	err = p.storage.ExecTx(ctx, func(s *storage.Storage) error {
-		record, err := s.ProjectRepository().FindByID(id)
-		if err != nil {
-			return errors.Wrap(err, "failed to retrieve project by id")
+	for _, service := range services {
+		if err := p.services.StopService(ctx, *service.Id, auth); err != nil {
+			return errors.Wrapf(err, "failed to stop service %s", service.Name)
 		}
-		record.InviteCode = newCode.String()
-		err = s.ProjectRepository().Update(*record)
-		if err != nil {
-			return errors.Wrap(err, "failed to update project")
+	}
+	managedServices, err := p.managedServices.GetProjectManagedServices(id, auth)
+	if err != nil {
+		return errors.Wrap(err, "failed to get project managed services")
+	}
+	for _, service := range managedServices {
+		if err := p.managedServices.StopManagedService(ctx, *service.Id, auth); err != nil {
+			return errors.Wrapf(err, "failed to stop managed service %s", service.Name)
 		}
-		return nil
-	})
+	}
+	recordAudit(p.storage, auth, auditEvent{project: id, resourceType: auditProject, resourceId: id, action: "stop"})
+	p.sync.Enqueue(id)
+	log.Infof("Stopped project %s", id)
+	return nil
+}
+
+// StartProject starts managed services first, so that they are starting up when services connect to them
+func (p projectsImpl) StartProject(ctx context.Context, id string, auth middleware.Authentication) error {
+	if err := p.checkAccess(id, auth, editResources); err != nil {
+		return err
+	}
+	managedServices, err := p.managedServices.GetProjectManagedServices(id, auth)
+	if err != nil {
+		return errors.Wrap(err, "failed to get project managed services")
+	}
+	for _, service := range managedServices {
+		if err := p.managedServices.StartManagedService(ctx, *service.Id, auth); err != nil {
+			return errors.Wrapf(err, "failed to start managed service %s", service.Name)
+		}
+	}
+	services, err := p.services.GetProjectServices(id, auth)
 	if err != nil {
-		return "", err
+		return errors.Wrap(err, "failed to get project services")
 	}
-	return newCode.String(), nil
+	for _, service := range services {
+		if err := p.services.StartService(ctx, *service.Id, auth); err != nil {
+			return errors.Wrapf(err, "failed to start service %s", service.Name)
+		}
+	}
+	recordAudit(p.storage, auth, auditEvent{project: id, resourceType: auditProject, resourceId: id, action: "start"})
+	p.sync.Enqueue(id)
+	log.Infof("Started project %s", id)
+	return nil
 }
 
 func (p projectsImpl) GetSecrets(projectId string, auth middleware.Authentication) ([]openapi.Secret, error) {
-	if err := p.checkAccess(projectId, auth); err != nil {
+	if err := p.checkAccess(projectId, auth, viewProject); err != nil {
 		return nil, err
 	}
 	entities, err := p.storage.SecretRepository().FindByProjectId(projectId)
@@ -334,7 +514,7 @@ func (p projectsImpl) CreateSecret(ctx context.Context, projectId string, secret
 		return nil, apperrors.BadRequest("User cannot create secrets prefixed with '" + managedSecretPrefix + "'")
 	}
 
-	if err := p.checkAccess(projectId, auth); err != nil {
+	if err := p.checkAccess(projectId, auth, editResources); err != nil {
 		return nil, err
 	}
 	exists, err := p.storage.SecretRepository().ExistsByProjectIdAndName(projectId, secretValue.Name)
@@ -366,19 +546,23 @@ func (p projectsImpl) CreateSecret(ctx context.Context, projectId string, secret
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create new secret")
 	}
+	recordAudit(p.storage, auth, auditEvent{project: projectId, resourceType: auditSecret, resourceId: secretValue.Name, action: "create"})
+	// the secret is applied without the managed label here, sync applies it in the form it is watched
+	p.sync.Enqueue(projectId)
 	log.Infof("Created secret %s in project %s", secretValue.Name, projectId)
 	secret := openapi.Secret{Name: secretValue.Name}
 	return &secret, nil
 }
 
 func (p projectsImpl) GetSecretValue(projectId string, name string, auth middleware.Authentication) (*openapi.SecretValue, error) {
-	if err := p.checkAccess(projectId, auth); err != nil {
+	if err := p.checkAccess(projectId, auth, viewSecrets); err != nil {
 		return nil, err
 	}
 	secret, err := p.storage.SecretRepository().FindByProjectIdAndName(projectId, name)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to retrieve secret")
 	}
+	recordAudit(p.storage, auth, auditEvent{project: projectId, resourceType: auditSecret, resourceId: name, action: "read-value"})
 	return &openapi.SecretValue{
 		Name:             secret.Name,
 		Value:            secret.Value,
@@ -386,8 +570,35 @@ func (p projectsImpl) GetSecretValue(projectId string, name string, auth middlew
 	}, nil
 }
 
+func (p projectsImpl) UpdateSecret(ctx context.Context, projectId string, secretValue openapi.SecretValue, auth middleware.Authentication) (*openapi.Secret, error) {
+	if err := p.checkAccess(projectId, auth, editResources); err != nil {
+		return nil, err
+	}
+	secret, err := p.storage.SecretRepository().FindByProjectIdAndName(projectId, secretValue.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get secret")
+	}
+	if secret.ManagedServiceId != nil {
+		return nil, apperrors.Forbidden("Managed service password secret cannot be changed")
+	}
+	secret.Value = secretValue.Value
+	err = p.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		if err := s.SecretRepository().Update(*secret); err != nil {
+			return err
+		}
+		_, err := p.clientset.CoreV1().Secrets(projectId).Apply(ctx, secretConfig(projectId, *secret), metav1.ApplyOptions{FieldManager: "letsdeploy"})
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update secret")
+	}
+	recordAudit(p.storage, auth, auditEvent{project: projectId, resourceType: auditSecret, resourceId: secretValue.Name, action: "update"})
+	log.Infof("Updated secret %s in project %s", secretValue.Name, projectId)
+	return &openapi.Secret{Name: secret.Name}, nil
+}
+
 func (p projectsImpl) DeleteSecret(ctx context.Context, projectId string, name string, auth middleware.Authentication) error {
-	if err := p.checkAccess(projectId, auth); err != nil {
+	if err := p.checkAccess(projectId, auth, editResources); err != nil {
 		return err
 	}
 	secret, err := p.storage.SecretRepository().FindByProjectIdAndName(projectId, name)
@@ -405,35 +616,112 @@ func (p projectsImpl) DeleteSecret(ctx context.Context, projectId string, name s
 	if err != nil && !apierrors.IsNotFound(err) {
 		log.WithError(err).Warnln("Failed to delete secret from Kubernetes")
 	}
+	recordAudit(p.storage, auth, auditEvent{project: projectId, resourceType: auditSecret, resourceId: name, action: "delete"})
+	p.sync.Enqueue(projectId)
 	log.Infof("Deleted secret %s in project %s", name, projectId)
 	return nil
 }
 
-func (p projectsImpl) checkAccess(id string, auth middleware.Authentication) error {
-	if auth == middleware.ServiceAccount {
-		return nil
-	}
-	isParticipant, err := p.storage.ProjectRepository().IsParticipant(id, auth.Username)
+func (p projectsImpl) withStorage(s *storage.Storage) Projects {
+	p.storage = s
+	return p
+}
+
+// checkAccess returns NotFound error if the user is not a participant of the project (or API key is not scoped to it)
+// and Forbidden error if the user role or API key scope does not have the permission
+func (p projectsImpl) checkAccess(id string, auth middleware.Authentication, permission permission) error {
+	role, err := p.getRole(id, auth)
 	if err != nil {
-		return errors.Wrap(err, "project access check unexpected failure")
+		return err
+	}
+	if !hasPermission(role, permission) {
+		return apperrors.Forbidden(fmt.Sprintf("Project role %s does not allow to %s", role, permission))
 	}
-	if !isParticipant {
-		return apperrors.NotFound(fmt.Sprintf("cannot find project with id %s", id))
+	if auth.Scope != nil && auth.Scope.ReadOnly && permission != viewProject && permission != viewSecrets {
+		return apperrors.Forbidden(fmt.Sprintf("Read-only API key does not allow to %s", permission))
 	}
 	return nil
 }
 
+func (p projectsImpl) getRole(id string, auth middleware.Authentication) (openapi.ProjectRole, error) {
+	if auth == middleware.ServiceAccount {
+		return openapi.Owner, nil
+	}
+	if !auth.Scope.AllowsProject(id) {
+		return "", apperrors.NotFound(fmt.Sprintf("cannot find project with id %s", id))
+	}
+	if auth.DeployToken != nil {
+		if auth.DeployToken.Project != id {
+			return "", apperrors.NotFound(fmt.Sprintf("cannot find project with id %s", id))
+		}
+		return "", apperrors.Forbidden("Deploy token can only access the services it is issued for")
+	}
+	role, err := p.storage.ProjectRepository().GetParticipantRole(id, auth.Username)
+	if apperrors.IsNotFound(err) {
+		return "", apperrors.NotFound(fmt.Sprintf("cannot find project with id %s", id))
+	} else if err != nil {
+		return "", errors.Wrap(err, "project access check unexpected failure")
+	}
+	return openapi.ProjectRole(role), nil
+}
+
 func (p projectsImpl) createProjectNamespace(ctx context.Context, project openapi.Project) error {
-	config := applyConfigsV1.Namespace(project.Id).WithLabels(map[string]string{namespaceLabel: "true"})
+	config := namespaceConfig(project.Id)
 	_, err := p.clientset.CoreV1().Namespaces().Apply(ctx, config, metav1.ApplyOptions{FieldManager: "letsdeploy"})
 	if err != nil {
 		return err
 	}
+	if err := p.applyProjectQuota(ctx, project.Id); err != nil {
+		return err
+	}
 	log.Infof("Namespace %s created/updated for a project", project.Id)
 	return nil
 }
 
+// applyProjectQuota mirrors the quota of the project to ResourceQuota and LimitRange of its namespace
+func (p projectsImpl) applyProjectQuota(ctx context.Context, projectId string) error {
+	quota, err := p.storage.ProjectQuotaRepository().FindByProjectId(projectId)
+	if apperrors.IsNotFound(err) {
+		err := p.clientset.CoreV1().ResourceQuotas(projectId).Delete(ctx, resourceQuotaName, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrap(err, "failed to delete resource quota")
+		}
+		err = p.clientset.CoreV1().LimitRanges(projectId).Delete(ctx, limitRangeName, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrap(err, "failed to delete limit range")
+		}
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to get project quota")
+	}
+	_, err = p.clientset.CoreV1().ResourceQuotas(projectId).
+		Apply(ctx, resourceQuotaConfig(*quota), metav1.ApplyOptions{FieldManager: "letsdeploy"})
+	if err != nil {
+		return errors.Wrap(err, "failed to apply resource quota")
+	}
+	_, err = p.clientset.CoreV1().LimitRanges(projectId).
+		Apply(ctx, limitRangeConfig(projectId), metav1.ApplyOptions{FieldManager: "letsdeploy"})
+	if err != nil {
+		return errors.Wrap(err, "failed to apply limit range")
+	}
+	return nil
+}
+
+func namespaceConfig(projectId string) *applyConfigsV1.NamespaceApplyConfiguration {
+	return applyConfigsV1.Namespace(projectId).WithLabels(map[string]string{namespaceLabel: "true"})
+}
+
+func secretConfig(projectId string, secret storage.SecretEntity) *applyConfigsV1.SecretApplyConfiguration {
+	return applyConfigsV1.Secret(strings.ToLower(secret.Name), projectId).
+		WithLabels(map[string]string{"letsdeploy.space/managed": "true"}).
+		WithStringData(map[string]string{secretKey: secret.Value})
+}
+
 func (p projectsImpl) createTlsCertificate(ctx context.Context, project string) error {
+	host, err := projectHost(p.storage, project)
+	if err != nil {
+		return err
+	}
 	cert := certManagerV1.Certificate{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: certManagerV1.SchemeGroupVersion.Identifier(),
@@ -444,7 +732,7 @@ func (p projectsImpl) createTlsCertificate(ctx context.Context, project string)
 		},
 		Spec: certManagerV1.CertificateSpec{
 			SecretName: getTlsSecretName(project),
-			DNSNames:   []string{project + ".letsdeploy.space"},
+			DNSNames:   []string{host},
 			IssuerRef: v1.ObjectReference{
 				Kind: "ClusterIssuer",
 				Name: p.cfg.GetString("tls.cluster-issuer"),
@@ -502,9 +790,7 @@ func (p projectsImpl) syncKubernetes(ctx context.Context, projectId string) erro
 			ManagedServiceId: secret.ManagedServiceId,
 			Name:             secret.Name,
 		}
-		config := applyConfigsV1.Secret(strings.ToLower(secret.Name), projectId).
-			WithLabels(map[string]string{"letsdeploy.space/managed": "true"}).
-			WithStringData(map[string]string{secretKey: secret.Value})
+		config := secretConfig(projectId, secret)
 		_, err = p.clientset.CoreV1().Secrets(projectId).Apply(ctx, config, metav1.ApplyOptions{FieldManager: "letsdeploy"})
 		if err != nil {
 			log.WithError(err).Errorf("Failed to create/update project secret %s, skipping", s.Name)
@@ -530,6 +816,24 @@ func (p projectsImpl) syncKubernetes(ctx context.Context, projectId string) erro
 	return nil
 }
 
+func (p projectsImpl) desiredObjects(projectId string) ([]any, error) {
+	secrets, err := p.storage.SecretRepository().FindByProjectId(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project secrets")
+	}
+	objects := []any{namespaceConfig(projectId)}
+	quota, err := p.storage.ProjectQuotaRepository().FindByProjectId(projectId)
+	if err == nil {
+		objects = append(objects, resourceQuotaConfig(*quota), limitRangeConfig(projectId))
+	} else if !apperrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to get project quota")
+	}
+	for _, secret := range secrets {
+		objects = append(objects, secretConfig(projectId, secret))
+	}
+	return objects, nil
+}
+
 func (p projectsImpl) removeExcessNamespaces(ctx context.Context, checkedProjects map[string]bool) {
 	namespaces, err := p.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
 	if err != nil {
@@ -551,6 +855,28 @@ func (p projectsImpl) removeExcessNamespaces(ctx context.Context, checkedProject
 	}
 }
 
+// projectHost returns the host of the project services,
+// previews and environments are served under the host of their parent unless the environment has its own domain
+func projectHost(s *storage.Storage, projectId string) (string, error) {
+	preview, err := s.PreviewEnvironmentRepository().FindByProjectId(projectId)
+	if err == nil && preview.ParentId.Valid {
+		return childProjectHost(preview.Name, preview.ParentId.String), nil
+	} else if err != nil && !apperrors.IsNotFound(err) {
+		return "", errors.Wrapf(err, "failed to get host of project %s", projectId)
+	}
+	environment, err := s.ProjectEnvironmentRepository().FindByProjectId(projectId)
+	if err == nil {
+		return environmentHost(*environment), nil
+	} else if !apperrors.IsNotFound(err) {
+		return "", errors.Wrapf(err, "failed to get host of project %s", projectId)
+	}
+	return projectId + "." + baseDomain, nil
+}
+
+func childProjectHost(name string, parentId string) string {
+	return name + "." + parentId + "." + baseDomain
+}
+
 func getTlsSecretName(project string) string {
 	return managedSecretPrefix + project + ".tls"
 }
@@ -0,0 +1,398 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/app/util/promise"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	applyConfigsCoreV1 "k8s.io/client-go/applyconfigurations/core/v1"
+	"slices"
+	"strings"
+)
+
+const (
+	// containerCpuLimitMillis and containerMemoryLimitMi are set by LimitRange of the project namespace
+	// to containers without limits, so they are used to calculate the usage of the quota
+	containerCpuLimitMillis   = 250
+	containerMemoryLimitMi    = 512
+	containerCpuRequestMillis = 50
+	containerMemoryRequestMi  = 128
+	// deploymentMaxSurge is the number of extra pods created during the rollout of the service
+	deploymentMaxSurge = 1
+	resourceQuotaName  = "letsdeploy-quota"
+	limitRangeName     = "letsdeploy-limits"
+)
+
+// Quotas limits resources of projects. Quotas are enforced before services and managed services are created or updated,
+// and also applied to the project namespace as ResourceQuota and LimitRange
+type Quotas interface {
+	// GetQuota returns the quota of the project with its usage, limits are not set if the project has no quota
+	GetQuota(projectId string, auth middleware.Authentication) (*openapi.ProjectQuota, error)
+	// SetQuota replaces the quota of the project, available only to platform admins
+	SetQuota(projectId string, quota openapi.ProjectQuota, auth middleware.Authentication) (*openapi.ProjectQuota, error)
+	DeleteQuota(projectId string, auth middleware.Authentication) error
+	// checkService returns Forbidden error if the service exceeds the quota of its project,
+	// previous is the stored state of the updated service and nil for the new one
+	checkService(service openapi.Service, previous *openapi.Service) error
+	checkManagedService(service openapi.ManagedService, previous *openapi.ManagedService) error
+	withStorage(s *storage.Storage) Quotas
+}
+
+type quotasImpl struct {
+	projects        Projects
+	managedServices ManagedServices
+	sync            Sync
+	storage         *storage.Storage
+	cfg             *viper.Viper
+}
+
+// quotaUsage is the amount of resources used by the project. All configured replicas are counted,
+// including the ones of stopped services, so that stopped services can always be started
+type quotaUsage struct {
+	cpuMillis       int
+	memoryMi        int
+	storageMi       int
+	services        int
+	managedServices int
+}
+
+var _ Quotas = (*quotasImpl)(nil)
+
+func InitQuotas(projects Projects, storage *storage.Storage, cfg *viper.Viper, core promise.Promise[Core]) Quotas {
+	q := &quotasImpl{projects: projects, storage: storage, cfg: cfg}
+	core.OnProvided(func(core Core) {
+		q.managedServices = core.ManagedServices
+		q.sync = core.Sync
+	})
+	return q
+}
+
+func (q quotasImpl) GetQuota(projectId string, auth middleware.Authentication) (*openapi.ProjectQuota, error) {
+	if !isPlatformAdmin(q.cfg, auth) {
+		if err := q.projects.checkAccess(projectId, auth, viewProject); err != nil {
+			return nil, err
+		}
+	} else if err := q.checkProjectExists(projectId); err != nil {
+		return nil, err
+	}
+	quota := &openapi.ProjectQuota{}
+	entity, err := q.storage.ProjectQuotaRepository().FindByProjectId(projectId)
+	if err == nil {
+		quota = quotaFromEntity(*entity)
+	} else if !apperrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to get project quota")
+	}
+	usage, err := q.projectUsage(projectId)
+	if err != nil {
+		return nil, err
+	}
+	quota.Usage = &openapi.QuotaUsage{
+		CpuMillis:       usage.cpuMillis,
+		MemoryMi:        usage.memoryMi,
+		StorageMi:       usage.storageMi,
+		Services:        usage.services,
+		ManagedServices: usage.managedServices,
+	}
+	return quota, nil
+}
+
+func (q quotasImpl) SetQuota(projectId string, quota openapi.ProjectQuota, auth middleware.Authentication) (*openapi.ProjectQuota, error) {
+	if !isPlatformAdmin(q.cfg, auth) {
+		return nil, apperrors.Forbidden("Project quota can be set only by platform admins")
+	}
+	if err := q.checkProjectExists(projectId); err != nil {
+		return nil, err
+	}
+	entity, err := q.storage.ProjectQuotaRepository().Save(storage.ProjectQuotaEntity{
+		ProjectId:       projectId,
+		CpuMillis:       toNullInt32(quota.CpuMillis),
+		MemoryMi:        toNullInt32(quota.MemoryMi),
+		StorageMi:       toNullInt32(quota.StorageMi),
+		Services:        toNullInt32(quota.Services),
+		ManagedServices: toNullInt32(quota.ManagedServices),
+		UpdatedBy:       auth.Username,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// ResourceQuota and LimitRange are applied with the namespace
+	q.sync.Enqueue(projectId)
+	recordAudit(q.storage, auth, auditEvent{project: projectId, resourceType: auditQuota, resourceId: projectId,
+		action: "update", summary: quotaSummary(*entity)})
+	log.Infof("Quota of project %s is set by %s", projectId, auth.Username)
+	return q.GetQuota(projectId, auth)
+}
+
+func (q quotasImpl) DeleteQuota(projectId string, auth middleware.Authentication) error {
+	if !isPlatformAdmin(q.cfg, auth) {
+		return apperrors.Forbidden("Project quota can be removed only by platform admins")
+	}
+	if err := q.checkProjectExists(projectId); err != nil {
+		return err
+	}
+	if err := q.storage.ProjectQuotaRepository().Delete(projectId); err != nil {
+		return err
+	}
+	q.sync.Enqueue(projectId)
+	recordAudit(q.storage, auth, auditEvent{project: projectId, resourceType: auditQuota, resourceId: projectId, action: "delete"})
+	log.Infof("Quota of project %s is removed by %s", projectId, auth.Username)
+	return nil
+}
+
+func (q quotasImpl) withStorage(s *storage.Storage) Quotas {
+	q.storage = s
+	return q
+}
+
+func (q quotasImpl) checkService(service openapi.Service, previous *openapi.Service) error {
+	current, err := q.projectUsage(service.Project)
+	if err != nil {
+		return err
+	}
+	projected := current
+	if previous != nil {
+		projected = projected.minus(serviceUsage(*previous))
+	}
+	projected = projected.plus(serviceUsage(service))
+	return q.checkUsage(service.Project, current, projected)
+}
+
+func (q quotasImpl) checkManagedService(service openapi.ManagedService, previous *openapi.ManagedService) error {
+	current, err := q.projectUsage(service.Project)
+	if err != nil {
+		return err
+	}
+	projected := current
+	if previous != nil {
+		projected = projected.minus(q.managedServices.resourceUsage(*previous))
+	}
+	projected = projected.plus(q.managedServices.resourceUsage(service))
+	return q.checkUsage(service.Project, current, projected)
+}
+
+// checkUsage returns Forbidden error if the projected usage exceeds the quota. Usage that is already above the quota
+// is allowed to stay or decrease, so that lowering the quota does not block unrelated changes
+func (q quotasImpl) checkUsage(projectId string, current quotaUsage, projected quotaUsage) error {
+	entity, err := q.storage.ProjectQuotaRepository().FindByProjectId(projectId)
+	if apperrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to get project quota")
+	}
+	limits := []struct {
+		resource  string
+		limit     sql.NullInt32
+		current   int
+		projected int
+	}{
+		{"CPU millicores", entity.CpuMillis, current.cpuMillis, projected.cpuMillis},
+		{"memory MiB", entity.MemoryMi, current.memoryMi, projected.memoryMi},
+		{"storage MiB", entity.StorageMi, current.storageMi, projected.storageMi},
+		{"services", entity.Services, current.services, projected.services},
+		{"managed services", entity.ManagedServices, current.managedServices, projected.managedServices},
+	}
+	for _, l := range limits {
+		if l.limit.Valid && l.projected > int(l.limit.Int32) && l.projected > l.current {
+			return apperrors.Forbidden(fmt.Sprintf("Project quota exceeded: %d %s requested, %d allowed",
+				l.projected, l.resource, l.limit.Int32))
+		}
+	}
+	return nil
+}
+
+func (q quotasImpl) projectUsage(projectId string) (quotaUsage, error) {
+	usage := quotaUsage{}
+	services, err := q.storage.ServiceRepository().FindByProjectId(projectId)
+	if err != nil {
+		return usage, errors.Wrap(err, "failed to get project services")
+	}
+	for _, service := range services {
+		usage = usage.plus(serviceUsage(openapi.Service{Replicas: service.Replicas}))
+	}
+	managedServices, err := q.storage.ManagedServiceRepository().FindByProjectId(projectId)
+	if err != nil {
+		return usage, errors.Wrap(err, "failed to get project managed services")
+	}
+	for _, service := range managedServices {
+		usage = usage.plus(q.managedServices.resourceUsage(managedServiceFromEntity(service)))
+	}
+	return usage, nil
+}
+
+func (q quotasImpl) checkProjectExists(projectId string) error {
+	exists, err := q.storage.ProjectRepository().ExistsByID(projectId)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if project exists")
+	}
+	if !exists {
+		return apperrors.NotFound(fmt.Sprintf("cannot find project with id %s", projectId))
+	}
+	return nil
+}
+
+// resourceUsage counts all pods of the managed service with the default container limits and their volumes
+func (m managedServicesImpl) resourceUsage(service openapi.ManagedService) quotaUsage {
+	pods := 1
+	if m.highAvailabilityEnabled(service) {
+		pods = managedServiceReplicas(service)
+		if m.types[service.Type].highAvailability == redisSentinel {
+			pods += redisSentinelReplicas
+		}
+	}
+	storageMi := 0
+	for _, volume := range m.types[service.Type].volumes {
+		size := resource.MustParse(volume.size)
+		storageMi += int((size.Value() + 1<<20 - 1) >> 20)
+	}
+	return quotaUsage{
+		cpuMillis:       pods * containerCpuLimitMillis,
+		memoryMi:        pods * containerMemoryLimitMi,
+		storageMi:       pods * storageMi,
+		managedServices: 1,
+	}
+}
+
+func serviceUsage(service openapi.Service) quotaUsage {
+	return quotaUsage{
+		cpuMillis: service.Replicas * containerCpuLimitMillis,
+		memoryMi:  service.Replicas * containerMemoryLimitMi,
+		services:  1,
+	}
+}
+
+func (u quotaUsage) plus(other quotaUsage) quotaUsage {
+	return quotaUsage{
+		cpuMillis:       u.cpuMillis + other.cpuMillis,
+		memoryMi:        u.memoryMi + other.memoryMi,
+		storageMi:       u.storageMi + other.storageMi,
+		services:        u.services + other.services,
+		managedServices: u.managedServices + other.managedServices,
+	}
+}
+
+func (u quotaUsage) minus(other quotaUsage) quotaUsage {
+	return quotaUsage{
+		cpuMillis:       u.cpuMillis - other.cpuMillis,
+		memoryMi:        u.memoryMi - other.memoryMi,
+		storageMi:       u.storageMi - other.storageMi,
+		services:        u.services - other.services,
+		managedServices: u.managedServices - other.managedServices,
+	}
+}
+
+// resourceQuotaConfig builds ResourceQuota of the project namespace, managed services count is enforced only in core.
+// CPU and memory have headroom for surge pods of rolling updates, as they are not counted in core
+func resourceQuotaConfig(quota storage.ProjectQuotaEntity) *applyConfigsCoreV1.ResourceQuotaApplyConfiguration {
+	hard := v1.ResourceList{}
+	surgePods := int64(maxDeployments(quota) * deploymentMaxSurge)
+	if quota.CpuMillis.Valid {
+		cpuMillis := int64(quota.CpuMillis.Int32) + surgePods*containerCpuLimitMillis
+		hard[v1.ResourceLimitsCPU] = *resource.NewMilliQuantity(cpuMillis, resource.DecimalSI)
+	}
+	if quota.MemoryMi.Valid {
+		memoryMi := int64(quota.MemoryMi.Int32) + surgePods*containerMemoryLimitMi
+		hard[v1.ResourceLimitsMemory] = *resource.NewQuantity(memoryMi<<20, resource.BinarySI)
+	}
+	if quota.StorageMi.Valid {
+		hard[v1.ResourceRequestsStorage] = *resource.NewQuantity(int64(quota.StorageMi.Int32)<<20, resource.BinarySI)
+	}
+	if quota.Services.Valid {
+		hard["count/deployments.apps"] = *resource.NewQuantity(int64(quota.Services.Int32), resource.DecimalSI)
+	}
+	return applyConfigsCoreV1.ResourceQuota(resourceQuotaName, quota.ProjectId).
+		WithLabels(map[string]string{"letsdeploy.space/managed": "true"}).
+		WithSpec(applyConfigsCoreV1.ResourceQuotaSpec().WithHard(hard))
+}
+
+// maxDeployments returns the maximum number of deployments of the project under the quota,
+// each deployment of a running service has at least one pod within CPU and memory limits
+func maxDeployments(quota storage.ProjectQuotaEntity) int {
+	bounds := make([]int, 0, 3)
+	if quota.Services.Valid {
+		bounds = append(bounds, int(quota.Services.Int32))
+	}
+	if quota.CpuMillis.Valid {
+		bounds = append(bounds, int(quota.CpuMillis.Int32)/containerCpuLimitMillis)
+	}
+	if quota.MemoryMi.Valid {
+		bounds = append(bounds, int(quota.MemoryMi.Int32)/containerMemoryLimitMi)
+	}
+	if len(bounds) == 0 {
+		return 0
+	}
+	return max(slices.Min(bounds), 0)
+}
+
+// limitRangeConfig builds LimitRange that sets limits to containers without them,
+// ResourceQuota with CPU and memory limits rejects pods without limits
+func limitRangeConfig(projectId string) *applyConfigsCoreV1.LimitRangeApplyConfiguration {
+	return applyConfigsCoreV1.LimitRange(limitRangeName, projectId).
+		WithLabels(map[string]string{"letsdeploy.space/managed": "true"}).
+		WithSpec(applyConfigsCoreV1.LimitRangeSpec().WithLimits(applyConfigsCoreV1.LimitRangeItem().
+			WithType(v1.LimitTypeContainer).
+			WithDefault(v1.ResourceList{
+				v1.ResourceCPU:    *resource.NewMilliQuantity(containerCpuLimitMillis, resource.DecimalSI),
+				v1.ResourceMemory: *resource.NewQuantity(containerMemoryLimitMi<<20, resource.BinarySI),
+			}).
+			WithDefaultRequest(v1.ResourceList{
+				v1.ResourceCPU:    *resource.NewMilliQuantity(containerCpuRequestMillis, resource.DecimalSI),
+				v1.ResourceMemory: *resource.NewQuantity(containerMemoryRequestMi<<20, resource.BinarySI),
+			})))
+}
+
+func quotaFromEntity(entity storage.ProjectQuotaEntity) *openapi.ProjectQuota {
+	return &openapi.ProjectQuota{
+		CpuMillis:       fromNullInt32(entity.CpuMillis),
+		MemoryMi:        fromNullInt32(entity.MemoryMi),
+		StorageMi:       fromNullInt32(entity.StorageMi),
+		Services:        fromNullInt32(entity.Services),
+		ManagedServices: fromNullInt32(entity.ManagedServices),
+		UpdatedBy:       &entity.UpdatedBy,
+		UpdatedAt:       &entity.UpdatedAt,
+	}
+}
+
+func quotaSummary(entity storage.ProjectQuotaEntity) string {
+	var limits []string
+	for _, l := range []struct {
+		name  string
+		value sql.NullInt32
+	}{
+		{"cpu", entity.CpuMillis},
+		{"memory", entity.MemoryMi},
+		{"storage", entity.StorageMi},
+		{"services", entity.Services},
+		{"managed services", entity.ManagedServices},
+	} {
+		if l.value.Valid {
+			limits = append(limits, fmt.Sprintf("%s %d", l.name, l.value.Int32))
+		}
+	}
+	if len(limits) == 0 {
+		return "no limits"
+	}
+	return strings.Join(limits, ", ")
+}
+
+func toNullInt32(i *int) sql.NullInt32 {
+	if i == nil {
+		return sql.NullInt32{}
+	}
+	return sql.NullInt32{Int32: int32(*i), Valid: true}
+}
+
+func fromNullInt32(i sql.NullInt32) *int {
+	if !i.Valid {
+		return nil
+	}
+	value := int(i.Int32)
+	return &value
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"github.com/kuzznya/letsdeploy/app/apperrors"
 	"github.com/kuzznya/letsdeploy/app/middleware"
 	"github.com/kuzznya/letsdeploy/app/storage"
@@ -14,6 +15,7 @@ import (
 	applyConfigsCoreV1 "k8s.io/client-go/applyconfigurations/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -23,7 +25,10 @@ type ContainerRegistries interface {
 	projectSynchronizable
 	GetProjectContainerRegistries(project string, auth middleware.Authentication) ([]openapi.ContainerRegistry, error)
 	AddContainerRegistry(ctx context.Context, project string, registry openapi.ContainerRegistry, auth middleware.Authentication) (openapi.ContainerRegistry, error)
+	// UpdateContainerRegistry changes credentials of the registry, the password is kept if it is not set
+	UpdateContainerRegistry(ctx context.Context, project string, id int, registry openapi.ContainerRegistry, auth middleware.Authentication) (openapi.ContainerRegistry, error)
 	DeleteContainerRegistry(ctx context.Context, project string, id int, auth middleware.Authentication) error
+	withStorage(s *storage.Storage) ContainerRegistries
 }
 
 type containerRegistriesImpl struct {
@@ -47,7 +52,7 @@ func (r containerRegistriesImpl) GetProjectContainerRegistries(project string, a
 }
 
 func (r containerRegistriesImpl) AddContainerRegistry(ctx context.Context, project string, registry openapi.ContainerRegistry, auth middleware.Authentication) (openapi.ContainerRegistry, error) {
-	if err := r.projects.checkAccess(project, auth); err != nil {
+	if err := r.projects.checkAccess(project, auth, manageProject); err != nil {
 		return openapi.ContainerRegistry{}, err
 	}
 
@@ -73,12 +78,55 @@ func (r containerRegistriesImpl) AddContainerRegistry(ctx context.Context, proje
 	if err != nil {
 		return openapi.ContainerRegistry{}, errors.Wrap(err, "failed to create container registry")
 	}
+	recordAudit(r.storage, auth, auditEvent{project: project, resourceType: auditRegistry, resourceId: strconv.Itoa(*registry.Id),
+		action: "create", summary: registry.Url})
 	log.Infof("Added container registry %d (%s) to project %s", registry.Id, registry.Url, project)
 	return registry, nil
 }
 
+func (r containerRegistriesImpl) UpdateContainerRegistry(
+	ctx context.Context,
+	project string,
+	id int,
+	registry openapi.ContainerRegistry,
+	auth middleware.Authentication,
+) (openapi.ContainerRegistry, error) {
+	if err := r.projects.checkAccess(project, auth, manageProject); err != nil {
+		return openapi.ContainerRegistry{}, err
+	}
+	entities, err := r.storage.ContainerRegistryRepository().FindByProjectId(project)
+	if err != nil {
+		return openapi.ContainerRegistry{}, err
+	}
+	entity, found := toMapSelf(entities, func(e storage.ContainerRegistryEntity) int { return e.Id })[id]
+	if !found {
+		return openapi.ContainerRegistry{}, apperrors.NotFound(fmt.Sprintf("Container registry %d not found", id))
+	}
+	if registry.Url != entity.Url {
+		return openapi.ContainerRegistry{}, apperrors.BadRequest("Container registry URL cannot be changed")
+	}
+	entity.Username = registry.Username
+	if registry.Password != nil {
+		entity.Password = *registry.Password
+	}
+	// registries secret is re-applied with the new credentials, so it is never missing during the update
+	err = r.storage.ExecTx(ctx, func(s *storage.Storage) error {
+		if err := s.ContainerRegistryRepository().Update(entity); err != nil {
+			return err
+		}
+		return r.syncProjectSecret(ctx, s, project, auth)
+	})
+	if err != nil {
+		return openapi.ContainerRegistry{}, errors.Wrap(err, "failed to update container registry")
+	}
+	recordAudit(r.storage, auth, auditEvent{project: project, resourceType: auditRegistry, resourceId: strconv.Itoa(id),
+		action: "update", summary: registry.Url})
+	log.Infof("Updated container registry %d (%s) in project %s", id, registry.Url, project)
+	return openapi.ContainerRegistry{Id: &entity.Id, Url: entity.Url, Username: entity.Username}, nil
+}
+
 func (r containerRegistriesImpl) DeleteContainerRegistry(ctx context.Context, project string, id int, auth middleware.Authentication) error {
-	if err := r.projects.checkAccess(project, auth); err != nil {
+	if err := r.projects.checkAccess(project, auth, manageProject); err != nil {
 		return err
 	}
 	err := r.storage.ExecTx(ctx, func(s *storage.Storage) error {
@@ -91,14 +139,33 @@ func (r containerRegistriesImpl) DeleteContainerRegistry(ctx context.Context, pr
 	if err != nil {
 		return errors.Wrap(err, "failed to delete container registry")
 	}
+	recordAudit(r.storage, auth, auditEvent{project: project, resourceType: auditRegistry, resourceId: strconv.Itoa(id), action: "delete"})
 	log.Infof("Deleted container registry %d from project %s", id, project)
 	return nil
 }
 
+func (r containerRegistriesImpl) withStorage(s *storage.Storage) ContainerRegistries {
+	r.storage = s
+	r.projects = r.projects.withStorage(s)
+	return r
+}
+
 func (r containerRegistriesImpl) syncKubernetes(ctx context.Context, projectId string) error {
 	return r.syncProjectSecret(ctx, r.storage, projectId, middleware.ServiceAccount)
 }
 
+func (r containerRegistriesImpl) desiredObjects(projectId string) ([]any, error) {
+	regs, err := r.getProjectContainerRegistries(r.storage, projectId, true, middleware.ServiceAccount)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := registriesSecretConfig(projectId, regs)
+	if err != nil {
+		return nil, err
+	}
+	return []any{secret}, nil
+}
+
 func (r containerRegistriesImpl) syncProjectSecret(
 	ctx context.Context,
 	s *storage.Storage,
@@ -118,7 +185,7 @@ func (r containerRegistriesImpl) getProjectContainerRegistries(
 	withPwd bool,
 	auth middleware.Authentication,
 ) ([]openapi.ContainerRegistry, error) {
-	if err := r.projects.checkAccess(project, auth); err != nil {
+	if err := r.projects.checkAccess(project, auth, viewProject); err != nil {
 		return nil, err
 	}
 	entities, err := s.ContainerRegistryRepository().FindByProjectId(project)
@@ -141,6 +208,20 @@ func (r containerRegistriesImpl) getProjectContainerRegistries(
 }
 
 func (r containerRegistriesImpl) createRegistriesSecret(ctx context.Context, project string, registries []openapi.ContainerRegistry) error {
+	secret, err := registriesSecretConfig(project, registries)
+	if err != nil {
+		return err
+	}
+	_, err = r.clientset.CoreV1().Secrets(project).Apply(ctx, secret, metav1.ApplyOptions{FieldManager: "letsdeploy"})
+	if err != nil {
+		return errors.Wrap(err, "failed to apply registry auth secret")
+	}
+	log.Debugf("Applied registry secret configuration to project %s", project)
+	return nil
+}
+
+// registriesSecretConfig builds dockerconfigjson secret, registries should contain passwords
+func registriesSecretConfig(project string, registries []openapi.ContainerRegistry) (*applyConfigsCoreV1.SecretApplyConfiguration, error) {
 	type regAuth struct {
 		Auth string `json:"auth"`
 	}
@@ -154,19 +235,13 @@ func (r containerRegistriesImpl) createRegistriesSecret(ctx context.Context, pro
 	}
 	configJson, err := json.Marshal(dockerConfig{Auths: auths})
 	if err != nil {
-		return errors.Wrap(err, "failed to serialize dockerconfigjson")
+		return nil, errors.Wrap(err, "failed to serialize dockerconfigjson")
 	}
 
 	secret := applyConfigsCoreV1.Secret(regcredSecretName, project).
 		WithType("kubernetes.io/dockerconfigjson").
 		WithData(map[string][]byte{".dockerconfigjson": configJson})
-
-	_, err = r.clientset.CoreV1().Secrets(project).Apply(ctx, secret, metav1.ApplyOptions{FieldManager: "letsdeploy"})
-	if err != nil {
-		return errors.Wrap(err, "failed to apply registry auth secret")
-	}
-	log.Debugf("Applied registry secret configuration to project %s", project)
-	return nil
+	return secret, nil
 }
 
 func isRegistryUrlValid(regUrl string) bool {
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/spf13/viper"
+	"slices"
+)
+
+// permission is an action on the project that is allowed to some project roles
+type permission string
+
+const (
+	// viewProject allows to read project resources, their statuses and logs, but not secret values
+	viewProject permission = "view project"
+	// viewSecrets allows to read secret values
+	viewSecrets permission = "view secret values"
+	// editResources allows to manage services, managed services, secrets and MongoDB users
+	editResources permission = "edit resources"
+	// restartServices allows to restart services
+	restartServices permission = "restart services"
+	// updateServiceImages allows to change images of services
+	updateServiceImages permission = "update service images"
+	// manageProject allows to manage container registries, invite code and hibernation schedule
+	manageProject permission = "manage project"
+	// manageMembers allows to add, remove and change roles of developers and viewers
+	manageMembers permission = "manage members"
+	// manageAdmins allows to grant and revoke admin role
+	manageAdmins permission = "manage admins"
+	// ownProject allows to delete and transfer the project
+	ownProject permission = "delete or transfer project"
+)
+
+var rolePermissions = map[openapi.ProjectRole][]permission{
+	openapi.Owner: {viewProject, viewSecrets, editResources, restartServices, updateServiceImages,
+		manageProject, manageMembers, manageAdmins, ownProject},
+	openapi.Admin: {viewProject, viewSecrets, editResources, restartServices, updateServiceImages,
+		manageProject, manageMembers},
+	openapi.Developer: {viewProject, viewSecrets, editResources, restartServices, updateServiceImages},
+	openapi.Viewer:    {viewProject},
+}
+
+func isValidRole(role openapi.ProjectRole) bool {
+	_, found := rolePermissions[role]
+	return found
+}
+
+func hasPermission(role openapi.ProjectRole, p permission) bool {
+	return slices.Contains(rolePermissions[role], p)
+}
+
+// isPlatformAdmin returns true if the user is listed in platform.admins config.
+// Project scoped API keys and deploy tokens never grant platform admin access
+func isPlatformAdmin(cfg *viper.Viper, auth middleware.Authentication) bool {
+	if auth.DeployToken != nil || auth.Scope.ProjectScoped() {
+		return false
+	}
+	return slices.Contains(cfg.GetStringSlice("platform.admins"), auth.Username)
+}
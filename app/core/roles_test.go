@@ -0,0 +1,135 @@
+package core
+
+import (
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/spf13/viper"
+	"slices"
+	"testing"
+)
+
+func TestHasPermission(t *testing.T) {
+	permissions := []permission{viewProject, viewSecrets, editResources, restartServices, updateServiceImages,
+		manageProject, manageMembers, manageAdmins, ownProject}
+	tests := []struct {
+		name    string
+		role    openapi.ProjectRole
+		allowed []permission
+	}{
+		{
+			name:    "Owner",
+			role:    openapi.Owner,
+			allowed: permissions,
+		},
+		{
+			name: "Admin",
+			role: openapi.Admin,
+			allowed: []permission{viewProject, viewSecrets, editResources, restartServices, updateServiceImages,
+				manageProject, manageMembers},
+		},
+		{
+			name:    "Developer",
+			role:    openapi.Developer,
+			allowed: []permission{viewProject, viewSecrets, editResources, restartServices, updateServiceImages},
+		},
+		{
+			name:    "Viewer",
+			role:    openapi.Viewer,
+			allowed: []permission{viewProject},
+		},
+		{
+			name:    "UnknownRole",
+			role:    "guest",
+			allowed: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, p := range permissions {
+				want := slices.Contains(tt.allowed, p)
+				if got := hasPermission(tt.role, p); got != want {
+					t.Errorf("hasPermission(%s, %s) = %v, want %v", tt.role, p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIsValidRole(t *testing.T) {
+	tests := []struct {
+		name string
+		role openapi.ProjectRole
+		want bool
+	}{
+		{
+			name: "Owner",
+			role: openapi.Owner,
+			want: true,
+		},
+		{
+			name: "Viewer",
+			role: openapi.Viewer,
+			want: true,
+		},
+		{
+			name: "UnknownRole",
+			role: "guest",
+			want: false,
+		},
+		{
+			name: "EmptyRole",
+			role: "",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isValidRole(tt.role); got != tt.want {
+				t.Errorf("isValidRole() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPlatformAdmin(t *testing.T) {
+	cfg := viper.New()
+	cfg.Set("platform.admins", []string{"root"})
+	tests := []struct {
+		name string
+		auth middleware.Authentication
+		want bool
+	}{
+		{
+			name: "Admin",
+			auth: middleware.Authentication{Username: "root"},
+			want: true,
+		},
+		{
+			name: "NotAdmin",
+			auth: middleware.Authentication{Username: "user"},
+			want: false,
+		},
+		{
+			name: "UnscopedApiKey",
+			auth: middleware.Authentication{Username: "root", Scope: &middleware.Scope{ReadOnly: true}},
+			want: true,
+		},
+		{
+			name: "ProjectScopedApiKey",
+			auth: middleware.Authentication{Username: "root", Scope: &middleware.Scope{Projects: []string{"test"}}},
+			want: false,
+		},
+		{
+			name: "DeployToken",
+			auth: middleware.Authentication{Username: "root", DeployToken: &middleware.DeployToken{Project: "test"}},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPlatformAdmin(cfg, tt.auth); got != tt.want {
+				t.Errorf("isPlatformAdmin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@ import (
 	traefikClientset "github.com/traefik/traefik/v2/pkg/provider/kubernetes/crd/generated/clientset/versioned/typed/traefikio/v1alpha1"
 	traefikCrd "github.com/traefik/traefik/v2/pkg/provider/kubernetes/crd/traefikio/v1alpha1"
 	"io"
+	appsV1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	networkingV1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -31,7 +32,10 @@ import (
 	"time"
 )
 
-const containerName = "container-0"
+const (
+	containerName       = "container-0"
+	rolloutPollInterval = 2 * time.Second
+)
 
 type Services interface {
 	projectSynchronizable
@@ -42,11 +46,25 @@ type Services interface {
 	DeleteService(ctx context.Context, id int, auth middleware.Authentication) error
 	GetServiceStatus(ctx context.Context, id int, auth middleware.Authentication) (*openapi.ServiceStatus, error)
 	RestartService(ctx context.Context, id int, auth middleware.Authentication) error
+	// DeployService changes the image of the service and optionally waits until the rollout is finished
+	DeployService(
+		ctx context.Context,
+		id int,
+		deployment openapi.ServiceDeployment,
+		wait bool,
+		timeout time.Duration,
+		auth middleware.Authentication,
+	) (*openapi.ServiceDeploymentResult, error)
+	StopService(ctx context.Context, id int, auth middleware.Authentication) error
+	StartService(ctx context.Context, id int, auth middleware.Authentication) error
 	StreamServiceLogs(ctx context.Context, serviceId int, replica int, auth middleware.Authentication) (io.Reader, error)
+	getServiceStatus(ctx context.Context, service openapi.Service) (*openapi.ServiceStatus, error)
+	withStorage(store *storage.Storage) Services
 }
 
 type servicesImpl struct {
 	projects      Projects
+	quotas        Quotas
 	storage       *storage.Storage
 	clientset     *kubernetes.Clientset
 	traefikClient traefikClientset.TraefikV1alpha1Interface
@@ -57,6 +75,7 @@ var _ Services = (*servicesImpl)(nil)
 
 func InitServices(
 	projects Projects,
+	quotas Quotas,
 	storage *storage.Storage,
 	clientset *kubernetes.Clientset,
 	cfg *viper.Viper,
@@ -64,6 +83,7 @@ func InitServices(
 	traefikClient := k8s.SetupTraefikClient(cfg)
 	s := servicesImpl{
 		projects:      projects,
+		quotas:        quotas,
 		storage:       storage,
 		clientset:     clientset,
 		traefikClient: traefikClient,
@@ -73,7 +93,7 @@ func InitServices(
 }
 
 func (s servicesImpl) GetProjectServices(project string, auth middleware.Authentication) ([]openapi.Service, error) {
-	if err := s.projects.checkAccess(project, auth); err != nil {
+	if err := s.projects.checkAccess(project, auth, viewProject); err != nil {
 		return nil, err
 	}
 	entities, err := s.storage.ServiceRepository().FindByProjectId(project)
@@ -91,6 +111,7 @@ func (s servicesImpl) GetProjectServices(project string, auth middleware.Authent
 		if entity.PublicApiPrefix.Valid && entity.StripApiPrefix {
 			stripApiPrefix = true
 		}
+		stopped := entity.Stopped
 		services[i] = openapi.Service{
 			Id:              &id,
 			Image:           entity.Image,
@@ -101,13 +122,17 @@ func (s servicesImpl) GetProjectServices(project string, auth middleware.Authent
 			StripApiPrefix:  &stripApiPrefix,
 			EnvVars:         envVars,
 			Replicas:        entity.Replicas,
+			Stopped:         &stopped,
 		}
 	}
 	return services, nil
 }
 
 func (s servicesImpl) CreateService(ctx context.Context, service openapi.Service, auth middleware.Authentication) (*openapi.Service, error) {
-	if err := s.projects.checkAccess(service.Project, auth); err != nil {
+	if err := s.projects.checkAccess(service.Project, auth, editResources); err != nil {
+		return nil, err
+	}
+	if err := s.quotas.checkService(service, nil); err != nil {
 		return nil, err
 	}
 
@@ -135,7 +160,13 @@ func (s servicesImpl) CreateService(ctx context.Context, service openapi.Service
 		StripApiPrefix:  stripApiPrefix,
 		EnvVars:         envVars,
 		Replicas:        service.Replicas,
+		Command:         fromPtr(service.Command),
+	}
+	if record.Command == nil {
+		record.Command = storage.StringList{}
 	}
+	stopped := false
+	service.Stopped = &stopped
 	err := s.storage.ExecTx(ctx, func(store *storage.Storage) error {
 		id, err := store.ServiceRepository().CreateNew(record)
 		if err != nil {
@@ -152,27 +183,45 @@ func (s servicesImpl) CreateService(ctx context.Context, service openapi.Service
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create new service")
 	}
+	recordAudit(s.storage, auth, auditEvent{project: service.Project, resourceType: auditService, resourceId: service.Name,
+		action: "create", summary: "image " + service.Image})
 	log.Infof("Created service %s in project %s", service.Name, service.Project)
 	return &service, nil
 }
 
+func (s servicesImpl) withStorage(store *storage.Storage) Services {
+	s.storage = store
+	s.projects = s.projects.withStorage(store)
+	s.quotas = s.quotas.withStorage(store)
+	return s
+}
+
 func (s servicesImpl) GetService(id int, auth middleware.Authentication) (*openapi.Service, error) {
+	return s.getService(id, auth, viewProject)
+}
+
+// getService returns the service if the user has the permission in its project
+func (s servicesImpl) getService(id int, auth middleware.Authentication, permission permission) (*openapi.Service, error) {
 	entity, err := s.storage.ServiceRepository().FindByID(id)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get service by id")
 	}
-	if err := s.projects.checkAccess(entity.ProjectId, auth); err != nil {
-		return nil, err
+	if auth.DeployToken != nil {
+		err = checkDeployTokenAccess(auth.DeployToken, entity.ProjectId, id, permission)
+	} else {
+		err = s.projects.checkAccess(entity.ProjectId, auth, permission)
 	}
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get service env vars")
+		return nil, err
 	}
+	return serviceFromEntity(*entity)
+}
 
+func serviceFromEntity(entity storage.ServiceEntity) (*openapi.Service, error) {
 	envVars, err := mapEnvVarEntities(entity.EnvVars)
 	if err != nil {
 		return nil, err
 	}
-
 	return &openapi.Service{
 		Id:              &entity.Id,
 		Image:           entity.Image,
@@ -183,21 +232,46 @@ func (s servicesImpl) GetService(id int, auth middleware.Authentication) (*opena
 		PublicApiPrefix: fromNullString(entity.PublicApiPrefix),
 		StripApiPrefix:  &entity.StripApiPrefix,
 		Replicas:        entity.Replicas,
+		Stopped:         &entity.Stopped,
+		Command:         (*[]string)(&entity.Command),
 	}, nil
 }
 
 func (s servicesImpl) UpdateService(ctx context.Context, service openapi.Service, auth middleware.Authentication) (*openapi.Service, error) {
-	retrieved, err := s.GetService(*service.Id, auth)
+	permission := editResources
+	if auth.DeployToken != nil {
+		permission = updateServiceImages
+	}
+	retrieved, err := s.getService(*service.Id, auth, permission)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get service by id")
 	}
+	if auth.DeployToken != nil {
+		// deploy token can change only the image, other fields are taken from the stored service
+		image := service.Image
+		service = *retrieved
+		service.Image = image
+	}
 	if retrieved.Project != service.Project {
 		return nil, apperrors.BadRequest("Project field cannot be updated")
 	}
 	if retrieved.Name != service.Name {
 		return nil, apperrors.BadRequest("Name cannot be updated")
 	}
+	if err := s.quotas.checkService(service, retrieved); err != nil {
+		return nil, err
+	}
+	updated, err := s.saveService(ctx, service, *retrieved)
+	if err != nil {
+		return nil, err
+	}
+	recordAudit(s.storage, auth, auditEvent{project: updated.Project, resourceType: auditService, resourceId: updated.Name,
+		action: "update", summary: serviceChangesSummary(*retrieved, *updated)})
+	return updated, nil
+}
 
+// saveService stores the updated service and applies it to K8s, retrieved is the currently stored service
+func (s servicesImpl) saveService(ctx context.Context, service openapi.Service, retrieved openapi.Service) (*openapi.Service, error) {
 	envVars := mapItems(service.EnvVars, func(v openapi.EnvVar) storage.EnvVarEntity {
 		varEntity := storage.EnvVarEntity{
 			Name: v.Name,
@@ -223,8 +297,15 @@ func (s servicesImpl) UpdateService(ctx context.Context, service openapi.Service
 		StripApiPrefix:  stripApiPrefix,
 		EnvVars:         envVars,
 		Replicas:        service.Replicas,
+		Stopped:         *retrieved.Stopped,
+		Command:         fromPtr(service.Command),
 	}
-	err = s.storage.ExecTx(ctx, func(store *storage.Storage) error {
+	if updated.Command == nil {
+		updated.Command = storage.StringList{}
+	}
+	// stopped flag is changed only by StopService and StartService
+	service.Stopped = retrieved.Stopped
+	err := s.storage.ExecTx(ctx, func(store *storage.Storage) error {
 		err := store.ServiceRepository().Update(updated)
 		if err != nil {
 			return err
@@ -256,13 +337,15 @@ func (s servicesImpl) UpdateService(ctx context.Context, service openapi.Service
 		PublicApiPrefix: fromNullString(updated.PublicApiPrefix),
 		StripApiPrefix:  &updated.StripApiPrefix,
 		Replicas:        updated.Replicas,
+		Stopped:         &updated.Stopped,
+		Command:         (*[]string)(&updated.Command),
 	}
 	log.Infof("Updated service %s in project %s", service.Name, service.Project)
 	return &result, nil
 }
 
 func (s servicesImpl) DeleteService(ctx context.Context, id int, auth middleware.Authentication) error {
-	service, err := s.GetService(id, auth)
+	service, err := s.getService(id, auth, editResources)
 	if apperrors.IsNotFound(err) {
 		return nil
 	} else if err != nil {
@@ -282,6 +365,7 @@ func (s servicesImpl) DeleteService(ctx context.Context, id int, auth middleware
 	if err != nil {
 		return errors.Wrap(err, "failed to delete service")
 	}
+	recordAudit(s.storage, auth, auditEvent{project: service.Project, resourceType: auditService, resourceId: service.Name, action: "delete"})
 	log.Infof("Deleted service %s in project %s", service.Name, service.Project)
 	return nil
 }
@@ -291,6 +375,14 @@ func (s servicesImpl) GetServiceStatus(ctx context.Context, id int, auth middlew
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get service by id")
 	}
+	return s.getServiceStatus(ctx, *service)
+}
+
+func (s servicesImpl) getServiceStatus(ctx context.Context, service openapi.Service) (*openapi.ServiceStatus, error) {
+	id := *service.Id
+	if *service.Stopped {
+		return &openapi.ServiceStatus{Id: id, Status: openapi.Stopped}, nil
+	}
 
 	deploy, err := s.clientset.AppsV1().Deployments(service.Project).Get(ctx, service.Name, metav1.GetOptions{})
 	if err != nil {
@@ -344,12 +436,19 @@ func (s servicesImpl) GetServiceStatus(ctx context.Context, id int, auth middlew
 }
 
 func (s servicesImpl) RestartService(ctx context.Context, id int, auth middleware.Authentication) error {
-	service, err := s.GetService(id, auth)
+	service, err := s.getService(id, auth, restartServices)
 	if err != nil {
 		return errors.Wrap(err, "failed to get service by id")
 	}
+	if err := s.restartDeployment(ctx, service.Project, service.Name); err != nil {
+		return err
+	}
+	recordAudit(s.storage, auth, auditEvent{project: service.Project, resourceType: auditService, resourceId: service.Name, action: "restart"})
+	return nil
+}
 
-	deploy, err := s.clientset.AppsV1().Deployments(service.Project).Get(ctx, service.Name, metav1.GetOptions{})
+func (s servicesImpl) restartDeployment(ctx context.Context, project string, name string) error {
+	deploy, err := s.clientset.AppsV1().Deployments(project).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		return errors.Wrap(err, "failed to get service deployment")
 	}
@@ -358,13 +457,165 @@ func (s servicesImpl) RestartService(ctx context.Context, id int, auth middlewar
 		deploy.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
 	}
 	deploy.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
-	_, err = s.clientset.AppsV1().Deployments(service.Project).Update(ctx, deploy, metav1.UpdateOptions{FieldManager: "letsdeploy"})
+	_, err = s.clientset.AppsV1().Deployments(project).Update(ctx, deploy, metav1.UpdateOptions{FieldManager: "letsdeploy"})
 	if err != nil {
 		return errors.Wrap(err, "failed to update service deployment")
 	}
 	return nil
 }
 
+func (s servicesImpl) DeployService(
+	ctx context.Context,
+	id int,
+	deployment openapi.ServiceDeployment,
+	wait bool,
+	timeout time.Duration,
+	auth middleware.Authentication,
+) (*openapi.ServiceDeploymentResult, error) {
+	service, err := s.getService(id, auth, updateServiceImages)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get service by id")
+	}
+	if (deployment.Image == nil) == (deployment.Tag == nil) {
+		return nil, apperrors.BadRequest("Either image or tag should be provided")
+	}
+	// nothing would run the new image, so the rollout cannot succeed
+	if service.Stopped != nil && *service.Stopped {
+		return nil, apperrors.BadRequest(fmt.Sprintf("Service %s is stopped, start it before deploying", service.Name))
+	}
+	previousImage := service.Image
+	image := ""
+	if deployment.Image != nil {
+		image = *deployment.Image
+	} else {
+		image = imageWithTag(previousImage, *deployment.Tag)
+	}
+
+	if image == previousImage {
+		// image is always pulled, so restart deploys the new version of the same tag
+		err = s.restartDeployment(ctx, service.Project, service.Name)
+	} else {
+		updated := *service
+		updated.Image = image
+		service, err = s.saveService(ctx, updated, *service)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to deploy service")
+	}
+	recordAudit(s.storage, auth, auditEvent{project: service.Project, resourceType: auditService, resourceId: service.Name,
+		action: "deploy", summary: fmt.Sprintf("image %s -> %s", previousImage, image)})
+	log.Infof("Deploying image %s of service %s in project %s", image, service.Name, service.Project)
+
+	result := &openapi.ServiceDeploymentResult{
+		Id:            id,
+		Image:         image,
+		PreviousImage: previousImage,
+		Rollout:       openapi.Pending,
+	}
+	if !wait {
+		status, err := s.getServiceStatus(ctx, *service)
+		if err != nil {
+			return nil, err
+		}
+		result.Status = *status
+		return result, nil
+	}
+
+	status, err := s.waitForRollout(ctx, *service, timeout)
+	if err != nil {
+		return nil, err
+	}
+	result.Status = *status
+	switch status.Status {
+	case openapi.Available:
+		result.Rollout = openapi.Succeeded
+	case openapi.Unhealthy, openapi.Stopped:
+		// the service can be stopped during the rollout, e.g. by project hibernation
+		result.Rollout = openapi.Failed
+	default:
+		result.Rollout = openapi.TimedOut
+	}
+	data := deploymentFinishedData{
+		Service:       service.Name,
+		ServiceId:     id,
+		Image:         image,
+		PreviousImage: previousImage,
+		Rollout:       result.Rollout,
+		Status:        status.Status,
+	}
+	publishEvent(s.storage, service.Project, openapi.DeploymentFinished, data)
+	if status.Status == openapi.Unhealthy {
+		publishEvent(s.storage, service.Project, openapi.ServiceUnhealthy, data)
+	}
+	log.Infof("Rollout of image %s of service %s in project %s finished with result %s",
+		image, service.Name, service.Project, result.Rollout)
+	return result, nil
+}
+
+// waitForRollout polls the service status until it is not progressing anymore or the timeout expires,
+// the last status is returned in case of timeout
+func (s servicesImpl) waitForRollout(ctx context.Context, service openapi.Service, timeout time.Duration) (*openapi.ServiceStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+	for {
+		status, err := s.getServiceStatus(ctx, service)
+		if ctx.Err() != nil {
+			return &openapi.ServiceStatus{Id: *service.Id, Status: openapi.Progressing}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if status.Status != openapi.Progressing {
+			return status, nil
+		}
+		select {
+		case <-ctx.Done():
+			return status, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s servicesImpl) StopService(ctx context.Context, id int, auth middleware.Authentication) error {
+	return s.setStopped(ctx, id, true, auth)
+}
+
+func (s servicesImpl) StartService(ctx context.Context, id int, auth middleware.Authentication) error {
+	return s.setStopped(ctx, id, false, auth)
+}
+
+// setStopped stores the stopped flag and scales the deployment accordingly,
+// the flag is kept in the database so that syncKubernetes does not start the service again
+func (s servicesImpl) setStopped(ctx context.Context, id int, stopped bool, auth middleware.Authentication) error {
+	service, err := s.getService(id, auth, editResources)
+	if err != nil {
+		return errors.Wrap(err, "failed to get service by id")
+	}
+	if *service.Stopped == stopped {
+		return nil
+	}
+	service.Stopped = &stopped
+	err = s.storage.ExecTx(ctx, func(store *storage.Storage) error {
+		if err := store.ServiceRepository().SetStopped(id, stopped); err != nil {
+			return err
+		}
+		return s.applyServiceDeployment(ctx, *service)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to change service state")
+	}
+	if stopped {
+		recordAudit(s.storage, auth, auditEvent{project: service.Project, resourceType: auditService, resourceId: service.Name, action: "stop"})
+		log.Infof("Stopped service %s in project %s", service.Name, service.Project)
+	} else {
+		recordAudit(s.storage, auth, auditEvent{project: service.Project, resourceType: auditService, resourceId: service.Name, action: "start"})
+		log.Infof("Started service %s in project %s", service.Name, service.Project)
+	}
+	return nil
+}
+
 func (s servicesImpl) StreamServiceLogs(ctx context.Context, serviceId int, replica int, auth middleware.Authentication) (io.Reader, error) {
 	service, err := s.GetService(serviceId, auth)
 	if err != nil {
@@ -426,6 +677,22 @@ func (s servicesImpl) applyServiceDeployment(ctx context.Context, service openap
 		return err
 	}
 
+	deployment := deploymentConfig(service)
+	_, err := s.clientset.AppsV1().Deployments(service.Project).
+		Apply(ctx, deployment, metav1.ApplyOptions{FieldManager: "letsdeploy"})
+	if err != nil {
+		if err := s.deleteIngress(ctx, service.Project, service.Name); err != nil {
+			log.WithError(err).Errorln("Failed to delete ingress after deployment failure, skipping")
+		}
+		if err := s.deleteK8sService(ctx, service.Project, service.Name); err != nil {
+			log.WithError(err).Errorln("Failed to delete K8s service after deployment failure, skipping")
+		}
+		return errors.Wrap(err, "failed to create service deployment")
+	}
+	return nil
+}
+
+func deploymentConfig(service openapi.Service) *applyConfigsAppsV1.DeploymentApplyConfiguration {
 	limits := v1.ResourceList{}
 	limits.Cpu().SetMilli(250)
 	limits.Memory().SetScaled(512, resource.Mega)
@@ -435,6 +702,9 @@ func (s servicesImpl) applyServiceDeployment(ctx context.Context, service openap
 		WithImagePullPolicy(v1.PullAlways).
 		WithPorts(applyConfigsCoreV1.ContainerPort().WithContainerPort(int32(service.Port))).
 		WithResources(applyConfigsCoreV1.ResourceRequirements().WithLimits(limits))
+	if service.Command != nil && len(*service.Command) > 0 {
+		container = container.WithArgs(*service.Command...)
+	}
 	if service.EnvVars == nil {
 		service.EnvVars = []openapi.EnvVar{}
 	}
@@ -449,6 +719,10 @@ func (s servicesImpl) applyServiceDeployment(ctx context.Context, service openap
 				container = container.WithEnv(applyConfigsCoreV1.EnvVar().WithName(envVar.Name).WithValueFrom(source))
 			})
 	}
+	replicas := int32(service.Replicas)
+	if service.Stopped != nil && *service.Stopped {
+		replicas = 0
+	}
 	podTemplate := applyConfigsCoreV1.PodTemplateSpec().
 		WithLabels(map[string]string{"app": service.Name}).
 		WithSpec(applyConfigsCoreV1.PodSpec().
@@ -460,23 +734,26 @@ func (s servicesImpl) applyServiceDeployment(ctx context.Context, service openap
 			WithSelector(applyConfigsMetaV1.LabelSelector().
 				WithMatchLabels(map[string]string{"app": service.Name})).
 			WithTemplate(podTemplate).
-			WithReplicas(int32(service.Replicas)))
+			WithReplicas(replicas).
+			// ResourceQuota of the project has headroom for one surge pod per deployment
+			WithStrategy(applyConfigsAppsV1.DeploymentStrategy().
+				WithType(appsV1.RollingUpdateDeploymentStrategyType).
+				WithRollingUpdate(applyConfigsAppsV1.RollingUpdateDeployment().
+					WithMaxSurge(intstr.FromInt32(deploymentMaxSurge)))))
+
+	return deployment
+}
 
-	_, err := s.clientset.AppsV1().Deployments(service.Project).
-		Apply(ctx, deployment, metav1.ApplyOptions{FieldManager: "letsdeploy"})
+func (s servicesImpl) createK8sService(ctx context.Context, service openapi.Service) error {
+	svc := k8sServiceConfig(service)
+	_, err := s.clientset.CoreV1().Services(service.Project).Apply(ctx, svc, metav1.ApplyOptions{FieldManager: "letsdeploy"})
 	if err != nil {
-		if err := s.deleteIngress(ctx, service.Project, service.Name); err != nil {
-			log.WithError(err).Errorln("Failed to delete ingress after deployment failure, skipping")
-		}
-		if err := s.deleteK8sService(ctx, service.Project, service.Name); err != nil {
-			log.WithError(err).Errorln("Failed to delete K8s service after deployment failure, skipping")
-		}
-		return errors.Wrap(err, "failed to create service deployment")
+		return errors.Wrap(err, "failed to create K8s service for user service")
 	}
 	return nil
 }
 
-func (s servicesImpl) createK8sService(ctx context.Context, service openapi.Service) error {
+func k8sServiceConfig(service openapi.Service) *applyConfigsCoreV1.ServiceApplyConfiguration {
 	port := applyConfigsCoreV1.ServicePort().
 		WithPort(80).
 		WithTargetPort(intstr.FromInt32(int32(service.Port)))
@@ -488,11 +765,7 @@ func (s servicesImpl) createK8sService(ctx context.Context, service openapi.Serv
 		}).
 		WithSpec(applyConfigsCoreV1.ServiceSpec().WithPorts(port).
 			WithSelector(map[string]string{"app": service.Name}))
-	_, err := s.clientset.CoreV1().Services(service.Project).Apply(ctx, svc, metav1.ApplyOptions{FieldManager: "letsdeploy"})
-	if err != nil {
-		return errors.Wrap(err, "failed to create K8s service for user service")
-	}
-	return nil
+	return svc
 }
 
 func (s servicesImpl) createIngress(ctx context.Context, service openapi.Service) error {
@@ -520,6 +793,23 @@ func (s servicesImpl) createIngress(ctx context.Context, service openapi.Service
 		}
 	}
 
+	ingress, err := s.ingressConfig(service)
+	if err != nil {
+		return err
+	}
+	_, err = s.clientset.NetworkingV1().Ingresses(service.Project).Apply(ctx, ingress, metav1.ApplyOptions{FieldManager: "letsdeploy"})
+	if err != nil {
+		return errors.Wrap(err, "failed to create Ingress for service "+service.Name)
+	}
+	return nil
+}
+
+// ingressConfig builds Ingress of the service, the service should have public API prefix
+func (s servicesImpl) ingressConfig(service openapi.Service) (*applyConfigsNetworkingV1.IngressApplyConfiguration, error) {
+	host, err := projectHost(s.storage, service.Project)
+	if err != nil {
+		return nil, err
+	}
 	backend := applyConfigsNetworkingV1.IngressBackend().
 		WithService(applyConfigsNetworkingV1.IngressServiceBackend().
 			WithName(service.Name).
@@ -529,13 +819,13 @@ func (s servicesImpl) createIngress(ctx context.Context, service openapi.Service
 		WithPath(*service.PublicApiPrefix).
 		WithBackend(backend)
 	rule := applyConfigsNetworkingV1.IngressRule().
-		WithHost(service.Project + ".letsdeploy.space").
+		WithHost(host).
 		WithHTTP(applyConfigsNetworkingV1.HTTPIngressRuleValue().WithPaths(path))
 	tls := make([]*applyConfigsNetworkingV1.IngressTLSApplyConfiguration, 0)
 	if s.cfg.GetBool("tls.enabled") {
 		log.Debugf("TLS enabled, adding Ingress TLS config")
 		tls = append(tls, applyConfigsNetworkingV1.IngressTLS().
-			WithHosts(service.Project+".letsdeploy.space").
+			WithHosts(host).
 			WithSecretName(getTlsSecretName(service.Project)),
 		)
 	}
@@ -557,11 +847,7 @@ func (s servicesImpl) createIngress(ctx context.Context, service openapi.Service
 		middlewareRef := fmt.Sprintf("%s-%s-strip-prefix@kubernetescrd", service.Project, service.Name)
 		ingress.Annotations["traefik.ingress.kubernetes.io/router.middlewares"] = middlewareRef
 	}
-	_, err := s.clientset.NetworkingV1().Ingresses(service.Project).Apply(ctx, ingress, metav1.ApplyOptions{FieldManager: "letsdeploy"})
-	if err != nil {
-		return errors.Wrap(err, "failed to create Ingress for service "+service.Name)
-	}
-	return nil
+	return ingress, nil
 }
 
 func (s servicesImpl) createStripPrefixMiddleware(ctx context.Context, service openapi.Service) error {
@@ -573,27 +859,10 @@ func (s servicesImpl) createStripPrefixMiddleware(ctx context.Context, service o
 		return nil
 	}
 
-	middlewareName := service.Name + "-strip-prefix"
-	stripPrefixMiddleware := traefikCrd.Middleware{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: traefikCrd.SchemeGroupVersion.Identifier(),
-			Kind:       "Middleware",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      middlewareName,
-			Namespace: service.Project,
-			Labels: map[string]string{
-				"letsdeploy.space/managed": "true",
-			},
-		},
-		Spec: traefikCrd.MiddlewareSpec{
-			StripPrefix: &dynamic.StripPrefix{
-				Prefixes: []string{*service.PublicApiPrefix},
-			},
-		},
-	}
+	stripPrefixMiddleware := stripPrefixMiddlewareConfig(service)
+	middlewareName := stripPrefixMiddleware.Name
 
-	_, err := s.traefikClient.Middlewares(service.Project).Create(ctx, &stripPrefixMiddleware, metav1.CreateOptions{FieldManager: "letsdeploy"})
+	_, err := s.traefikClient.Middlewares(service.Project).Create(ctx, stripPrefixMiddleware, metav1.CreateOptions{FieldManager: "letsdeploy"})
 	if err == nil {
 		log.Debugf("Created strip prefix middleware %s in namespace %s", middlewareName, service.Project)
 		return nil
@@ -615,7 +884,7 @@ func (s servicesImpl) createStripPrefixMiddleware(ctx context.Context, service o
 	}
 
 	stripPrefixMiddleware.ResourceVersion = mw.ResourceVersion
-	_, err = s.traefikClient.Middlewares(service.Project).Update(ctx, &stripPrefixMiddleware, metav1.UpdateOptions{FieldManager: "letsdeploy"})
+	_, err = s.traefikClient.Middlewares(service.Project).Update(ctx, stripPrefixMiddleware, metav1.UpdateOptions{FieldManager: "letsdeploy"})
 	if err != nil {
 		return errors.Wrapf(err, "failed to create/update strip prefix middleware for service %s", service.Name)
 	}
@@ -623,6 +892,28 @@ func (s servicesImpl) createStripPrefixMiddleware(ctx context.Context, service o
 	return nil
 }
 
+// stripPrefixMiddlewareConfig builds Traefik middleware of the service, the service should have public API prefix
+func stripPrefixMiddlewareConfig(service openapi.Service) *traefikCrd.Middleware {
+	return &traefikCrd.Middleware{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: traefikCrd.SchemeGroupVersion.Identifier(),
+			Kind:       "Middleware",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service.Name + "-strip-prefix",
+			Namespace: service.Project,
+			Labels: map[string]string{
+				"letsdeploy.space/managed": "true",
+			},
+		},
+		Spec: traefikCrd.MiddlewareSpec{
+			StripPrefix: &dynamic.StripPrefix{
+				Prefixes: []string{*service.PublicApiPrefix},
+			},
+		},
+	}
+}
+
 func (s servicesImpl) deleteStripPrefixMiddleware(ctx context.Context, project string, service string) error {
 	middlewareName := service + "-strip-prefix"
 	err := s.traefikClient.Middlewares(project).Delete(ctx, middlewareName, metav1.DeleteOptions{})
@@ -669,6 +960,29 @@ func (s servicesImpl) deleteIngress(ctx context.Context, project string, service
 	return nil
 }
 
+func (s servicesImpl) desiredObjects(projectId string) ([]any, error) {
+	services, err := s.GetProjectServices(projectId, middleware.ServiceAccount)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project services")
+	}
+	var objects []any
+	for _, service := range services {
+		objects = append(objects, k8sServiceConfig(service))
+		if service.PublicApiPrefix != nil {
+			ingress, err := s.ingressConfig(service)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, ingress)
+		}
+		if service.PublicApiPrefix != nil && service.StripApiPrefix != nil && *service.StripApiPrefix {
+			objects = append(objects, stripPrefixMiddlewareConfig(service))
+		}
+		objects = append(objects, deploymentConfig(service))
+	}
+	return objects, nil
+}
+
 func (s servicesImpl) syncKubernetes(ctx context.Context, projectId string) error {
 	services, err := s.GetProjectServices(projectId, middleware.ServiceAccount)
 	if err != nil {
@@ -750,6 +1064,65 @@ func (s servicesImpl) syncKubernetes(ctx context.Context, projectId string) erro
 	return nil
 }
 
+// serviceChangesSummary lists changed fields of the service, env var values are omitted as they may be sensitive
+func serviceChangesSummary(before openapi.Service, after openapi.Service) string {
+	var changes []string
+	if before.Image != after.Image {
+		changes = append(changes, fmt.Sprintf("image %s -> %s", before.Image, after.Image))
+	}
+	if before.Port != after.Port {
+		changes = append(changes, fmt.Sprintf("port %d -> %d", before.Port, after.Port))
+	}
+	if before.Replicas != after.Replicas {
+		changes = append(changes, fmt.Sprintf("replicas %d -> %d", before.Replicas, after.Replicas))
+	}
+	beforePrefix, afterPrefix := fromPtr(before.PublicApiPrefix), fromPtr(after.PublicApiPrefix)
+	if beforePrefix != afterPrefix {
+		changes = append(changes, fmt.Sprintf("public API prefix %q -> %q", beforePrefix, afterPrefix))
+	}
+	if !slices.Equal(fromPtr(before.Command), fromPtr(after.Command)) {
+		changes = append(changes, fmt.Sprintf("command %q -> %q", fromPtr(before.Command), fromPtr(after.Command)))
+	}
+	if fromPtr(before.StripApiPrefix) != fromPtr(after.StripApiPrefix) {
+		changes = append(changes, fmt.Sprintf("strip API prefix %t -> %t", fromPtr(before.StripApiPrefix), fromPtr(after.StripApiPrefix)))
+	}
+	beforeVars := toMapSelf(before.EnvVars, func(v openapi.EnvVar) string { return v.Name })
+	afterVars := toMapSelf(after.EnvVars, func(v openapi.EnvVar) string { return v.Name })
+	for _, v := range after.EnvVars {
+		if old, found := beforeVars[v.Name]; !found {
+			changes = append(changes, "added env var "+v.Name)
+		} else if envVarSource(old) != envVarSource(v) {
+			changes = append(changes, "changed env var "+v.Name)
+		}
+	}
+	for _, v := range before.EnvVars {
+		if _, found := afterVars[v.Name]; !found {
+			changes = append(changes, "removed env var "+v.Name)
+		}
+	}
+	return strings.Join(changes, "; ")
+}
+
+// envVarSource returns the value or the secret name of the env var
+func envVarSource(envVar openapi.EnvVar) string {
+	source := ""
+	processEnvVar(envVar,
+		func(e openapi.EnvVar0) { source = "value:" + e.Value },
+		func(e openapi.EnvVar1) { source = "secret:" + e.Secret })
+	return source
+}
+
+// imageWithTag replaces the tag or digest of the image reference with the new tag
+func imageWithTag(image string, tag string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image + ":" + tag
+}
+
 func processEnvVar(envVar openapi.EnvVar, onValue func(openapi.EnvVar0), onSecret func(openapi.EnvVar1)) {
 	withValue, _ := envVar.AsEnvVar0()
 	if withValue.Value != "" {
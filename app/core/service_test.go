@@ -0,0 +1,58 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestImageWithTag(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+		tag   string
+		want  string
+	}{
+		{
+			name:  "NoTag",
+			image: "nginx",
+			tag:   "1.27",
+			want:  "nginx:1.27",
+		},
+		{
+			name:  "Tag",
+			image: "ghcr.io/org/app:v1",
+			tag:   "v2",
+			want:  "ghcr.io/org/app:v2",
+		},
+		{
+			name:  "RegistryWithPort",
+			image: "registry.local:5000/app",
+			tag:   "v2",
+			want:  "registry.local:5000/app:v2",
+		},
+		{
+			name:  "RegistryWithPortAndTag",
+			image: "registry.local:5000/app:v1",
+			tag:   "v2",
+			want:  "registry.local:5000/app:v2",
+		},
+		{
+			name:  "Digest",
+			image: "ghcr.io/org/app@sha256:abcdef",
+			tag:   "v2",
+			want:  "ghcr.io/org/app:v2",
+		},
+		{
+			name:  "TagAndDigest",
+			image: "ghcr.io/org/app:v1@sha256:abcdef",
+			tag:   "v2",
+			want:  "ghcr.io/org/app:v2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := imageWithTag(tt.image, tt.tag); got != tt.want {
+				t.Errorf("imageWithTag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
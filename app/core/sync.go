@@ -3,54 +3,334 @@ package core
 import (
 	"codnect.io/chrono"
 	"context"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 	"time"
 )
 
-func InitSync(core *Core, scheduler chrono.TaskScheduler) {
-	_, err := scheduler.ScheduleWithFixedDelay(sync(core), 1*time.Minute)
+const (
+	managedLabelSelector = "letsdeploy.space/managed=true"
+	// syncChannel is the Redis channel used to forward enqueued projects to the leader
+	syncChannel = "letsdeploy:sync"
+)
+
+// Sync reconciles K8s objects of projects with the database.
+// Project is enqueued when its managed K8s objects are changed or deleted outside of letsdeploy
+// and after database changes, the full resync of all projects runs periodically as a safety net.
+// Only the leader replica reconciles projects, other replicas forward enqueued projects to it
+type Sync interface {
+	// Enqueue schedules reconciliation of the project, repeated requests are merged until the project is reconciled
+	Enqueue(projectId string)
+	GetProjectSyncStatus(projectId string, auth middleware.Authentication) (*openapi.ProjectSyncStatus, error)
+	// SyncProject enqueues the project for immediate sync
+	SyncProject(projectId string, auth middleware.Authentication) error
+	// start watches K8s objects and reconciles projects, it is called when all core components are initialized
+	start(core *Core)
+}
+
+type syncImpl struct {
+	core       *Core
+	storage    *storage.Storage
+	rdb        *redis.Client
+	clientset  *kubernetes.Clientset
+	leadership Leadership
+	scheduler  chrono.TaskScheduler
+	cfg        *viper.Viper
+	queue      workqueue.RateLimitingInterface
+}
+
+var _ Sync = (*syncImpl)(nil)
+
+func InitSync(
+	storage *storage.Storage,
+	rdb *redis.Client,
+	clientset *kubernetes.Clientset,
+	leadership Leadership,
+	scheduler chrono.TaskScheduler,
+	cfg *viper.Viper,
+) Sync {
+	cfg.SetDefault("sync.resync-interval", 30*time.Minute)
+	cfg.SetDefault("sync.workers", 4)
+	cfg.SetDefault("sync.project-timeout", 2*time.Minute)
+	return &syncImpl{
+		storage:    storage,
+		rdb:        rdb,
+		clientset:  clientset,
+		leadership: leadership,
+		scheduler:  scheduler,
+		cfg:        cfg,
+		queue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+}
+
+func (s *syncImpl) start(core *Core) {
+	s.core = core
+	stop := make(chan struct{})
+	managedInformers := informers.NewSharedInformerFactoryWithOptions(s.clientset, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) { options.LabelSelector = managedLabelSelector }))
+	namespaceInformers := informers.NewSharedInformerFactoryWithOptions(s.clientset, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) { options.LabelSelector = namespaceLabel + "=true" }))
+	handler := cache.ResourceEventHandlerFuncs{UpdateFunc: s.onUpdate, DeleteFunc: s.onDelete}
+	for _, informer := range []cache.SharedIndexInformer{
+		managedInformers.Apps().V1().Deployments().Informer(),
+		managedInformers.Apps().V1().StatefulSets().Informer(),
+		managedInformers.Core().V1().Services().Informer(),
+		managedInformers.Core().V1().Secrets().Informer(),
+		managedInformers.Networking().V1().Ingresses().Informer(),
+		namespaceInformers.Core().V1().Namespaces().Informer(),
+	} {
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			log.WithError(err).Panicln("Unable to watch managed K8s objects")
+		}
+	}
+	managedInformers.Start(stop)
+	namespaceInformers.Start(stop)
+
+	workers := max(s.cfg.GetInt("sync.workers"), 1)
+	timeout := s.cfg.GetDuration("sync.project-timeout")
+	for range workers {
+		go s.run(context.Background(), timeout)
+	}
+	go s.receiveForwarded(context.Background())
+	// projects enqueued while another replica was the leader may be missed
+	s.leadership.onElected(func() { s.resyncAll(context.Background()) })
+
+	_, err := s.scheduler.ScheduleWithFixedDelay(s.resyncAll, s.cfg.GetDuration("sync.resync-interval"))
 	if err != nil {
 		log.WithError(err).Panicln("Unable to schedule k8s synchronization")
 	}
 }
 
-func sync(core *Core) chrono.Task {
-	return func(ctx context.Context) {
-		log.Infoln("Kubernetes sync started")
-
-		limit := 1000
-		offset := 0
-		checkedProjects := make(map[string]bool)
-		for {
-			projects, err := core.Projects.FindAll(limit, offset)
-			if err != nil {
-				log.WithError(err).Errorln("Failed to retrieve projects")
-				return
-			}
-			for _, project := range projects {
-				checkedProjects[project.Id] = true
-
-				if err := core.Projects.syncKubernetes(ctx, project.Id); err != nil {
-					log.WithError(err).Errorf("Project %s sync failed, skipping", project.Id)
-					continue
-				}
-				if err := core.Registries.syncKubernetes(ctx, project.Id); err != nil {
-					log.WithError(err).Errorf("Project %s registries sync failed", project.Id)
-				}
-				if err := core.Services.syncKubernetes(ctx, project.Id); err != nil {
-					log.WithError(err).Errorf("Project %s services sync failed", project.Id)
-				}
-				if err := core.ManagedServices.syncKubernetes(ctx, project.Id); err != nil {
-					log.WithError(err).Errorf("Project %s managed services sync failed", project.Id)
-				}
-			}
-			if len(projects) < limit {
-				break
-			}
+func (s syncImpl) Enqueue(projectId string) {
+	if s.leadership.IsLeader() {
+		s.queue.Add(projectId)
+		return
+	}
+	if err := s.rdb.Publish(context.Background(), syncChannel, projectId).Err(); err != nil {
+		log.WithError(err).Errorf("Failed to forward project %s sync to the leader", projectId)
+	}
+}
+
+// receiveForwarded enqueues projects forwarded by other replicas while the replica is the leader
+func (s syncImpl) receiveForwarded(ctx context.Context) {
+	subscription := s.rdb.Subscribe(ctx, syncChannel)
+	defer subscription.Close()
+	for msg := range subscription.Channel() {
+		if s.leadership.IsLeader() {
+			s.queue.Add(msg.Payload)
 		}
+	}
+}
+
+// onUpdate ignores changes of the object status, so that rollouts do not cause reconciliation
+func (s syncImpl) onUpdate(oldObj interface{}, newObj interface{}) {
+	oldMeta, err := meta.Accessor(oldObj)
+	if err != nil {
+		return
+	}
+	newMeta, err := meta.Accessor(newObj)
+	if err != nil {
+		return
+	}
+	if newMeta.GetGeneration() != 0 && newMeta.GetGeneration() == oldMeta.GetGeneration() {
+		return
+	}
+	if newMeta.GetResourceVersion() == oldMeta.GetResourceVersion() {
+		return
+	}
+	s.enqueueObject(newMeta)
+}
+
+func (s syncImpl) onDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	s.enqueueObject(objMeta)
+}
+
+// enqueueObject enqueues the project the object belongs to, projects are named after their namespaces.
+// Every replica watches the objects, so the changes are handled only by the leader
+func (s syncImpl) enqueueObject(obj metav1.Object) {
+	if !s.leadership.IsLeader() {
+		return
+	}
+	projectId := obj.GetNamespace()
+	if projectId == "" {
+		projectId = obj.GetName()
+	}
+	log.Debugf("Managed object %s/%s changed, project %s is enqueued", obj.GetNamespace(), obj.GetName(), projectId)
+	s.Enqueue(projectId)
+}
+
+func (s syncImpl) GetProjectSyncStatus(projectId string, auth middleware.Authentication) (*openapi.ProjectSyncStatus, error) {
+	if err := s.core.Projects.checkAccess(projectId, auth, viewProject); err != nil {
+		return nil, err
+	}
+	entity, err := s.storage.ProjectSyncStatusRepository().FindByProjectId(projectId)
+	if apperrors.IsNotFound(err) {
+		return &openapi.ProjectSyncStatus{}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get project sync status")
+	}
+	outcome := openapi.ProjectSyncStatusOutcome(entity.Outcome)
+	status := &openapi.ProjectSyncStatus{
+		StartedAt:  &entity.StartedAt,
+		DurationMs: &entity.DurationMs,
+		Outcome:    &outcome,
+		Error:      fromNullString(entity.Error),
+	}
+	if entity.LastSuccessAt.Valid {
+		status.LastSuccessAt = &entity.LastSuccessAt.Time
+	}
+	return status, nil
+}
+
+func (s syncImpl) SyncProject(projectId string, auth middleware.Authentication) error {
+	if err := s.core.Projects.checkAccess(projectId, auth, editResources); err != nil {
+		return err
+	}
+	s.Enqueue(projectId)
+	recordAudit(s.storage, auth, auditEvent{project: projectId, resourceType: auditProject, resourceId: projectId, action: "sync"})
+	log.Infof("Project %s sync is requested by %s", projectId, auth.Username)
+	return nil
+}
+
+// run processes enqueued projects until the queue is shut down, several workers run concurrently,
+// the queue guarantees that the same project is not processed by several workers at the same time
+func (s syncImpl) run(ctx context.Context, timeout time.Duration) {
+	for s.processNext(ctx, timeout) {
+	}
+}
+
+func (s syncImpl) processNext(ctx context.Context, timeout time.Duration) bool {
+	item, shutdown := s.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer s.queue.Done(item)
+	projectId := item.(string)
+	if !s.leadership.IsLeader() {
+		log.Debugf("Replica is not the leader anymore, skipping project %s sync", projectId)
+		s.queue.Forget(item)
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	started := time.Now()
+	err := s.syncProject(ctx, projectId)
+	if errors.Is(err, errProjectNotFound) {
+		s.queue.Forget(item)
+		return true
+	}
+	s.saveStatus(projectId, started, err)
+	if err != nil {
+		log.WithError(err).Errorf("Project %s sync failed, retrying", projectId)
+		s.queue.AddRateLimited(item)
+		return true
+	}
+	s.queue.Forget(item)
+	return true
+}
+
+func (s syncImpl) saveStatus(projectId string, started time.Time, syncErr error) {
+	status := storage.ProjectSyncStatusEntity{
+		ProjectId:  projectId,
+		StartedAt:  started,
+		DurationMs: time.Since(started).Milliseconds(),
+		Outcome:    storage.SyncSucceeded,
+	}
+	if syncErr != nil {
+		status.Outcome = storage.SyncFailed
+		msg := syncErr.Error()
+		status.Error = toNullString(&msg)
+	}
+	if err := s.storage.ProjectSyncStatusRepository().Save(status); err != nil {
+		log.WithError(err).Errorf("Failed to save project %s sync status", projectId)
+	}
+}
+
+// errProjectNotFound is returned for projects deleted before they are synced, such projects are not retried
+var errProjectNotFound = errors.New("project not found")
+
+func (s syncImpl) syncProject(ctx context.Context, projectId string) error {
+	exists, err := s.storage.ProjectRepository().ExistsByID(projectId)
+	if err != nil {
+		return errors.Wrap(err, "failed to check if project exists")
+	}
+	if !exists {
+		// namespaces of deleted projects are removed by the full resync
+		log.Debugf("Project %s does not exist, skipping sync", projectId)
+		return errProjectNotFound
+	}
+
+	log.Debugf("Project %s sync started", projectId)
+	if err := s.core.Projects.syncKubernetes(ctx, projectId); err != nil {
+		return err
+	}
+	var failed []string
+	if err := s.core.Registries.syncKubernetes(ctx, projectId); err != nil {
+		log.WithError(err).Errorf("Project %s registries sync failed", projectId)
+		failed = append(failed, "registries")
+	}
+	if err := s.core.Services.syncKubernetes(ctx, projectId); err != nil {
+		log.WithError(err).Errorf("Project %s services sync failed", projectId)
+		failed = append(failed, "services")
+	}
+	if err := s.core.ManagedServices.syncKubernetes(ctx, projectId); err != nil {
+		log.WithError(err).Errorf("Project %s managed services sync failed", projectId)
+		failed = append(failed, "managed services")
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to sync %v", failed)
+	}
+	log.Debugf("Project %s sync finished", projectId)
+	return nil
+}
 
-		core.Projects.(*projectsImpl).removeExcessNamespaces(ctx, checkedProjects) // TODO: 09.11.22 refactor
+// resyncAll enqueues all projects and removes namespaces of deleted projects
+func (s syncImpl) resyncAll(ctx context.Context) {
+	if !s.leadership.IsLeader() {
+		return
+	}
+	log.Infoln("Kubernetes full resync started")
 
-		log.Infoln("Kubernetes sync finished")
+	limit := 1000
+	offset := 0
+	checkedProjects := make(map[string]bool)
+	for {
+		projects, err := s.core.Projects.FindAll(limit, offset)
+		if err != nil {
+			log.WithError(err).Errorln("Failed to retrieve projects")
+			return
+		}
+		for _, project := range projects {
+			checkedProjects[project.Id] = true
+			s.Enqueue(project.Id)
+		}
+		if len(projects) < limit {
+			break
+		}
+		offset += limit
 	}
+
+	s.core.Projects.(*projectsImpl).removeExcessNamespaces(ctx, checkedProjects) // TODO: 09.11.22 refactor
+
+	log.Infof("Kubernetes full resync finished, %d projects enqueued", len(checkedProjects))
 }
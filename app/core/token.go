@@ -28,6 +28,13 @@ func InitTokens(rdb *redis.Client) Tokens {
 }
 
 func (t tokensImpl) CreateTempToken(ctx context.Context, auth middleware.Authentication) (string, error) {
+	// temp token is not bound to the scope, so it would give access to logs of all user's projects
+	if auth.Scope.ProjectScoped() {
+		return "", apperrors.Forbidden("Temporary token cannot be created with a project scoped API key")
+	}
+	if auth.DeployToken != nil {
+		return "", apperrors.Forbidden("Temporary token cannot be created with a deploy token")
+	}
 	letters := []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 	tokenLen := 16
 	b := make([]rune, tokenLen)
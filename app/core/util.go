@@ -1,6 +1,13 @@
 package core
 
-import "database/sql"
+import (
+	"crypto/rand"
+	"database/sql"
+	"math/big"
+)
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+const lowercaseAlphanumeric = "abcdefghijklmnopqrstuvwxyz0123456789"
 
 func toMap[T any, K comparable, V any](items []T, keyExtractor func(T) K, valueExtractor func(T) V) map[K]V {
 	m := make(map[K]V)
@@ -58,6 +65,15 @@ func fromNullString(s sql.NullString) *string {
 	}
 }
 
+// fromPtr returns the value of the pointer or the zero value if the pointer is nil
+func fromPtr[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
+
 func toNullString(s *string) sql.NullString {
 	if s != nil {
 		return sql.NullString{String: *s, Valid: true}
@@ -65,3 +81,18 @@ func toNullString(s *string) sql.NullString {
 		return sql.NullString{Valid: false}
 	}
 }
+
+// randomString generates a string of the given length from the alphabet using crypto/rand
+func randomString(alphabet string, length int) (string, error) {
+	letters := []rune(alphabet)
+	max := big.NewInt(int64(len(letters)))
+	b := make([]rune, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = letters[n.Int64()]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,461 @@
+package core
+
+import (
+	"bytes"
+	"codnect.io/chrono"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/app/storage"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookSecretLength      = 32
+	webhookDeliveryInterval  = 5 * time.Second
+	webhookDeliveryBatchSize = 50
+	webhookRequestTimeout    = 10 * time.Second
+	// webhookDeliveryLease postpones claimed deliveries, so that they are retried if the instance stops while sending
+	webhookDeliveryLease = time.Minute
+	// webhookBatchDeadline limits sending of the whole claimed batch,
+	// it is shorter than the lease so that deliveries in flight are not claimed again
+	webhookBatchDeadline  = webhookDeliveryLease / 2
+	webhookMaxAttempts    = 8
+	webhookInitialBackoff = 10 * time.Second
+	webhookMaxBackoff     = time.Hour
+	webhookResponseLimit  = 64 * 1024
+)
+
+// webhookPayload is the JSON body sent to webhooks
+type webhookPayload struct {
+	Event     openapi.WebhookEventType `json:"event"`
+	Project   string                   `json:"project"`
+	CreatedAt time.Time                `json:"createdAt"`
+	Data      any                      `json:"data"`
+}
+
+// deploymentFinishedData is the data of deployment.finished and service.unhealthy events,
+// rollout is empty when the service becomes unhealthy outside of deployment
+type deploymentFinishedData struct {
+	Service       string                                 `json:"service"`
+	ServiceId     int                                    `json:"serviceId"`
+	Image         string                                 `json:"image"`
+	PreviousImage string                                 `json:"previousImage,omitempty"`
+	Rollout       openapi.ServiceDeploymentResultRollout `json:"rollout,omitempty"`
+	Status        openapi.ServiceStatusStatus            `json:"status"`
+}
+
+// memberChangedData is the data of member.changed event
+type memberChangedData struct {
+	Username string `json:"username"`
+	// Change is one of added, joined, role-changed, removed, transferred
+	Change string              `json:"change"`
+	Role   openapi.ProjectRole `json:"role,omitempty"`
+	Actor  string              `json:"actor"`
+}
+
+// Webhooks manages webhook subscriptions of projects. Events are put to the persistent delivery queue
+// and sent in background with retries, the queue also serves as the delivery log
+type Webhooks interface {
+	GetWebhooks(projectId string, auth middleware.Authentication) ([]openapi.Webhook, error)
+	CreateWebhook(ctx context.Context, projectId string, webhook openapi.Webhook, auth middleware.Authentication) (*openapi.Webhook, error)
+	UpdateWebhook(ctx context.Context, projectId string, id int, webhook openapi.Webhook, auth middleware.Authentication) (*openapi.Webhook, error)
+	DeleteWebhook(ctx context.Context, projectId string, id int, auth middleware.Authentication) error
+	GetWebhookDeliveries(projectId string, id int, limit int, offset int, auth middleware.Authentication) ([]openapi.WebhookDelivery, error)
+}
+
+type webhooksImpl struct {
+	projects Projects
+	storage  *storage.Storage
+	client   *http.Client
+}
+
+var _ Webhooks = (*webhooksImpl)(nil)
+
+func InitWebhooks(projects Projects, storage *storage.Storage, scheduler chrono.TaskScheduler) Webhooks {
+	w := &webhooksImpl{
+		projects: projects,
+		storage:  storage,
+		client: &http.Client{
+			Timeout: webhookRequestTimeout,
+			// no proxy, so that the address check of the dialer applies to the webhook host itself
+			Transport: &http.Transport{
+				DialContext:         (&net.Dialer{Timeout: webhookRequestTimeout, Control: checkWebhookAddress}).DialContext,
+				TLSHandshakeTimeout: webhookRequestTimeout,
+			},
+		},
+	}
+	_, err := scheduler.ScheduleWithFixedDelay(w.deliverPending, webhookDeliveryInterval)
+	if err != nil {
+		log.WithError(err).Panicln("Unable to schedule webhook deliveries")
+	}
+	return w
+}
+
+func (w webhooksImpl) GetWebhooks(projectId string, auth middleware.Authentication) ([]openapi.Webhook, error) {
+	if err := w.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	entities, err := w.storage.WebhookRepository().FindByProjectId(projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project webhooks")
+	}
+	return mapItems(entities, webhookFromEntity), nil
+}
+
+func (w webhooksImpl) CreateWebhook(
+	ctx context.Context,
+	projectId string,
+	webhook openapi.Webhook,
+	auth middleware.Authentication,
+) (*openapi.Webhook, error) {
+	if err := w.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	if err := validateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	secret := fromPtr(webhook.Secret)
+	if secret == "" {
+		var err error
+		secret, err = randomString(alphanumeric, webhookSecretLength)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate webhook secret")
+		}
+	}
+	entity := storage.WebhookEntity{
+		ProjectId:  projectId,
+		Url:        webhook.Url,
+		EventTypes: webhookEventTypes(webhook.EventTypes),
+		Secret:     secret,
+		Enabled:    webhook.Enabled == nil || *webhook.Enabled,
+		CreatedBy:  auth.Username,
+		CreatedAt:  time.Now(),
+	}
+	id, err := w.storage.WebhookRepository().CreateNew(entity)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create webhook")
+	}
+	entity.Id = id
+	recordAudit(w.storage, auth, auditEvent{project: projectId, resourceType: auditWebhook, resourceId: strconv.Itoa(id),
+		action: "create", summary: fmt.Sprintf("%s, events %v", entity.Url, entity.EventTypes)})
+	log.Infof("Created webhook %d in project %s", id, projectId)
+	result := webhookFromEntity(entity)
+	result.Secret = &secret
+	return &result, nil
+}
+
+func (w webhooksImpl) UpdateWebhook(
+	ctx context.Context,
+	projectId string,
+	id int,
+	webhook openapi.Webhook,
+	auth middleware.Authentication,
+) (*openapi.Webhook, error) {
+	if err := w.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	if err := validateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	entity, err := w.getWebhook(projectId, id)
+	if err != nil {
+		return nil, err
+	}
+	entity.Url = webhook.Url
+	entity.EventTypes = webhookEventTypes(webhook.EventTypes)
+	if webhook.Enabled != nil {
+		entity.Enabled = *webhook.Enabled
+	}
+	if err := w.storage.WebhookRepository().Update(*entity); err != nil {
+		return nil, errors.Wrap(err, "failed to update webhook")
+	}
+	recordAudit(w.storage, auth, auditEvent{project: projectId, resourceType: auditWebhook, resourceId: strconv.Itoa(id),
+		action: "update", summary: fmt.Sprintf("%s, events %v, enabled: %t", entity.Url, entity.EventTypes, entity.Enabled)})
+	log.Infof("Updated webhook %d in project %s", id, projectId)
+	result := webhookFromEntity(*entity)
+	return &result, nil
+}
+
+func (w webhooksImpl) DeleteWebhook(ctx context.Context, projectId string, id int, auth middleware.Authentication) error {
+	if err := w.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return err
+	}
+	if err := w.storage.WebhookRepository().Delete(projectId, id); err != nil {
+		return errors.Wrap(err, "failed to delete webhook")
+	}
+	recordAudit(w.storage, auth, auditEvent{project: projectId, resourceType: auditWebhook, resourceId: strconv.Itoa(id), action: "delete"})
+	log.Infof("Deleted webhook %d in project %s", id, projectId)
+	return nil
+}
+
+func (w webhooksImpl) GetWebhookDeliveries(
+	projectId string,
+	id int,
+	limit int,
+	offset int,
+	auth middleware.Authentication,
+) ([]openapi.WebhookDelivery, error) {
+	if err := w.projects.checkAccess(projectId, auth, manageProject); err != nil {
+		return nil, err
+	}
+	if _, err := w.getWebhook(projectId, id); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	entities, err := w.storage.WebhookDeliveryRepository().FindByWebhookId(id, limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get webhook deliveries")
+	}
+	deliveries := make([]openapi.WebhookDelivery, len(entities))
+	for i, entity := range entities {
+		deliveries[i], err = webhookDeliveryFromEntity(entity)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return deliveries, nil
+}
+
+// getWebhook returns NotFound error if the webhook does not belong to the project
+func (w webhooksImpl) getWebhook(projectId string, id int) (*storage.WebhookEntity, error) {
+	entity, err := w.storage.WebhookRepository().FindByID(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get webhook")
+	}
+	if entity.ProjectId != projectId {
+		return nil, apperrors.NotFound(fmt.Sprintf("Webhook %d not found", id))
+	}
+	return entity, nil
+}
+
+// deliverPending concurrently sends the deliveries which next attempt time has come.
+// Sending is limited by webhookBatchDeadline, so all attempts are saved before the claim lease expires
+func (w webhooksImpl) deliverPending(ctx context.Context) {
+	deliveries, err := w.storage.WebhookDeliveryRepository().ClaimDue(webhookDeliveryBatchSize, webhookDeliveryLease)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to get pending webhook deliveries")
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookBatchDeadline)
+	defer cancel()
+	webhooks := make(map[int]*storage.WebhookEntity)
+	wg := sync.WaitGroup{}
+	for _, delivery := range deliveries {
+		webhook, found := webhooks[delivery.WebhookId]
+		if !found {
+			webhook, err = w.storage.WebhookRepository().FindByID(delivery.WebhookId)
+			if err != nil {
+				log.WithError(err).Errorf("Failed to get webhook %d, skipping delivery %d", delivery.WebhookId, delivery.Id)
+				continue
+			}
+			webhooks[delivery.WebhookId] = webhook
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.deliver(ctx, *webhook, delivery)
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver makes the delivery attempt and schedules the next one with exponential backoff if the attempt fails
+func (w webhooksImpl) deliver(ctx context.Context, webhook storage.WebhookEntity, delivery storage.WebhookDeliveryEntity) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = sql.NullTime{Time: now, Valid: true}
+	status, err := w.send(ctx, webhook, delivery)
+	delivery.ResponseStatus = sql.NullInt32{Int32: int32(status), Valid: status != 0}
+	if err == nil {
+		delivery.Status = storage.WebhookDeliverySucceeded
+		delivery.Error = sql.NullString{}
+		log.Debugf("Delivered %s event to webhook %d", delivery.EventType, webhook.Id)
+	} else {
+		delivery.Error = sql.NullString{String: err.Error(), Valid: true}
+		if delivery.Attempts >= webhookMaxAttempts {
+			delivery.Status = storage.WebhookDeliveryFailed
+			log.WithError(err).Warnf("Delivery %d to webhook %d failed after %d attempts", delivery.Id, webhook.Id, delivery.Attempts)
+		} else {
+			delivery.NextAttemptAt = now.Add(webhookBackoff(delivery.Attempts))
+			log.WithError(err).Debugf("Delivery %d to webhook %d failed, retrying at %s", delivery.Id, webhook.Id, delivery.NextAttemptAt)
+		}
+	}
+	if err := w.storage.WebhookDeliveryRepository().SaveAttempt(delivery); err != nil {
+		log.WithError(err).Errorf("Failed to save attempt of webhook delivery %d", delivery.Id)
+	}
+}
+
+// send posts the payload signed with HMAC-SHA256 and returns the response status
+func (w webhooksImpl) send(ctx context.Context, webhook storage.WebhookEntity, delivery storage.WebhookDeliveryEntity) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Letsdeploy-Webhook")
+	req.Header.Set("X-Letsdeploy-Event", delivery.EventType)
+	req.Header.Set("X-Letsdeploy-Delivery", strconv.FormatInt(delivery.Id, 10))
+	req.Header.Set("X-Letsdeploy-Signature", "sha256="+signWebhookPayload(webhook.Secret, delivery.Payload))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, webhookResponseLimit))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// publishEvent puts deliveries of the event to subscribed webhooks of the project to the queue.
+// Like recordAudit, it is called after the operation succeeds, so failures are logged instead of being returned
+func publishEvent(s *storage.Storage, projectId string, eventType openapi.WebhookEventType, data any) {
+	webhooks, err := s.WebhookRepository().FindSubscribed(projectId, string(eventType))
+	if err != nil {
+		log.WithError(err).Errorf("Failed to get webhooks subscribed to %s event of project %s", eventType, projectId)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(webhookPayload{Event: eventType, Project: projectId, CreatedAt: time.Now(), Data: data})
+	if err != nil {
+		log.WithError(err).Errorf("Failed to serialize %s event of project %s", eventType, projectId)
+		return
+	}
+	for _, webhook := range webhooks {
+		delivery := storage.WebhookDeliveryEntity{WebhookId: webhook.Id, EventType: string(eventType), Payload: payload}
+		if err := s.WebhookDeliveryRepository().CreateNew(delivery); err != nil {
+			log.WithError(err).Errorf("Failed to enqueue %s event delivery to webhook %d", eventType, webhook.Id)
+		}
+	}
+}
+
+func validateWebhook(ctx context.Context, webhook openapi.Webhook) error {
+	u, err := url.Parse(webhook.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return apperrors.BadRequest("Webhook URL should be an absolute HTTP(S) URL")
+	}
+	addresses, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return apperrors.BadRequest(fmt.Sprintf("Cannot resolve webhook host %s", u.Hostname()))
+	}
+	for _, address := range addresses {
+		if !isPublicAddress(address) {
+			return apperrors.BadRequest(fmt.Sprintf("Webhook host %s resolves to non-public address", u.Hostname()))
+		}
+	}
+	for _, eventType := range webhook.EventTypes {
+		if !slices.Contains(webhookEventTypeValues, eventType) {
+			return apperrors.BadRequest(fmt.Sprintf("Unknown webhook event type %s", eventType))
+		}
+	}
+	return nil
+}
+
+// checkWebhookAddress is the dialer control function that prevents requests to the cluster network.
+// The check is done on the resolved address of every connection, so DNS changes and redirects do not bypass it
+func checkWebhookAddress(network string, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return errors.Wrapf(err, "invalid webhook address %s", address)
+	}
+	if !isPublicAddress(addrPort.Addr()) {
+		return errors.Errorf("webhook address %s is not public", addrPort.Addr())
+	}
+	return nil
+}
+
+// carrierGradeNat is the shared address space (RFC 6598) that is often used for pods and K8s services
+var carrierGradeNat = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddress returns false for loopback, private, link-local, multicast and unspecified addresses
+func isPublicAddress(address netip.Addr) bool {
+	address = address.Unmap()
+	return address.IsValid() &&
+		address.IsGlobalUnicast() &&
+		!address.IsPrivate() &&
+		!carrierGradeNat.Contains(address)
+}
+
+var webhookEventTypeValues = []openapi.WebhookEventType{
+	openapi.DeploymentFinished,
+	openapi.ServiceUnhealthy,
+	openapi.MemberChanged,
+	openapi.IncidentOpened,
+	openapi.IncidentResolved,
+}
+
+func webhookEventTypes(eventTypes []openapi.WebhookEventType) storage.WebhookEventTypes {
+	types := mapItems(eventTypes, func(t openapi.WebhookEventType) string { return string(t) })
+	slices.Sort(types)
+	return slices.Compact(types)
+}
+
+func webhookBackoff(attempts int) time.Duration {
+	return min(webhookInitialBackoff<<(attempts-1), webhookMaxBackoff)
+}
+
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookFromEntity(entity storage.WebhookEntity) openapi.Webhook {
+	return openapi.Webhook{
+		Id:         &entity.Id,
+		Url:        entity.Url,
+		EventTypes: mapItems(entity.EventTypes, func(t string) openapi.WebhookEventType { return openapi.WebhookEventType(t) }),
+		Enabled:    &entity.Enabled,
+		CreatedBy:  &entity.CreatedBy,
+		CreatedAt:  &entity.CreatedAt,
+	}
+}
+
+func webhookDeliveryFromEntity(entity storage.WebhookDeliveryEntity) (openapi.WebhookDelivery, error) {
+	delivery := openapi.WebhookDelivery{
+		Id:        entity.Id,
+		EventType: openapi.WebhookEventType(entity.EventType),
+		Status:    openapi.WebhookDeliveryStatus(entity.Status),
+		Attempts:  entity.Attempts,
+		Error:     fromNullString(entity.Error),
+		CreatedAt: entity.CreatedAt,
+	}
+	if err := json.Unmarshal(entity.Payload, &delivery.Payload); err != nil {
+		return openapi.WebhookDelivery{}, errors.Wrap(err, "failed to parse webhook delivery payload")
+	}
+	if entity.Status == storage.WebhookDeliveryPending {
+		delivery.NextAttemptAt = &entity.NextAttemptAt
+	}
+	if entity.LastAttemptAt.Valid {
+		delivery.LastAttemptAt = &entity.LastAttemptAt.Time
+	}
+	if entity.ResponseStatus.Valid {
+		status := int(entity.ResponseStatus.Int32)
+		delivery.ResponseStatus = &status
+	}
+	return delivery, nil
+}
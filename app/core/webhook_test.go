@@ -0,0 +1,99 @@
+package core
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		want     time.Duration
+	}{
+		{
+			name:     "FirstAttempt",
+			attempts: 1,
+			want:     10 * time.Second,
+		},
+		{
+			name:     "SecondAttempt",
+			attempts: 2,
+			want:     20 * time.Second,
+		},
+		{
+			name:     "LastAttempt",
+			attempts: webhookMaxAttempts,
+			want:     1280 * time.Second,
+		},
+		{
+			name:     "CappedByMaxBackoff",
+			attempts: 10,
+			want:     time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := webhookBackoff(tt.attempts); got != tt.want {
+				t.Errorf("webhookBackoff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		payload string
+		want    string
+	}{
+		{
+			name:    "Payload",
+			secret:  "secret",
+			payload: `{"event":"test"}`,
+			want:    "8419ab361b37d61b696d008ef7549a18325132dae5da84c7424e8e1c590d0498",
+		},
+		{
+			name:    "OtherPayload",
+			secret:  "secret",
+			payload: "key",
+			want:    "96de09a0f8699191b28587118ac57df88bbf6c2d0c131d196dcd90f7efd68c93",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signWebhookPayload(tt.secret, []byte(tt.payload)); got != tt.want {
+				t.Errorf("signWebhookPayload() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    bool
+	}{
+		{name: "Public", address: "93.184.216.34", want: true},
+		{name: "PublicIpv6", address: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{name: "Loopback", address: "127.0.0.1", want: false},
+		{name: "LoopbackIpv6", address: "::1", want: false},
+		{name: "Private", address: "10.0.0.1", want: false},
+		{name: "PrivateIpv6", address: "fd00::1", want: false},
+		{name: "LinkLocal", address: "169.254.169.254", want: false},
+		{name: "CarrierGradeNat", address: "100.64.0.10", want: false},
+		{name: "Unspecified", address: "0.0.0.0", want: false},
+		{name: "Multicast", address: "224.0.0.1", want: false},
+		{name: "Ipv4MappedPrivate", address: "::ffff:192.168.0.1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPublicAddress(netip.MustParseAddr(tt.address)); got != tt.want {
+				t.Errorf("isPublicAddress(%s) = %v, want %v", tt.address, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"strings"
+)
+
+// ExecInPod runs command in the container of the pod and returns its stdout.
+// If stdin is not nil, it is streamed to the command's standard input.
+func ExecInPod(
+	ctx context.Context,
+	clientset *kubernetes.Clientset,
+	config *rest.Config,
+	namespace string,
+	pod string,
+	container string,
+	command []string,
+	stdin io.Reader,
+) (string, error) {
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create pod command executor")
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "command failed in pod %s: %s", pod, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -15,25 +15,21 @@ import (
 )
 
 func Setup(cfg *viper.Viper) (*kubernetes.Clientset, *certManagerClientset.Clientset) {
-	isInCluster := cfg.GetBool("kubernetes.in-cluster")
-	var config *rest.Config
-	if isInCluster {
-		config = inCluster()
-	} else {
-		config = outOfCluster(cfg)
-	}
+	config := SetupConfig(cfg)
 	return kubernetes.NewForConfigOrDie(config), certManagerClientset.NewForConfigOrDie(config)
 }
 
 func SetupTraefikClient(cfg *viper.Viper) *v1alpha1.TraefikV1alpha1Client {
+	return v1alpha1.NewForConfigOrDie(SetupConfig(cfg))
+}
+
+func SetupConfig(cfg *viper.Viper) *rest.Config {
 	isInCluster := cfg.GetBool("kubernetes.in-cluster")
-	var config *rest.Config
 	if isInCluster {
-		config = inCluster()
+		return inCluster()
 	} else {
-		config = outOfCluster(cfg)
+		return outOfCluster(cfg)
 	}
-	return v1alpha1.NewForConfigOrDie(config)
 }
 
 func inCluster() *rest.Config {
@@ -5,9 +5,27 @@ import (
 	"github.com/kuzznya/letsdeploy/app/apperrors"
 	"github.com/kuzznya/letsdeploy/internal/openapi"
 	log "github.com/sirupsen/logrus"
+	"net/http"
+	"slices"
 )
 
-type UserProviderFunc = func(apiKey string) (username string, err error)
+// Scope restricts the access of the authenticated API key
+type Scope struct {
+	// Projects limits the access to the listed projects, all projects of the user are accessible if nil
+	Projects []string
+	ReadOnly bool
+}
+
+func (s *Scope) AllowsProject(id string) bool {
+	return s == nil || s.Projects == nil || slices.Contains(s.Projects, id)
+}
+
+// ProjectScoped returns true if the access is limited to some projects
+func (s *Scope) ProjectScoped() bool {
+	return s != nil && s.Projects != nil
+}
+
+type UserProviderFunc = func(apiKey string) (username string, scope *Scope, err error)
 
 func CreateApiKeyAuthMiddleware(userProvider UserProviderFunc) openapi.MiddlewareFunc {
 	return func(c *gin.Context) {
@@ -21,14 +39,20 @@ func ApiKeyAuthMiddleware(ctx *gin.Context, userProvider UserProviderFunc) {
 		ctx.Next()
 		return
 	}
-	username, err := userProvider(key)
+	username, scope, err := userProvider(key)
 	if err != nil {
 		log.WithError(err).Errorln("Failed to authenticate by API key")
 		_ = ctx.Error(apperrors.Forbidden("Failed to authenticate by API key"))
 		ctx.Abort()
 		return
 	}
-	ctx.Set(authContextKey, &Authentication{Username: username, Token: key})
+	if scope != nil && scope.ReadOnly && ctx.Request.Method != http.MethodGet && ctx.Request.Method != http.MethodHead {
+		log.Debugf("User %s tried to perform %s request with read-only API key", username, ctx.Request.Method)
+		_ = ctx.Error(apperrors.Forbidden("API key is read-only"))
+		ctx.Abort()
+		return
+	}
+	ctx.Set(authContextKey, &Authentication{Username: username, Method: AuthMethodApiKey, Token: key, Scope: scope})
 	log.Debugf("User %s authenticated by API key", username)
 
 	ctx.Next()
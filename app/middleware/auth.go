@@ -20,17 +20,37 @@ import (
 
 const authContextKey = "authentication"
 
+// AuthMethod is the way the request was authenticated
+type AuthMethod string
+
+const (
+	AuthMethodBearer         AuthMethod = "bearer"
+	AuthMethodApiKey         AuthMethod = "api-key"
+	AuthMethodDeployToken    AuthMethod = "deploy-token"
+	AuthMethodTempToken      AuthMethod = "temp-token"
+	AuthMethodServiceAccount AuthMethod = "service-account"
+)
+
 type Authentication struct {
 	Username string
-	Token    string
+	Method   AuthMethod
+	// Email is the verified email of the user, empty if unknown
+	Email string
+	Token string
+	// Scope restricts the access of API key authentication, nil if the access is not restricted
+	Scope *Scope
+	// DeployToken is set if the request is authenticated by a project deploy token instead of a user
+	DeployToken *DeployToken
 }
 
 var ServiceAccount = Authentication{
 	Username: "letsdeploy-service-account",
+	Method:   AuthMethodServiceAccount,
 	Token:    "TODO",
 }
 
 func CreateAuthMiddleware(cfg *viper.Viper) openapi.MiddlewareFunc {
+	cfg.SetDefault("oidc.email-claim", "email")
 	oidcProvider := cfg.GetString("oidc.provider")
 	rsaKeys := getPublicKeys(oidcProvider)
 	return func(c *gin.Context) {
@@ -68,7 +88,8 @@ func AuthMiddleware(ctx *gin.Context, cfg *viper.Viper, rsaKeys map[string]*rsa.
 	}
 	claim := cfg.GetString("oidc.username-claim")
 	username := token.Claims.(jwt.MapClaims)[claim].(string)
-	ctx.Set(authContextKey, &Authentication{Username: username, Token: tokenString})
+	email := getVerifiedEmail(cfg, token.Claims.(jwt.MapClaims))
+	ctx.Set(authContextKey, &Authentication{Username: username, Method: AuthMethodBearer, Email: email, Token: tokenString})
 	log.Debugf("User %s authenticated", username)
 
 	ctx.Next()
@@ -157,6 +178,16 @@ func getJwksUri(oidcProvider string) string {
 	return jwksUri
 }
 
+// getVerifiedEmail returns the email from the claim configured by oidc.email-claim
+// only if the provider reports that the email is verified
+func getVerifiedEmail(cfg *viper.Viper, claims jwt.MapClaims) string {
+	email, _ := claims[cfg.GetString("oidc.email-claim")].(string)
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return ""
+	}
+	return email
+}
+
 func checkClaims(oidcProvider string, token *jwt.Token) error {
 	if !token.Valid || token.Claims.(jwt.MapClaims)["iss"] != oidcProvider {
 		return apperrors.Forbidden("Failed to authenticate user")
@@ -7,7 +7,7 @@ import (
 
 func Authz(c *gin.Context) {
 	if c.Value(authContextKey) == nil {
-		_ = c.Error(apperrors.Unauthorized("Either Bearer authentication or API key authentication should be provided"))
+		_ = c.Error(apperrors.Unauthorized("Either Bearer authentication, API key or deploy token authentication should be provided"))
 		c.Abort()
 		return
 	}
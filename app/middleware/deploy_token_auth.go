@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeployToken is the identity of the request authenticated by a project deploy token
+type DeployToken struct {
+	Id       int
+	Name     string
+	Project  string
+	Services []int
+	Actions  []openapi.DeployTokenAction
+}
+
+type DeployTokenProviderFunc = func(token string) (*DeployToken, error)
+
+func CreateDeployTokenAuthMiddleware(tokenProvider DeployTokenProviderFunc) openapi.MiddlewareFunc {
+	return func(c *gin.Context) {
+		DeployTokenAuthMiddleware(c, tokenProvider)
+	}
+}
+
+func DeployTokenAuthMiddleware(ctx *gin.Context, tokenProvider DeployTokenProviderFunc) {
+	token := ctx.GetHeader("Deploy-Token")
+	if token == "" {
+		ctx.Next()
+		return
+	}
+	deployToken, err := tokenProvider(token)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to authenticate by deploy token")
+		_ = ctx.Error(apperrors.Forbidden("Failed to authenticate by deploy token"))
+		ctx.Abort()
+		return
+	}
+	// username identifies the token in logs instead of the user who created it
+	username := "deploy-token:" + deployToken.Project + "/" + deployToken.Name
+	ctx.Set(authContextKey, &Authentication{Username: username, Method: AuthMethodDeployToken, Token: token, DeployToken: deployToken})
+	log.Debugf("Deploy token %s of project %s authenticated", deployToken.Name, deployToken.Project)
+
+	ctx.Next()
+}
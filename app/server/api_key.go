@@ -24,7 +24,7 @@ func (s Server) CreateApiKey(ctx context.Context, request openapi.CreateApiKeyRe
 }
 
 func (s Server) DeleteApiKey(ctx context.Context, request openapi.DeleteApiKeyRequestObject) (openapi.DeleteApiKeyResponseObject, error) {
-	err := s.core.ApiKeys.DeleteApiKey(ctx, request.Key, middleware.GetAuth(ctx))
+	err := s.core.ApiKeys.DeleteApiKey(ctx, request.Id, middleware.GetAuth(ctx))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to delete API key")
 	}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"github.com/kuzznya/letsdeploy/app/core"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+)
+
+func (s Server) GetProjectAuditEvents(ctx context.Context, request openapi.GetProjectAuditEventsRequestObject) (openapi.GetProjectAuditEventsResponseObject, error) {
+	params := request.Params
+	query := core.AuditQuery{
+		Actor:        fromPtr(params.Actor),
+		ResourceType: fromPtr(params.ResourceType),
+		ResourceId:   fromPtr(params.ResourceId),
+		Action:       fromPtr(params.Action),
+		From:         params.From,
+		To:           params.To,
+		Limit:        fromPtr(params.Limit),
+		Offset:       fromPtr(params.Offset),
+	}
+	page, err := s.core.Audit.GetProjectAuditEvents(request.Id, query, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return openapi.GetProjectAuditEvents200JSONResponse(*page), nil
+}
+
+func (s Server) GetAuditEvents(ctx context.Context, request openapi.GetAuditEventsRequestObject) (openapi.GetAuditEventsResponseObject, error) {
+	params := request.Params
+	query := core.AuditQuery{
+		Project:      fromPtr(params.Project),
+		Actor:        fromPtr(params.Actor),
+		ResourceType: fromPtr(params.ResourceType),
+		ResourceId:   fromPtr(params.ResourceId),
+		Action:       fromPtr(params.Action),
+		From:         params.From,
+		To:           params.To,
+		Limit:        fromPtr(params.Limit),
+		Offset:       fromPtr(params.Offset),
+	}
+	page, err := s.core.Audit.GetAuditEvents(query, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return openapi.GetAuditEvents200JSONResponse(*page), nil
+}
+
+// fromPtr returns the value of the optional parameter or the zero value if it is not set
+func fromPtr[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
@@ -0,0 +1,16 @@
+package server
+
+import (
+	"context"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+)
+
+func (s Server) CloneProject(ctx context.Context, request openapi.CloneProjectRequestObject) (openapi.CloneProjectResponseObject, error) {
+	project, err := s.core.Clones.CloneProject(ctx, request.Id, *request.Body, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to clone project")
+	}
+	return openapi.CloneProject200JSONResponse(*project), nil
+}
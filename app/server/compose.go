@@ -0,0 +1,26 @@
+package server
+
+import (
+	"context"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	"io"
+)
+
+func (s Server) ImportCompose(ctx context.Context, request openapi.ImportComposeRequestObject) (openapi.ImportComposeResponseObject, error) {
+	if request.Body == nil {
+		return nil, apperrors.BadRequest("Compose file should be sent as application/yaml")
+	}
+	compose, err := io.ReadAll(request.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read compose file")
+	}
+	result, err := s.core.ComposeImports.ImportCompose(ctx, request.Id, compose,
+		request.Params.Apply != nil && *request.Params.Apply, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to import compose file")
+	}
+	return openapi.ImportCompose200JSONResponse(*result), nil
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+)
+
+func (s Server) GetDeployTokens(ctx context.Context, request openapi.GetDeployTokensRequestObject) (openapi.GetDeployTokensResponseObject, error) {
+	tokens, err := s.core.DeployTokens.GetDeployTokens(request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get deploy tokens")
+	}
+	return openapi.GetDeployTokens200JSONResponse(tokens), nil
+}
+
+func (s Server) CreateDeployToken(ctx context.Context, request openapi.CreateDeployTokenRequestObject) (openapi.CreateDeployTokenResponseObject, error) {
+	token, err := s.core.DeployTokens.CreateDeployToken(ctx, request.Id, *request.Body, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create deploy token")
+	}
+	return openapi.CreateDeployToken200JSONResponse(*token), nil
+}
+
+func (s Server) DeleteDeployToken(ctx context.Context, request openapi.DeleteDeployTokenRequestObject) (openapi.DeleteDeployTokenResponseObject, error) {
+	err := s.core.DeployTokens.DeleteDeployToken(ctx, request.Id, request.TokenId, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete deploy token")
+	}
+	return openapi.DeleteDeployToken200Response{}, nil
+}
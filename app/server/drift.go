@@ -0,0 +1,16 @@
+package server
+
+import (
+	"context"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+)
+
+func (s Server) GetProjectDrift(ctx context.Context, request openapi.GetProjectDriftRequestObject) (openapi.GetProjectDriftResponseObject, error) {
+	report, err := s.core.Drift.GetProjectDrift(ctx, request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project drift")
+	}
+	return openapi.GetProjectDrift200JSONResponse(*report), nil
+}
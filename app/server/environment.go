@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	"time"
+)
+
+func (s Server) GetProjectEnvironments(ctx context.Context, request openapi.GetProjectEnvironmentsRequestObject) (openapi.GetProjectEnvironmentsResponseObject, error) {
+	environments, err := s.core.Environments.GetEnvironments(request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project environments")
+	}
+	return openapi.GetProjectEnvironments200JSONResponse(environments), nil
+}
+
+func (s Server) CreateProjectEnvironment(ctx context.Context, request openapi.CreateProjectEnvironmentRequestObject) (openapi.CreateProjectEnvironmentResponseObject, error) {
+	environment, err := s.core.Environments.CreateEnvironment(ctx, request.Id, *request.Body, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create project environment")
+	}
+	return openapi.CreateProjectEnvironment200JSONResponse(*environment), nil
+}
+
+func (s Server) UpdateProjectEnvironment(ctx context.Context, request openapi.UpdateProjectEnvironmentRequestObject) (openapi.UpdateProjectEnvironmentResponseObject, error) {
+	environment, err := s.core.Environments.UpdateEnvironment(ctx, request.Id, request.Name, *request.Body, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update project environment")
+	}
+	return openapi.UpdateProjectEnvironment200JSONResponse(*environment), nil
+}
+
+func (s Server) DeleteProjectEnvironment(ctx context.Context, request openapi.DeleteProjectEnvironmentRequestObject) (openapi.DeleteProjectEnvironmentResponseObject, error) {
+	err := s.core.Environments.DeleteEnvironment(ctx, request.Id, request.Name, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete project environment")
+	}
+	return openapi.DeleteProjectEnvironment200Response{}, nil
+}
+
+func (s Server) PromoteService(ctx context.Context, request openapi.PromoteServiceRequestObject) (openapi.PromoteServiceResponseObject, error) {
+	wait := request.Params.Wait != nil && *request.Params.Wait
+	timeout := defaultRolloutTimeout
+	if request.Params.Timeout != nil {
+		timeout = time.Duration(*request.Params.Timeout) * time.Second
+	}
+	result, err := s.core.Environments.PromoteService(ctx, request.Id, request.Name, *request.Body, wait, timeout, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to promote service")
+	}
+	return openapi.PromoteService200JSONResponse(*result), nil
+}
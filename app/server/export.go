@@ -0,0 +1,38 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+)
+
+func (s Server) ExportProject(ctx context.Context, request openapi.ExportProjectRequestObject) (openapi.ExportProjectResponseObject, error) {
+	format := openapi.ExportYaml
+	if request.Params.Format != nil {
+		format = *request.Params.Format
+	}
+	redactSecrets := request.Params.RedactSecrets == nil || *request.Params.RedactSecrets
+	content, err := s.core.Exports.ExportProject(request.Id, format, redactSecrets, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to export project")
+	}
+	if format == openapi.ExportHelm {
+		return openapi.ExportProject200ApplicationgzipResponse{
+			Body:          bytes.NewReader(content),
+			Headers:       openapi.ExportProject200ResponseHeaders{ContentDisposition: attachment(request.Id + ".tgz")},
+			ContentLength: int64(len(content)),
+		}, nil
+	}
+	return openapi.ExportProject200ApplicationyamlResponse{
+		Body:          bytes.NewReader(content),
+		Headers:       openapi.ExportProject200ResponseHeaders{ContentDisposition: attachment(request.Id + ".yaml")},
+		ContentLength: int64(len(content)),
+	}, nil
+}
+
+func attachment(fileName string) string {
+	return fmt.Sprintf("attachment; filename=%q", fileName)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+)
+
+func (s Server) GetIncidents(ctx context.Context, request openapi.GetIncidentsRequestObject) (openapi.GetIncidentsResponseObject, error) {
+	params := request.Params
+	incidents, err := s.core.Incidents.GetIncidents(request.Id, fromPtr(params.Open), fromPtr(params.Limit), fromPtr(params.Offset),
+		middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get incidents")
+	}
+	return openapi.GetIncidents200JSONResponse(incidents), nil
+}
+
+func (s Server) GetIncidentNotifications(ctx context.Context, request openapi.GetIncidentNotificationsRequestObject) (openapi.GetIncidentNotificationsResponseObject, error) {
+	notifications, err := s.core.Incidents.GetNotifications(request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get incident notification settings")
+	}
+	return openapi.GetIncidentNotifications200JSONResponse(*notifications), nil
+}
+
+func (s Server) SetIncidentNotifications(ctx context.Context, request openapi.SetIncidentNotificationsRequestObject) (openapi.SetIncidentNotificationsResponseObject, error) {
+	notifications, err := s.core.Incidents.SetNotifications(ctx, request.Id, *request.Body, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set incident notification settings")
+	}
+	return openapi.SetIncidentNotifications200JSONResponse(*notifications), nil
+}
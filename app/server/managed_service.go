@@ -23,6 +23,16 @@ func (s Server) GetManagedService(ctx context.Context, request openapi.GetManage
 	return openapi.GetManagedService200JSONResponse(*service), err
 }
 
+func (s Server) UpdateManagedService(ctx context.Context, request openapi.UpdateManagedServiceRequestObject) (openapi.UpdateManagedServiceResponseObject, error) {
+	service := *request.Body
+	service.Id = &request.Id
+	updated, err := s.core.ManagedServices.UpdateManagedService(ctx, service, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update managed service")
+	}
+	return openapi.UpdateManagedService200JSONResponse(*updated), nil
+}
+
 func (s Server) DeleteManagedService(ctx context.Context, request openapi.DeleteManagedServiceRequestObject) (openapi.DeleteManagedServiceResponseObject, error) {
 	err := s.core.ManagedServices.DeleteManagedService(ctx, request.Id, middleware.GetAuth(ctx))
 	if err != nil {
@@ -38,3 +48,32 @@ func (s Server) GetManagedServiceStatus(ctx context.Context, request openapi.Get
 	}
 	return openapi.GetManagedServiceStatus200JSONResponse(*status), nil
 }
+
+func (s Server) StopManagedService(ctx context.Context, request openapi.StopManagedServiceRequestObject) (openapi.StopManagedServiceResponseObject, error) {
+	err := s.core.ManagedServices.StopManagedService(ctx, request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return openapi.StopManagedService200Response{}, nil
+}
+
+func (s Server) StartManagedService(ctx context.Context, request openapi.StartManagedServiceRequestObject) (openapi.StartManagedServiceResponseObject, error) {
+	err := s.core.ManagedServices.StartManagedService(ctx, request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return openapi.StartManagedService200Response{}, nil
+}
+
+func (s Server) RotateManagedServicePassword(ctx context.Context, request openapi.RotateManagedServicePasswordRequestObject) (openapi.RotateManagedServicePasswordResponseObject, error) {
+	restartServices := request.Params.RestartServices != nil && *request.Params.RestartServices
+	err := s.core.ManagedServices.RotatePassword(ctx, request.Id, restartServices, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to rotate managed service password")
+	}
+	return openapi.RotateManagedServicePassword200Response{}, nil
+}
+
+func (s Server) GetManagedServiceTypes(ctx context.Context, request openapi.GetManagedServiceTypesRequestObject) (openapi.GetManagedServiceTypesResponseObject, error) {
+	return openapi.GetManagedServiceTypes200JSONResponse(s.core.ManagedServices.GetManagedServiceTypes()), nil
+}
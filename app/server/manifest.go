@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+	"io"
+	"sigs.k8s.io/yaml"
+)
+
+func (s Server) GetProjectManifest(ctx context.Context, request openapi.GetProjectManifestRequestObject) (openapi.GetProjectManifestResponseObject, error) {
+	manifest, err := s.core.Manifests.GetProjectManifest(request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project manifest")
+	}
+	if request.Params.Format != nil && *request.Params.Format == openapi.ManifestJson {
+		return openapi.GetProjectManifest200JSONResponse(*manifest), nil
+	}
+	body, err := yaml.Marshal(manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to serialize project manifest")
+	}
+	return openapi.GetProjectManifest200ApplicationyamlResponse{
+		Body:          bytes.NewReader(body),
+		ContentLength: int64(len(body)),
+	}, nil
+}
+
+func (s Server) ApplyProjectManifest(ctx context.Context, request openapi.ApplyProjectManifestRequestObject) (openapi.ApplyProjectManifestResponseObject, error) {
+	manifest := request.JSONBody
+	if manifest == nil {
+		if request.Body == nil {
+			return nil, apperrors.BadRequest("Manifest should be sent as application/json or application/yaml")
+		}
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read manifest")
+		}
+		manifest = &openapi.ProjectManifest{}
+		if err := yaml.Unmarshal(body, manifest); err != nil {
+			return nil, apperrors.BadRequest("Invalid manifest: " + err.Error())
+		}
+	}
+	plan, err := s.core.Manifests.ApplyProjectManifest(ctx, request.Id, *manifest,
+		request.Params.DryRun != nil && *request.Params.DryRun,
+		request.Params.Prune != nil && *request.Params.Prune,
+		middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to apply project manifest")
+	}
+	return openapi.ApplyProjectManifest200JSONResponse(*plan), nil
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+)
+
+func (s Server) GetPreviewEnvironments(ctx context.Context, request openapi.GetPreviewEnvironmentsRequestObject) (openapi.GetPreviewEnvironmentsResponseObject, error) {
+	previews, err := s.core.Previews.GetPreviews(request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get preview environments")
+	}
+	return openapi.GetPreviewEnvironments200JSONResponse(previews), nil
+}
+
+func (s Server) CreatePreviewEnvironment(ctx context.Context, request openapi.CreatePreviewEnvironmentRequestObject) (openapi.CreatePreviewEnvironmentResponseObject, error) {
+	preview, err := s.core.Previews.CreatePreview(ctx, request.Id, *request.Body, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create preview environment")
+	}
+	return openapi.CreatePreviewEnvironment200JSONResponse(*preview), nil
+}
+
+func (s Server) ClosePreviewEnvironment(ctx context.Context, request openapi.ClosePreviewEnvironmentRequestObject) (openapi.ClosePreviewEnvironmentResponseObject, error) {
+	err := s.core.Previews.ClosePreview(ctx, request.Id, request.Name, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to close preview environment")
+	}
+	return openapi.ClosePreviewEnvironment200Response{}, nil
+}
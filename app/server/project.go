@@ -56,15 +56,23 @@ func (s Server) RemoveProjectParticipant(ctx context.Context, request openapi.Re
 }
 
 func (s Server) AddProjectParticipant(ctx context.Context, request openapi.AddProjectParticipantRequestObject) (openapi.AddProjectParticipantResponseObject, error) {
-	err := s.core.Projects.AddParticipant(request.Id, request.Username, middleware.GetAuth(ctx))
+	err := s.core.Projects.AddParticipant(request.Id, request.Username, request.Params.Role, middleware.GetAuth(ctx))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to add project participant")
 	}
 	return openapi.AddProjectParticipant200Response{}, nil
 }
 
+func (s Server) TransferProject(ctx context.Context, request openapi.TransferProjectRequestObject) (openapi.TransferProjectResponseObject, error) {
+	err := s.core.Projects.TransferProject(ctx, request.Id, request.Body.Username, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to transfer project")
+	}
+	return openapi.TransferProject200Response{}, nil
+}
+
 func (s Server) JoinProject(ctx context.Context, request openapi.JoinProjectRequestObject) (openapi.JoinProjectResponseObject, error) {
-	project, err := s.core.Projects.JoinProject(ctx, request.InviteCode, middleware.GetAuth(ctx))
+	project, err := s.core.Invitations.JoinProject(ctx, request.InviteCode, middleware.GetAuth(ctx))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to join project")
 	}
@@ -72,13 +80,53 @@ func (s Server) JoinProject(ctx context.Context, request openapi.JoinProjectRequ
 }
 
 func (s Server) RegenerateInviteCode(ctx context.Context, request openapi.RegenerateInviteCodeRequestObject) (openapi.RegenerateInviteCodeResponseObject, error) {
-	code, err := s.core.Projects.RegenerateInviteCode(ctx, request.Id, middleware.GetAuth(ctx))
+	code, err := s.core.Invitations.RegenerateInviteCode(ctx, request.Id, middleware.GetAuth(ctx))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to regenerate invite code")
 	}
 	return openapi.RegenerateInviteCode200JSONResponse{InviteCode: code}, nil
 }
 
+func (s Server) StopProject(ctx context.Context, request openapi.StopProjectRequestObject) (openapi.StopProjectResponseObject, error) {
+	err := s.core.Projects.StopProject(ctx, request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stop project")
+	}
+	return openapi.StopProject200Response{}, nil
+}
+
+func (s Server) StartProject(ctx context.Context, request openapi.StartProjectRequestObject) (openapi.StartProjectResponseObject, error) {
+	err := s.core.Projects.StartProject(ctx, request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start project")
+	}
+	return openapi.StartProject200Response{}, nil
+}
+
+func (s Server) GetHibernationSchedule(ctx context.Context, request openapi.GetHibernationScheduleRequestObject) (openapi.GetHibernationScheduleResponseObject, error) {
+	schedule, err := s.core.Hibernation.GetSchedule(request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get hibernation schedule")
+	}
+	return openapi.GetHibernationSchedule200JSONResponse(*schedule), nil
+}
+
+func (s Server) SetHibernationSchedule(ctx context.Context, request openapi.SetHibernationScheduleRequestObject) (openapi.SetHibernationScheduleResponseObject, error) {
+	schedule, err := s.core.Hibernation.SetSchedule(ctx, request.Id, *request.Body, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set hibernation schedule")
+	}
+	return openapi.SetHibernationSchedule200JSONResponse(*schedule), nil
+}
+
+func (s Server) DeleteHibernationSchedule(ctx context.Context, request openapi.DeleteHibernationScheduleRequestObject) (openapi.DeleteHibernationScheduleResponseObject, error) {
+	err := s.core.Hibernation.DeleteSchedule(ctx, request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete hibernation schedule")
+	}
+	return openapi.DeleteHibernationSchedule200Response{}, nil
+}
+
 func (s Server) GetSecrets(ctx context.Context, request openapi.GetSecretsRequestObject) (openapi.GetSecretsResponseObject, error) {
 	secrets, err := s.core.Projects.GetSecrets(request.Id, middleware.GetAuth(ctx))
 	if err != nil {
@@ -110,3 +158,27 @@ func (s Server) DeleteSecret(ctx context.Context, request openapi.DeleteSecretRe
 	}
 	return openapi.DeleteSecret200Response{}, nil
 }
+
+func (s Server) GetInvitations(ctx context.Context, request openapi.GetInvitationsRequestObject) (openapi.GetInvitationsResponseObject, error) {
+	invitations, err := s.core.Invitations.GetInvitations(request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project invitations")
+	}
+	return openapi.GetInvitations200JSONResponse(invitations), nil
+}
+
+func (s Server) CreateInvitation(ctx context.Context, request openapi.CreateInvitationRequestObject) (openapi.CreateInvitationResponseObject, error) {
+	invitation, err := s.core.Invitations.CreateInvitation(ctx, request.Id, *request.Body, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create project invitation")
+	}
+	return openapi.CreateInvitation200JSONResponse(*invitation), nil
+}
+
+func (s Server) RevokeInvitation(ctx context.Context, request openapi.RevokeInvitationRequestObject) (openapi.RevokeInvitationResponseObject, error) {
+	err := s.core.Invitations.RevokeInvitation(ctx, request.Id, request.InvitationId, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to revoke project invitation")
+	}
+	return openapi.RevokeInvitation200Response{}, nil
+}
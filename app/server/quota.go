@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+)
+
+func (s Server) GetProjectQuota(ctx context.Context, request openapi.GetProjectQuotaRequestObject) (openapi.GetProjectQuotaResponseObject, error) {
+	quota, err := s.core.Quotas.GetQuota(request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project quota")
+	}
+	return openapi.GetProjectQuota200JSONResponse(*quota), nil
+}
+
+func (s Server) SetProjectQuota(ctx context.Context, request openapi.SetProjectQuotaRequestObject) (openapi.SetProjectQuotaResponseObject, error) {
+	quota, err := s.core.Quotas.SetQuota(request.Id, *request.Body, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set project quota")
+	}
+	return openapi.SetProjectQuota200JSONResponse(*quota), nil
+}
+
+func (s Server) DeleteProjectQuota(ctx context.Context, request openapi.DeleteProjectQuotaRequestObject) (openapi.DeleteProjectQuotaResponseObject, error) {
+	err := s.core.Quotas.DeleteQuota(request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete project quota")
+	}
+	return openapi.DeleteProjectQuota200Response{}, nil
+}
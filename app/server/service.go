@@ -4,8 +4,12 @@ import (
 	"context"
 	"github.com/kuzznya/letsdeploy/app/middleware"
 	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"time"
 )
 
+// defaultRolloutTimeout matches the default of timeout parameter in the API spec
+const defaultRolloutTimeout = 300 * time.Second
+
 func (s Server) CreateService(ctx context.Context, request openapi.CreateServiceRequestObject) (openapi.CreateServiceResponseObject, error) {
 	service, err := s.core.Services.CreateService(ctx, *request.Body, middleware.GetAuth(ctx))
 	if err != nil {
@@ -54,3 +58,32 @@ func (s Server) RestartService(ctx context.Context, request openapi.RestartServi
 	}
 	return openapi.RestartService200Response{}, nil
 }
+
+func (s Server) StopService(ctx context.Context, request openapi.StopServiceRequestObject) (openapi.StopServiceResponseObject, error) {
+	err := s.core.Services.StopService(ctx, request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return openapi.StopService200Response{}, nil
+}
+
+func (s Server) StartService(ctx context.Context, request openapi.StartServiceRequestObject) (openapi.StartServiceResponseObject, error) {
+	err := s.core.Services.StartService(ctx, request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return openapi.StartService200Response{}, nil
+}
+
+func (s Server) DeployService(ctx context.Context, request openapi.DeployServiceRequestObject) (openapi.DeployServiceResponseObject, error) {
+	wait := request.Params.Wait != nil && *request.Params.Wait
+	timeout := defaultRolloutTimeout
+	if request.Params.Timeout != nil {
+		timeout = time.Duration(*request.Params.Timeout) * time.Second
+	}
+	result, err := s.core.Services.DeployService(ctx, request.Id, *request.Body, wait, timeout, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return openapi.DeployService200JSONResponse(*result), nil
+}
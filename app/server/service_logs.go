@@ -65,7 +65,7 @@ func ServiceLogStreamEndpoint(r *gin.Engine, c *core.Core, rdb *redis.Client) {
 
 		logCtx, cancel := context.WithCancel(ctx)
 
-		logs, err := c.Services.StreamServiceLogs(logCtx, id, replica, middleware.Authentication{Username: username})
+		logs, err := c.Services.StreamServiceLogs(logCtx, id, replica, middleware.Authentication{Username: username, Method: middleware.AuthMethodTempToken})
 		if err != nil {
 			_ = ctx.AbortWithError(http.StatusInternalServerError, err)
 		}
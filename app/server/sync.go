@@ -0,0 +1,23 @@
+package server
+
+import (
+	"context"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+)
+
+func (s Server) GetProjectSyncStatus(ctx context.Context, request openapi.GetProjectSyncStatusRequestObject) (openapi.GetProjectSyncStatusResponseObject, error) {
+	status, err := s.core.Sync.GetProjectSyncStatus(request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project sync status")
+	}
+	return openapi.GetProjectSyncStatus200JSONResponse(*status), nil
+}
+
+func (s Server) SyncProject(ctx context.Context, request openapi.SyncProjectRequestObject) (openapi.SyncProjectResponseObject, error) {
+	if err := s.core.Sync.SyncProject(request.Id, middleware.GetAuth(ctx)); err != nil {
+		return nil, errors.Wrap(err, "failed to sync project")
+	}
+	return openapi.SyncProject202Response{}, nil
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"github.com/kuzznya/letsdeploy/app/middleware"
+	"github.com/kuzznya/letsdeploy/internal/openapi"
+	"github.com/pkg/errors"
+)
+
+func (s Server) GetWebhooks(ctx context.Context, request openapi.GetWebhooksRequestObject) (openapi.GetWebhooksResponseObject, error) {
+	webhooks, err := s.core.Webhooks.GetWebhooks(request.Id, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get webhooks")
+	}
+	return openapi.GetWebhooks200JSONResponse(webhooks), nil
+}
+
+func (s Server) CreateWebhook(ctx context.Context, request openapi.CreateWebhookRequestObject) (openapi.CreateWebhookResponseObject, error) {
+	webhook, err := s.core.Webhooks.CreateWebhook(ctx, request.Id, *request.Body, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create webhook")
+	}
+	return openapi.CreateWebhook200JSONResponse(*webhook), nil
+}
+
+func (s Server) UpdateWebhook(ctx context.Context, request openapi.UpdateWebhookRequestObject) (openapi.UpdateWebhookResponseObject, error) {
+	webhook, err := s.core.Webhooks.UpdateWebhook(ctx, request.Id, request.WebhookId, *request.Body, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update webhook")
+	}
+	return openapi.UpdateWebhook200JSONResponse(*webhook), nil
+}
+
+func (s Server) DeleteWebhook(ctx context.Context, request openapi.DeleteWebhookRequestObject) (openapi.DeleteWebhookResponseObject, error) {
+	err := s.core.Webhooks.DeleteWebhook(ctx, request.Id, request.WebhookId, middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete webhook")
+	}
+	return openapi.DeleteWebhook200Response{}, nil
+}
+
+func (s Server) GetWebhookDeliveries(ctx context.Context, request openapi.GetWebhookDeliveriesRequestObject) (openapi.GetWebhookDeliveriesResponseObject, error) {
+	deliveries, err := s.core.Webhooks.GetWebhookDeliveries(request.Id, request.WebhookId,
+		fromPtr(request.Params.Limit), fromPtr(request.Params.Offset), middleware.GetAuth(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get webhook deliveries")
+	}
+	return openapi.GetWebhookDeliveries200JSONResponse(deliveries), nil
+}
@@ -4,46 +4,64 @@ import (
 	"database/sql"
 	"github.com/kuzznya/letsdeploy/app/apperrors"
 	"github.com/pkg/errors"
+	"time"
 )
 
 type ApiKeyEntity struct {
-	Id       string `db:"id"`
-	Username string `db:"username"`
-	Name     string `db:"name"`
+	Id            int          `db:"id"`
+	Username      string       `db:"username"`
+	Name          string       `db:"name"`
+	KeyHash       string       `db:"key_hash"`
+	Prefix        string       `db:"prefix"`
+	ExpiresAt     sql.NullTime `db:"expires_at"`
+	LastUsedAt    sql.NullTime `db:"last_used_at"`
+	CreatedAt     time.Time    `db:"created_at"`
+	ReadOnly      bool         `db:"read_only"`
+	ProjectScoped bool         `db:"project_scoped"`
 }
 
 type ApiKeyRepository interface {
-	CrudRepository[ApiKeyEntity, string]
+	CrudRepository[ApiKeyEntity, int]
 	GetByUsername(username string) ([]ApiKeyEntity, error)
+	FindByKeyHash(keyHash string) (*ApiKeyEntity, error)
+	GetProjects(id int) ([]string, error)
+	SetProjects(id int, projects []string) error
+	UpdateLastUsedAt(id int, lastUsedAt time.Time) error
 }
 
 type apiKeyRepositoryImpl struct {
 	db QueryExecDB
 }
 
-func (r apiKeyRepositoryImpl) CreateNew(apiKey ApiKeyEntity) (string, error) {
-	_, err := r.db.Exec("INSERT INTO api_key (id, name, username) VALUES ($1, $2, $3)",
-		apiKey.Id, apiKey.Name, apiKey.Username)
+func (r apiKeyRepositoryImpl) CreateNew(apiKey ApiKeyEntity) (int, error) {
+	var id int
+	err := r.db.Get(&id, `
+INSERT INTO api_key (username, name, key_hash, prefix, expires_at, read_only, project_scoped)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id`,
+		apiKey.Username, apiKey.Name, apiKey.KeyHash, apiKey.Prefix, apiKey.ExpiresAt, apiKey.ReadOnly, apiKey.ProjectScoped)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to create the new API key")
+		return 0, errors.Wrap(err, "failed to create the new API key")
 	}
-	return apiKey.Id, nil
+	return id, nil
 }
 
-func (r apiKeyRepositoryImpl) ExistsByID(id string) (bool, error) {
+func (r apiKeyRepositoryImpl) ExistsByID(id int) (bool, error) {
 	var exists bool
-	err := r.db.Select(&exists, "SELECT exists(SELECT * FROM api_key WHERE id = $1)", id)
+	err := r.db.Get(&exists, "SELECT exists(SELECT * FROM api_key WHERE id = $1)", id)
 	if err != nil {
 		return false, errors.Wrap(err, "failed to check if API key exists")
 	}
 	return exists, nil
 }
 
-func (r apiKeyRepositoryImpl) FindByID(id string) (*ApiKeyEntity, error) {
+func (r apiKeyRepositoryImpl) FindByID(id int) (*ApiKeyEntity, error) {
 	var apiKey ApiKeyEntity
 	err := r.db.Get(&apiKey, "SELECT * FROM api_key WHERE id = $1", id)
-	if err != nil && errors.Is(err, sql.ErrNoRows) {
+	if errors.Is(err, sql.ErrNoRows) {
 		return nil, apperrors.NotFound("API key not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get API key")
 	}
 	return &apiKey, nil
 }
@@ -52,7 +70,7 @@ func (r apiKeyRepositoryImpl) Update(record ApiKeyEntity) error {
 	panic("not implemented")
 }
 
-func (r apiKeyRepositoryImpl) Delete(id string) error {
+func (r apiKeyRepositoryImpl) Delete(id int) error {
 	_, err := r.db.Exec("DELETE FROM api_key WHERE id = $1", id)
 	if err != nil {
 		return errors.Wrap(err, "failed to delete the API key")
@@ -62,9 +80,51 @@ func (r apiKeyRepositoryImpl) Delete(id string) error {
 
 func (r apiKeyRepositoryImpl) GetByUsername(username string) ([]ApiKeyEntity, error) {
 	apiKeys := []ApiKeyEntity{}
-	err := r.db.Select(&apiKeys, "SELECT * FROM api_key WHERE username = $1", username)
+	err := r.db.Select(&apiKeys, "SELECT * FROM api_key WHERE username = $1 ORDER BY id", username)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to retrieve user's API keys")
 	}
 	return apiKeys, nil
 }
+
+func (r apiKeyRepositoryImpl) FindByKeyHash(keyHash string) (*ApiKeyEntity, error) {
+	var apiKey ApiKeyEntity
+	err := r.db.Get(&apiKey, "SELECT * FROM api_key WHERE key_hash = $1", keyHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("API key not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get API key")
+	}
+	return &apiKey, nil
+}
+
+func (r apiKeyRepositoryImpl) GetProjects(id int) ([]string, error) {
+	projects := []string{}
+	err := r.db.Select(&projects, "SELECT project_id FROM api_key_project WHERE api_key_id = $1 ORDER BY project_id", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get API key projects")
+	}
+	return projects, nil
+}
+
+func (r apiKeyRepositoryImpl) SetProjects(id int, projects []string) error {
+	_, err := r.db.Exec("DELETE FROM api_key_project WHERE api_key_id = $1", id)
+	if err != nil {
+		return errors.Wrap(err, "failed to set API key projects")
+	}
+	for _, project := range projects {
+		_, err := r.db.Exec("INSERT INTO api_key_project (api_key_id, project_id) VALUES ($1, $2)", id, project)
+		if err != nil {
+			return errors.Wrap(err, "failed to set API key projects")
+		}
+	}
+	return nil
+}
+
+func (r apiKeyRepositoryImpl) UpdateLastUsedAt(id int, lastUsedAt time.Time) error {
+	_, err := r.db.Exec("UPDATE api_key SET last_used_at = $1 WHERE id = $2", lastUsedAt, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update API key last usage time")
+	}
+	return nil
+}
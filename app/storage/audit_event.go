@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+type AuditEventEntity struct {
+	Id           int64          `db:"id"`
+	Actor        string         `db:"actor"`
+	AuthMethod   string         `db:"auth_method"`
+	ProjectId    sql.NullString `db:"project_id"`
+	ResourceType string         `db:"resource_type"`
+	ResourceId   sql.NullString `db:"resource_id"`
+	Action       string         `db:"action"`
+	Summary      sql.NullString `db:"summary"`
+	CreatedAt    time.Time      `db:"created_at"`
+}
+
+// AuditEventFilter limits the found audit events, empty fields are not used for filtering
+type AuditEventFilter struct {
+	ProjectId    string
+	Actor        string
+	ResourceType string
+	ResourceId   string
+	Action       string
+	From         *time.Time
+	To           *time.Time
+}
+
+// AuditEventRepository provides append-only access to audit events
+type AuditEventRepository interface {
+	CreateNew(event AuditEventEntity) error
+	Find(filter AuditEventFilter, limit int, offset int) ([]AuditEventEntity, error)
+	Count(filter AuditEventFilter) (int, error)
+}
+
+type auditEventRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r auditEventRepositoryImpl) CreateNew(event AuditEventEntity) error {
+	_, err := r.db.Exec(`
+INSERT INTO audit_event (actor, auth_method, project_id, resource_type, resource_id, action, summary)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		event.Actor, event.AuthMethod, event.ProjectId, event.ResourceType, event.ResourceId, event.Action, event.Summary)
+	if err != nil {
+		return errors.Wrap(err, "failed to create audit event")
+	}
+	return nil
+}
+
+func (r auditEventRepositoryImpl) Find(filter AuditEventFilter, limit int, offset int) ([]AuditEventEntity, error) {
+	where, args := filter.where()
+	args = append(args, limit, offset)
+	query := fmt.Sprintf("SELECT * FROM audit_event %s ORDER BY id DESC LIMIT $%d OFFSET $%d", where, len(args)-1, len(args))
+	events := []AuditEventEntity{}
+	if err := r.db.Select(&events, query, args...); err != nil {
+		return nil, errors.Wrap(err, "failed to find audit events")
+	}
+	return events, nil
+}
+
+func (r auditEventRepositoryImpl) Count(filter AuditEventFilter) (int, error) {
+	where, args := filter.where()
+	var count int
+	if err := r.db.Get(&count, "SELECT count(*) FROM audit_event "+where, args...); err != nil {
+		return 0, errors.Wrap(err, "failed to count audit events")
+	}
+	return count, nil
+}
+
+func (f AuditEventFilter) where() (string, []any) {
+	var conditions []string
+	var args []any
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if f.ProjectId != "" {
+		add("project_id = $%d", f.ProjectId)
+	}
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if f.ResourceType != "" {
+		add("resource_type = $%d", f.ResourceType)
+	}
+	if f.ResourceId != "" {
+		add("resource_id = $%d", f.ResourceId)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.From != nil {
+		add("created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("created_at < $%d", *f.To)
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type DeployTokenEntity struct {
+	Id         int                `db:"id"`
+	ProjectId  string             `db:"project_id"`
+	Name       string             `db:"name"`
+	TokenHash  string             `db:"token_hash"`
+	Prefix     string             `db:"prefix"`
+	Actions    DeployTokenActions `db:"actions"`
+	ExpiresAt  sql.NullTime       `db:"expires_at"`
+	LastUsedAt sql.NullTime       `db:"last_used_at"`
+	CreatedBy  string             `db:"created_by"`
+	CreatedAt  time.Time          `db:"created_at"`
+}
+
+type DeployTokenActions []string
+
+func (a *DeployTokenActions) Value() (driver.Value, error) {
+	return json.Marshal(a)
+}
+
+func (a *DeployTokenActions) Scan(value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	return json.Unmarshal(b, &a)
+}
+
+type DeployTokenRepository interface {
+	CreateNew(token DeployTokenEntity) (int, error)
+	FindByID(id int) (*DeployTokenEntity, error)
+	FindByProjectId(projectId string) ([]DeployTokenEntity, error)
+	FindByTokenHash(tokenHash string) (*DeployTokenEntity, error)
+	ExistsByNameAndProjectId(name string, projectId string) (bool, error)
+	GetServices(id int) ([]int, error)
+	SetServices(id int, services []int) error
+	UpdateLastUsedAt(id int, lastUsedAt time.Time) error
+	Delete(projectId string, id int) error
+}
+
+type deployTokenRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r deployTokenRepositoryImpl) CreateNew(token DeployTokenEntity) (int, error) {
+	var id int
+	err := r.db.Get(&id, `
+INSERT INTO deploy_token (project_id, name, token_hash, prefix, actions, expires_at, created_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id`,
+		token.ProjectId, token.Name, token.TokenHash, token.Prefix, &token.Actions, token.ExpiresAt, token.CreatedBy)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create deploy token")
+	}
+	return id, nil
+}
+
+func (r deployTokenRepositoryImpl) FindByID(id int) (*DeployTokenEntity, error) {
+	var token DeployTokenEntity
+	err := r.db.Get(&token, "SELECT * FROM deploy_token WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Deploy token not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get deploy token")
+	}
+	return &token, nil
+}
+
+func (r deployTokenRepositoryImpl) FindByProjectId(projectId string) ([]DeployTokenEntity, error) {
+	tokens := []DeployTokenEntity{}
+	err := r.db.Select(&tokens, "SELECT * FROM deploy_token WHERE project_id = $1 ORDER BY name", projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve project deploy tokens")
+	}
+	return tokens, nil
+}
+
+func (r deployTokenRepositoryImpl) FindByTokenHash(tokenHash string) (*DeployTokenEntity, error) {
+	var token DeployTokenEntity
+	err := r.db.Get(&token, "SELECT * FROM deploy_token WHERE token_hash = $1", tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Deploy token not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get deploy token")
+	}
+	return &token, nil
+}
+
+func (r deployTokenRepositoryImpl) ExistsByNameAndProjectId(name string, projectId string) (bool, error) {
+	var exists bool
+	err := r.db.Get(&exists, "SELECT exists(SELECT * FROM deploy_token WHERE name = $1 AND project_id = $2)", name, projectId)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check if deploy token exists")
+	}
+	return exists, nil
+}
+
+func (r deployTokenRepositoryImpl) GetServices(id int) ([]int, error) {
+	services := []int{}
+	err := r.db.Select(&services, "SELECT service_id FROM deploy_token_service WHERE deploy_token_id = $1 ORDER BY service_id", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get deploy token services")
+	}
+	return services, nil
+}
+
+func (r deployTokenRepositoryImpl) SetServices(id int, services []int) error {
+	_, err := r.db.Exec("DELETE FROM deploy_token_service WHERE deploy_token_id = $1", id)
+	if err != nil {
+		return errors.Wrap(err, "failed to set deploy token services")
+	}
+	for _, service := range services {
+		_, err := r.db.Exec("INSERT INTO deploy_token_service (deploy_token_id, service_id) VALUES ($1, $2)", id, service)
+		if err != nil {
+			return errors.Wrap(err, "failed to set deploy token services")
+		}
+	}
+	return nil
+}
+
+func (r deployTokenRepositoryImpl) UpdateLastUsedAt(id int, lastUsedAt time.Time) error {
+	_, err := r.db.Exec("UPDATE deploy_token SET last_used_at = $1 WHERE id = $2", lastUsedAt, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update deploy token last usage time")
+	}
+	return nil
+}
+
+func (r deployTokenRepositoryImpl) Delete(projectId string, id int) error {
+	_, err := r.db.Exec("DELETE FROM deploy_token WHERE project_id = $1 AND id = $2", projectId, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete deploy token")
+	}
+	return nil
+}
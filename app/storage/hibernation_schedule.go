@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"database/sql"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/pkg/errors"
+)
+
+type HibernationScheduleEntity struct {
+	ProjectId string `db:"project_id"`
+	StopCron  string `db:"stop_cron"`
+	StartCron string `db:"start_cron"`
+	TimeZone  string `db:"time_zone"`
+	Enabled   bool   `db:"enabled"`
+}
+
+type HibernationScheduleRepository interface {
+	FindAll() ([]HibernationScheduleEntity, error)
+	FindByProjectId(projectId string) (*HibernationScheduleEntity, error)
+	Save(schedule HibernationScheduleEntity) error
+	Delete(projectId string) error
+}
+
+type hibernationScheduleRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r hibernationScheduleRepositoryImpl) FindAll() ([]HibernationScheduleEntity, error) {
+	schedules := []HibernationScheduleEntity{}
+	err := r.db.Select(&schedules, "SELECT * FROM hibernation_schedule ORDER BY project_id")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve hibernation schedules")
+	}
+	return schedules, nil
+}
+
+func (r hibernationScheduleRepositoryImpl) FindByProjectId(projectId string) (*HibernationScheduleEntity, error) {
+	var schedule HibernationScheduleEntity
+	err := r.db.Get(&schedule, "SELECT * FROM hibernation_schedule WHERE project_id = $1", projectId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Hibernation schedule not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to find hibernation schedule")
+	}
+	return &schedule, nil
+}
+
+func (r hibernationScheduleRepositoryImpl) Save(schedule HibernationScheduleEntity) error {
+	_, err := r.db.Exec(`INSERT INTO hibernation_schedule (project_id, stop_cron, start_cron, time_zone, enabled) 
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (project_id) DO UPDATE 
+		SET stop_cron = excluded.stop_cron, start_cron = excluded.start_cron, 
+		    time_zone = excluded.time_zone, enabled = excluded.enabled`,
+		schedule.ProjectId, schedule.StopCron, schedule.StartCron, schedule.TimeZone, schedule.Enabled)
+	if err != nil {
+		return errors.Wrap(err, "failed to save hibernation schedule")
+	}
+	return nil
+}
+
+func (r hibernationScheduleRepositoryImpl) Delete(projectId string) error {
+	_, err := r.db.Exec("DELETE FROM hibernation_schedule WHERE project_id = $1", projectId)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete hibernation schedule")
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type IncidentEntity struct {
+	Id          int64          `db:"id"`
+	ProjectId   string         `db:"project_id"`
+	ServiceKind string         `db:"service_kind"`
+	ServiceId   int            `db:"service_id"`
+	ServiceName string         `db:"service_name"`
+	Reason      string         `db:"reason"`
+	Details     sql.NullString `db:"details"`
+	OpenedAt    time.Time      `db:"opened_at"`
+	ResolvedAt  sql.NullTime   `db:"resolved_at"`
+}
+
+type IncidentRepository interface {
+	CreateNew(incident IncidentEntity) (int64, error)
+	// FindOpen returns incidents of all projects that are not resolved yet
+	FindOpen() ([]IncidentEntity, error)
+	FindByProjectId(projectId string, openOnly bool, limit int, offset int) ([]IncidentEntity, error)
+	Resolve(id int64, resolvedAt time.Time) error
+}
+
+type incidentRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r incidentRepositoryImpl) CreateNew(incident IncidentEntity) (int64, error) {
+	var id int64
+	err := r.db.Get(&id, `
+INSERT INTO incident (project_id, service_kind, service_id, service_name, reason, details, opened_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id`,
+		incident.ProjectId, incident.ServiceKind, incident.ServiceId, incident.ServiceName, incident.Reason,
+		incident.Details, incident.OpenedAt)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create incident")
+	}
+	return id, nil
+}
+
+func (r incidentRepositoryImpl) FindOpen() ([]IncidentEntity, error) {
+	incidents := []IncidentEntity{}
+	err := r.db.Select(&incidents, "SELECT * FROM incident WHERE resolved_at IS NULL ORDER BY id")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve open incidents")
+	}
+	return incidents, nil
+}
+
+func (r incidentRepositoryImpl) FindByProjectId(projectId string, openOnly bool, limit int, offset int) ([]IncidentEntity, error) {
+	incidents := []IncidentEntity{}
+	err := r.db.Select(&incidents, `
+SELECT * FROM incident
+WHERE project_id = $1 AND (NOT $2 OR resolved_at IS NULL)
+ORDER BY id DESC
+LIMIT $3 OFFSET $4`, projectId, openOnly, limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve project incidents")
+	}
+	return incidents, nil
+}
+
+func (r incidentRepositoryImpl) Resolve(id int64, resolvedAt time.Time) error {
+	_, err := r.db.Exec("UPDATE incident SET resolved_at = $1 WHERE id = $2 AND resolved_at IS NULL", resolvedAt, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve incident")
+	}
+	return nil
+}
+
+type IncidentNotificationEntity struct {
+	ProjectId string     `db:"project_id"`
+	Channels  StringList `db:"channels"`
+	Emails    StringList `db:"emails"`
+}
+
+// StringList is a list of strings stored as jsonb array
+type StringList []string
+
+func (l *StringList) Value() (driver.Value, error) {
+	return json.Marshal(l)
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	return json.Unmarshal(b, &l)
+}
+
+type IncidentNotificationRepository interface {
+	FindByProjectId(projectId string) (*IncidentNotificationEntity, error)
+	Save(notification IncidentNotificationEntity) error
+}
+
+type incidentNotificationRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r incidentNotificationRepositoryImpl) FindByProjectId(projectId string) (*IncidentNotificationEntity, error) {
+	var notification IncidentNotificationEntity
+	err := r.db.Get(&notification, "SELECT * FROM incident_notification WHERE project_id = $1", projectId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Incident notification settings not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to find incident notification settings")
+	}
+	return &notification, nil
+}
+
+func (r incidentNotificationRepositoryImpl) Save(notification IncidentNotificationEntity) error {
+	_, err := r.db.Exec(`INSERT INTO incident_notification (project_id, channels, emails)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (project_id) DO UPDATE
+		SET channels = excluded.channels, emails = excluded.emails`,
+		notification.ProjectId, &notification.Channels, &notification.Emails)
+	if err != nil {
+		return errors.Wrap(err, "failed to save incident notification settings")
+	}
+	return nil
+}
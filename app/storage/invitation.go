@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"database/sql"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type InvitationEntity struct {
+	Id          int            `db:"id"`
+	ProjectId   string         `db:"project_id"`
+	Code        string         `db:"code"`
+	Role        string         `db:"role"`
+	ExpiresAt   sql.NullTime   `db:"expires_at"`
+	MaxUses     sql.NullInt32  `db:"max_uses"`
+	Uses        int            `db:"uses"`
+	Username    sql.NullString `db:"username"`
+	EmailDomain sql.NullString `db:"email_domain"`
+	IsDefault   bool           `db:"is_default"`
+	CreatedBy   sql.NullString `db:"created_by"`
+	CreatedAt   time.Time      `db:"created_at"`
+}
+
+type InvitationRepository interface {
+	CreateNew(invitation InvitationEntity) (*InvitationEntity, error)
+	FindByProjectId(projectId string) ([]InvitationEntity, error)
+	FindByCode(code string) (*InvitationEntity, error)
+	FindDefault(projectId string) (*InvitationEntity, error)
+	// Use increments uses of the invitation, returns false if max uses is reached
+	Use(id int) (bool, error)
+	Delete(projectId string, id int) error
+	DeleteDefault(projectId string) error
+}
+
+type invitationRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r invitationRepositoryImpl) CreateNew(invitation InvitationEntity) (*InvitationEntity, error) {
+	var result InvitationEntity
+	err := r.db.Get(&result, `
+INSERT INTO invitation (project_id, code, role, expires_at, max_uses, username, email_domain, is_default, created_by) 
+VALUES ($1, $2::uuid, $3, $4, $5, $6, $7, $8, $9)
+RETURNING *`,
+		invitation.ProjectId, invitation.Code, invitation.Role, invitation.ExpiresAt, invitation.MaxUses,
+		invitation.Username, invitation.EmailDomain, invitation.IsDefault, invitation.CreatedBy)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create invitation")
+	}
+	return &result, nil
+}
+
+func (r invitationRepositoryImpl) FindByProjectId(projectId string) ([]InvitationEntity, error) {
+	invitations := []InvitationEntity{}
+	err := r.db.Select(&invitations, "SELECT * FROM invitation WHERE project_id = $1 ORDER BY id", projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve project invitations")
+	}
+	return invitations, nil
+}
+
+func (r invitationRepositoryImpl) FindByCode(code string) (*InvitationEntity, error) {
+	var invitation InvitationEntity
+	err := r.db.Get(&invitation, "SELECT * FROM invitation WHERE code = $1::uuid", code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Invitation not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve invitation by code")
+	}
+	return &invitation, nil
+}
+
+func (r invitationRepositoryImpl) FindDefault(projectId string) (*InvitationEntity, error) {
+	var invitation InvitationEntity
+	err := r.db.Get(&invitation, "SELECT * FROM invitation WHERE project_id = $1 AND is_default", projectId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Default invitation not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve default invitation")
+	}
+	return &invitation, nil
+}
+
+func (r invitationRepositoryImpl) Use(id int) (bool, error) {
+	result, err := r.db.Exec(
+		"UPDATE invitation SET uses = uses + 1 WHERE id = $1 AND (max_uses IS NULL OR uses < max_uses)", id)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to use invitation")
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to use invitation")
+	}
+	return updated > 0, nil
+}
+
+func (r invitationRepositoryImpl) Delete(projectId string, id int) error {
+	_, err := r.db.Exec("DELETE FROM invitation WHERE project_id = $1 AND id = $2", projectId, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete invitation")
+	}
+	return nil
+}
+
+func (r invitationRepositoryImpl) DeleteDefault(projectId string) error {
+	_, err := r.db.Exec("DELETE FROM invitation WHERE project_id = $1 AND is_default", projectId)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete default invitation")
+	}
+	return nil
+}
@@ -11,6 +11,8 @@ type ManagedServiceEntity struct {
 	ProjectId string `db:"project_id"`
 	Name      string `db:"name"`
 	Type      string `db:"type"`
+	Replicas  int    `db:"replicas"`
+	Stopped   bool   `db:"stopped"`
 }
 
 type ManagedServiceRepository interface {
@@ -18,6 +20,7 @@ type ManagedServiceRepository interface {
 	FindAll(limit int, offset int) ([]ManagedServiceEntity, error)
 	FindByProjectId(projectId string) ([]ManagedServiceEntity, error)
 	ExistsByNameAndProjectId(name string, projectId string) (bool, error)
+	SetStopped(id int, stopped bool) error
 }
 
 type managedServiceRepositoryImpl struct {
@@ -27,8 +30,8 @@ type managedServiceRepositoryImpl struct {
 func (r managedServiceRepositoryImpl) CreateNew(entity ManagedServiceEntity) (int, error) {
 	var id int
 	err := r.db.Get(&id,
-		"INSERT INTO managed_service (project_id, name, type) VALUES ($1, $2, $3) RETURNING id",
-		entity.ProjectId, entity.Name, entity.Type)
+		"INSERT INTO managed_service (project_id, name, type, replicas) VALUES ($1, $2, $3, $4) RETURNING id",
+		entity.ProjectId, entity.Name, entity.Type, entity.Replicas)
 	if err != nil {
 		return 0, errors.Wrap(err, "cannot save new managed service")
 	}
@@ -56,8 +59,8 @@ func (r managedServiceRepositoryImpl) FindByID(id int) (*ManagedServiceEntity, e
 }
 
 func (r managedServiceRepositoryImpl) Update(entity ManagedServiceEntity) error {
-	_, err := r.db.Exec("UPDATE managed_service SET name = $1, type = $2 WHERE id = $3",
-		entity.Name, entity.Type, entity.Id)
+	_, err := r.db.Exec("UPDATE managed_service SET name = $1, type = $2, replicas = $3 WHERE id = $4",
+		entity.Name, entity.Type, entity.Replicas, entity.Id)
 	if err != nil {
 		return errors.Wrap(err, "cannot update managed service")
 	}
@@ -100,3 +103,11 @@ func (r managedServiceRepositoryImpl) ExistsByNameAndProjectId(name string, proj
 	}
 	return exists, nil
 }
+
+func (r managedServiceRepositoryImpl) SetStopped(id int, stopped bool) error {
+	_, err := r.db.Exec("UPDATE managed_service SET stopped = $1 WHERE id = $2", stopped, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update stopped flag of managed service")
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"database/sql"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type PreviewEnvironmentEntity struct {
+	ProjectId string         `db:"project_id"`
+	ParentId  sql.NullString `db:"parent_id"`
+	Name      string         `db:"name"`
+	ExpiresAt time.Time      `db:"expires_at"`
+	CreatedBy string         `db:"created_by"`
+	CreatedAt time.Time      `db:"created_at"`
+}
+
+type PreviewEnvironmentRepository interface {
+	CreateNew(preview PreviewEnvironmentEntity) (*PreviewEnvironmentEntity, error)
+	FindByProjectId(projectId string) (*PreviewEnvironmentEntity, error)
+	FindByParentId(parentId string) ([]PreviewEnvironmentEntity, error)
+	FindByParentIdAndName(parentId string, name string) (*PreviewEnvironmentEntity, error)
+	// FindExpired returns previews that expired before the time and previews of deleted projects
+	FindExpired(before time.Time) ([]PreviewEnvironmentEntity, error)
+}
+
+type previewEnvironmentRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r previewEnvironmentRepositoryImpl) CreateNew(preview PreviewEnvironmentEntity) (*PreviewEnvironmentEntity, error) {
+	var result PreviewEnvironmentEntity
+	err := r.db.Get(&result, `
+INSERT INTO preview_environment (project_id, parent_id, name, expires_at, created_by)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *`,
+		preview.ProjectId, preview.ParentId, preview.Name, preview.ExpiresAt, preview.CreatedBy)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create preview environment")
+	}
+	return &result, nil
+}
+
+func (r previewEnvironmentRepositoryImpl) FindByProjectId(projectId string) (*PreviewEnvironmentEntity, error) {
+	var preview PreviewEnvironmentEntity
+	err := r.db.Get(&preview, "SELECT * FROM preview_environment WHERE project_id = $1", projectId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Preview environment not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve preview environment")
+	}
+	return &preview, nil
+}
+
+func (r previewEnvironmentRepositoryImpl) FindByParentId(parentId string) ([]PreviewEnvironmentEntity, error) {
+	previews := []PreviewEnvironmentEntity{}
+	err := r.db.Select(&previews, "SELECT * FROM preview_environment WHERE parent_id = $1 ORDER BY created_at", parentId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve project preview environments")
+	}
+	return previews, nil
+}
+
+func (r previewEnvironmentRepositoryImpl) FindByParentIdAndName(parentId string, name string) (*PreviewEnvironmentEntity, error) {
+	var preview PreviewEnvironmentEntity
+	err := r.db.Get(&preview, "SELECT * FROM preview_environment WHERE parent_id = $1 AND name = $2", parentId, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Preview environment not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve preview environment")
+	}
+	return &preview, nil
+}
+
+func (r previewEnvironmentRepositoryImpl) FindExpired(before time.Time) ([]PreviewEnvironmentEntity, error) {
+	previews := []PreviewEnvironmentEntity{}
+	err := r.db.Select(&previews,
+		"SELECT * FROM preview_environment WHERE expires_at < $1 OR parent_id IS NULL ORDER BY expires_at", before)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve expired preview environments")
+	}
+	return previews, nil
+}
@@ -7,8 +7,12 @@ import (
 )
 
 type ProjectEntity struct {
-	Id         string `db:"id"`
-	InviteCode string `db:"invite_code"`
+	Id string `db:"id"`
+}
+
+type ParticipantEntity struct {
+	Username string `db:"username"`
+	Role     string `db:"role"`
 }
 
 type ProjectRepository interface {
@@ -16,10 +20,12 @@ type ProjectRepository interface {
 	FindAll(limit int, offset int) ([]ProjectEntity, error)
 	FindUserProjects(username string) ([]ProjectEntity, error)
 	GetParticipants(id string) ([]string, error)
+	GetMembers(id string) ([]ParticipantEntity, error)
 	IsParticipant(id string, username string) (bool, error)
-	AddParticipant(id string, username string) error
+	GetParticipantRole(id string, username string) (string, error)
+	AddParticipant(id string, username string, role string) error
+	SetParticipantRole(id string, username string, role string) error
 	RemoveParticipant(id string, username string) error
-	FindByInviteCode(code string) (*ProjectEntity, error)
 }
 
 type projectRepositoryImpl struct {
@@ -28,8 +34,7 @@ type projectRepositoryImpl struct {
 
 func (r projectRepositoryImpl) CreateNew(project ProjectEntity) (string, error) {
 	var result string
-	err := r.db.Get(&result, "INSERT INTO project (id, invite_code) VALUES ($1, $2) RETURNING id",
-		project.Id, project.InviteCode)
+	err := r.db.Get(&result, "INSERT INTO project (id) VALUES ($1) RETURNING id", project.Id)
 	if err != nil {
 		return "", errors.Wrap(err, "cannot save new project")
 	}
@@ -56,12 +61,8 @@ func (r projectRepositoryImpl) FindByID(id string) (*ProjectEntity, error) {
 	return &project, nil
 }
 
+// Update does nothing as project has no mutable fields
 func (r projectRepositoryImpl) Update(entity ProjectEntity) error {
-	_, err := r.db.Exec("UPDATE project SET invite_code = $1 WHERE id = $2",
-		entity.InviteCode, entity.Id)
-	if err != nil {
-		return errors.Wrap(err, "failed to update project")
-	}
 	return nil
 }
 
@@ -112,6 +113,15 @@ func (r projectRepositoryImpl) GetParticipants(id string) ([]string, error) {
 	return participants, nil
 }
 
+func (r projectRepositoryImpl) GetMembers(id string) ([]ParticipantEntity, error) {
+	members := []ParticipantEntity{}
+	err := r.db.Select(&members, "SELECT username, role FROM project_participant WHERE project_id = $1 ORDER BY username", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get project members")
+	}
+	return members, nil
+}
+
 func (r projectRepositoryImpl) IsParticipant(id string, username string) (bool, error) {
 	exists, err := r.ExistsByID(id)
 	if err != nil {
@@ -134,7 +144,18 @@ func (r projectRepositoryImpl) IsParticipant(id string, username string) (bool,
 	return isParticipant, nil
 }
 
-func (r projectRepositoryImpl) AddParticipant(id string, username string) error {
+func (r projectRepositoryImpl) GetParticipantRole(id string, username string) (string, error) {
+	var role string
+	err := r.db.Get(&role, "SELECT role FROM project_participant WHERE project_id = $1 AND username = $2", id, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", apperrors.NotFound("Project participant not found")
+	} else if err != nil {
+		return "", errors.Wrap(err, "failed to get project participant role")
+	}
+	return role, nil
+}
+
+func (r projectRepositoryImpl) AddParticipant(id string, username string, role string) error {
 	exists, err := r.ExistsByID(id)
 	if err != nil {
 		return err
@@ -143,15 +164,23 @@ func (r projectRepositoryImpl) AddParticipant(id string, username string) error
 		return errors.Errorf("project with id %s does not exist", id)
 	}
 	_, err = r.db.Exec(`
-INSERT INTO project_participant (project_id, username) 
-VALUES ($1, $2) 
-ON CONFLICT (project_id, username) DO NOTHING`, id, username)
+INSERT INTO project_participant (project_id, username, role) 
+VALUES ($1, $2, $3) 
+ON CONFLICT (project_id, username) DO NOTHING`, id, username, role)
 	if err != nil {
 		return errors.Wrap(err, "failed to add new participant")
 	}
 	return nil
 }
 
+func (r projectRepositoryImpl) SetParticipantRole(id string, username string, role string) error {
+	_, err := r.db.Exec("UPDATE project_participant SET role = $1 WHERE project_id = $2 AND username = $3", role, id, username)
+	if err != nil {
+		return errors.Wrap(err, "failed to set participant role")
+	}
+	return nil
+}
+
 func (r projectRepositoryImpl) RemoveParticipant(id string, username string) error {
 	exists, err := r.ExistsByID(id)
 	if err != nil {
@@ -166,14 +195,3 @@ func (r projectRepositoryImpl) RemoveParticipant(id string, username string) err
 	}
 	return nil
 }
-
-func (r projectRepositoryImpl) FindByInviteCode(code string) (*ProjectEntity, error) {
-	var project ProjectEntity
-	err := r.db.Get(&project, "SELECT * FROM project WHERE invite_code = $1::uuid", code)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, apperrors.NotFound("Project with this invite code not found")
-	} else if err != nil {
-		return nil, errors.Wrap(err, "failed to retrieve project by invite code")
-	}
-	return &project, nil
-}
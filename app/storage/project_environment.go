@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type ProjectEnvironmentEntity struct {
+	ProjectId string               `db:"project_id"`
+	ParentId  sql.NullString       `db:"parent_id"`
+	Name      string               `db:"name"`
+	Position  int                  `db:"position"`
+	Domain    sql.NullString       `db:"domain"`
+	Overrides EnvironmentOverrides `db:"overrides"`
+	CreatedAt time.Time            `db:"created_at"`
+}
+
+// EnvironmentOverrides are the values of the environment that differ from the parent project
+type EnvironmentOverrides struct {
+	// EnvVars are env var values by service name
+	EnvVars map[string]map[string]string `json:"envVars,omitempty"`
+	// Secrets are secret values by secret name
+	Secrets map[string]string `json:"secrets,omitempty"`
+	// Replicas are replica counts by service name
+	Replicas map[string]int `json:"replicas,omitempty"`
+}
+
+func (o *EnvironmentOverrides) Value() (driver.Value, error) {
+	return json.Marshal(o)
+}
+
+func (o *EnvironmentOverrides) Scan(value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	return json.Unmarshal(b, &o)
+}
+
+type ProjectEnvironmentRepository interface {
+	// CreateNew stores the environment as the last one in the promotion chain of the parent project
+	CreateNew(environment ProjectEnvironmentEntity) (*ProjectEnvironmentEntity, error)
+	FindByProjectId(projectId string) (*ProjectEnvironmentEntity, error)
+	// FindByParentId returns environments of the project in the order of promotion
+	FindByParentId(parentId string) ([]ProjectEnvironmentEntity, error)
+	FindByParentIdAndName(parentId string, name string) (*ProjectEnvironmentEntity, error)
+	ExistsByDomain(domain string) (bool, error)
+	Update(environment ProjectEnvironmentEntity) error
+}
+
+type projectEnvironmentRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r projectEnvironmentRepositoryImpl) CreateNew(environment ProjectEnvironmentEntity) (*ProjectEnvironmentEntity, error) {
+	var result ProjectEnvironmentEntity
+	err := r.db.Get(&result, `
+INSERT INTO project_environment (project_id, parent_id, name, position, domain, overrides)
+VALUES ($1, $2, $3, (SELECT coalesce(max(position), -1) + 1 FROM project_environment WHERE parent_id = $2), $4, $5)
+RETURNING *`,
+		environment.ProjectId, environment.ParentId, environment.Name, environment.Domain, &environment.Overrides)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create project environment")
+	}
+	return &result, nil
+}
+
+func (r projectEnvironmentRepositoryImpl) FindByProjectId(projectId string) (*ProjectEnvironmentEntity, error) {
+	var environment ProjectEnvironmentEntity
+	err := r.db.Get(&environment, "SELECT * FROM project_environment WHERE project_id = $1", projectId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Project environment not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve project environment")
+	}
+	return &environment, nil
+}
+
+func (r projectEnvironmentRepositoryImpl) FindByParentId(parentId string) ([]ProjectEnvironmentEntity, error) {
+	environments := []ProjectEnvironmentEntity{}
+	err := r.db.Select(&environments, "SELECT * FROM project_environment WHERE parent_id = $1 ORDER BY position", parentId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve project environments")
+	}
+	return environments, nil
+}
+
+func (r projectEnvironmentRepositoryImpl) FindByParentIdAndName(parentId string, name string) (*ProjectEnvironmentEntity, error) {
+	var environment ProjectEnvironmentEntity
+	err := r.db.Get(&environment, "SELECT * FROM project_environment WHERE parent_id = $1 AND name = $2", parentId, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Project environment not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve project environment")
+	}
+	return &environment, nil
+}
+
+func (r projectEnvironmentRepositoryImpl) ExistsByDomain(domain string) (bool, error) {
+	var exists bool
+	err := r.db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM project_environment WHERE domain = $1)", domain)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check if environment domain is used")
+	}
+	return exists, nil
+}
+
+func (r projectEnvironmentRepositoryImpl) Update(environment ProjectEnvironmentEntity) error {
+	_, err := r.db.Exec("UPDATE project_environment SET domain = $2, overrides = $3 WHERE project_id = $1",
+		environment.ProjectId, environment.Domain, &environment.Overrides)
+	if err != nil {
+		return errors.Wrap(err, "failed to update project environment")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"database/sql"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type ProjectQuotaEntity struct {
+	ProjectId       string        `db:"project_id"`
+	CpuMillis       sql.NullInt32 `db:"cpu_millis"`
+	MemoryMi        sql.NullInt32 `db:"memory_mi"`
+	StorageMi       sql.NullInt32 `db:"storage_mi"`
+	Services        sql.NullInt32 `db:"services"`
+	ManagedServices sql.NullInt32 `db:"managed_services"`
+	UpdatedBy       string        `db:"updated_by"`
+	UpdatedAt       time.Time     `db:"updated_at"`
+}
+
+type ProjectQuotaRepository interface {
+	FindByProjectId(projectId string) (*ProjectQuotaEntity, error)
+	Save(quota ProjectQuotaEntity) (*ProjectQuotaEntity, error)
+	Delete(projectId string) error
+}
+
+type projectQuotaRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r projectQuotaRepositoryImpl) FindByProjectId(projectId string) (*ProjectQuotaEntity, error) {
+	var quota ProjectQuotaEntity
+	err := r.db.Get(&quota, "SELECT * FROM project_quota WHERE project_id = $1", projectId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Project quota not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve project quota")
+	}
+	return &quota, nil
+}
+
+func (r projectQuotaRepositoryImpl) Save(quota ProjectQuotaEntity) (*ProjectQuotaEntity, error) {
+	var result ProjectQuotaEntity
+	err := r.db.Get(&result, `
+INSERT INTO project_quota (project_id, cpu_millis, memory_mi, storage_mi, services, managed_services, updated_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (project_id) DO UPDATE
+SET cpu_millis = excluded.cpu_millis, memory_mi = excluded.memory_mi, storage_mi = excluded.storage_mi,
+    services = excluded.services, managed_services = excluded.managed_services,
+    updated_by = excluded.updated_by, updated_at = now()
+RETURNING *`,
+		quota.ProjectId, quota.CpuMillis, quota.MemoryMi, quota.StorageMi, quota.Services, quota.ManagedServices, quota.UpdatedBy)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save project quota")
+	}
+	return &result, nil
+}
+
+func (r projectQuotaRepositoryImpl) Delete(projectId string) error {
+	_, err := r.db.Exec("DELETE FROM project_quota WHERE project_id = $1", projectId)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete project quota")
+	}
+	return nil
+}
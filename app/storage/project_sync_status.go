@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"database/sql"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	SyncSucceeded = "succeeded"
+	SyncFailed    = "failed"
+)
+
+type ProjectSyncStatusEntity struct {
+	ProjectId     string         `db:"project_id"`
+	StartedAt     time.Time      `db:"started_at"`
+	DurationMs    int64          `db:"duration_ms"`
+	Outcome       string         `db:"outcome"`
+	Error         sql.NullString `db:"error"`
+	LastSuccessAt sql.NullTime   `db:"last_success_at"`
+}
+
+type ProjectSyncStatusRepository interface {
+	FindByProjectId(projectId string) (*ProjectSyncStatusEntity, error)
+	// Save stores the result of the last sync, last success time is kept if the sync failed
+	Save(status ProjectSyncStatusEntity) error
+}
+
+type projectSyncStatusRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r projectSyncStatusRepositoryImpl) FindByProjectId(projectId string) (*ProjectSyncStatusEntity, error) {
+	var status ProjectSyncStatusEntity
+	err := r.db.Get(&status, "SELECT * FROM project_sync_status WHERE project_id = $1", projectId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Project sync status not found")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to find project sync status")
+	}
+	return &status, nil
+}
+
+func (r projectSyncStatusRepositoryImpl) Save(status ProjectSyncStatusEntity) error {
+	_, err := r.db.Exec(`INSERT INTO project_sync_status (project_id, started_at, duration_ms, outcome, error, last_success_at)
+		VALUES ($1, $2, $3, $4, $5, CASE WHEN $4 = 'succeeded' THEN $2::timestamptz END)
+		ON CONFLICT (project_id) DO UPDATE
+		SET started_at = excluded.started_at, duration_ms = excluded.duration_ms, outcome = excluded.outcome,
+		    error = excluded.error,
+		    last_success_at = coalesce(excluded.last_success_at, project_sync_status.last_success_at)`,
+		status.ProjectId, status.StartedAt, status.DurationMs, status.Outcome, status.Error)
+	if err != nil {
+		return errors.Wrap(err, "failed to save project sync status")
+	}
+	return nil
+}
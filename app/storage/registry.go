@@ -14,6 +14,7 @@ type ContainerRegistryRepository interface {
 	FindByProjectId(projectId string) ([]ContainerRegistryEntity, error)
 	CreateNew(registry ContainerRegistryEntity) (int, error)
 	ExistsByProjectIdAndUrl(projectId string, url string) (bool, error)
+	Update(registry ContainerRegistryEntity) error
 	Delete(id int) error
 }
 
@@ -50,6 +51,15 @@ func (r containerRegistryRepositoryImpl) ExistsByProjectIdAndUrl(projectId strin
 	return exists, nil
 }
 
+func (r containerRegistryRepositoryImpl) Update(registry ContainerRegistryEntity) error {
+	_, err := r.db.Exec("UPDATE container_registry SET username = $1, password = $2 WHERE id = $3 AND project_id = $4",
+		registry.Username, registry.Password, registry.Id, registry.ProjectId)
+	if err != nil {
+		return errors.Wrap(err, "failed to update container registry")
+	}
+	return nil
+}
+
 func (r containerRegistryRepositoryImpl) Delete(id int) error {
 	_, err := r.db.Exec("DELETE FROM container_registry WHERE id = $1", id)
 	if err != nil {
@@ -20,6 +20,7 @@ type SecretRepository interface {
 	CreateNew(secret SecretEntity) error
 	ExistsByProjectIdAndName(id string, name string) (bool, error)
 	FindByProjectIdAndName(id string, name string) (*SecretEntity, error)
+	Update(secret SecretEntity) error
 	DeleteByProjectIdAndName(id string, name string) error
 }
 
@@ -66,6 +67,14 @@ func (s secretRepositoryImpl) FindByProjectIdAndName(id string, name string) (*S
 	return &secret, nil
 }
 
+func (s secretRepositoryImpl) Update(secret SecretEntity) error {
+	_, err := s.db.Exec("UPDATE secret SET value = $1 WHERE id = $2", secret.Value, secret.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update secret")
+	}
+	return nil
+}
+
 func (s secretRepositoryImpl) DeleteByProjectIdAndName(id string, name string) error {
 	_, err := s.db.Exec("DELETE FROM secret WHERE project_id = $1 AND name = $2", id, name)
 	if err != nil {
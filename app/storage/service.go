@@ -18,6 +18,8 @@ type ServiceEntity struct {
 	StripApiPrefix  bool           `db:"strip_api_prefix"`
 	EnvVars         EnvVars        `db:"env_vars"`
 	Replicas        int            `db:"replicas"`
+	Stopped         bool           `db:"stopped"`
+	Command         StringList     `db:"command"`
 }
 
 type EnvVarEntity struct {
@@ -45,6 +47,7 @@ type ServiceRepository interface {
 	FindAll(limit int, offset int) ([]ServiceEntity, error)
 	FindByProjectId(projectId string) ([]ServiceEntity, error)
 	ExistsByNameAndProjectId(name string, projectId string) (bool, error)
+	SetStopped(id int, stopped bool) error
 }
 
 type serviceRepositoryImpl struct {
@@ -54,10 +57,11 @@ type serviceRepositoryImpl struct {
 func (r serviceRepositoryImpl) CreateNew(service ServiceEntity) (int, error) {
 	var id int
 	err := r.db.Get(&id,
-		`INSERT INTO service (project_id, name, image, port, public_api_prefix, strip_api_prefix, env_vars, replicas) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
+		`INSERT INTO service (project_id, name, image, port, public_api_prefix, strip_api_prefix, env_vars, replicas, command) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) 
 		RETURNING id`,
-		service.ProjectId, service.Name, service.Image, service.Port, service.PublicApiPrefix, service.StripApiPrefix, &service.EnvVars, &service.Replicas)
+		service.ProjectId, service.Name, service.Image, service.Port, service.PublicApiPrefix, service.StripApiPrefix, &service.EnvVars, &service.Replicas,
+		&service.Command)
 	if err != nil {
 		return 0, errors.Wrap(err, "cannot save new service")
 	}
@@ -86,10 +90,11 @@ func (r serviceRepositoryImpl) FindByID(id int) (*ServiceEntity, error) {
 
 func (r serviceRepositoryImpl) Update(service ServiceEntity) error {
 	_, err := r.db.Exec(`UPDATE service 
-			SET name = $1, image = $2, port = $3, public_api_prefix = $4, strip_api_prefix = $5, env_vars = $6, replicas = $7
-			WHERE id = $8`,
+			SET name = $1, image = $2, port = $3, public_api_prefix = $4, strip_api_prefix = $5, env_vars = $6, replicas = $7,
+			    command = $8
+			WHERE id = $9`,
 		service.Name, service.Image, service.Port, service.PublicApiPrefix, service.StripApiPrefix, &service.EnvVars, service.Replicas,
-		service.Id)
+		&service.Command, service.Id)
 	if err != nil {
 		return errors.Wrap(err, "failed to update service")
 	}
@@ -132,3 +137,11 @@ func (r serviceRepositoryImpl) ExistsByNameAndProjectId(name string, projectId s
 	}
 	return exists, nil
 }
+
+func (r serviceRepositoryImpl) SetStopped(id int, stopped bool) error {
+	_, err := r.db.Exec("UPDATE service SET stopped = $1 WHERE id = $2", stopped, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update stopped flag of service")
+	}
+	return nil
+}
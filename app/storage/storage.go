@@ -47,6 +47,54 @@ func (s *Storage) ApiKeyRepository() ApiKeyRepository {
 	return &apiKeyRepositoryImpl{db: s.db}
 }
 
+func (s *Storage) HibernationScheduleRepository() HibernationScheduleRepository {
+	return &hibernationScheduleRepositoryImpl{db: s.db}
+}
+
+func (s *Storage) InvitationRepository() InvitationRepository {
+	return &invitationRepositoryImpl{db: s.db}
+}
+
+func (s *Storage) DeployTokenRepository() DeployTokenRepository {
+	return &deployTokenRepositoryImpl{db: s.db}
+}
+
+func (s *Storage) AuditEventRepository() AuditEventRepository {
+	return &auditEventRepositoryImpl{db: s.db}
+}
+
+func (s *Storage) WebhookRepository() WebhookRepository {
+	return &webhookRepositoryImpl{db: s.db}
+}
+
+func (s *Storage) WebhookDeliveryRepository() WebhookDeliveryRepository {
+	return &webhookDeliveryRepositoryImpl{db: s.db}
+}
+
+func (s *Storage) IncidentRepository() IncidentRepository {
+	return &incidentRepositoryImpl{db: s.db}
+}
+
+func (s *Storage) IncidentNotificationRepository() IncidentNotificationRepository {
+	return &incidentNotificationRepositoryImpl{db: s.db}
+}
+
+func (s *Storage) ProjectSyncStatusRepository() ProjectSyncStatusRepository {
+	return &projectSyncStatusRepositoryImpl{db: s.db}
+}
+
+func (s *Storage) PreviewEnvironmentRepository() PreviewEnvironmentRepository {
+	return &previewEnvironmentRepositoryImpl{db: s.db}
+}
+
+func (s *Storage) ProjectEnvironmentRepository() ProjectEnvironmentRepository {
+	return &projectEnvironmentRepositoryImpl{db: s.db}
+}
+
+func (s *Storage) ProjectQuotaRepository() ProjectQuotaRepository {
+	return &projectQuotaRepositoryImpl{db: s.db}
+}
+
 func (s *Storage) ExecTx(ctx context.Context, f func(*Storage) error) error {
 	var tx *sqlx.Tx
 
@@ -65,16 +113,16 @@ func (s *Storage) ExecTx(ctx context.Context, f func(*Storage) error) error {
 	}
 
 	err := f(&Storage{db: tx})
+	// do not commit or rollback tx to let parent tx decide whether to commit
+	if nestedTx {
+		return err
+	}
 	if err != nil {
 		if err := tx.Rollback(); err != nil {
 			log.WithError(err).Panicln("cannot rollback transaction")
 		}
 		return err
 	}
-	// do not commit tx to let parent tx decide whether to commit
-	if nestedTx {
-		return nil
-	}
 	if err := tx.Commit(); err != nil {
 		return errors.Wrap(err, "cannot commit transaction")
 	}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/kuzznya/letsdeploy/app/apperrors"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type WebhookEntity struct {
+	Id         int               `db:"id"`
+	ProjectId  string            `db:"project_id"`
+	Url        string            `db:"url"`
+	EventTypes WebhookEventTypes `db:"event_types"`
+	Secret     string            `db:"secret"`
+	Enabled    bool              `db:"enabled"`
+	CreatedBy  string            `db:"created_by"`
+	CreatedAt  time.Time         `db:"created_at"`
+}
+
+type WebhookEventTypes []string
+
+func (t *WebhookEventTypes) Value() (driver.Value, error) {
+	return json.Marshal(t)
+}
+
+func (t *WebhookEventTypes) Scan(value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	return json.Unmarshal(b, &t)
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+type WebhookDeliveryEntity struct {
+	Id             int64          `db:"id"`
+	WebhookId      int            `db:"webhook_id"`
+	EventType      string         `db:"event_type"`
+	Payload        []byte         `db:"payload"`
+	Status         string         `db:"status"`
+	Attempts       int            `db:"attempts"`
+	NextAttemptAt  time.Time      `db:"next_attempt_at"`
+	LastAttemptAt  sql.NullTime   `db:"last_attempt_at"`
+	ResponseStatus sql.NullInt32  `db:"response_status"`
+	Error          sql.NullString `db:"error"`
+	CreatedAt      time.Time      `db:"created_at"`
+}
+
+type WebhookRepository interface {
+	CreateNew(webhook WebhookEntity) (int, error)
+	FindByID(id int) (*WebhookEntity, error)
+	FindByProjectId(projectId string) ([]WebhookEntity, error)
+	// FindSubscribed returns enabled webhooks of the project subscribed to the event type
+	FindSubscribed(projectId string, eventType string) ([]WebhookEntity, error)
+	Update(webhook WebhookEntity) error
+	Delete(projectId string, id int) error
+}
+
+type webhookRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r webhookRepositoryImpl) CreateNew(webhook WebhookEntity) (int, error) {
+	var id int
+	err := r.db.Get(&id, `
+INSERT INTO webhook (project_id, url, event_types, secret, enabled, created_by)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id`,
+		webhook.ProjectId, webhook.Url, &webhook.EventTypes, webhook.Secret, webhook.Enabled, webhook.CreatedBy)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create webhook")
+	}
+	return id, nil
+}
+
+func (r webhookRepositoryImpl) FindByID(id int) (*WebhookEntity, error) {
+	var webhook WebhookEntity
+	err := r.db.Get(&webhook, "SELECT * FROM webhook WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound(fmt.Sprintf("Webhook %d not found", id))
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get webhook")
+	}
+	return &webhook, nil
+}
+
+func (r webhookRepositoryImpl) FindByProjectId(projectId string) ([]WebhookEntity, error) {
+	webhooks := []WebhookEntity{}
+	err := r.db.Select(&webhooks, "SELECT * FROM webhook WHERE project_id = $1 ORDER BY id", projectId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve project webhooks")
+	}
+	return webhooks, nil
+}
+
+func (r webhookRepositoryImpl) FindSubscribed(projectId string, eventType string) ([]WebhookEntity, error) {
+	webhooks := []WebhookEntity{}
+	err := r.db.Select(&webhooks, "SELECT * FROM webhook WHERE project_id = $1 AND enabled AND event_types @> jsonb_build_array($2::text) ORDER BY id",
+		projectId, eventType)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve subscribed webhooks")
+	}
+	return webhooks, nil
+}
+
+func (r webhookRepositoryImpl) Update(webhook WebhookEntity) error {
+	_, err := r.db.Exec("UPDATE webhook SET url = $1, event_types = $2, enabled = $3 WHERE id = $4",
+		webhook.Url, &webhook.EventTypes, webhook.Enabled, webhook.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update webhook")
+	}
+	return nil
+}
+
+func (r webhookRepositoryImpl) Delete(projectId string, id int) error {
+	_, err := r.db.Exec("DELETE FROM webhook WHERE project_id = $1 AND id = $2", projectId, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete webhook")
+	}
+	return nil
+}
+
+type WebhookDeliveryRepository interface {
+	CreateNew(delivery WebhookDeliveryEntity) error
+	FindByWebhookId(webhookId int, limit int, offset int) ([]WebhookDeliveryEntity, error)
+	// ClaimDue returns pending deliveries which next attempt time has come and postpones their next attempt by lease,
+	// so that concurrent workers do not send them at the same time
+	ClaimDue(limit int, lease time.Duration) ([]WebhookDeliveryEntity, error)
+	// SaveAttempt stores the result of the delivery attempt
+	SaveAttempt(delivery WebhookDeliveryEntity) error
+}
+
+type webhookDeliveryRepositoryImpl struct {
+	db QueryExecDB
+}
+
+func (r webhookDeliveryRepositoryImpl) CreateNew(delivery WebhookDeliveryEntity) error {
+	_, err := r.db.Exec("INSERT INTO webhook_delivery (webhook_id, event_type, payload) VALUES ($1, $2, $3)",
+		delivery.WebhookId, delivery.EventType, delivery.Payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to create webhook delivery")
+	}
+	return nil
+}
+
+func (r webhookDeliveryRepositoryImpl) FindByWebhookId(webhookId int, limit int, offset int) ([]WebhookDeliveryEntity, error) {
+	deliveries := []WebhookDeliveryEntity{}
+	err := r.db.Select(&deliveries, "SELECT * FROM webhook_delivery WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3",
+		webhookId, limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve webhook deliveries")
+	}
+	return deliveries, nil
+}
+
+func (r webhookDeliveryRepositoryImpl) ClaimDue(limit int, lease time.Duration) ([]WebhookDeliveryEntity, error) {
+	deliveries := []WebhookDeliveryEntity{}
+	err := r.db.Select(&deliveries, `
+UPDATE webhook_delivery SET next_attempt_at = now() + $2 * interval '1 second'
+WHERE id IN (
+    SELECT id FROM webhook_delivery
+    WHERE status = 'pending' AND next_attempt_at <= now()
+    ORDER BY next_attempt_at
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *`, limit, lease.Seconds())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to claim webhook deliveries")
+	}
+	return deliveries, nil
+}
+
+func (r webhookDeliveryRepositoryImpl) SaveAttempt(delivery WebhookDeliveryEntity) error {
+	_, err := r.db.Exec(`
+UPDATE webhook_delivery
+SET status = $1, attempts = $2, next_attempt_at = $3, last_attempt_at = $4, response_status = $5, error = $6
+WHERE id = $7`,
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastAttemptAt, delivery.ResponseStatus,
+		delivery.Error, delivery.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to save webhook delivery attempt")
+	}
+	return nil
+}
@@ -1,40 +1,75 @@
-import { ManagedServiceTypeEnum } from "@/api/generated";
 import { defineComponent, h, PropType, VNode } from "vue";
 
 export type ManagedServiceType = {
-  type: ManagedServiceTypeEnum;
+  type: string;
   name: string;
   image: () => VNode;
 };
 
-export const types: { [type in ManagedServiceTypeEnum]: ManagedServiceType } = {
-  [ManagedServiceTypeEnum.Postgres]: {
-    type: ManagedServiceTypeEnum.Postgres,
+export const types: { [type: string]: ManagedServiceType } = {
+  postgres: {
+    type: "postgres",
     name: "PostgreSQL 15",
     image: () => h("i", { class: "bi bi-database" }),
   },
-  [ManagedServiceTypeEnum.Mysql]: {
-    type: ManagedServiceTypeEnum.Mysql,
+  mysql: {
+    type: "mysql",
     name: "MySQL 8",
     image: () => h("i", { class: "bi bi-database" }),
   },
-  [ManagedServiceTypeEnum.Mongo]: {
-    type: ManagedServiceTypeEnum.Mongo,
+  mongo: {
+    type: "mongo",
     name: "MongoDB 6",
     image: () => h("i", { class: "bi bi-database" }),
   },
-  [ManagedServiceTypeEnum.Redis]: {
-    type: ManagedServiceTypeEnum.Redis,
+  redis: {
+    type: "redis",
     name: "Redis 7",
     image: () => h("i", { class: "bi bi-database-gear" }),
   },
-  [ManagedServiceTypeEnum.Rabbitmq]: {
-    type: ManagedServiceTypeEnum.Rabbitmq,
+  rabbitmq: {
+    type: "rabbitmq",
     name: "RabbitMQ 3",
     image: () => h("i", { class: "bi bi-chat-left-dots" }),
   },
+  minio: {
+    type: "minio",
+    name: "MinIO",
+    image: () => h("i", { class: "bi bi-bucket" }),
+  },
+  kafka: {
+    type: "kafka",
+    name: "Kafka 3",
+    image: () => h("i", { class: "bi bi-collection" }),
+  },
+  elasticsearch: {
+    type: "elasticsearch",
+    name: "Elasticsearch 8",
+    image: () => h("i", { class: "bi bi-search" }),
+  },
+  memcached: {
+    type: "memcached",
+    name: "Memcached 1.6",
+    image: () => h("i", { class: "bi bi-memory" }),
+  },
+  nats: {
+    type: "nats",
+    name: "NATS 2",
+    image: () => h("i", { class: "bi bi-send" }),
+  },
 };
 
+// typeInfo returns the type description, types from the managed service catalog get a generic one
+export function typeInfo(type: string): ManagedServiceType {
+  return (
+    types[type] ?? {
+      type: type,
+      name: type,
+      image: () => h("i", { class: "bi bi-box" }),
+    }
+  );
+}
+
 export const TypeImage = defineComponent({
   props: {
     type: {
@@ -0,0 +1 @@
+ALTER TABLE managed_service DROP COLUMN IF EXISTS replicas;
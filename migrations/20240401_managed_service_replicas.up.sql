@@ -0,0 +1 @@
+ALTER TABLE managed_service ADD COLUMN replicas int NOT NULL DEFAULT 1 CHECK ( replicas >= 1 );
@@ -0,0 +1,2 @@
+ALTER TABLE service DROP COLUMN IF EXISTS stopped;
+ALTER TABLE managed_service DROP COLUMN IF EXISTS stopped;
@@ -0,0 +1,2 @@
+ALTER TABLE service ADD COLUMN stopped boolean NOT NULL DEFAULT false;
+ALTER TABLE managed_service ADD COLUMN stopped boolean NOT NULL DEFAULT false;
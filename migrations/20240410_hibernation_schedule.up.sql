@@ -0,0 +1,7 @@
+CREATE TABLE hibernation_schedule (
+    project_id text PRIMARY KEY REFERENCES project(id) ON DELETE CASCADE,
+    stop_cron text NOT NULL,
+    start_cron text NOT NULL,
+    time_zone text NOT NULL DEFAULT 'UTC',
+    enabled boolean NOT NULL DEFAULT true
+);
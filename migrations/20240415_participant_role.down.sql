@@ -0,0 +1 @@
+ALTER TABLE project_participant DROP COLUMN IF EXISTS role;
@@ -0,0 +1,8 @@
+ALTER TABLE project_participant ADD COLUMN role text NOT NULL DEFAULT 'admin'
+    CHECK ( role IN ('owner', 'admin', 'developer', 'viewer') );
+
+-- existing participants had full access, the first one becomes the owner
+UPDATE project_participant pp SET role = 'owner'
+WHERE pp.id = (SELECT min(id) FROM project_participant WHERE project_id = pp.project_id);
+
+ALTER TABLE project_participant ALTER COLUMN role SET DEFAULT 'developer';
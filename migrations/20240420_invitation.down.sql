@@ -0,0 +1,7 @@
+ALTER TABLE project ADD COLUMN IF NOT EXISTS invite_code uuid NOT NULL UNIQUE DEFAULT uuid_generate_v4();
+
+UPDATE project p SET invite_code = i.code
+FROM invitation i
+WHERE i.project_id = p.id AND i.is_default;
+
+DROP TABLE IF EXISTS invitation;
@@ -0,0 +1,23 @@
+CREATE TABLE invitation (
+    id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    project_id text NOT NULL REFERENCES project(id) ON DELETE CASCADE,
+    code uuid NOT NULL UNIQUE DEFAULT uuid_generate_v4(),
+    role text NOT NULL DEFAULT 'developer' CHECK ( role IN ('admin', 'developer', 'viewer') ),
+    expires_at timestamptz,
+    max_uses int CHECK ( max_uses > 0 ),
+    uses int NOT NULL DEFAULT 0,
+    username text,
+    email_domain text,
+    -- default invitation is the project invite link that is replaced by RegenerateInviteCode
+    is_default boolean NOT NULL DEFAULT false,
+    created_by text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CHECK ( username IS NULL OR email_domain IS NULL )
+);
+
+CREATE UNIQUE INDEX invitation_default_idx ON invitation (project_id) WHERE is_default;
+
+INSERT INTO invitation (project_id, code, role, is_default)
+SELECT id, invite_code, 'developer', true FROM project;
+
+ALTER TABLE project DROP COLUMN invite_code;
@@ -0,0 +1,10 @@
+-- raw keys cannot be restored from hashes, so all API keys are revoked
+DROP TABLE IF EXISTS api_key_project;
+DROP TABLE IF EXISTS api_key;
+
+CREATE TABLE api_key (
+    id text PRIMARY KEY,
+    username text NOT NULL,
+    name text NOT NULL,
+    UNIQUE (username, name)
+);
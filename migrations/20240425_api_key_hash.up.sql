@@ -0,0 +1,23 @@
+-- raw keys are replaced with their SHA-256 hashes, first 12 characters are kept to identify the key
+ALTER TABLE api_key ADD COLUMN key_hash text;
+ALTER TABLE api_key ADD COLUMN prefix text;
+UPDATE api_key SET key_hash = encode(sha256(convert_to(id, 'UTF8')), 'hex'), prefix = left(id, 12);
+ALTER TABLE api_key ALTER COLUMN key_hash SET NOT NULL;
+ALTER TABLE api_key ALTER COLUMN prefix SET NOT NULL;
+ALTER TABLE api_key ADD CONSTRAINT api_key_key_hash_key UNIQUE (key_hash);
+
+ALTER TABLE api_key DROP COLUMN id;
+ALTER TABLE api_key ADD COLUMN id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY;
+
+ALTER TABLE api_key ADD COLUMN expires_at timestamptz;
+ALTER TABLE api_key ADD COLUMN last_used_at timestamptz;
+ALTER TABLE api_key ADD COLUMN created_at timestamptz NOT NULL DEFAULT now();
+ALTER TABLE api_key ADD COLUMN read_only boolean NOT NULL DEFAULT false;
+-- project scoped key has access only to projects listed in api_key_project
+ALTER TABLE api_key ADD COLUMN project_scoped boolean NOT NULL DEFAULT false;
+
+CREATE TABLE api_key_project (
+    api_key_id int NOT NULL REFERENCES api_key(id) ON DELETE CASCADE,
+    project_id text NOT NULL REFERENCES project(id) ON DELETE CASCADE,
+    PRIMARY KEY (api_key_id, project_id)
+);
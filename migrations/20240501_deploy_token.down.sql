@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS deploy_token_service;
+DROP TABLE IF EXISTS deploy_token;
@@ -0,0 +1,20 @@
+CREATE TABLE deploy_token (
+    id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    project_id text NOT NULL REFERENCES project(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    token_hash text NOT NULL UNIQUE,
+    prefix text NOT NULL,
+    -- allowed actions: update-image, restart, read-status
+    actions jsonb NOT NULL DEFAULT '[]'::jsonb,
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    created_by text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (project_id, name)
+);
+
+CREATE TABLE deploy_token_service (
+    deploy_token_id int NOT NULL REFERENCES deploy_token(id) ON DELETE CASCADE,
+    service_id int NOT NULL REFERENCES service(id) ON DELETE CASCADE,
+    PRIMARY KEY (deploy_token_id, service_id)
+);
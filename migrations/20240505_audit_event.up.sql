@@ -0,0 +1,24 @@
+-- project_id and resource_id are not foreign keys, so events outlive deleted resources
+CREATE TABLE audit_event (
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    actor text NOT NULL,
+    auth_method text NOT NULL,
+    project_id text,
+    resource_type text NOT NULL,
+    resource_id text,
+    action text NOT NULL,
+    summary text,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX audit_event_project_id_idx ON audit_event (project_id, id);
+
+CREATE FUNCTION audit_event_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_event_append_only
+    BEFORE UPDATE OR DELETE ON audit_event
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_event_append_only();
@@ -0,0 +1,30 @@
+CREATE TABLE webhook (
+    id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    project_id text NOT NULL REFERENCES project(id) ON DELETE CASCADE,
+    url text NOT NULL,
+    event_types jsonb NOT NULL DEFAULT '[]'::jsonb,
+    -- signing secret is stored as is, because it is needed to sign payloads
+    secret text NOT NULL,
+    enabled boolean NOT NULL DEFAULT true,
+    created_by text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- webhook_delivery is the persistent delivery queue and the delivery log at the same time
+CREATE TABLE webhook_delivery (
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    webhook_id int NOT NULL REFERENCES webhook(id) ON DELETE CASCADE,
+    event_type text NOT NULL,
+    payload jsonb NOT NULL,
+    -- pending, succeeded or failed
+    status text NOT NULL DEFAULT 'pending',
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    last_attempt_at timestamptz,
+    response_status int,
+    error text,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX webhook_delivery_pending_idx ON webhook_delivery (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_delivery_webhook_id_idx ON webhook_delivery (webhook_id, id);
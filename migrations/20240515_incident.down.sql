@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS incident_notification;
+DROP TABLE IF EXISTS incident;
@@ -0,0 +1,23 @@
+CREATE TABLE incident (
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    project_id text NOT NULL REFERENCES project(id) ON DELETE CASCADE,
+    -- service or managed-service
+    service_kind text NOT NULL,
+    service_id int NOT NULL,
+    service_name text NOT NULL,
+    -- unhealthy, oom-killed or restart-spike
+    reason text NOT NULL,
+    details text,
+    opened_at timestamptz NOT NULL DEFAULT now(),
+    resolved_at timestamptz
+);
+
+CREATE INDEX incident_project_id_idx ON incident (project_id, id);
+CREATE INDEX incident_open_idx ON incident (service_kind, service_id) WHERE resolved_at IS NULL;
+
+CREATE TABLE incident_notification (
+    project_id text PRIMARY KEY REFERENCES project(id) ON DELETE CASCADE,
+    -- channels used to notify about incidents: webhook and email
+    channels jsonb NOT NULL DEFAULT '["webhook"]'::jsonb,
+    emails jsonb NOT NULL DEFAULT '[]'::jsonb
+);
@@ -0,0 +1,9 @@
+CREATE TABLE project_sync_status (
+    project_id text PRIMARY KEY REFERENCES project(id) ON DELETE CASCADE,
+    started_at timestamptz NOT NULL,
+    duration_ms bigint NOT NULL,
+    -- succeeded or failed
+    outcome text NOT NULL,
+    error text,
+    last_success_at timestamptz
+);
@@ -0,0 +1 @@
+ALTER TABLE service DROP COLUMN IF EXISTS command;
@@ -0,0 +1,2 @@
+-- arguments overriding the default command (CMD) of the image, empty array keeps the default one
+ALTER TABLE service ADD COLUMN command jsonb NOT NULL DEFAULT '[]';
@@ -0,0 +1,12 @@
+CREATE TABLE preview_environment (
+    project_id text PRIMARY KEY REFERENCES project(id) ON DELETE CASCADE,
+    -- previews of the deleted project are removed by the cleanup task
+    parent_id text REFERENCES project(id) ON DELETE SET NULL,
+    name text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    created_by text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (parent_id, name)
+);
+
+CREATE INDEX preview_environment_expires_at_idx ON preview_environment (expires_at);
@@ -0,0 +1,13 @@
+CREATE TABLE project_environment (
+    project_id text PRIMARY KEY REFERENCES project(id) ON DELETE CASCADE,
+    -- environments of the deleted project are kept as standalone projects
+    parent_id text REFERENCES project(id) ON DELETE SET NULL,
+    name text NOT NULL,
+    -- order of the environment in the promotion chain
+    position int NOT NULL,
+    domain text UNIQUE,
+    -- env vars, secrets and replicas overridden in the environment
+    overrides jsonb NOT NULL DEFAULT '{}',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (parent_id, name)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE project_quota (
+    project_id text PRIMARY KEY REFERENCES project(id) ON DELETE CASCADE,
+    -- limits are not applied if null
+    cpu_millis int,
+    memory_mi int,
+    storage_mi int,
+    services int,
+    managed_services int,
+    updated_by text NOT NULL,
+    updated_at timestamptz NOT NULL DEFAULT now()
+);